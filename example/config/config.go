@@ -21,7 +21,7 @@ type Config struct {
 	VertexProjectID  string
 	VertexLocation   string
 	RedisConfig      redis.Config
-	AzureRegions     map[string]string
+	AzureRegions     map[string]model.AzureRegion
 }
 
 // LoadConfig loads configuration from environment variables
@@ -57,9 +57,14 @@ func LoadConfig() Config {
 			Password: os.Getenv("REDIS_PASSWORD"),
 			DB:       0, // Default DB
 		},
-		AzureRegions: map[string]string{
-			"eastus":     os.Getenv("AZURE_ENDPOINT"),
-			"westeurope": "https://custom-westeurope.openai.azure.com", // Example endpoint
+		AzureRegions: map[string]model.AzureRegion{
+			"eastus": {
+				Endpoint: os.Getenv("AZURE_ENDPOINT"),
+				APIKey:   os.Getenv("AZURE_API_KEY"),
+			},
+			"westeurope": {
+				Endpoint: "https://custom-westeurope.openai.azure.com", // Example endpoint
+			},
 		},
 	}
 
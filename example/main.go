@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/Not-Diamond/go-notdiamond/pkg/http/response"
+	"github.com/Not-Diamond/go-notdiamond/pkg/model"
 	"github.com/Not-Diamond/go-notdiamond/pkg/transport"
 
 	"example/config"
@@ -46,9 +47,9 @@ func main() {
 	modelConfig.RedisConfig = &cfg.RedisConfig
 
 	// Set up Azure regions
-	modelConfig.AzureRegions = map[string]string{
-		"eastus":     cfg.AzureEndpoint,
-		"westeurope": "https://custom-westeurope.openai.azure.com",
+	modelConfig.AzureRegions = map[string]model.AzureRegion{
+		"eastus":     {Endpoint: cfg.AzureEndpoint, APIKey: cfg.AzureAPIKey},
+		"westeurope": {Endpoint: "https://custom-westeurope.openai.azure.com"},
 	}
 
 	// Create transport with configuration
@@ -137,17 +138,13 @@ func main() {
 	defer resp.Body.Close()
 
 	// Read response
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Fatalf("Failed to read response: %v", err)
-	}
-
-	result, err := response.Parse(body, start)
+	result, err := response.ParseResponse(resp, start)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	fmt.Printf("🤖 Model: %s\n", result.Model)
+	fmt.Printf("🤖 Model: %s/%s\n", result.Provider, result.Model)
+	fmt.Printf("🏁 Finish reason: %s\n", result.FinishReason)
 	fmt.Printf("⏱️  Time: %.2fs\n", result.TimeTaken.Seconds())
 	fmt.Printf("💬 Response: %s\n", result.Response)
 }
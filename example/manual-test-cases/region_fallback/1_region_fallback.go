@@ -31,10 +31,10 @@ var RegionFallbackAzureTest = model.Config{
 		"azure/gpt-35-turbo/westeurope", // Final fallback to westeurope
 	},
 	AzureAPIVersion: "2023-05-15", // Specify Azure API version
-	AzureRegions: map[string]string{
-		"eastus":     "https://notdiamond-azure-openai.openai.azure.com",
-		"westus":     "https://notdiamond-westus.openai.azure.com",
-		"westeurope": "https://custom-westeurope.openai.azure.com",
+	AzureRegions: map[string]model.AzureRegion{
+		"eastus":     {Endpoint: "https://notdiamond-azure-openai.openai.azure.com"},
+		"westus":     {Endpoint: "https://notdiamond-westus.openai.azure.com"},
+		"westeurope": {Endpoint: "https://custom-westeurope.openai.azure.com"},
 	},
 }
 
@@ -48,8 +48,8 @@ var RegionFallbackMixedTest = model.Config{
 		"openai/gpt-3.5-turbo", // Fallback to OpenAI (no region)
 	},
 	AzureAPIVersion: "2023-05-15", // Specify Azure API version
-	AzureRegions: map[string]string{
-		"eastus":     "https://notdiamond-azure-openai.openai.azure.com",
-		"westeurope": "https://custom-westeurope.openai.azure.com",
+	AzureRegions: map[string]model.AzureRegion{
+		"eastus":     {Endpoint: "https://notdiamond-azure-openai.openai.azure.com"},
+		"westeurope": {Endpoint: "https://custom-westeurope.openai.azure.com"},
 	},
 }
@@ -14,6 +14,9 @@ var RegionFallbackWeightedTest = model.Config{
 		"azure/gpt-35-turbo/eastus":     0.1, // 10% chance to try Azure eastus first
 	},
 	AzureAPIVersion: "2023-05-15", // Specify Azure API version
+	AzureRegions: map[string]model.AzureRegion{
+		"eastus": {Endpoint: "https://notdiamond-azure-openai.openai.azure.com"},
+	},
 }
 
 // RegionFallbackWithTimeoutTest demonstrates region fallback with timeouts
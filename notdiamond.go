@@ -2,8 +2,8 @@ package notdiamond
 
 import (
 	"log/slog"
-	"strings"
 
+	"github.com/Not-Diamond/go-notdiamond/pkg/clients"
 	http_client "github.com/Not-Diamond/go-notdiamond/pkg/http/client"
 	"github.com/Not-Diamond/go-notdiamond/pkg/model"
 	"github.com/Not-Diamond/go-notdiamond/pkg/validation"
@@ -34,16 +34,15 @@ func Init(config model.Config) (*http_client.Client, error) {
 
 	// Create modelProviders map
 	modelProviders := make(map[string]map[string]bool)
+	providers := clients.Registry(config)
 
 	switch models := config.Models.(type) {
 	case model.WeightedModels:
 		for modelFull := range models {
-			parts := strings.Split(modelFull, "/")
-			provider := parts[0]
-			modelName := parts[1]
+			provider, modelName, region := providers.SplitModel(modelFull)
 
 			// Handle region if present
-			if len(parts) > 2 {
+			if region != "" {
 				// For provider/model/region format, we use model as the key
 				// but we don't include the region in the key
 				if modelProviders[modelName] == nil {
@@ -60,12 +59,10 @@ func Init(config model.Config) (*http_client.Client, error) {
 		}
 	case model.OrderedModels:
 		for _, modelFull := range models {
-			parts := strings.Split(modelFull, "/")
-			provider := parts[0]
-			modelName := parts[1]
+			provider, modelName, region := providers.SplitModel(modelFull)
 
 			// Handle region if present
-			if len(parts) > 2 {
+			if region != "" {
 				// For provider/model/region format, we use model as the key
 				// but we don't include the region in the key
 				if modelProviders[modelName] == nil {
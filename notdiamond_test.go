@@ -446,8 +446,11 @@ func TestInitModelProviders(t *testing.T) {
 					},
 				},
 				Models: model.WeightedModels{
-					"openai/gpt-4/us-east1": 0.5,
-					"azure/gpt-4/eastus":    0.5,
+					"openai/gpt-4":       0.5,
+					"azure/gpt-4/eastus": 0.5,
+				},
+				AzureRegions: map[string]model.AzureRegion{
+					"eastus": {Endpoint: "https://eastus-resource.openai.azure.com"},
 				},
 				RedisConfig: &redis.Config{
 					Addr:     mr.Addr(),
@@ -0,0 +1,119 @@
+package anthropic
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Not-Diamond/go-notdiamond/pkg/http/request"
+	"github.com/Not-Diamond/go-notdiamond/pkg/http/response"
+	"github.com/Not-Diamond/go-notdiamond/pkg/model"
+)
+
+// Provider is the built-in Anthropic provider.
+type Provider struct{}
+
+// MatchesHost reports whether the host is an Anthropic API host.
+func (Provider) MatchesHost(host string) bool {
+	return strings.Contains(host, "anthropic.com")
+}
+
+// UpdateURL points the request at the Messages API, Anthropic has a single global endpoint.
+func (Provider) UpdateURL(req *http.Request, modelName string, region string, config model.Config) error {
+	req.URL.Path = "/v1/messages"
+	req.URL.RawQuery = ""
+	return nil
+}
+
+// UsesAPIKeys reports that Anthropic requests take their key from key pools.
+func (Provider) UsesAPIKeys() bool {
+	return true
+}
+
+// Authenticate sets the x-api-key and anthropic-version headers, preferring the key
+// selected from a key pool.
+func (Provider) Authenticate(ctx context.Context, req *http.Request, region string, config model.Config) error {
+	apiKey := model.APIKey(ctx)
+	if apiKey == "" {
+		apiKey = request.ExtractAPIKey(req)
+	}
+	req.Header.Set("x-api-key", apiKey)
+	if req.Header.Get("anthropic-version") == "" {
+		req.Header.Set("anthropic-version", APIVersion)
+	}
+	req.Header.Del("Authorization")
+	req.Header.Del("api-key")
+	return nil
+}
+
+// EncodeRequest transforms the body to Anthropic Messages API format. Parameters
+// Anthropic has no equivalent for are handled as config.UnsupportedParams says.
+// Embedding requests are not supported.
+func (Provider) EncodeRequest(body []byte, modelName string, config model.Config) ([]byte, error) {
+	if request.IsEmbeddingsPayload(body) {
+		return nil, fmt.Errorf("embeddings are not supported by Anthropic")
+	}
+	if err := request.CheckUnsupportedParams(request.UnsupportedAnthropicParams(body), string(model.ClientTypeAnthropic), config.UnsupportedParams); err != nil {
+		return nil, err
+	}
+	return request.TransformToAnthropicRequest(body, modelName)
+}
+
+// DecodeResponse transforms an Anthropic response to OpenAI format.
+func (Provider) DecodeResponse(body []byte) ([]byte, error) {
+	return request.TransformFromAnthropicResponse(body)
+}
+
+// EncodeResponse transforms an OpenAI response to Anthropic format.
+func (Provider) EncodeResponse(body []byte) ([]byte, error) {
+	return request.TransformToAnthropicResponse(body)
+}
+
+// DecodeStreamEvent transforms a streamed Anthropic event to an OpenAI chunk.
+func (Provider) DecodeStreamEvent(data []byte) ([]byte, error) {
+	return request.NewAnthropicStreamDecoder().Decode(data)
+}
+
+// EncodeStreamEvent fails, an OpenAI chunk becomes several named Anthropic events,
+// which EncodeStreamEvents returns.
+func (Provider) EncodeStreamEvent(data []byte) ([]byte, error) {
+	return nil, fmt.Errorf("anthropic stream events are encoded with EncodeStreamEvents")
+}
+
+// EncodeStreamEvents transforms an OpenAI chunk to streamed Anthropic events.
+func (Provider) EncodeStreamEvents(data []byte) ([]model.StreamEvent, error) {
+	return request.NewAnthropicStreamEncoder().Encode(data)
+}
+
+// NewStream returns the translator of a single stream, which keeps the message
+// and its content blocks open across the events of the stream.
+func (Provider) NewStream() model.StreamProvider {
+	return &stream{
+		decoder: request.NewAnthropicStreamDecoder(),
+		encoder: request.NewAnthropicStreamEncoder(),
+	}
+}
+
+// stream translates the events of a single Anthropic stream.
+type stream struct {
+	decoder *request.AnthropicStreamDecoder
+	encoder *request.AnthropicStreamEncoder
+}
+
+func (s *stream) DecodeStreamEvent(data []byte) ([]byte, error) {
+	return s.decoder.Decode(data)
+}
+
+func (s *stream) EncodeStreamEvent(data []byte) ([]byte, error) {
+	return Provider{}.EncodeStreamEvent(data)
+}
+
+func (s *stream) EncodeStreamEvents(data []byte) ([]model.StreamEvent, error) {
+	return s.encoder.Encode(data)
+}
+
+// ParseError builds an error from an Anthropic error response.
+func (Provider) ParseError(statusCode int, body []byte, modelName string, region string) error {
+	return response.ParseError(statusCode, body)
+}
@@ -0,0 +1,27 @@
+package anthropic
+
+import (
+	"errors"
+	"net/http"
+)
+
+// APIVersion is the Anthropic API version sent with every request.
+const APIVersion = "2023-06-01"
+
+// NewRequest creates a new request for the Anthropic Messages API.
+func NewRequest(url string, apiKey string) (*http.Request, error) {
+	if url == "" {
+		return nil, errors.New("url cannot be empty")
+	}
+
+	req, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", apiKey)
+	req.Header.Set("anthropic-version", APIVersion)
+
+	return req, nil
+}
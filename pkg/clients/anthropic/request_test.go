@@ -0,0 +1,103 @@
+package anthropic
+
+import (
+	"testing"
+)
+
+func TestNewRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		url         string
+		apiKey      string
+		wantErr     bool
+		checkHeader bool
+	}{
+		{
+			name:        "valid request",
+			url:         "https://api.anthropic.com/v1/messages",
+			apiKey:      "test-api-key",
+			wantErr:     false,
+			checkHeader: true,
+		},
+		{
+			name:        "empty URL",
+			url:         "",
+			apiKey:      "test-api-key",
+			wantErr:     true,
+			checkHeader: false,
+		},
+		{
+			name:        "invalid URL",
+			url:         "://invalid-url",
+			apiKey:      "test-api-key",
+			wantErr:     true,
+			checkHeader: false,
+		},
+		{
+			name:        "empty API key",
+			url:         "https://api.anthropic.com/v1/messages",
+			apiKey:      "",
+			wantErr:     false,
+			checkHeader: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := NewRequest(tt.url, tt.apiKey)
+
+			// Check error cases
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewRequest() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			// If we expected an error, no need to check the request
+			if tt.wantErr {
+				return
+			}
+
+			// Verify request is not nil
+			if req == nil {
+				t.Error("NewRequest() returned nil request with no error")
+				return
+			}
+
+			// Check headers if required
+			if tt.checkHeader {
+				// Verify Content-Type header
+				contentType := req.Header.Get("Content-Type")
+				if contentType != "application/json" {
+					t.Errorf("Expected Content-Type header to be 'application/json', got %q", contentType)
+				}
+
+				// Verify x-api-key header
+				apiKey := req.Header.Get("x-api-key")
+				if apiKey != tt.apiKey {
+					t.Errorf("Expected x-api-key header to be %q, got %q", tt.apiKey, apiKey)
+				}
+
+				// Verify anthropic-version header
+				version := req.Header.Get("anthropic-version")
+				if version != APIVersion {
+					t.Errorf("Expected anthropic-version header to be %q, got %q", APIVersion, version)
+				}
+
+				// Anthropic does not use bearer auth
+				if auth := req.Header.Get("Authorization"); auth != "" {
+					t.Errorf("Expected Authorization header to be empty, got %q", auth)
+				}
+			}
+
+			// Verify request method
+			if req.Method != "POST" {
+				t.Errorf("Expected request method to be 'POST', got %q", req.Method)
+			}
+
+			// Verify URL
+			if req.URL.String() != tt.url {
+				t.Errorf("Expected URL to be %q, got %q", tt.url, req.URL.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+const (
+	// DefaultScope is the Entra ID scope of Azure OpenAI.
+	DefaultScope = "https://cognitiveservices.azure.com/.default"
+	// DefaultAuthorityHost is the Entra ID authority of the public cloud.
+	DefaultAuthorityHost = "https://login.microsoftonline.com"
+
+	// refreshBefore is how long before expiry cached tokens are refreshed.
+	refreshBefore = 5 * time.Minute
+	// managedIdentityTimeout bounds a managed identity token request, so that a host
+	// without a reachable metadata service fails fast instead of stalling the request.
+	managedIdentityTimeout = 5 * time.Second
+)
+
+// imdsEndpoint is the Azure Instance Metadata Service token endpoint, replaced in tests.
+var imdsEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+
+// managedIdentityClient is the HTTP client of managed identity token requests.
+var managedIdentityClient = &http.Client{Timeout: managedIdentityTimeout}
+
+// EntraConfig configures Entra ID (Azure AD) authentication. Empty fields fall back
+// to the AZURE_TENANT_ID, AZURE_CLIENT_ID, AZURE_CLIENT_SECRET,
+// AZURE_FEDERATED_TOKEN_FILE and AZURE_AUTHORITY_HOST environment variables.
+type EntraConfig struct {
+	TenantID           string
+	ClientID           string
+	ClientSecret       string
+	FederatedTokenFile string // Workload identity token file, re-read on every refresh
+	AuthorityHost      string
+	Scope              string // Defaults to DefaultScope
+}
+
+// withDefaults fills empty fields from the environment and the defaults.
+func (c EntraConfig) withDefaults() EntraConfig {
+	fill := func(value *string, env string, fallback string) {
+		if *value == "" {
+			*value = os.Getenv(env)
+		}
+		if *value == "" {
+			*value = fallback
+		}
+	}
+	fill(&c.TenantID, "AZURE_TENANT_ID", "")
+	fill(&c.ClientID, "AZURE_CLIENT_ID", "")
+	fill(&c.ClientSecret, "AZURE_CLIENT_SECRET", "")
+	fill(&c.FederatedTokenFile, "AZURE_FEDERATED_TOKEN_FILE", "")
+	fill(&c.AuthorityHost, "AZURE_AUTHORITY_HOST", DefaultAuthorityHost)
+	if c.Scope == "" {
+		c.Scope = DefaultScope
+	}
+	return c
+}
+
+// tokenURL returns the OAuth 2.0 token endpoint of the tenant.
+func (c EntraConfig) tokenURL() string {
+	return fmt.Sprintf("%s/%s/oauth2/v2.0/token", strings.TrimSuffix(c.AuthorityHost, "/"), c.TenantID)
+}
+
+// NewClientSecretTokenSource returns a cached token source for a service principal
+// authenticating with a client secret.
+func NewClientSecretTokenSource(config EntraConfig) (oauth2.TokenSource, error) {
+	config = config.withDefaults()
+	if config.TenantID == "" || config.ClientID == "" || config.ClientSecret == "" {
+		return nil, errors.New("tenant ID, client ID and client secret are required for client credentials")
+	}
+
+	credentials := clientcredentials.Config{
+		ClientID:     config.ClientID,
+		ClientSecret: config.ClientSecret,
+		TokenURL:     config.tokenURL(),
+		Scopes:       []string{config.Scope},
+		AuthStyle:    oauth2.AuthStyleInParams,
+	}
+	return oauth2.ReuseTokenSourceWithExpiry(nil, clientSecretSource{credentials: credentials}, refreshBefore), nil
+}
+
+// clientSecretSource fetches a token on every call. The token source of
+// clientcredentials.Config caches tokens itself, so it is not used.
+type clientSecretSource struct {
+	credentials clientcredentials.Config
+}
+
+func (s clientSecretSource) Token() (*oauth2.Token, error) {
+	return s.credentials.Token(context.Background())
+}
+
+// NewWorkloadIdentityTokenSource returns a cached token source for workload identity
+// federation, e.g. on AKS, exchanging the federated token file for an access token.
+func NewWorkloadIdentityTokenSource(config EntraConfig) (oauth2.TokenSource, error) {
+	config = config.withDefaults()
+	if config.TenantID == "" || config.ClientID == "" || config.FederatedTokenFile == "" {
+		return nil, errors.New("tenant ID, client ID and federated token file are required for workload identity")
+	}
+	return oauth2.ReuseTokenSourceWithExpiry(nil, workloadIdentitySource{config: config}, refreshBefore), nil
+}
+
+// workloadIdentitySource fetches tokens with the federated token as client assertion.
+type workloadIdentitySource struct {
+	config EntraConfig
+}
+
+func (s workloadIdentitySource) Token() (*oauth2.Token, error) {
+	// The token file is rotated by the platform, so it is read on every fetch
+	assertion, err := os.ReadFile(s.config.FederatedTokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read federated token file: %w", err)
+	}
+
+	credentials := clientcredentials.Config{
+		ClientID: s.config.ClientID,
+		TokenURL: s.config.tokenURL(),
+		Scopes:   []string{s.config.Scope},
+		EndpointParams: url.Values{
+			"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+			"client_assertion":      {strings.TrimSpace(string(assertion))},
+		},
+		AuthStyle: oauth2.AuthStyleInParams,
+	}
+	return credentials.Token(context.Background())
+}
+
+// NewManagedIdentityTokenSource returns a cached token source for a managed identity.
+// An empty client ID selects the system-assigned identity. App Service and Functions
+// are detected through IDENTITY_ENDPOINT, everything else uses the instance metadata service.
+func NewManagedIdentityTokenSource(clientID string) oauth2.TokenSource {
+	return oauth2.ReuseTokenSourceWithExpiry(nil, managedIdentitySource{clientID: clientID}, refreshBefore)
+}
+
+// managedIdentitySource fetches tokens from the local managed identity endpoint.
+type managedIdentitySource struct {
+	clientID string
+}
+
+func (s managedIdentitySource) Token() (*oauth2.Token, error) {
+	resource := strings.TrimSuffix(DefaultScope, "/.default")
+
+	// App Service and Functions expose their own endpoint instead of the metadata service
+	identityEndpoint := os.Getenv("IDENTITY_ENDPOINT")
+	endpoint, apiVersion := imdsEndpoint, "2018-02-01"
+	if identityEndpoint != "" {
+		endpoint, apiVersion = identityEndpoint, "2019-08-01"
+	}
+
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid managed identity endpoint: %w", err)
+	}
+	if identityEndpoint != "" {
+		req.Header.Set("X-IDENTITY-HEADER", os.Getenv("IDENTITY_HEADER"))
+	} else {
+		req.Header.Set("Metadata", "true")
+	}
+
+	query := req.URL.Query()
+	query.Set("api-version", apiVersion)
+	query.Set("resource", resource)
+	if s.clientID != "" {
+		query.Set("client_id", s.clientID)
+	}
+	req.URL.RawQuery = query.Encode()
+
+	resp, err := managedIdentityClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("managed identity request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read managed identity response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("managed identity endpoint returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	// expires_on is a string of epoch seconds on both endpoints
+	var tokenResponse struct {
+		AccessToken string      `json:"access_token"`
+		TokenType   string      `json:"token_type"`
+		ExpiresOn   json.Number `json:"expires_on"`
+	}
+	if err := json.Unmarshal(body, &tokenResponse); err != nil {
+		return nil, fmt.Errorf("failed to parse managed identity response: %w", err)
+	}
+	if tokenResponse.AccessToken == "" {
+		return nil, errors.New("managed identity response has no access token")
+	}
+
+	token := &oauth2.Token{
+		AccessToken: tokenResponse.AccessToken,
+		TokenType:   tokenResponse.TokenType,
+	}
+	if expiresOn, err := strconv.ParseInt(tokenResponse.ExpiresOn.String(), 10, 64); err == nil {
+		token.Expiry = time.Unix(expiresOn, 0)
+	}
+	return token, nil
+}
@@ -0,0 +1,289 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Not-Diamond/go-notdiamond/pkg/model"
+	"golang.org/x/oauth2"
+)
+
+// newTokenStub starts a token endpoint that checks the form with check and
+// returns tokens expiring in expiresIn seconds. calls counts the token requests.
+func newTokenStub(t *testing.T, expiresIn int, check func(r *http.Request)) (*httptest.Server, *int) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("failed to parse form: %v", err)
+		}
+		if r.URL.Path != "/test-tenant/oauth2/v2.0/token" {
+			t.Errorf("unexpected token path %q", r.URL.Path)
+		}
+		check(r)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token": "token-%d", "token_type": "Bearer", "expires_in": %d}`, calls, expiresIn)
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func TestNewClientSecretTokenSource(t *testing.T) {
+	tests := []struct {
+		name       string
+		expiresIn  int
+		wantTokens []string
+		wantCalls  int
+	}{
+		{
+			name:       "token is cached",
+			expiresIn:  3600,
+			wantTokens: []string{"token-1", "token-1"},
+			wantCalls:  1,
+		},
+		{
+			name:       "token is refreshed before expiry",
+			expiresIn:  60,
+			wantTokens: []string{"token-1", "token-2"},
+			wantCalls:  2,
+		},
+		{
+			name:       "token is refreshed five minutes before expiry",
+			expiresIn:  299,
+			wantTokens: []string{"token-1", "token-2", "token-3"},
+			wantCalls:  3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, calls := newTokenStub(t, tt.expiresIn, func(r *http.Request) {
+				for key, want := range map[string]string{
+					"grant_type":    "client_credentials",
+					"client_id":     "test-client",
+					"client_secret": "test-secret",
+					"scope":         DefaultScope,
+				} {
+					if got := r.PostForm.Get(key); got != want {
+						t.Errorf("form %s = %q, want %q", key, got, want)
+					}
+				}
+			})
+
+			tokenSource, err := NewClientSecretTokenSource(EntraConfig{
+				TenantID:      "test-tenant",
+				ClientID:      "test-client",
+				ClientSecret:  "test-secret",
+				AuthorityHost: server.URL,
+			})
+			if err != nil {
+				t.Fatalf("NewClientSecretTokenSource() error = %v", err)
+			}
+
+			for i, want := range tt.wantTokens {
+				token, err := tokenSource.Token()
+				if err != nil {
+					t.Fatalf("Token() error = %v", err)
+				}
+				if token.AccessToken != want {
+					t.Errorf("Token() call %d = %q, want %q", i+1, token.AccessToken, want)
+				}
+			}
+			if *calls != tt.wantCalls {
+				t.Errorf("token endpoint called %d times, want %d", *calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestNewClientSecretTokenSourceMissingConfig(t *testing.T) {
+	t.Setenv("AZURE_TENANT_ID", "")
+	t.Setenv("AZURE_CLIENT_ID", "")
+	t.Setenv("AZURE_CLIENT_SECRET", "")
+
+	if _, err := NewClientSecretTokenSource(EntraConfig{TenantID: "test-tenant"}); err == nil {
+		t.Error("NewClientSecretTokenSource() expected error without client ID and secret")
+	}
+}
+
+func TestNewWorkloadIdentityTokenSource(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("federated-jwt\n"), 0o600); err != nil {
+		t.Fatalf("failed to write token file: %v", err)
+	}
+
+	server, _ := newTokenStub(t, 3600, func(r *http.Request) {
+		for key, want := range map[string]string{
+			"grant_type":            "client_credentials",
+			"client_id":             "test-client",
+			"client_assertion_type": "urn:ietf:params:oauth:client-assertion-type:jwt-bearer",
+			"client_assertion":      "federated-jwt",
+		} {
+			if got := r.PostForm.Get(key); got != want {
+				t.Errorf("form %s = %q, want %q", key, got, want)
+			}
+		}
+		if r.PostForm.Has("client_secret") {
+			t.Error("client_secret must not be sent for workload identity")
+		}
+	})
+
+	// Configured through the environment, as on AKS
+	t.Setenv("AZURE_TENANT_ID", "test-tenant")
+	t.Setenv("AZURE_CLIENT_ID", "test-client")
+	t.Setenv("AZURE_FEDERATED_TOKEN_FILE", tokenFile)
+	t.Setenv("AZURE_AUTHORITY_HOST", server.URL)
+
+	tokenSource, err := NewWorkloadIdentityTokenSource(EntraConfig{})
+	if err != nil {
+		t.Fatalf("NewWorkloadIdentityTokenSource() error = %v", err)
+	}
+	token, err := tokenSource.Token()
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if token.AccessToken != "token-1" {
+		t.Errorf("Token() = %q, want %q", token.AccessToken, "token-1")
+	}
+}
+
+func TestNewManagedIdentityTokenSource(t *testing.T) {
+	tests := []struct {
+		name        string
+		appService  bool
+		clientID    string
+		wantHeader  string
+		wantVersion string
+	}{
+		{
+			name:        "instance metadata service, system-assigned",
+			wantHeader:  "Metadata",
+			wantVersion: "2018-02-01",
+		},
+		{
+			name:        "App Service, user-assigned",
+			appService:  true,
+			clientID:    "user-assigned-id",
+			wantHeader:  "X-IDENTITY-HEADER",
+			wantVersion: "2019-08-01",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expiresOn := time.Now().Add(time.Hour).Unix()
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get(tt.wantHeader) == "" {
+					t.Errorf("missing %s header", tt.wantHeader)
+				}
+				query := r.URL.Query()
+				if got := query.Get("api-version"); got != tt.wantVersion {
+					t.Errorf("api-version = %q, want %q", got, tt.wantVersion)
+				}
+				if got := query.Get("resource"); got != "https://cognitiveservices.azure.com" {
+					t.Errorf("resource = %q, want %q", got, "https://cognitiveservices.azure.com")
+				}
+				if got := query.Get("client_id"); got != tt.clientID {
+					t.Errorf("client_id = %q, want %q", got, tt.clientID)
+				}
+				fmt.Fprintf(w, `{"access_token": "mi-token", "token_type": "Bearer", "expires_on": "%d"}`, expiresOn)
+			}))
+			defer server.Close()
+
+			if tt.appService {
+				t.Setenv("IDENTITY_ENDPOINT", server.URL)
+				t.Setenv("IDENTITY_HEADER", "identity-secret")
+			} else {
+				t.Setenv("IDENTITY_ENDPOINT", "")
+				original := imdsEndpoint
+				imdsEndpoint = server.URL
+				defer func() { imdsEndpoint = original }()
+			}
+
+			token, err := NewManagedIdentityTokenSource(tt.clientID).Token()
+			if err != nil {
+				t.Fatalf("Token() error = %v", err)
+			}
+			if token.AccessToken != "mi-token" {
+				t.Errorf("Token() = %q, want %q", token.AccessToken, "mi-token")
+			}
+			if token.Expiry.Unix() != expiresOn {
+				t.Errorf("Token() expiry = %v, want %v", token.Expiry.Unix(), expiresOn)
+			}
+		})
+	}
+}
+
+func TestProviderAuthenticateWithTokenSource(t *testing.T) {
+	config := model.Config{
+		AzureTokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "default-token"}),
+		AzureRegions: map[string]model.AzureRegion{
+			"westeurope": {
+				Endpoint:    "https://westeurope-resource.openai.azure.com",
+				TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "westeurope-token"}),
+			},
+			"swedencentral": {
+				Endpoint: "https://swedencentral-resource.openai.azure.com",
+				APIKey:   "swedencentral-key",
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		region   string
+		config   model.Config
+		wantAuth string
+		wantKey  string
+	}{
+		{
+			name:     "default token source",
+			region:   "eastus",
+			config:   config,
+			wantAuth: "Bearer default-token",
+		},
+		{
+			name:     "region token source",
+			region:   "westeurope",
+			config:   config,
+			wantAuth: "Bearer westeurope-token",
+		},
+		{
+			name:    "region api key",
+			region:  "swedencentral",
+			config:  config,
+			wantKey: "swedencentral-key",
+		},
+		{
+			name:    "api key without token source",
+			region:  "eastus",
+			config:  model.Config{},
+			wantKey: "static-key",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := NewRequest("https://myresource.openai.azure.com", "static-key")
+			if err != nil {
+				t.Fatalf("NewRequest() error = %v", err)
+			}
+
+			if err := (Provider{}).Authenticate(context.Background(), req, tt.region, tt.config); err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+			if got := req.Header.Get("Authorization"); got != tt.wantAuth {
+				t.Errorf("Authorization = %q, want %q", got, tt.wantAuth)
+			}
+			if got := req.Header.Get("api-key"); got != tt.wantKey {
+				t.Errorf("api-key = %q, want %q", got, tt.wantKey)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/Not-Diamond/go-notdiamond/pkg/http/request"
+	"github.com/Not-Diamond/go-notdiamond/pkg/http/response"
+	"github.com/Not-Diamond/go-notdiamond/pkg/model"
+)
+
+// DefaultAPIVersion is used when Config.AzureAPIVersion is not set.
+const DefaultAPIVersion = "2023-05-15"
+
+// Provider is the built-in Azure OpenAI provider.
+type Provider struct{}
+
+// MatchesHost reports whether the host is the endpoint of an Azure OpenAI or
+// Azure AI Services resource. Other hosts, e.g. gateways, are tagged with
+// model.WithProvider.
+func (Provider) MatchesHost(host string) bool {
+	return strings.HasSuffix(host, ".openai.azure.com") || strings.HasSuffix(host, ".cognitiveservices.azure.com")
+}
+
+// Regional reports that Azure OpenAI models are served from regions.
+func (Provider) Regional() bool {
+	return true
+}
+
+// UpdateURL points the request at the model's deployment, calling its embeddings
+// endpoint for embedding requests. When a region is given, the endpoint, API
+// version and deployment name come from Config.AzureRegions.
+func (Provider) UpdateURL(req *http.Request, modelName string, region string, config model.Config) error {
+	deployment := modelName
+	apiVersion := config.AzureAPIVersion
+
+	if region != "" {
+		azureRegion, ok := config.AzureRegions[region]
+		if !ok {
+			return fmt.Errorf("azure region %s is not configured in AzureRegions", region)
+		}
+		if azureRegion.Endpoint == "" {
+			return fmt.Errorf("azure region %s has no endpoint", region)
+		}
+		if name, ok := azureRegion.Deployments[modelName]; ok {
+			deployment = name
+		}
+		if azureRegion.APIVersion != "" {
+			apiVersion = azureRegion.APIVersion
+		}
+
+		// Use the endpoint of the region's resource
+		req.URL.Host = strings.TrimPrefix(strings.TrimPrefix(azureRegion.Endpoint, "https://"), "http://")
+		req.URL.Scheme = "https"
+		req.Host = req.URL.Host
+		slog.Info("🔄 Using Azure endpoint from AzureRegions", "region", region, "endpoint", azureRegion.Endpoint, "deployment", deployment)
+	}
+
+	// Fall back to the default API version
+	if apiVersion == "" {
+		apiVersion = DefaultAPIVersion
+	}
+	endpoint := "chat/completions"
+	if model.Embeddings(req.Context()) || strings.HasSuffix(req.URL.Path, "/embeddings") {
+		endpoint = "embeddings"
+	}
+	req.URL.Path = fmt.Sprintf("/openai/deployments/%s/%s", deployment, endpoint)
+	req.URL.RawQuery = fmt.Sprintf("api-version=%s", apiVersion)
+	return nil
+}
+
+// UsesAPIKeys reports that Azure OpenAI requests take their key from key pools.
+func (Provider) UsesAPIKeys() bool {
+	return true
+}
+
+// Authenticate sets the key selected from a key pool or the region's credential
+// configured in Config.AzureRegions, then falls back to the default Entra ID token
+// source and finally to the caller's api-key.
+func (Provider) Authenticate(ctx context.Context, req *http.Request, region string, config model.Config) error {
+	tokenSource := config.AzureTokenSource
+	apiKey := model.APIKey(ctx)
+	if apiKey != "" {
+		tokenSource = nil
+	} else if azureRegion, ok := config.AzureRegions[region]; ok && region != "" {
+		if azureRegion.TokenSource != nil {
+			tokenSource = azureRegion.TokenSource
+		} else if azureRegion.APIKey != "" {
+			tokenSource = nil
+			apiKey = azureRegion.APIKey
+		}
+	}
+
+	if tokenSource != nil {
+		token, err := tokenSource.Token()
+		if err != nil {
+			return fmt.Errorf("error getting Entra ID token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+		req.Header.Del("api-key")
+		req.Header.Del("x-api-key")
+		return nil
+	}
+
+	if apiKey == "" {
+		apiKey = request.ExtractAPIKey(req)
+	}
+	req.Header.Set("api-key", apiKey)
+	req.Header.Del("Authorization")
+	req.Header.Del("x-api-key")
+	return nil
+}
+
+// EncodeRequest transforms the body to OpenAI format. The model is dropped
+// because Azure takes the deployment from the URL. Parameters OpenAI has no
+// equivalent for are handled as config.UnsupportedParams says.
+func (Provider) EncodeRequest(body []byte, modelName string, config model.Config) ([]byte, error) {
+	if err := request.CheckUnsupportedParams(request.UnsupportedOpenAIParams(body), string(model.ClientTypeAzure), config.UnsupportedParams); err != nil {
+		return nil, err
+	}
+	return request.TransformToOpenAIRequest(body, "")
+}
+
+// DecodeResponse returns the body unchanged, it is already in OpenAI format.
+func (Provider) DecodeResponse(body []byte) ([]byte, error) {
+	return body, nil
+}
+
+// EncodeResponse returns the body unchanged, Azure responds in OpenAI format.
+func (Provider) EncodeResponse(body []byte) ([]byte, error) {
+	return body, nil
+}
+
+// DecodeStreamEvent returns the chunk unchanged, it is already in OpenAI format.
+// The [DONE] event is dropped, it is sent again once the stream has ended.
+func (Provider) DecodeStreamEvent(data []byte) ([]byte, error) {
+	if string(data) == "[DONE]" {
+		return nil, nil
+	}
+	return data, nil
+}
+
+// EncodeStreamEvent returns the chunk unchanged, it is already in OpenAI format.
+func (Provider) EncodeStreamEvent(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+// ParseError builds an error from an Azure error response.
+func (Provider) ParseError(statusCode int, body []byte, modelName string, region string) error {
+	return response.ParseError(statusCode, body)
+}
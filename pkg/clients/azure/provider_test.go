@@ -0,0 +1,140 @@
+package azure
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/Not-Diamond/go-notdiamond/pkg/model"
+)
+
+func TestProviderUpdateURL(t *testing.T) {
+	config := model.Config{
+		AzureAPIVersion: "2024-02-01",
+		AzureRegions: map[string]model.AzureRegion{
+			"eastus": {
+				Endpoint: "https://eastus-resource.openai.azure.com",
+			},
+			"westeurope": {
+				Endpoint:    "https://westeurope-resource.openai.azure.com",
+				APIVersion:  "2024-06-01",
+				Deployments: map[string]string{"gpt-4o": "gpt-4o-prod"},
+			},
+			"swedencentral": {},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		modelName  string
+		region     string
+		config     model.Config
+		embeddings bool
+		wantURL    string
+		wantErr    bool
+	}{
+		{
+			name:      "no region keeps client host",
+			modelName: "gpt-4o",
+			config:    config,
+			wantURL:   "https://myresource.openai.azure.com/openai/deployments/gpt-4o/chat/completions?api-version=2024-02-01",
+		},
+		{
+			name:      "default API version",
+			modelName: "gpt-4o",
+			config:    model.Config{},
+			wantURL:   "https://myresource.openai.azure.com/openai/deployments/gpt-4o/chat/completions?api-version=" + DefaultAPIVersion,
+		},
+		{
+			name:      "region endpoint",
+			modelName: "gpt-4o",
+			region:    "eastus",
+			config:    config,
+			wantURL:   "https://eastus-resource.openai.azure.com/openai/deployments/gpt-4o/chat/completions?api-version=2024-02-01",
+		},
+		{
+			name:      "region deployment and API version",
+			modelName: "gpt-4o",
+			region:    "westeurope",
+			config:    config,
+			wantURL:   "https://westeurope-resource.openai.azure.com/openai/deployments/gpt-4o-prod/chat/completions?api-version=2024-06-01",
+		},
+		{
+			name:      "model without deployment mapping",
+			modelName: "gpt-4o-mini",
+			region:    "westeurope",
+			config:    config,
+			wantURL:   "https://westeurope-resource.openai.azure.com/openai/deployments/gpt-4o-mini/chat/completions?api-version=2024-06-01",
+		},
+		{
+			name:       "embeddings deployment",
+			modelName:  "text-embedding-3-small",
+			region:     "eastus",
+			config:     config,
+			embeddings: true,
+			wantURL:    "https://eastus-resource.openai.azure.com/openai/deployments/text-embedding-3-small/embeddings?api-version=2024-02-01",
+		},
+		{
+			name:      "unconfigured region",
+			modelName: "gpt-4o",
+			region:    "japaneast",
+			config:    config,
+			wantErr:   true,
+		},
+		{
+			name:      "region without endpoint",
+			modelName: "gpt-4o",
+			region:    "swedencentral",
+			config:    config,
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "https://myresource.openai.azure.com/openai/deployments/gpt-4/chat/completions", nil)
+			if err != nil {
+				t.Fatalf("Failed to create request: %v", err)
+			}
+			if tt.embeddings {
+				req = req.WithContext(model.WithEmbeddings(req.Context()))
+			}
+
+			err = Provider{}.UpdateURL(req, tt.modelName, tt.region, tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UpdateURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if req.URL.String() != tt.wantURL {
+				t.Errorf("url = %q, want %q", req.URL.String(), tt.wantURL)
+			}
+			if req.Host != req.URL.Host {
+				t.Errorf("Host = %q, want %q", req.Host, req.URL.Host)
+			}
+		})
+	}
+}
+
+func TestProviderMatchesHost(t *testing.T) {
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{host: "myresource.openai.azure.com", want: true},
+		{host: "myresource.cognitiveservices.azure.com", want: true},
+		{host: "myazure.example.com", want: false},
+		{host: "azure-status.example.com", want: false},
+		{host: "openai.azure.com.example.com", want: false},
+		{host: "api.openai.com", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			if got := (Provider{}).MatchesHost(tt.host); got != tt.want {
+				t.Errorf("MatchesHost(%q) = %v, want %v", tt.host, got, tt.want)
+			}
+		})
+	}
+}
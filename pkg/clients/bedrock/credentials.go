@@ -0,0 +1,259 @@
+package bedrock
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// refreshBefore is how long before expiry cached temporary credentials are
+	// resolved again.
+	refreshBefore = 5 * time.Minute
+	// staticCredentialsTTL is how long credentials without an expiry are cached, so
+	// that rotated environment variables and shared files are picked up.
+	staticCredentialsTTL = 15 * time.Minute
+	// credentialsTimeout bounds a request for remote credentials, so that a host
+	// without a reachable metadata service fails fast instead of stalling the request.
+	credentialsTimeout = 5 * time.Second
+)
+
+// Endpoints of the remote credential sources, replaced in tests.
+var (
+	containerEndpoint = "http://169.254.170.2"
+	imdsEndpoint      = "http://169.254.169.254"
+	stsEndpoint       = func(region string) string {
+		if region == "" {
+			return "https://sts.amazonaws.com"
+		}
+		return fmt.Sprintf("https://sts.%s.amazonaws.com", region)
+	}
+)
+
+// credentialsClient is the HTTP client of requests for remote credentials.
+var credentialsClient = &http.Client{Timeout: credentialsTimeout}
+
+// credentialsCache keeps the credentials resolved for each profile until they are
+// due for a refresh. Failed lookups are not cached, so a credential source that is
+// not ready yet is retried.
+var credentialsCache = struct {
+	sync.Mutex
+	byProfile map[string]cachedCredential
+}{byProfile: make(map[string]cachedCredential)}
+
+type cachedCredential struct {
+	credentials Credentials
+	refreshAt   time.Time
+}
+
+// cachedCredentials returns the credentials of the profile from the cache, and
+// resolves them with LoadCredentials once they are due for a refresh.
+func cachedCredentials(profile string) (Credentials, error) {
+	profile = resolveProfile(profile)
+
+	credentialsCache.Lock()
+	defer credentialsCache.Unlock()
+
+	if cached, ok := credentialsCache.byProfile[profile]; ok && now().Before(cached.refreshAt) {
+		return cached.credentials, nil
+	}
+
+	credentials, err := LoadCredentials(profile)
+	if err != nil {
+		return Credentials{}, err
+	}
+	refreshAt := now().Add(staticCredentialsTTL)
+	if !credentials.Expires.IsZero() {
+		refreshAt = credentials.Expires.Add(-refreshBefore)
+	}
+	credentialsCache.byProfile[profile] = cachedCredential{credentials: credentials, refreshAt: refreshAt}
+	return credentials, nil
+}
+
+// webIdentityCredentials assumes AWS_ROLE_ARN with the token of
+// AWS_WEB_IDENTITY_TOKEN_FILE, as set up by EKS IAM roles for service accounts.
+// The token file is re-read on every call, it is rotated on disk.
+func webIdentityCredentials(tokenFile string) (Credentials, error) {
+	roleARN := os.Getenv("AWS_ROLE_ARN")
+	if roleARN == "" {
+		return Credentials{}, errors.New("AWS_WEB_IDENTITY_TOKEN_FILE is set but AWS_ROLE_ARN is not")
+	}
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("failed to read web identity token: %w", err)
+	}
+	sessionName := os.Getenv("AWS_ROLE_SESSION_NAME")
+	if sessionName == "" {
+		sessionName = fmt.Sprintf("go-notdiamond-%d", now().UnixNano())
+	}
+
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	form := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {roleARN},
+		"RoleSessionName":  {sessionName},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	resp, err := credentialsClient.PostForm(stsEndpoint(region)+"/", form)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("web identity request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("failed to read web identity response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Credentials{}, fmt.Errorf("STS returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var stsResponse struct {
+		Credentials struct {
+			AccessKeyID     string `xml:"AccessKeyId"`
+			SecretAccessKey string `xml:"SecretAccessKey"`
+			SessionToken    string `xml:"SessionToken"`
+			Expiration      string `xml:"Expiration"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err := xml.Unmarshal(body, &stsResponse); err != nil {
+		return Credentials{}, fmt.Errorf("failed to parse web identity response: %w", err)
+	}
+	c := stsResponse.Credentials
+	return temporaryCredentials(c.AccessKeyID, c.SecretAccessKey, c.SessionToken, c.Expiration)
+}
+
+// containerCredentials fetches the credentials of the task role from the ECS (or
+// EKS Pod Identity) container credentials endpoint.
+func containerCredentials() (Credentials, error) {
+	endpoint := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if relative := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); relative != "" {
+		endpoint = containerEndpoint + relative
+	}
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("invalid container credentials endpoint: %w", err)
+	}
+
+	authorization := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+	if file := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); file != "" {
+		token, err := os.ReadFile(file)
+		if err != nil {
+			return Credentials{}, fmt.Errorf("failed to read container authorization token: %w", err)
+		}
+		authorization = strings.TrimSpace(string(token))
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	body, err := getCredentialsEndpoint(req, "container credentials")
+	if err != nil {
+		return Credentials{}, err
+	}
+	return parseRoleCredentials(body, "container credentials")
+}
+
+// instanceCredentials fetches the credentials of the instance role from the EC2
+// instance metadata service, with an IMDSv2 session token.
+func instanceCredentials() (Credentials, error) {
+	req, err := http.NewRequest("PUT", imdsEndpoint+"/latest/api/token", nil)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("invalid instance metadata endpoint: %w", err)
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+	token, err := getCredentialsEndpoint(req, "instance metadata token")
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	get := func(path string) ([]byte, error) {
+		req, err := http.NewRequest("GET", imdsEndpoint+"/latest/meta-data/iam/security-credentials/"+path, nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid instance metadata endpoint: %w", err)
+		}
+		req.Header.Set("X-aws-ec2-metadata-token", string(token))
+		return getCredentialsEndpoint(req, "instance metadata")
+	}
+
+	roles, err := get("")
+	if err != nil {
+		return Credentials{}, err
+	}
+	role, _, _ := strings.Cut(strings.TrimSpace(string(roles)), "\n")
+	if role == "" {
+		return Credentials{}, errors.New("instance has no IAM role")
+	}
+	body, err := get(role)
+	if err != nil {
+		return Credentials{}, err
+	}
+	return parseRoleCredentials(body, "instance metadata")
+}
+
+// getCredentialsEndpoint sends a request to a credential endpoint and returns the
+// body of its successful response.
+func getCredentialsEndpoint(req *http.Request, name string) ([]byte, error) {
+	resp, err := credentialsClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s request failed: %w", name, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s response: %w", name, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s endpoint returned status %d: %s", name, resp.StatusCode, string(body))
+	}
+	return body, nil
+}
+
+// parseRoleCredentials parses the role credentials returned by the container and
+// instance metadata endpoints.
+func parseRoleCredentials(body []byte, name string) (Credentials, error) {
+	var roleCredentials struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string `json:"SecretAccessKey"`
+		Token           string `json:"Token"`
+		Expiration      string `json:"Expiration"`
+	}
+	if err := json.Unmarshal(body, &roleCredentials); err != nil {
+		return Credentials{}, fmt.Errorf("failed to parse %s response: %w", name, err)
+	}
+	c := roleCredentials
+	return temporaryCredentials(c.AccessKeyID, c.SecretAccessKey, c.Token, c.Expiration)
+}
+
+// temporaryCredentials builds credentials that expire at the RFC 3339 expiration.
+func temporaryCredentials(accessKeyID, secretAccessKey, sessionToken, expiration string) (Credentials, error) {
+	if accessKeyID == "" || secretAccessKey == "" {
+		return Credentials{}, errors.New("response has no access key")
+	}
+	credentials := Credentials{
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: secretAccessKey,
+		SessionToken:    sessionToken,
+	}
+	if expiration != "" {
+		expires, err := time.Parse(time.RFC3339, expiration)
+		if err != nil {
+			return Credentials{}, fmt.Errorf("invalid expiration %q: %w", expiration, err)
+		}
+		credentials.Expires = expires
+	}
+	return credentials, nil
+}
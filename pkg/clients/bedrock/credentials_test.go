@@ -0,0 +1,164 @@
+package bedrock
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// withoutRemoteCredentials clears the environment of the remote credential sources
+// and disables the instance metadata service.
+func withoutRemoteCredentials(t *testing.T) {
+	t.Helper()
+	for _, key := range []string{
+		"AWS_WEB_IDENTITY_TOKEN_FILE", "AWS_ROLE_ARN", "AWS_ROLE_SESSION_NAME",
+		"AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "AWS_CONTAINER_CREDENTIALS_FULL_URI",
+		"AWS_CONTAINER_AUTHORIZATION_TOKEN", "AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE",
+	} {
+		t.Setenv(key, "")
+	}
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+}
+
+// withoutStaticCredentials points the environment and shared files at nothing.
+func withoutStaticCredentials(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	for _, key := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_PROFILE"} {
+		t.Setenv(key, "")
+	}
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+}
+
+func TestLoadRemoteCredentials(t *testing.T) {
+	expiration := "2024-01-02T04:00:00Z"
+	want := Credentials{
+		AccessKeyID:     "ROLEKEY",
+		SecretAccessKey: "rolesecret",
+		SessionToken:    "roletoken",
+		Expires:         time.Date(2024, 1, 2, 4, 0, 0, 0, time.UTC),
+	}
+	roleCredentials := `{"Code": "Success", "AccessKeyId": "ROLEKEY", "SecretAccessKey": "rolesecret", "Token": "roletoken", "Expiration": "` + expiration + `"}`
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/" && r.Method == "POST":
+			r.ParseForm()
+			if r.Form.Get("Action") != "AssumeRoleWithWebIdentity" || r.Form.Get("RoleArn") != "arn:aws:iam::123456789012:role/app" || r.Form.Get("WebIdentityToken") != "web-token" {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`<AssumeRoleWithWebIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+				<AssumeRoleWithWebIdentityResult><Credentials>
+					<AccessKeyId>ROLEKEY</AccessKeyId><SecretAccessKey>rolesecret</SecretAccessKey>
+					<SessionToken>roletoken</SessionToken><Expiration>` + expiration + `</Expiration>
+				</Credentials></AssumeRoleWithWebIdentityResult>
+			</AssumeRoleWithWebIdentityResponse>`))
+		case r.URL.Path == "/v2/credentials/task":
+			if r.Header.Get("Authorization") != "container-token" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(roleCredentials))
+		case r.URL.Path == "/latest/api/token" && r.Method == "PUT":
+			w.Write([]byte("imds-token"))
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/":
+			if r.Header.Get("X-aws-ec2-metadata-token") != "imds-token" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte("instance-role"))
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/instance-role":
+			w.Write([]byte(roleCredentials))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	defer func(container, imds string, sts func(string) string) {
+		containerEndpoint, imdsEndpoint, stsEndpoint = container, imds, sts
+	}(containerEndpoint, imdsEndpoint, stsEndpoint)
+	containerEndpoint, imdsEndpoint = server.URL, server.URL
+	stsEndpoint = func(string) string { return server.URL }
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	os.WriteFile(tokenFile, []byte("web-token\n"), 0o600)
+
+	tests := []struct {
+		name string
+		env  map[string]string
+	}{
+		{
+			name: "web identity",
+			env:  map[string]string{"AWS_WEB_IDENTITY_TOKEN_FILE": tokenFile, "AWS_ROLE_ARN": "arn:aws:iam::123456789012:role/app"},
+		},
+		{
+			name: "container",
+			env:  map[string]string{"AWS_CONTAINER_CREDENTIALS_RELATIVE_URI": "/v2/credentials/task", "AWS_CONTAINER_AUTHORIZATION_TOKEN": "container-token"},
+		},
+		{
+			name: "instance metadata",
+			env:  map[string]string{"AWS_EC2_METADATA_DISABLED": ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withoutStaticCredentials(t)
+			withoutRemoteCredentials(t)
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			got, err := LoadCredentials("")
+			if err != nil {
+				t.Fatalf("LoadCredentials() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("LoadCredentials() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestCachedCredentials(t *testing.T) {
+	withoutStaticCredentials(t)
+	withoutRemoteCredentials(t)
+	signingTime := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+	now = func() time.Time { return signingTime }
+	defer func() { now = time.Now }()
+
+	// Container credentials expiring at 04:00 are refreshed from 03:55
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"AccessKeyId": "ROLEKEY", "SecretAccessKey": "rolesecret", "Token": "roletoken", "Expiration": "2024-01-02T04:00:00Z"}`))
+	}))
+	defer server.Close()
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", server.URL)
+	t.Setenv("AWS_PROFILE", "cache-test")
+
+	if _, err := cachedCredentials(""); err == nil {
+		t.Fatal("cachedCredentials() succeeded with the endpoint failing")
+	}
+	for i, at := range []time.Time{signingTime, signingTime.Add(50 * time.Minute), signingTime.Add(56 * time.Minute)} {
+		signingTime = at
+		if _, err := cachedCredentials(""); err != nil {
+			t.Fatalf("cachedCredentials() call %d error = %v", i, err)
+		}
+	}
+	// One failure, one lookup after it and one refresh
+	if calls != 3 {
+		t.Errorf("endpoint calls = %d, want 3", calls)
+	}
+}
@@ -0,0 +1,146 @@
+// Package bedrock provides the AWS Bedrock provider, which sends requests to the
+// Converse API signed with SigV4.
+package bedrock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Not-Diamond/go-notdiamond/pkg/http/request"
+	"github.com/Not-Diamond/go-notdiamond/pkg/http/response"
+	"github.com/Not-Diamond/go-notdiamond/pkg/model"
+)
+
+// Provider is the built-in AWS Bedrock provider. The zero value resolves
+// credentials from the standard AWS chain, see LoadCredentials, and the region from
+// the AWS environment and shared config.
+type Provider struct {
+	// Credentials are used instead of the AWS credential chain when set.
+	Credentials *Credentials
+	// Profile selects the shared config profile, defaulting to AWS_PROFILE or "default".
+	Profile string
+	// Endpoint overrides https://bedrock-runtime.<region>.amazonaws.com,
+	// e.g. for VPC endpoints.
+	Endpoint string
+}
+
+// MatchesHost reports whether the host is a Bedrock runtime host or the configured endpoint.
+func (p Provider) MatchesHost(host string) bool {
+	if p.Endpoint != "" {
+		if u, err := url.Parse(p.Endpoint); err == nil && u.Host == host {
+			return true
+		}
+	}
+	return strings.HasPrefix(host, "bedrock-runtime.") && strings.HasSuffix(host, ".amazonaws.com")
+}
+
+// Regional reports that Bedrock models are served from regions.
+func (Provider) Regional() bool {
+	return true
+}
+
+// IsStreaming reports whether the request asks for a streamed response, either
+// through its context or by already calling converse-stream.
+func IsStreaming(req *http.Request) bool {
+	return model.Streaming(req.Context()) || strings.HasSuffix(req.URL.Path, "/converse-stream")
+}
+
+// UpdateURL points the request at the Converse endpoint of the model in the region,
+// or at ConverseStream for streamed requests. The region falls back to the AWS
+// environment and shared config.
+func (p Provider) UpdateURL(req *http.Request, modelName string, region string, config model.Config) error {
+	action := "converse"
+	if IsStreaming(req) {
+		action = "converse-stream"
+	}
+
+	region, err := resolveRegion(region, p.Profile)
+	if err != nil {
+		return err
+	}
+
+	scheme, host := "https", fmt.Sprintf("bedrock-runtime.%s.amazonaws.com", region)
+	if p.Endpoint != "" {
+		u, err := url.Parse(p.Endpoint)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("invalid Bedrock endpoint %q", p.Endpoint)
+		}
+		scheme, host = u.Scheme, u.Host
+	}
+
+	// Model IDs such as anthropic.claude-3-haiku-20240307-v1:0 must be escaped in the path
+	req.URL.Scheme = scheme
+	req.URL.Host = host
+	req.URL.Path = fmt.Sprintf("/model/%s/%s", modelName, action)
+	req.URL.RawPath = fmt.Sprintf("/model/%s/%s", strings.ReplaceAll(url.PathEscape(modelName), ":", "%3A"), action)
+	req.URL.RawQuery = ""
+	req.Host = host
+
+	slog.Info("🔄 Updated Bedrock URL", "host", req.URL.Host, "path", req.URL.Path, "region", region)
+	return nil
+}
+
+// Authenticate signs the request with SigV4. Credentials resolved from the AWS chain
+// are cached per profile until shortly before they expire.
+func (p Provider) Authenticate(ctx context.Context, req *http.Request, region string, config model.Config) error {
+	region, err := resolveRegion(region, p.Profile)
+	if err != nil {
+		return err
+	}
+
+	var credentials Credentials
+	if p.Credentials != nil {
+		credentials = *p.Credentials
+	} else {
+		credentials, err = cachedCredentials(p.Profile)
+		if err != nil {
+			return fmt.Errorf("failed to load AWS credentials: %w", err)
+		}
+	}
+
+	req.Header.Del("api-key")
+	req.Header.Del("x-api-key")
+	return signRequest(req, credentials, region, signingService, now())
+}
+
+// EncodeRequest transforms the body to Converse format. Parameters and message
+// content Converse translation has no equivalent for are handled as
+// config.UnsupportedParams says. Embedding requests are not supported.
+func (Provider) EncodeRequest(body []byte, modelName string, config model.Config) ([]byte, error) {
+	if request.IsEmbeddingsPayload(body) {
+		return nil, fmt.Errorf("embeddings are not supported by Bedrock")
+	}
+	if err := request.CheckUnsupportedParams(request.UnsupportedBedrockParams(body), string(model.ClientTypeBedrock), config.UnsupportedParams); err != nil {
+		return nil, err
+	}
+	return request.TransformToBedrockRequest(body)
+}
+
+// DecodeResponse transforms a Converse response to OpenAI format.
+func (Provider) DecodeResponse(body []byte) ([]byte, error) {
+	return request.TransformFromBedrockResponse(body)
+}
+
+// EncodeResponse transforms an OpenAI response to Converse format.
+func (Provider) EncodeResponse(body []byte) ([]byte, error) {
+	return request.TransformToBedrockResponse(body)
+}
+
+// ParseError builds an error from a Bedrock error response.
+func (Provider) ParseError(statusCode int, body []byte, modelName string, region string) error {
+	var errorResponse struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &errorResponse); err == nil && errorResponse.Message != "" {
+		return fmt.Errorf("with status %d (%s): %s",
+			statusCode,
+			http.StatusText(statusCode),
+			errorResponse.Message)
+	}
+	return response.ParseError(statusCode, body)
+}
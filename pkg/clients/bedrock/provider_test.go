@@ -0,0 +1,292 @@
+package bedrock
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Not-Diamond/go-notdiamond/pkg/model"
+)
+
+func TestSignRequest(t *testing.T) {
+	// get-vanilla from the AWS SigV4 test suite
+	req, err := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	credentials := Credentials{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+	signingTime := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	if err := signRequest(req, credentials, "us-east-1", "service", signingTime); err != nil {
+		t.Fatalf("signRequest() error = %v", err)
+	}
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, " +
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("signRequest() Authorization = %q, want %q", got, want)
+	}
+	if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+		t.Errorf("signRequest() X-Amz-Date = %q, want %q", got, "20150830T123600Z")
+	}
+}
+
+func TestProviderAgainstStub(t *testing.T) {
+	credentials := &Credentials{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		SessionToken:    "session-token",
+	}
+	signingTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	now = func() time.Time { return signingTime }
+	defer func() { now = time.Now }()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		if r.URL.EscapedPath() != "/model/anthropic.claude-3-haiku-20240307-v1%3A0/converse" {
+			t.Errorf("unexpected path %q", r.URL.EscapedPath())
+		}
+		if got := r.Header.Get("X-Amz-Date"); got != "20240102T030405Z" {
+			t.Errorf("X-Amz-Date = %q, want %q", got, "20240102T030405Z")
+		}
+		if got := r.Header.Get("X-Amz-Security-Token"); got != "session-token" {
+			t.Errorf("X-Amz-Security-Token = %q, want %q", got, "session-token")
+		}
+		if got, want := r.Header.Get("X-Amz-Content-Sha256"), fmt.Sprintf("%x", sha256.Sum256(body)); got != want {
+			t.Errorf("X-Amz-Content-Sha256 = %q, want %q", got, want)
+		}
+
+		auth := r.Header.Get("Authorization")
+		for _, part := range []string{
+			"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20240102/us-west-2/bedrock/aws4_request",
+			"SignedHeaders=content-type;host;x-amz-content-sha256;x-amz-date;x-amz-security-token",
+		} {
+			if !strings.Contains(auth, part) {
+				t.Errorf("Authorization %q does not contain %q", auth, part)
+			}
+		}
+
+		// Re-sign the request as received to verify the signature survived the wire
+		received, _ := http.NewRequest(r.Method, "http://"+r.Host+r.URL.EscapedPath(), bytes.NewReader(body))
+		received.Header.Set("Content-Type", r.Header.Get("Content-Type"))
+		received.Header.Set("X-Amz-Security-Token", r.Header.Get("X-Amz-Security-Token"))
+		if err := signRequest(received, *credentials, "us-west-2", "bedrock", signingTime); err != nil {
+			t.Errorf("signRequest() error = %v", err)
+		}
+		if received.Header.Get("Authorization") != auth {
+			t.Errorf("signature mismatch: got %q, want %q", auth, received.Header.Get("Authorization"))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{
+			"output": {"message": {"role": "assistant", "content": [{"text": "Hello from Bedrock"}]}},
+			"stopReason": "end_turn",
+			"usage": {"inputTokens": 12, "outputTokens": 4, "totalTokens": 16}
+		}`))
+	}))
+	defer server.Close()
+
+	provider := Provider{Credentials: credentials, Endpoint: server.URL}
+
+	body, err := provider.EncodeRequest([]byte(`{"model": "gpt-4", "messages": [{"role": "user", "content": "Hello"}]}`), "", model.Config{})
+	if err != nil {
+		t.Fatalf("EncodeRequest() error = %v", err)
+	}
+
+	req, err := NewRequest("https://bedrock-runtime.us-east-1.amazonaws.com")
+	if err != nil {
+		t.Fatalf("NewRequest() error = %v", err)
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+
+	if err := provider.UpdateURL(req, "anthropic.claude-3-haiku-20240307-v1:0", "us-west-2", model.Config{}); err != nil {
+		t.Fatalf("UpdateURL() error = %v", err)
+	}
+	if !provider.MatchesHost(req.URL.Host) {
+		t.Errorf("MatchesHost(%q) = false, want true", req.URL.Host)
+	}
+	if err := provider.Authenticate(context.Background(), req, "us-west-2", model.Config{}); err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+
+	decoded, err := provider.DecodeResponse(respBody)
+	if err != nil {
+		t.Fatalf("DecodeResponse() error = %v", err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(decoded, &got); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	choice := got["choices"].([]interface{})[0].(map[string]interface{})
+	if content := choice["message"].(map[string]interface{})["content"]; content != "Hello from Bedrock" {
+		t.Errorf("content = %v, want %q", content, "Hello from Bedrock")
+	}
+	if choice["finish_reason"] != "stop" {
+		t.Errorf("finish_reason = %v, want stop", choice["finish_reason"])
+	}
+}
+
+func TestUpdateURL(t *testing.T) {
+	tests := []struct {
+		name      string
+		region    string
+		envRegion string
+		path      string
+		wantURL   string
+		wantErr   bool
+	}{
+		{
+			name:    "region from model name",
+			region:  "eu-central-1",
+			wantURL: "https://bedrock-runtime.eu-central-1.amazonaws.com/model/amazon.titan-text-express-v1/converse",
+		},
+		{
+			name:      "region from environment",
+			envRegion: "ap-southeast-2",
+			wantURL:   "https://bedrock-runtime.ap-southeast-2.amazonaws.com/model/amazon.titan-text-express-v1/converse",
+		},
+		{
+			name:    "streamed request",
+			region:  "eu-central-1",
+			path:    "/model/amazon.titan-text-express-v1/converse-stream",
+			wantURL: "https://bedrock-runtime.eu-central-1.amazonaws.com/model/amazon.titan-text-express-v1/converse-stream",
+		},
+		{
+			name:    "no region",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AWS_REGION", tt.envRegion)
+			t.Setenv("AWS_DEFAULT_REGION", "")
+			t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+
+			req, _ := NewRequest("https://bedrock-runtime.us-east-1.amazonaws.com" + tt.path)
+			err := Provider{}.UpdateURL(req, "amazon.titan-text-express-v1", tt.region, model.Config{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UpdateURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && req.URL.String() != tt.wantURL {
+				t.Errorf("UpdateURL() url = %q, want %q", req.URL.String(), tt.wantURL)
+			}
+		})
+	}
+}
+
+func TestLoadCredentials(t *testing.T) {
+	dir := t.TempDir()
+	credentialsFile := filepath.Join(dir, "credentials")
+	configFile := filepath.Join(dir, "config")
+	os.WriteFile(credentialsFile, []byte(`
+[default]
+aws_access_key_id = DEFAULTKEY
+aws_secret_access_key = defaultsecret
+
+# comment
+[work]
+aws_access_key_id = WORKKEY
+aws_secret_access_key = worksecret
+aws_session_token = worktoken
+`), 0o600)
+	os.WriteFile(configFile, []byte(`
+[profile dev]
+region = eu-west-1
+aws_access_key_id = DEVKEY
+aws_secret_access_key = devsecret
+`), 0o600)
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		profile string
+		want    Credentials
+		wantErr bool
+	}{
+		{
+			name: "environment",
+			env: map[string]string{
+				"AWS_ACCESS_KEY_ID":     "ENVKEY",
+				"AWS_SECRET_ACCESS_KEY": "envsecret",
+				"AWS_SESSION_TOKEN":     "envtoken",
+			},
+			want: Credentials{AccessKeyID: "ENVKEY", SecretAccessKey: "envsecret", SessionToken: "envtoken"},
+		},
+		{
+			name:    "environment without secret",
+			env:     map[string]string{"AWS_ACCESS_KEY_ID": "ENVKEY"},
+			wantErr: true,
+		},
+		{
+			name: "default profile from credentials file",
+			want: Credentials{AccessKeyID: "DEFAULTKEY", SecretAccessKey: "defaultsecret"},
+		},
+		{
+			name:    "named profile from credentials file",
+			profile: "work",
+			want:    Credentials{AccessKeyID: "WORKKEY", SecretAccessKey: "worksecret", SessionToken: "worktoken"},
+		},
+		{
+			name: "AWS_PROFILE from config file",
+			env:  map[string]string{"AWS_PROFILE": "dev"},
+			want: Credentials{AccessKeyID: "DEVKEY", SecretAccessKey: "devsecret"},
+		},
+		{
+			name:    "unknown profile",
+			profile: "missing",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_PROFILE"} {
+				t.Setenv(key, tt.env[key])
+			}
+			t.Setenv("AWS_SHARED_CREDENTIALS_FILE", credentialsFile)
+			t.Setenv("AWS_CONFIG_FILE", configFile)
+			withoutRemoteCredentials(t)
+
+			got, err := LoadCredentials(tt.profile)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadCredentials() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoadCredentials() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseError(t *testing.T) {
+	err := Provider{}.ParseError(400, []byte(`{"message": "The provided model identifier is invalid."}`), "anthropic.claude-3-haiku-20240307-v1:0", "us-east-1")
+	want := "with status 400 (Bad Request): The provided model identifier is invalid."
+	if err == nil || err.Error() != want {
+		t.Errorf("ParseError() = %v, want %q", err, want)
+	}
+}
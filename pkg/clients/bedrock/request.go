@@ -0,0 +1,23 @@
+package bedrock
+
+import (
+	"errors"
+	"net/http"
+)
+
+// NewRequest creates a new request for the Bedrock runtime API. Requests are
+// signed with SigV4 when sent, so no credentials are set here.
+func NewRequest(url string) (*http.Request, error) {
+	if url == "" {
+		return nil, errors.New("url cannot be empty")
+	}
+
+	req, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	return req, nil
+}
@@ -0,0 +1,62 @@
+package bedrock
+
+import (
+	"testing"
+)
+
+func TestNewRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		wantErr bool
+	}{
+		{
+			name:    "valid request",
+			url:     "https://bedrock-runtime.us-east-1.amazonaws.com",
+			wantErr: false,
+		},
+		{
+			name:    "empty URL",
+			url:     "",
+			wantErr: true,
+		},
+		{
+			name:    "invalid URL",
+			url:     "://invalid-url",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := NewRequest(tt.url)
+
+			// Check error cases
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewRequest() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			// If we expected an error, no need to check the request
+			if tt.wantErr {
+				return
+			}
+
+			// Verify Content-Type header
+			contentType := req.Header.Get("Content-Type")
+			if contentType != "application/json" {
+				t.Errorf("Expected Content-Type header to be 'application/json', got %q", contentType)
+			}
+
+			// Requests are signed when sent, not up front
+			if auth := req.Header.Get("Authorization"); auth != "" {
+				t.Errorf("Expected Authorization header to be empty, got %q", auth)
+			}
+
+			// Verify request method
+			if req.Method != "POST" {
+				t.Errorf("Expected request method to be 'POST', got %q", req.Method)
+			}
+		})
+	}
+}
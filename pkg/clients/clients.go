@@ -0,0 +1,40 @@
+// Package clients wires the built-in providers into a registry.
+package clients
+
+import (
+	"github.com/Not-Diamond/go-notdiamond/pkg/clients/anthropic"
+	"github.com/Not-Diamond/go-notdiamond/pkg/clients/azure"
+	"github.com/Not-Diamond/go-notdiamond/pkg/clients/bedrock"
+	"github.com/Not-Diamond/go-notdiamond/pkg/clients/gemini"
+	"github.com/Not-Diamond/go-notdiamond/pkg/clients/openai"
+	"github.com/Not-Diamond/go-notdiamond/pkg/clients/openaicompat"
+	"github.com/Not-Diamond/go-notdiamond/pkg/clients/vertex"
+	"github.com/Not-Diamond/go-notdiamond/pkg/model"
+)
+
+// Builtin returns the providers shipped with the module.
+func Builtin() model.Providers {
+	return model.Providers{
+		string(model.ClientTypeAzure):     azure.Provider{},
+		string(model.ClientTypeOpenai):    openai.Provider{},
+		string(model.ClientTypeVertex):    vertex.Provider{},
+		string(model.ClientTypeAnthropic): anthropic.Provider{},
+		string(model.ClientTypeBedrock):   bedrock.Provider{},
+		string(model.ClientTypeGemini):    gemini.Provider{},
+	}
+}
+
+// Registry returns the built-in providers merged with the custom providers
+// from the config. Custom providers override built-in ones with the same name.
+// OpenAI-compatible providers without a name are named after their prefix.
+func Registry(config model.Config) model.Providers {
+	providers := Builtin()
+	for name, provider := range config.Providers {
+		if compat, ok := provider.(openaicompat.Provider); ok && compat.Name == "" {
+			compat.Name = name
+			provider = compat
+		}
+		providers[name] = provider
+	}
+	return providers
+}
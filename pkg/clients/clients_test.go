@@ -0,0 +1,82 @@
+package clients
+
+import (
+	"testing"
+
+	"github.com/Not-Diamond/go-notdiamond/pkg/clients/openai"
+	"github.com/Not-Diamond/go-notdiamond/pkg/clients/openaicompat"
+	"github.com/Not-Diamond/go-notdiamond/pkg/model"
+)
+
+func TestRegistry(t *testing.T) {
+	custom := openai.Provider{}
+
+	tests := []struct {
+		name      string
+		config    model.Config
+		wantNames []string
+	}{
+		{
+			name:      "built-in providers only",
+			config:    model.Config{},
+			wantNames: []string{"anthropic", "azure", "bedrock", "gemini", "openai", "vertex"},
+		},
+		{
+			name: "custom provider added",
+			config: model.Config{
+				Providers: model.Providers{"gateway": custom},
+			},
+			wantNames: []string{"anthropic", "azure", "bedrock", "gateway", "gemini", "openai", "vertex"},
+		},
+		{
+			name: "custom provider overrides built-in",
+			config: model.Config{
+				Providers: model.Providers{"azure": custom},
+			},
+			wantNames: []string{"anthropic", "azure", "bedrock", "gemini", "openai", "vertex"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			providers := Registry(tt.config)
+			names := providers.Names()
+			if len(names) != len(tt.wantNames) {
+				t.Fatalf("Registry() names = %v, want %v", names, tt.wantNames)
+			}
+			for i := range names {
+				if names[i] != tt.wantNames[i] {
+					t.Errorf("Registry() names = %v, want %v", names, tt.wantNames)
+					break
+				}
+			}
+			for name, provider := range tt.config.Providers {
+				if providers[name] != provider {
+					t.Errorf("Registry()[%q] = %T, want custom provider", name, providers[name])
+				}
+			}
+		})
+	}
+}
+
+func TestRegistryNamesOpenAICompatibleProviders(t *testing.T) {
+	providers := Registry(model.Config{
+		Providers: model.Providers{
+			"local": openaicompat.Provider{BaseURL: "http://localhost:11434/v1"},
+			"groq":  openaicompat.Provider{Name: "groq-cloud", BaseURL: "https://api.groq.com/openai/v1"},
+		},
+	})
+
+	for name, want := range map[string]string{"local": "local", "groq": "groq-cloud"} {
+		if got := providers[name].(openaicompat.Provider).Name; got != want {
+			t.Errorf("Registry()[%q].Name = %q, want %q", name, got, want)
+		}
+	}
+
+	// Unsupported parameters are reported for the name, not the base URL
+	_, err := providers["local"].EncodeRequest([]byte(`{"contents": [{"role": "user", "parts": [{"text": "Hello"}]}], "safetySettings": []}`),
+		"llama3", model.Config{UnsupportedParams: model.UnsupportedParamsFail})
+	if err == nil || err.Error() != "parameters not supported by local: safetySettings" {
+		t.Errorf("EncodeRequest() error = %v, want it reported for local", err)
+	}
+}
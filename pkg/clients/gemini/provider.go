@@ -0,0 +1,112 @@
+// Package gemini provides the Gemini API (Google AI Studio) provider, which
+// authenticates with an API key instead of Google Cloud credentials.
+package gemini
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/Not-Diamond/go-notdiamond/pkg/clients/vertex"
+	"github.com/Not-Diamond/go-notdiamond/pkg/http/request"
+	"github.com/Not-Diamond/go-notdiamond/pkg/http/response"
+	"github.com/Not-Diamond/go-notdiamond/pkg/model"
+)
+
+// Host is the Gemini API host.
+const Host = "generativelanguage.googleapis.com"
+
+// Provider is the built-in Gemini API provider.
+type Provider struct{}
+
+// MatchesHost reports whether the host is the Gemini API host.
+func (Provider) MatchesHost(host string) bool {
+	return host == Host
+}
+
+// UpdateURL points the request at the model's generateContent endpoint, or at
+// streamGenerateContent for streamed requests. The Gemini API is global, so the
+// region is ignored.
+func (Provider) UpdateURL(req *http.Request, modelName string, region string, config model.Config) error {
+	stream := vertex.IsStreaming(req)
+	req.URL.Scheme = "https"
+	req.URL.Host = Host
+	req.URL.Path = fmt.Sprintf("/v1beta/models/%s", modelName)
+	vertex.SetMethod(req, stream)
+	req.Host = Host
+	return nil
+}
+
+// UsesAPIKeys reports that Gemini requests take their key from key pools.
+func (Provider) UsesAPIKeys() bool {
+	return true
+}
+
+// Authenticate sets the x-goog-api-key header, preferring the key selected from a key pool.
+func (Provider) Authenticate(ctx context.Context, req *http.Request, region string, config model.Config) error {
+	apiKey := model.APIKey(ctx)
+	if apiKey == "" {
+		apiKey = req.Header.Get("x-goog-api-key")
+	}
+	if apiKey == "" {
+		apiKey = req.URL.Query().Get("key")
+	}
+	if apiKey == "" {
+		apiKey = request.ExtractAPIKey(req)
+	}
+	if apiKey == "" {
+		return fmt.Errorf("no Gemini API key found on the client request")
+	}
+
+	req.Header.Set("x-goog-api-key", apiKey)
+	req.Header.Del("Authorization")
+	req.Header.Del("api-key")
+	req.Header.Del("x-api-key")
+
+	// Keys passed as a query parameter are moved to the header so they don't end up in logs
+	if query := req.URL.Query(); query.Has("key") {
+		query.Del("key")
+		req.URL.RawQuery = query.Encode()
+	}
+	return nil
+}
+
+// EncodeRequest transforms the body to the Vertex AI format, which the Gemini API shares.
+// Embedding requests are not supported.
+func (Provider) EncodeRequest(body []byte, modelName string, config model.Config) ([]byte, error) {
+	if request.IsEmbeddingsPayload(body) {
+		return nil, fmt.Errorf("embeddings are not supported by the Gemini API")
+	}
+	return vertex.Provider{}.EncodeRequest(body, modelName, config)
+}
+
+// DecodeResponse transforms a Gemini API response to OpenAI format.
+func (Provider) DecodeResponse(body []byte) ([]byte, error) {
+	return request.TransformFromVertexResponse(body)
+}
+
+// EncodeResponse transforms an OpenAI response to Gemini API format.
+func (Provider) EncodeResponse(body []byte) ([]byte, error) {
+	return request.TransformToVertexResponse(body)
+}
+
+// DecodeStreamEvent transforms a streamed Gemini API response to an OpenAI chunk.
+func (Provider) DecodeStreamEvent(data []byte) ([]byte, error) {
+	return vertex.Provider{}.DecodeStreamEvent(data)
+}
+
+// EncodeStreamEvent transforms an OpenAI chunk to a streamed Gemini API response.
+func (Provider) EncodeStreamEvent(data []byte) ([]byte, error) {
+	return vertex.Provider{}.EncodeStreamEvent(data)
+}
+
+// NewStream returns the translator of a single stream, the Gemini API streams
+// like Vertex AI.
+func (Provider) NewStream() model.StreamProvider {
+	return vertex.Provider{}.NewStream()
+}
+
+// ParseError builds an error from a Gemini API error response.
+func (Provider) ParseError(statusCode int, body []byte, modelName string, region string) error {
+	return response.ParseError(statusCode, body)
+}
@@ -0,0 +1,91 @@
+package gemini
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Not-Diamond/go-notdiamond/pkg/model"
+)
+
+func TestProvider(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		header  http.Header
+		wantURL string
+		wantKey string
+		wantErr bool
+	}{
+		{
+			name:    "key from x-goog-api-key header",
+			url:     "https://generativelanguage.googleapis.com/v1beta/models/gemini-pro:generateContent",
+			header:  http.Header{"X-Goog-Api-Key": []string{"gemini-key"}},
+			wantURL: "https://generativelanguage.googleapis.com/v1beta/models/gemini-1.5-flash:generateContent",
+			wantKey: "gemini-key",
+		},
+		{
+			name:    "key from query parameter",
+			url:     "https://generativelanguage.googleapis.com/v1beta/models/gemini-pro:generateContent?key=query-key",
+			header:  http.Header{},
+			wantURL: "https://generativelanguage.googleapis.com/v1beta/models/gemini-1.5-flash:generateContent",
+			wantKey: "query-key",
+		},
+		{
+			name:    "key from bearer header",
+			url:     "https://api.openai.com/v1/chat/completions",
+			header:  http.Header{"Authorization": []string{"Bearer bearer-key"}},
+			wantURL: "https://generativelanguage.googleapis.com/v1beta/models/gemini-1.5-flash:generateContent",
+			wantKey: "bearer-key",
+		},
+		{
+			name:    "streamed request",
+			url:     "https://generativelanguage.googleapis.com/v1beta/models/gemini-pro:streamGenerateContent?key=query-key",
+			header:  http.Header{},
+			wantURL: "https://generativelanguage.googleapis.com/v1beta/models/gemini-1.5-flash:streamGenerateContent?alt=sse",
+			wantKey: "query-key",
+		},
+		{
+			name:    "no key",
+			url:     "https://generativelanguage.googleapis.com/v1beta/models/gemini-pro:generateContent",
+			header:  http.Header{},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", tt.url, nil)
+			if err != nil {
+				t.Fatalf("Failed to create request: %v", err)
+			}
+			req.Header = tt.header
+
+			provider := Provider{}
+			if err := provider.UpdateURL(req, "gemini-1.5-flash", "us-central1", model.Config{}); err != nil {
+				t.Fatalf("UpdateURL() error = %v", err)
+			}
+
+			err = provider.Authenticate(context.Background(), req, "", model.Config{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Authenticate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if req.URL.String() != tt.wantURL {
+				t.Errorf("url = %q, want %q", req.URL.String(), tt.wantURL)
+			}
+			if got := req.Header.Get("x-goog-api-key"); got != tt.wantKey {
+				t.Errorf("x-goog-api-key = %q, want %q", got, tt.wantKey)
+			}
+			if got := req.Header.Get("Authorization"); got != "" {
+				t.Errorf("Authorization = %q, want empty", got)
+			}
+			if !provider.MatchesHost(req.URL.Host) {
+				t.Errorf("MatchesHost(%q) = false, want true", req.URL.Host)
+			}
+		})
+	}
+}
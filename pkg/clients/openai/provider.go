@@ -0,0 +1,87 @@
+package openai
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/Not-Diamond/go-notdiamond/pkg/http/request"
+	"github.com/Not-Diamond/go-notdiamond/pkg/http/response"
+	"github.com/Not-Diamond/go-notdiamond/pkg/model"
+)
+
+// Provider is the built-in OpenAI provider.
+type Provider struct{}
+
+// Host is the OpenAI API host.
+const Host = "api.openai.com"
+
+// MatchesHost reports whether the host is the OpenAI API host.
+func (Provider) MatchesHost(host string) bool {
+	return host == Host
+}
+
+// UpdateURL points embedding requests at the embeddings endpoint and otherwise
+// leaves the URL unchanged, OpenAI has no region-specific endpoints.
+func (Provider) UpdateURL(req *http.Request, modelName string, region string, config model.Config) error {
+	if model.Embeddings(req.Context()) && !strings.HasSuffix(req.URL.Path, "/embeddings") {
+		req.URL.Path = strings.TrimSuffix(req.URL.Path, "/chat/completions") + "/embeddings"
+		req.URL.RawPath = ""
+	}
+	return nil
+}
+
+// UsesAPIKeys reports that OpenAI requests take their key from key pools.
+func (Provider) UsesAPIKeys() bool {
+	return true
+}
+
+// Authenticate sets the bearer token header, preferring the key selected from a key pool.
+func (Provider) Authenticate(ctx context.Context, req *http.Request, region string, config model.Config) error {
+	apiKey := model.APIKey(ctx)
+	if apiKey == "" {
+		apiKey = request.ExtractAPIKey(req)
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Del("api-key")
+	req.Header.Del("x-api-key")
+	return nil
+}
+
+// EncodeRequest transforms the body to OpenAI format with the model set. Parameters
+// OpenAI has no equivalent for are handled as config.UnsupportedParams says.
+func (Provider) EncodeRequest(body []byte, modelName string, config model.Config) ([]byte, error) {
+	if err := request.CheckUnsupportedParams(request.UnsupportedOpenAIParams(body), string(model.ClientTypeOpenai), config.UnsupportedParams); err != nil {
+		return nil, err
+	}
+	return request.TransformToOpenAIRequest(body, modelName)
+}
+
+// DecodeResponse returns the body unchanged, it is already in OpenAI format.
+func (Provider) DecodeResponse(body []byte) ([]byte, error) {
+	return body, nil
+}
+
+// EncodeResponse returns the body unchanged, it is already in OpenAI format.
+func (Provider) EncodeResponse(body []byte) ([]byte, error) {
+	return body, nil
+}
+
+// DecodeStreamEvent returns the chunk unchanged, it is already in OpenAI format.
+// The [DONE] event is dropped, it is sent again once the stream has ended.
+func (Provider) DecodeStreamEvent(data []byte) ([]byte, error) {
+	if string(data) == "[DONE]" {
+		return nil, nil
+	}
+	return data, nil
+}
+
+// EncodeStreamEvent returns the chunk unchanged, it is already in OpenAI format.
+func (Provider) EncodeStreamEvent(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+// ParseError builds an error from an OpenAI error response.
+func (Provider) ParseError(statusCode int, body []byte, modelName string, region string) error {
+	return response.ParseError(statusCode, body)
+}
@@ -0,0 +1,24 @@
+package openai
+
+import "testing"
+
+func TestProviderMatchesHost(t *testing.T) {
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{host: "api.openai.com", want: true},
+		{host: "myresource.openai.azure.com", want: false},
+		{host: "status.openai.com", want: false},
+		{host: "api.openai.com.example.com", want: false},
+		{host: "notopenai.com", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			if got := (Provider{}).MatchesHost(tt.host); got != tt.want {
+				t.Errorf("MatchesHost(%q) = %v, want %v", tt.host, got, tt.want)
+			}
+		})
+	}
+}
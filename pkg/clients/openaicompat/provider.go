@@ -0,0 +1,136 @@
+// Package openaicompat provides a configurable provider for endpoints that speak
+// the OpenAI chat completions API, such as Ollama, vLLM, Together or Groq.
+package openaicompat
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Not-Diamond/go-notdiamond/pkg/http/request"
+	"github.com/Not-Diamond/go-notdiamond/pkg/http/response"
+	"github.com/Not-Diamond/go-notdiamond/pkg/model"
+)
+
+// Provider is an OpenAI-compatible provider. Register it in model.Config.Providers
+// under the prefix used in model names, e.g. "local" for "local/llama3".
+type Provider struct {
+	// Name is the prefix the provider is registered under, which unsupported
+	// parameters are reported for. clients.Registry sets it when it is empty.
+	Name string
+	// BaseURL is the API root, e.g. "http://localhost:11434/v1". Requests are sent
+	// to BaseURL + "/chat/completions".
+	BaseURL string
+	// APIKey overrides the key found on the configured client request.
+	APIKey string
+	// AuthHeader is the header carrying the key. Defaults to "Authorization",
+	// in which case the key is sent as a bearer token.
+	AuthHeader string
+}
+
+// MatchesHost reports whether the host is the host of the base URL.
+func (p Provider) MatchesHost(host string) bool {
+	u, err := url.Parse(p.BaseURL)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return host == u.Host
+}
+
+// UpdateURL points the request at the chat completions endpoint of the base URL,
+// or at its embeddings endpoint for embedding requests.
+func (p Provider) UpdateURL(req *http.Request, modelName string, region string, config model.Config) error {
+	u, err := url.Parse(p.BaseURL)
+	if err != nil {
+		return fmt.Errorf("invalid base URL %q: %w", p.BaseURL, err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid base URL %q: scheme and host are required", p.BaseURL)
+	}
+
+	req.URL.Scheme = u.Scheme
+	req.URL.Host = u.Host
+	endpoint := "/chat/completions"
+	if model.Embeddings(req.Context()) {
+		endpoint = "/embeddings"
+	}
+	req.URL.Path = strings.TrimSuffix(u.Path, "/") + endpoint
+	req.URL.RawQuery = u.RawQuery
+	req.Host = u.Host
+	return nil
+}
+
+// UsesAPIKeys reports that OpenAI-compatible requests take their key from key pools.
+func (Provider) UsesAPIKeys() bool {
+	return true
+}
+
+// Authenticate sets the configured auth header. Endpoints without a key, such as
+// a local Ollama, get no auth header at all.
+func (p Provider) Authenticate(ctx context.Context, req *http.Request, region string, config model.Config) error {
+	apiKey := model.APIKey(ctx)
+	if apiKey == "" {
+		apiKey = p.APIKey
+	}
+	if apiKey == "" && p.AuthHeader != "" && !strings.EqualFold(p.AuthHeader, "Authorization") {
+		apiKey = req.Header.Get(p.AuthHeader)
+	}
+	if apiKey == "" {
+		apiKey = request.ExtractAPIKey(req)
+	}
+
+	req.Header.Del("Authorization")
+	req.Header.Del("api-key")
+	req.Header.Del("x-api-key")
+	if apiKey == "" {
+		return nil
+	}
+
+	header := p.AuthHeader
+	if header == "" || strings.EqualFold(header, "Authorization") {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		return nil
+	}
+	req.Header.Set(header, apiKey)
+	return nil
+}
+
+// EncodeRequest transforms the body to OpenAI format with the model set. Parameters
+// OpenAI has no equivalent for are handled as config.UnsupportedParams says.
+func (p Provider) EncodeRequest(body []byte, modelName string, config model.Config) ([]byte, error) {
+	if err := request.CheckUnsupportedParams(request.UnsupportedOpenAIParams(body), p.Name, config.UnsupportedParams); err != nil {
+		return nil, err
+	}
+	return request.TransformToOpenAIRequest(body, modelName)
+}
+
+// DecodeResponse returns the body unchanged, it is already in OpenAI format.
+func (Provider) DecodeResponse(body []byte) ([]byte, error) {
+	return body, nil
+}
+
+// EncodeResponse returns the body unchanged, it is already in OpenAI format.
+func (Provider) EncodeResponse(body []byte) ([]byte, error) {
+	return body, nil
+}
+
+// DecodeStreamEvent returns the chunk unchanged, it is already in OpenAI format.
+// The [DONE] event is dropped, it is sent again once the stream has ended.
+func (Provider) DecodeStreamEvent(data []byte) ([]byte, error) {
+	if string(data) == "[DONE]" {
+		return nil, nil
+	}
+	return data, nil
+}
+
+// EncodeStreamEvent returns the chunk unchanged, it is already in OpenAI format.
+func (Provider) EncodeStreamEvent(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+// ParseError builds an error from an OpenAI-style error response.
+func (Provider) ParseError(statusCode int, body []byte, modelName string, region string) error {
+	return response.ParseError(statusCode, body)
+}
@@ -0,0 +1,112 @@
+package openaicompat
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Not-Diamond/go-notdiamond/pkg/model"
+)
+
+func TestProvider(t *testing.T) {
+	tests := []struct {
+		name        string
+		provider    Provider
+		header      http.Header
+		wantURL     string
+		wantHeaders map[string]string
+	}{
+		{
+			name:     "bearer key from client request",
+			provider: Provider{BaseURL: "https://api.groq.com/openai/v1"},
+			header:   http.Header{"Authorization": []string{"Bearer client-key"}},
+			wantURL:  "https://api.groq.com/openai/v1/chat/completions",
+			wantHeaders: map[string]string{
+				"Authorization": "Bearer client-key",
+			},
+		},
+		{
+			name:     "configured key overrides client key",
+			provider: Provider{BaseURL: "https://api.together.xyz/v1/", APIKey: "configured-key"},
+			header:   http.Header{"Authorization": []string{"Bearer client-key"}},
+			wantURL:  "https://api.together.xyz/v1/chat/completions",
+			wantHeaders: map[string]string{
+				"Authorization": "Bearer configured-key",
+			},
+		},
+		{
+			name:     "custom auth header",
+			provider: Provider{BaseURL: "http://vllm.internal:8000/v1", APIKey: "secret", AuthHeader: "X-Api-Token"},
+			header:   http.Header{"Api-Key": []string{"azure-key"}},
+			wantURL:  "http://vllm.internal:8000/v1/chat/completions",
+			wantHeaders: map[string]string{
+				"X-Api-Token":   "secret",
+				"Authorization": "",
+				"api-key":       "",
+			},
+		},
+		{
+			name:     "no key",
+			provider: Provider{BaseURL: "http://localhost:11434/v1"},
+			header:   http.Header{},
+			wantURL:  "http://localhost:11434/v1/chat/completions",
+			wantHeaders: map[string]string{
+				"Authorization": "",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", nil)
+			if err != nil {
+				t.Fatalf("Failed to create request: %v", err)
+			}
+			req.Header = tt.header
+
+			if err := tt.provider.UpdateURL(req, "llama3", "", model.Config{}); err != nil {
+				t.Fatalf("UpdateURL() error = %v", err)
+			}
+			if req.URL.String() != tt.wantURL {
+				t.Errorf("UpdateURL() url = %q, want %q", req.URL.String(), tt.wantURL)
+			}
+			if !tt.provider.MatchesHost(req.URL.Host) {
+				t.Errorf("MatchesHost(%q) = false, want true", req.URL.Host)
+			}
+
+			if err := tt.provider.Authenticate(context.Background(), req, "", model.Config{}); err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+			for key, want := range tt.wantHeaders {
+				if got := req.Header.Get(key); got != want {
+					t.Errorf("Authenticate() header %s = %q, want %q", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestProviderInvalidBaseURL(t *testing.T) {
+	provider := Provider{BaseURL: "localhost:11434"}
+	req, _ := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", nil)
+
+	if err := provider.UpdateURL(req, "llama3", "", model.Config{}); err == nil {
+		t.Error("UpdateURL() expected error for base URL without scheme")
+	}
+	if provider.MatchesHost("api.openai.com") {
+		t.Error("MatchesHost() matched an unrelated host")
+	}
+}
+
+func TestProviderEmbeddingsURL(t *testing.T) {
+	provider := Provider{BaseURL: "http://localhost:11434/v1"}
+	req, _ := http.NewRequest("POST", "https://api.openai.com/v1/embeddings", nil)
+	req = req.WithContext(model.WithEmbeddings(req.Context()))
+
+	if err := provider.UpdateURL(req, "nomic-embed-text", "", model.Config{}); err != nil {
+		t.Fatalf("UpdateURL() error = %v", err)
+	}
+	if want := "http://localhost:11434/v1/embeddings"; req.URL.String() != want {
+		t.Errorf("UpdateURL() url = %q, want %q", req.URL.String(), want)
+	}
+}
@@ -0,0 +1,99 @@
+package vertex
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// Scope is the OAuth scope requested for Vertex AI.
+const Scope = "https://www.googleapis.com/auth/cloud-platform"
+
+// sharedTokenSources holds one token source per credentials file, the empty
+// path being the application default credentials.
+var (
+	sharedTokenSourcesMu sync.Mutex
+	sharedTokenSources   = make(map[string]oauth2.TokenSource)
+)
+
+// NewTokenSource returns a token source for the service-account JSON file, or for
+// the application default credentials if the path is empty. Credentials are looked
+// up on the first Token call and tokens are cached until shortly before they expire.
+func NewTokenSource(credentialsFile string) oauth2.TokenSource {
+	if credentialsFile != "" {
+		return &fileTokenSource{credentialsFile: credentialsFile}
+	}
+	return &lazyTokenSource{}
+}
+
+// sharedTokenSource returns the process-wide token source for the credentials file.
+func sharedTokenSource(credentialsFile string) oauth2.TokenSource {
+	sharedTokenSourcesMu.Lock()
+	defer sharedTokenSourcesMu.Unlock()
+
+	tokenSource, ok := sharedTokenSources[credentialsFile]
+	if !ok {
+		tokenSource = NewTokenSource(credentialsFile)
+		sharedTokenSources[credentialsFile] = tokenSource
+	}
+	return tokenSource
+}
+
+// fileTokenSource reads and parses the service-account file on first use and
+// reuses the resulting token source for every later call. A file that can't be
+// read or parsed yet, e.g. a secret that is still being mounted, is retried on
+// the next call instead of being cached.
+type fileTokenSource struct {
+	mu              sync.Mutex
+	credentialsFile string
+	tokenSource     oauth2.TokenSource
+}
+
+func (s *fileTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	if s.tokenSource == nil {
+		data, err := os.ReadFile(s.credentialsFile)
+		if err != nil {
+			s.mu.Unlock()
+			return nil, fmt.Errorf("error reading credentials file: %w", err)
+		}
+		credentials, err := google.CredentialsFromJSON(context.Background(), data, Scope)
+		if err != nil {
+			s.mu.Unlock()
+			return nil, fmt.Errorf("error parsing credentials file: %w", err)
+		}
+		s.tokenSource = oauth2.ReuseTokenSource(nil, credentials.TokenSource)
+	}
+	tokenSource := s.tokenSource
+	s.mu.Unlock()
+
+	return tokenSource.Token()
+}
+
+// lazyTokenSource resolves the application default credentials on first use. A
+// failed lookup, such as an unreachable metadata server, is retried on the next
+// call instead of being cached.
+type lazyTokenSource struct {
+	mu          sync.Mutex
+	tokenSource oauth2.TokenSource
+}
+
+func (s *lazyTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	if s.tokenSource == nil {
+		credentials, err := findDefaultCredentials(context.Background(), Scope)
+		if err != nil {
+			s.mu.Unlock()
+			return nil, fmt.Errorf("error getting credentials: %w", err)
+		}
+		s.tokenSource = oauth2.ReuseTokenSource(nil, credentials.TokenSource)
+	}
+	tokenSource := s.tokenSource
+	s.mu.Unlock()
+
+	return tokenSource.Token()
+}
@@ -0,0 +1,163 @@
+package vertex
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Not-Diamond/go-notdiamond/pkg/model"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// mockTokenSource implements oauth2.TokenSource for testing
+type mockTokenSource struct {
+	calls int
+}
+
+func (m *mockTokenSource) Token() (*oauth2.Token, error) {
+	m.calls++
+	return &oauth2.Token{
+		AccessToken: "test-token",
+		TokenType:   "Bearer",
+		Expiry:      time.Now().Add(time.Hour),
+	}, nil
+}
+
+func TestNewTokenSourceDefaultCredentials(t *testing.T) {
+	lookups := 0
+	tokenSource := &mockTokenSource{}
+	lookupErr := errors.New("metadata server unavailable")
+
+	originalFindDefaultCredentials := findDefaultCredentials
+	findDefaultCredentials = func(ctx context.Context, scopes ...string) (*google.Credentials, error) {
+		lookups++
+		// The first lookup fails, which must not be cached
+		if lookups == 1 {
+			return nil, lookupErr
+		}
+		return &google.Credentials{TokenSource: tokenSource}, nil
+	}
+	defer func() { findDefaultCredentials = originalFindDefaultCredentials }()
+
+	source := NewTokenSource("")
+	if _, err := source.Token(); !errors.Is(err, lookupErr) {
+		t.Fatalf("Token() error = %v, want %v", err, lookupErr)
+	}
+
+	for i := 0; i < 3; i++ {
+		token, err := source.Token()
+		if err != nil {
+			t.Fatalf("Token() error = %v", err)
+		}
+		if token.AccessToken != "test-token" {
+			t.Errorf("Token() = %q, want %q", token.AccessToken, "test-token")
+		}
+	}
+
+	if lookups != 2 {
+		t.Errorf("credentials looked up %d times, want 2", lookups)
+	}
+	if tokenSource.calls != 1 {
+		t.Errorf("token fetched %d times, want 1", tokenSource.calls)
+	}
+}
+
+func TestNewTokenSourceCredentialsFile(t *testing.T) {
+	tokenRequests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenRequests++
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("failed to parse form: %v", err)
+		}
+		if got := r.PostForm.Get("grant_type"); got != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+			t.Errorf("grant_type = %q", got)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "sa-token", "token_type": "Bearer", "expires_in": 3600}`))
+	}))
+	defer server.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	serviceAccount, _ := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "test-project",
+		"private_key_id": "key-id",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})),
+		"client_email":   "test@test-project.iam.gserviceaccount.com",
+		"token_uri":      server.URL,
+	})
+	credentialsFile := filepath.Join(t.TempDir(), "service-account.json")
+	if err := os.WriteFile(credentialsFile, serviceAccount, 0o600); err != nil {
+		t.Fatalf("failed to write credentials file: %v", err)
+	}
+
+	source := NewTokenSource(credentialsFile)
+	for i := 0; i < 2; i++ {
+		token, err := source.Token()
+		if err != nil {
+			t.Fatalf("Token() error = %v", err)
+		}
+		if token.AccessToken != "sa-token" {
+			t.Errorf("Token() = %q, want %q", token.AccessToken, "sa-token")
+		}
+	}
+	if tokenRequests != 1 {
+		t.Errorf("token endpoint called %d times, want 1", tokenRequests)
+	}
+
+	// The file is read once, so replacing it does not affect the token source
+	if err := os.WriteFile(credentialsFile, []byte("not json"), 0o600); err != nil {
+		t.Fatalf("failed to overwrite credentials file: %v", err)
+	}
+	if _, err := source.Token(); err != nil {
+		t.Errorf("Token() error = %v after credentials file changed", err)
+	}
+
+	// A file that is missing at first is read once it appears
+	missingFile := filepath.Join(t.TempDir(), "missing.json")
+	missing := NewTokenSource(missingFile)
+	if _, err := missing.Token(); err == nil {
+		t.Error("Token() expected error for missing credentials file")
+	}
+	if err := os.WriteFile(missingFile, serviceAccount, 0o600); err != nil {
+		t.Fatalf("failed to write credentials file: %v", err)
+	}
+	if token, err := missing.Token(); err != nil || token.AccessToken != "sa-token" {
+		t.Errorf("Token() = %v, %v after credentials file was written, want sa-token", token, err)
+	}
+}
+
+func TestProviderAuthenticate(t *testing.T) {
+	req, err := NewRequest("test-project", "us-central1")
+	if err != nil {
+		t.Fatalf("NewRequest() error = %v", err)
+	}
+
+	config := model.Config{
+		VertexTokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "configured-token"}),
+	}
+	if err := (Provider{}).Authenticate(context.Background(), req, "us-central1", config); err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+	if got := req.Header.Get("Authorization"); got != "Bearer configured-token" {
+		t.Errorf("Authorization = %q, want %q", got, "Bearer configured-token")
+	}
+}
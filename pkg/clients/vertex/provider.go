@@ -0,0 +1,235 @@
+package vertex
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/Not-Diamond/go-notdiamond/pkg/http/request"
+	"github.com/Not-Diamond/go-notdiamond/pkg/http/response"
+	"github.com/Not-Diamond/go-notdiamond/pkg/model"
+)
+
+// Provider is the built-in Vertex AI provider.
+type Provider struct{}
+
+// MatchesHost reports whether the host is a Vertex AI host.
+func (Provider) MatchesHost(host string) bool {
+	return strings.Contains(host, "aiplatform.googleapis.com")
+}
+
+// IsStreaming reports whether the request asks for a streamed response, either
+// through its context or by already calling streamGenerateContent.
+func IsStreaming(req *http.Request) bool {
+	return model.Streaming(req.Context()) || strings.HasSuffix(req.URL.Path, ":streamGenerateContent")
+}
+
+// IsEmbeddings reports whether the request asks for embeddings, either through its
+// context or by already calling predict.
+func IsEmbeddings(req *http.Request) bool {
+	return model.Embeddings(req.Context()) || strings.HasSuffix(req.URL.Path, ":predict")
+}
+
+// SetMethod points the model path of the request at streamGenerateContent with
+// SSE output if stream is set, or at generateContent otherwise.
+func SetMethod(req *http.Request, stream bool) {
+	path := req.URL.Path
+	if i := strings.LastIndex(path, ":"); i > strings.LastIndex(path, "/") {
+		path = path[:i]
+	}
+
+	query := req.URL.Query()
+	if stream {
+		req.URL.Path = path + ":streamGenerateContent"
+		query.Set("alt", "sse")
+	} else {
+		req.URL.Path = path + ":generateContent"
+		query.Del("alt")
+	}
+	req.URL.RawPath = ""
+	req.URL.RawQuery = query.Encode()
+}
+
+// setPredict points the model path of the request at predict, which serves
+// embedding models.
+func setPredict(req *http.Request) {
+	path := req.URL.Path
+	if i := strings.LastIndex(path, ":"); i > strings.LastIndex(path, "/") {
+		path = path[:i]
+	}
+
+	query := req.URL.Query()
+	query.Del("alt")
+	req.URL.Path = path + ":predict"
+	req.URL.RawPath = ""
+	req.URL.RawQuery = query.Encode()
+}
+
+// Regional reports that Vertex AI models are served from regions.
+func (Provider) Regional() bool {
+	return true
+}
+
+// UpdateURL points the request at the regional Vertex AI endpoint for the model,
+// calling streamGenerateContent for streamed requests and predict for embeddings.
+// The region falls back to Config.VertexLocation.
+func (Provider) UpdateURL(req *http.Request, modelName string, region string, config model.Config) error {
+	projectID := config.VertexProjectID
+	stream := IsStreaming(req)
+	embeddings := IsEmbeddings(req)
+
+	// Check if project ID is valid
+	if projectID == "" {
+		return fmt.Errorf("vertex project ID is not set in the configuration")
+	}
+
+	// Log the project ID being used
+	slog.Info("🔄 Using project ID for Vertex AI", "project_id", projectID)
+
+	// Use specified region or fall back to config location
+	location := config.VertexLocation
+	if region != "" {
+		location = region
+	}
+
+	// Log the location being used
+	slog.Info("🔄 Using location for Vertex AI", "location", location)
+
+	// Update the host with the region
+	req.URL.Host = fmt.Sprintf("%s-aiplatform.googleapis.com", location)
+	req.URL.Scheme = "https"
+
+	// Check if the path already contains a location and replace it
+	path := req.URL.Path
+	if strings.Contains(path, "/locations/") {
+		// Extract the existing path components
+		pathParts := strings.Split(path, "/")
+		for i, part := range pathParts {
+			if part == "locations" && i+1 < len(pathParts) {
+				// Replace the location in the path
+				oldLocation := pathParts[i+1]
+				pathParts[i+1] = location
+				slog.Info("🔄 Replaced location in path", "old_location", oldLocation, "new_location", location)
+			}
+			if part == "models" && i+1 < len(pathParts) && modelName != "" {
+				// Replace the model in the path, keeping its method
+				method := ""
+				if j := strings.LastIndex(pathParts[i+1], ":"); j >= 0 {
+					method = pathParts[i+1][j:]
+				}
+				pathParts[i+1] = modelName + method
+			}
+		}
+		path = strings.Join(pathParts, "/")
+		req.URL.Path = path
+	} else {
+		// If path doesn't already have a location, construct a new path
+		newPath := fmt.Sprintf("/v1beta1/projects/%s/locations/%s/publishers/google/models/%s:generateContent",
+			projectID, location, modelName)
+		slog.Info("🔄 Constructed new path", "project_id", projectID, "location", location, "model", modelName)
+		req.URL.Path = newPath
+	}
+	if embeddings {
+		setPredict(req)
+	} else {
+		SetMethod(req, stream)
+	}
+
+	slog.Info("🔄 Updated Vertex URL", "host", req.URL.Host, "path", req.URL.Path)
+	return nil
+}
+
+// Authenticate sets a bearer token from Config.VertexTokenSource, or from a shared
+// token source for Config.VertexCredentialsFile or the application default credentials.
+func (Provider) Authenticate(ctx context.Context, req *http.Request, region string, config model.Config) error {
+	tokenSource := config.VertexTokenSource
+	if tokenSource == nil {
+		tokenSource = sharedTokenSource(config.VertexCredentialsFile)
+	}
+	token, err := tokenSource.Token()
+	if err != nil {
+		return fmt.Errorf("error getting token: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	return nil
+}
+
+// EncodeRequest transforms the body to Vertex AI format. Parameters Vertex AI has
+// no equivalent for are handled as config.UnsupportedParams says.
+func (Provider) EncodeRequest(body []byte, modelName string, config model.Config) ([]byte, error) {
+	if request.IsAnthropicPayload(body) {
+		transformed, err := request.TransformFromAnthropicToOpenAI(body)
+		if err != nil {
+			return nil, err
+		}
+		body = transformed
+	}
+	if err := request.CheckUnsupportedParams(request.UnsupportedVertexParams(body), string(model.ClientTypeVertex), config.UnsupportedParams); err != nil {
+		return nil, err
+	}
+	return request.TransformToVertexRequest(body, modelName)
+}
+
+// DecodeResponse transforms a Vertex AI response to OpenAI format.
+func (Provider) DecodeResponse(body []byte) ([]byte, error) {
+	return request.TransformFromVertexResponse(body)
+}
+
+// EncodeResponse transforms an OpenAI response to Vertex AI format.
+func (Provider) EncodeResponse(body []byte) ([]byte, error) {
+	return request.TransformToVertexResponse(body)
+}
+
+// DecodeStreamEvent transforms a streamed Vertex AI response to an OpenAI chunk.
+func (Provider) DecodeStreamEvent(data []byte) ([]byte, error) {
+	return request.TransformFromVertexStreamEvent(data)
+}
+
+// EncodeStreamEvent transforms an OpenAI chunk to a streamed Vertex AI response.
+// Vertex AI streams end without a final event.
+func (Provider) EncodeStreamEvent(data []byte) ([]byte, error) {
+	if string(data) == "[DONE]" {
+		return nil, nil
+	}
+	return request.TransformToVertexStreamEvent(data)
+}
+
+// NewStream returns the translator of a single stream, which numbers tool calls
+// across the stream and collects tool call arguments streamed in fragments.
+func (Provider) NewStream() model.StreamProvider {
+	return &stream{
+		decoder: request.NewVertexStreamDecoder(),
+		encoder: request.NewVertexStreamEncoder(),
+	}
+}
+
+// stream translates the events of a single Vertex AI stream.
+type stream struct {
+	decoder *request.VertexStreamDecoder
+	encoder *request.VertexStreamEncoder
+}
+
+func (s *stream) DecodeStreamEvent(data []byte) ([]byte, error) {
+	return s.decoder.Decode(data)
+}
+
+// EncodeStreamEvent sends the tool calls still collected at the end of the stream.
+func (s *stream) EncodeStreamEvent(data []byte) ([]byte, error) {
+	return s.encoder.Encode(data)
+}
+
+// ParseError builds an error from a Vertex AI error response. A 404 usually
+// means the model is not available in the requested region or project.
+func (Provider) ParseError(statusCode int, body []byte, modelName string, region string) error {
+	if statusCode == http.StatusNotFound && region != "" {
+		return fmt.Errorf("region %s not found for model %s: %d %s",
+			region, modelName, statusCode, http.StatusText(statusCode))
+	}
+	if statusCode == http.StatusNotFound {
+		return fmt.Errorf("model or project not found: %d %s",
+			statusCode, http.StatusText(statusCode))
+	}
+	return response.ParseError(statusCode, body)
+}
@@ -0,0 +1,45 @@
+package vertex
+
+import "testing"
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		body       string
+		modelName  string
+		region     string
+		want       string
+	}{
+		{
+			name:       "model not found in region",
+			statusCode: 404,
+			modelName:  "gemini-pro",
+			region:     "europe-west9",
+			want:       "region europe-west9 not found for model gemini-pro: 404 Not Found",
+		},
+		{
+			name:       "model not found without region",
+			statusCode: 404,
+			modelName:  "gemini-pro",
+			want:       "model or project not found: 404 Not Found",
+		},
+		{
+			name:       "other error",
+			statusCode: 400,
+			body:       `{"error": {"message": "Invalid argument"}}`,
+			modelName:  "gemini-pro",
+			region:     "us-central1",
+			want:       "with status 400 (Bad Request): Invalid argument",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Provider{}.ParseError(tt.statusCode, []byte(tt.body), tt.modelName, tt.region)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("ParseError() = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
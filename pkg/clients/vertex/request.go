@@ -1,7 +1,6 @@
 package vertex
 
 import (
-	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -30,23 +29,9 @@ func NewRequest(projectID string, location string) (*http.Request, error) {
 		return nil, err
 	}
 
-	// Set default headers
+	// Set default headers. The bearer token is added per request from the
+	// configured token source, so it never goes stale on the template request.
 	req.Header.Set("Content-Type", "application/json")
 
-	// Get credentials from the environment
-	ctx := context.Background()
-	credentials, err := findDefaultCredentials(ctx, "https://www.googleapis.com/auth/cloud-platform")
-	if err != nil {
-		return nil, fmt.Errorf("error getting credentials: %w", err)
-	}
-
-	// Get an OAuth2 token
-	token, err := credentials.TokenSource.Token()
-	if err != nil {
-		return nil, fmt.Errorf("error getting token: %w", err)
-	}
-
-	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
-
 	return req, nil
 }
@@ -1,39 +1,11 @@
 package vertex
 
 import (
-	"context"
 	"strings"
 	"testing"
-	"time"
-
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
 )
 
-// mockTokenSource implements oauth2.TokenSource for testing
-type mockTokenSource struct{}
-
-func (m *mockTokenSource) Token() (*oauth2.Token, error) {
-	return &oauth2.Token{
-		AccessToken: "test-token",
-		TokenType:   "Bearer",
-		Expiry:      time.Now().Add(time.Hour),
-	}, nil
-}
-
-// mockFindDefaultCredentials is our test replacement for google.FindDefaultCredentials
-func mockFindDefaultCredentials(ctx context.Context, scopes ...string) (*google.Credentials, error) {
-	return &google.Credentials{
-		TokenSource: &mockTokenSource{},
-	}, nil
-}
-
 func TestNewRequest(t *testing.T) {
-	// Override the default credentials function with our mock
-	originalFindDefaultCredentials := findDefaultCredentials
-	findDefaultCredentials = mockFindDefaultCredentials
-	defer func() { findDefaultCredentials = originalFindDefaultCredentials }()
-
 	tests := []struct {
 		name        string
 		projectID   string
@@ -99,10 +71,9 @@ func TestNewRequest(t *testing.T) {
 				t.Errorf("Expected Content-Type header to be 'application/json', got %q", contentType)
 			}
 
-			auth := req.Header.Get("Authorization")
-			expectedAuth := "Bearer test-token"
-			if auth != expectedAuth {
-				t.Errorf("Expected Authorization header to be %q, got %q", expectedAuth, auth)
+			// Tokens are set per request, not baked into the template
+			if auth := req.Header.Get("Authorization"); auth != "" {
+				t.Errorf("Expected Authorization header to be empty, got %q", auth)
 			}
 
 			// Verify request method
@@ -3,7 +3,7 @@ package http_client
 import (
 	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -11,13 +11,16 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/Not-Diamond/go-notdiamond/pkg/clients"
 	"github.com/Not-Diamond/go-notdiamond/pkg/http/request"
+	"github.com/Not-Diamond/go-notdiamond/pkg/http/response"
+	"github.com/Not-Diamond/go-notdiamond/pkg/keypool"
 	"github.com/Not-Diamond/go-notdiamond/pkg/metric"
 	"github.com/Not-Diamond/go-notdiamond/pkg/model"
 	"github.com/Not-Diamond/go-notdiamond/pkg/validation"
-	"golang.org/x/oauth2/google"
 )
 
 type Client struct {
@@ -37,6 +40,9 @@ type NotDiamondHttpClient struct {
 	*http.Client
 	Config         model.Config
 	MetricsTracker *metric.Tracker
+
+	keyPoolsOnce sync.Once
+	keyPools     *keypool.Pools
 }
 
 // NewNotDiamondHttpClient creates a new NotDiamond HTTP client.
@@ -70,40 +76,27 @@ func (c *NotDiamondHttpClient) Do(req *http.Request) (*http.Response, error) {
 	if err != nil {
 		return nil, err
 	}
-
-	// Create a new reader for each operation that needs the body
-	req.Body = io.NopCloser(bytes.NewBuffer(body))
-	messages := request.ExtractMessagesFromRequest(req.Clone(req.Context()))
-
-	req.Body = io.NopCloser(bytes.NewBuffer(body))
-	extractedModel, err := request.ExtractModelFromRequest(req.Clone(req.Context()))
-	if err != nil {
-		return nil, fmt.Errorf("failed to extract model: %w", err)
-	}
-
 	req.Body = io.NopCloser(bytes.NewBuffer(body))
-	extractedProvider := request.ExtractProviderFromRequest(req.Clone(req.Context()))
 
-	// Parse model and region if present
-	modelParts := strings.Split(extractedModel, "/")
-	baseModel := modelParts[0]
-	region := ""
-	if len(modelParts) > 1 {
-		region = modelParts[1]
-	}
+	client, _ := req.Context().Value(ClientKey).(*Client)
 
-	// Construct the current model string
-	var currentModel string
-	if region != "" {
-		currentModel = extractedProvider + "/" + baseModel + "/" + region
-		slog.Info("🔍 Using model with region", "model", currentModel)
-	} else {
-		currentModel = extractedProvider + "/" + baseModel
-		slog.Info("🔍 Using model without region", "model", currentModel)
+	// The transport has parsed the request already, unless the body changed since
+	env, ok := request.EnvelopeFromContext(req.Context())
+	if !ok || !bytes.Equal(env.Body, body) {
+		if env, err = client.parseEnvelope(req, body); err != nil {
+			return nil, err
+		}
 	}
+	// Remember the provider, the URL is rewritten in place when switching regions
+	req = model.WithProvider(req, env.Provider)
 
-	// Restore the original body for future operations
-	req.Body = io.NopCloser(bytes.NewBuffer(body))
+	// The model can name another provider than the caller's, e.g. OpenAI models
+	// serving an Anthropic caller
+	modelProvider := env.ModelProvider
+	baseModel := env.Model
+	region := env.Region
+	currentModel := env.RequestedModel()
+	slog.Info("🔍 Using model", "model", currentModel)
 
 	var lastErr error
 	originalCtx := req.Context()
@@ -111,25 +104,17 @@ func (c *NotDiamondHttpClient) Do(req *http.Request) (*http.Response, error) {
 	if client, ok := originalCtx.Value(ClientKey).(*Client); ok {
 		var modelsToTry []string
 
-		// Read and preserve the original request body
-		originalBody, err := io.ReadAll(req.Body)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read original request body: %v", err)
-		}
-		// Restore the body for future reads
-		req.Body = io.NopCloser(bytes.NewBuffer(originalBody))
-
 		if client.IsOrdered {
 			modelsToTry = client.Models.(model.OrderedModels)
 			// Validate that requested model is in the configured list
 			modelExists := false
 
 			// Check if the model (without region) exists in the configured list
-			baseCurrentModel := extractedProvider + "/" + baseModel
+			baseCurrentModel := modelProvider + "/" + baseModel
 			for _, m := range modelsToTry {
 				// Strip region from configured model if present
-				configModelParts := strings.Split(m, "/")
-				configBaseModel := configModelParts[0] + "/" + configModelParts[1]
+				configProvider, configModel, _ := c.providers().SplitModel(m)
+				configBaseModel := configProvider + "/" + configModel
 
 				if configBaseModel == baseCurrentModel {
 					modelExists = true
@@ -167,16 +152,16 @@ func (c *NotDiamondHttpClient) Do(req *http.Request) (*http.Response, error) {
 			regionSpecificModels := []string{}
 
 			// For the current model, add region-specific versions at the front
-			if extractedProvider == "vertex" {
+			if modelProvider == "vertex" {
 				// Default to us-east1 if no region is specified
-				regionSpecificModels = append(regionSpecificModels, extractedProvider+"/"+baseModel+"/us-east1")
-			} else if extractedProvider == "azure" {
+				regionSpecificModels = append(regionSpecificModels, modelProvider+"/"+baseModel+"/us-east1")
+			} else if modelProvider == "azure" {
 				// Default to eastus for Azure
-				regionSpecificModels = append(regionSpecificModels, extractedProvider+"/"+baseModel+"/eastus")
+				regionSpecificModels = append(regionSpecificModels, modelProvider+"/"+baseModel+"/eastus")
 			}
 
 			// Add the base model without region
-			regionSpecificModels = append(regionSpecificModels, extractedProvider+"/"+baseModel)
+			regionSpecificModels = append(regionSpecificModels, modelProvider+"/"+baseModel)
 
 			// Add models from config
 			for _, m := range modelsToTry {
@@ -197,14 +182,24 @@ func (c *NotDiamondHttpClient) Do(req *http.Request) (*http.Response, error) {
 			modelsToTry = regionSpecificModels
 		}
 
+		if env.Embeddings {
+			modelsToTry = embeddingFallbacks(modelsToTry, env, c.Config)
+		}
+		if env.Stream {
+			modelsToTry = streamFallbacks(modelsToTry, env.Provider, c.providers())
+			if len(modelsToTry) == 0 {
+				lastErr = fmt.Errorf("no configured model can stream for %s callers", env.Provider)
+			}
+		}
+
 		slog.Info("🔄 Models to try (in order)", "models", strings.Join(modelsToTry, ", "))
 
 		for _, modelFull := range modelsToTry {
 			// Reset the request body for each attempt
-			req.Body = io.NopCloser(bytes.NewBuffer(originalBody))
+			req.Body = io.NopCloser(bytes.NewBuffer(env.Body))
 
 			slog.Info("🔍 Trying model", "model", modelFull)
-			if resp, err := c.tryWithRetries(modelFull, req, messages, originalCtx); err == nil {
+			if resp, err := c.tryWithRetries(modelFull, req, env, originalCtx); err == nil {
 				return resp, nil
 			} else {
 				lastErr = err
@@ -219,6 +214,60 @@ func (c *NotDiamondHttpClient) Do(req *http.Request) (*http.Response, error) {
 	return nil, fmt.Errorf("all requests failed: %v", lastErr)
 }
 
+// embeddingFallbacks drops the models whose embeddings differ in size from the
+// requested model's, vectors of different sizes can't be compared. If the request
+// asks for dimensions, models of at least that size are kept, they shorten their
+// vectors. Sizes come from Config.EmbeddingDimensions, models of unknown size are
+// kept. Config.AllowEmbeddingDimensionMismatch keeps all models.
+func embeddingFallbacks(models []string, env *request.Envelope, config model.Config) []string {
+	if config.AllowEmbeddingDimensionMismatch {
+		return models
+	}
+
+	requested, ok := env.EmbeddingDimensions()
+	shortened := ok
+	if !ok {
+		if requested, ok = config.EmbeddingDimensions[env.ModelProvider+"/"+env.Model]; !ok {
+			return models
+		}
+	}
+
+	var fallbacks []string
+	for _, modelFull := range models {
+		provider, modelName, _ := clients.Registry(config).SplitModel(modelFull)
+		size, known := config.EmbeddingDimensions[provider+"/"+modelName]
+		if known && (size < requested || (!shortened && size != requested)) {
+			slog.Warn("⚠️ Skipping embedding model of another size", "model", modelFull, "dimensions", size, "requested_dimensions", requested)
+			continue
+		}
+		fallbacks = append(fallbacks, modelFull)
+	}
+	return fallbacks
+}
+
+// streamFallbacks drops the models whose streams can't be translated for the
+// caller's provider. A stream is committed to the caller before any of it is read,
+// so a model that can only answer in another format must not be tried at all.
+func streamFallbacks(models []string, callerProvider string, providers model.Providers) []string {
+	var fallbacks []string
+	for _, modelFull := range models {
+		if _, _, err := streamProviders(callerProvider, modelFull, providers); err != nil {
+			slog.Warn("⚠️ Skipping model for streamed request", "model", modelFull, "error", err)
+			continue
+		}
+		fallbacks = append(fallbacks, modelFull)
+	}
+	return fallbacks
+}
+
+// apiKeyPools returns the key pools of the configuration, created on first use.
+func (c *NotDiamondHttpClient) apiKeyPools() *keypool.Pools {
+	c.keyPoolsOnce.Do(func() {
+		c.keyPools = keypool.NewPools(c.Config.KeyPools)
+	})
+	return c.keyPools
+}
+
 // getMaxRetriesForStatus gets the maximum retries for a status code.
 func (c *NotDiamondHttpClient) getMaxRetriesForStatus(modelFull string, statusCode int) int {
 	// Check model-specific status code retries first
@@ -244,8 +293,9 @@ func (c *NotDiamondHttpClient) getMaxRetriesForStatus(modelFull string, statusCo
 	return 1
 }
 
-// tryWithRetries tries a request with retries.
-func (c *NotDiamondHttpClient) tryWithRetries(modelFull string, req *http.Request, messages []model.Message, originalCtx context.Context) (*http.Response, error) {
+// tryWithRetries tries a request with retries. Every attempt sends the body of the
+// parsed request again.
+func (c *NotDiamondHttpClient) tryWithRetries(modelFull string, req *http.Request, env *request.Envelope, originalCtx context.Context) (*http.Response, error) {
 	var lastErr error
 	var lastStatusCode int
 
@@ -264,13 +314,21 @@ func (c *NotDiamondHttpClient) tryWithRetries(modelFull string, req *http.Reques
 	}
 	slog.Info("✅ Initial health check passed", "model", modelFull)
 
+	// Requests retried with the next key of the pool don't count as retries
+	poolProvider, _, poolRegion := c.providers().SplitModel(modelFull)
+	pool := c.apiKeyPools().For(poolProvider, poolRegion)
+	rotations := 0
+
+	streaming := env.Stream
+
 	for attempt := 0; ; attempt++ {
 		maxRetries := c.getMaxRetriesForStatus(modelFull, lastStatusCode)
-		if attempt >= maxRetries {
+		if attempt-rotations >= maxRetries {
 			break
 		}
 
-		slog.Info(fmt.Sprintf("🔄 Request %d of %d for model %s", attempt+1, maxRetries, modelFull))
+		slog.Info(fmt.Sprintf("🔄 Request %d of %d for model %s", attempt-rotations+1, maxRetries, modelFull))
+		req.Body = io.NopCloser(bytes.NewBuffer(env.Body))
 
 		timeout := 100.0
 		if t, ok := c.Config.Timeout[modelFull]; ok && t > 0 {
@@ -278,30 +336,42 @@ func (c *NotDiamondHttpClient) tryWithRetries(modelFull string, req *http.Reques
 		}
 
 		ctx, cancel := context.WithTimeout(originalCtx, time.Duration(timeout*float64(time.Second)))
-		defer cancel()
+		if streaming {
+			ctx = model.WithStreaming(ctx)
+		}
+		if env.Embeddings {
+			ctx = model.WithEmbeddings(ctx)
+		}
+		// A streamed response keeps the context until the caller is done with it
+		streamed := false
+		defer func() {
+			if !streamed {
+				cancel()
+			}
+		}()
+
+		var apiKey string
+		if pool != nil {
+			key, err := pool.Next()
+			if err != nil {
+				return nil, fmt.Errorf("no API key available for model %s: %w", modelFull, err)
+			}
+			apiKey = key
+			ctx = model.WithAPIKey(ctx, apiKey)
+		}
 
 		startTime := time.Now()
 		var resp *http.Response
 		var reqErr error
 
 		if attempt == 0 {
+			client, _ := originalCtx.Value(ClientKey).(*Client)
+
 			// We only need the provider from the request
-			extractedProvider := request.ExtractProviderFromRequest(req)
+			extractedProvider := env.Provider
 
 			// Extract parts from modelFull (provider/model/region)
-			modelFullParts := strings.Split(modelFull, "/")
-			modelFullProvider := modelFullParts[0]
-			modelFullBase := ""
-			if len(modelFullParts) > 1 {
-				modelFullBase = modelFullParts[1]
-			}
-
-			// Read and preserve the original request body
-			originalBody, err := io.ReadAll(req.Body)
-			if err != nil {
-				return nil, err
-			}
-			req.Body = io.NopCloser(bytes.NewBuffer(originalBody))
+			modelFullProvider, modelFullBase, modelFullRegion := c.providers().SplitModel(modelFull)
 
 			// Log the original request URL before any modifications
 			slog.Info("🔄 Original request URL", "url", req.URL.String())
@@ -310,60 +380,13 @@ func (c *NotDiamondHttpClient) tryWithRetries(modelFull string, req *http.Reques
 			if modelFullProvider != extractedProvider {
 				slog.Info("🔄 Switching provider", "from", extractedProvider, "to", modelFullProvider)
 
-				// Get client from context
-				if client, ok := originalCtx.Value(ClientKey).(*Client); ok {
-					// Find the appropriate client request for the new provider
-					var foundClientReq *http.Request
-					for _, clientReq := range client.Clients {
-						url := clientReq.URL.String()
-						switch modelFullProvider {
-						case "vertex":
-							if strings.Contains(url, "aiplatform.googleapis.com") {
-								foundClientReq = &clientReq
-								break
-							}
-						case "azure":
-							if strings.Contains(url, "azure") {
-								foundClientReq = &clientReq
-								break
-							}
-						case "openai":
-							if strings.Contains(url, "openai.com") {
-								foundClientReq = &clientReq
-								break
-							}
-						}
-					}
-
-					if foundClientReq != nil {
-						// Create a new request with the appropriate URL and headers
-						newReq := foundClientReq.Clone(ctx)
-
-						// Transform the request body for the new provider
-						transformedBody, err := transformRequestForProvider(originalBody, modelFullProvider, modelFullBase, client)
-						if err != nil {
-							return nil, fmt.Errorf("failed to transform request body: %w", err)
-						}
-
-						newReq.Body = io.NopCloser(bytes.NewBuffer(transformedBody))
-						newReq.ContentLength = int64(len(transformedBody))
-
-						// Update the URL to include the region if present
-						if len(modelFullParts) > 2 && modelFullParts[2] != "" {
-							if err := updateRequestURL(newReq, modelFullProvider, modelFullBase+"/"+modelFullParts[2], client); err != nil {
-								return nil, fmt.Errorf("failed to update URL with region: %w", err)
-							}
-						} else {
-							if err := updateRequestURL(newReq, modelFullProvider, modelFullBase, client); err != nil {
-								return nil, fmt.Errorf("failed to update URL: %w", err)
-							}
-						}
-
-						// Update authentication
-						if err := updateRequestAuth(newReq, modelFullProvider, ctx); err != nil {
-							return nil, fmt.Errorf("failed to update authentication: %w", err)
-						}
-
+				if client != nil {
+					newReq, err := client.newProviderRequest(ctx, modelFullProvider, modelFullBase, modelFullRegion, env)
+					if errors.Is(err, errNoClient) {
+						reqErr = err
+					} else if err != nil {
+						return nil, err
+					} else {
 						// Log the updated request URL
 						slog.Info("🔄 Modified request URL for provider switch", "url", newReq.URL.String())
 
@@ -373,34 +396,36 @@ func (c *NotDiamondHttpClient) tryWithRetries(modelFull string, req *http.Reques
 							Timeout:   c.Client.Timeout,
 						}
 						resp, reqErr = rawClient.Do(newReq)
-					} else {
-						reqErr = fmt.Errorf("no client found for provider %s", modelFullProvider)
 					}
 				}
 			} else {
-				// Same provider, just update the URL with region if needed
-				// Update the URL to include the region if present in modelFull
-				if len(modelFullParts) > 2 && modelFullParts[2] != "" {
-					// Get client from context
-					if client, ok := originalCtx.Value(ClientKey).(*Client); ok {
-						// Update the request URL with the region
-						if err := updateRequestURL(req, modelFullProvider, modelFullBase+"/"+modelFullParts[2], client); err != nil {
+				if client != nil {
+					// Same provider, just update the URL with region if needed
+					if modelFullRegion != "" {
+						if err := updateRequestURL(req, modelFullProvider, modelFullBase+"/"+modelFullRegion, client); err != nil {
 							return nil, fmt.Errorf("failed to update URL with region: %w", err)
 						}
 					}
-				}
 
-				// Log the updated request URL after modifications
-				slog.Info("🔄 Modified request URL", "url", req.URL.String())
+					// The caller's body is already in the provider's format, only its model
+					// and the model's parameters change
+					body, err := client.sameProviderBody(env.Body, modelFullProvider, modelFullBase, modelFullRegion)
+					if err != nil {
+						return nil, err
+					}
+					req.Body = io.NopCloser(bytes.NewBuffer(body))
+					req.ContentLength = int64(len(body))
 
-				// Update authentication for the request
-				if modelFullProvider == "vertex" {
-					if err := updateRequestAuth(req, modelFullProvider, ctx); err != nil {
+					// Refresh authentication, e.g. short-lived Vertex AI tokens. Bedrock signs
+					// the body, so it is authenticated once the body is final.
+					if err := updateRequestAuth(req, modelFullProvider, modelFullRegion, ctx, client); err != nil {
 						return nil, fmt.Errorf("failed to update authentication: %w", err)
 					}
-					slog.Info("🔑 Updated authentication for Vertex AI")
 				}
 
+				// Log the updated request URL after modifications
+				slog.Info("🔄 Modified request URL", "url", req.URL.String())
+
 				// Use a client with the same transport as the original client
 				rawClient := &http.Client{
 					Transport: c.Client.Transport,
@@ -410,7 +435,7 @@ func (c *NotDiamondHttpClient) tryWithRetries(modelFull string, req *http.Reques
 			}
 		} else {
 			if client, ok := originalCtx.Value(ClientKey).(*Client); ok {
-				resp, reqErr = tryNextModel(client, modelFull, messages, ctx, req)
+				resp, reqErr = tryNextModel(client, modelFull, env, ctx)
 			}
 		}
 
@@ -431,6 +456,38 @@ func (c *NotDiamondHttpClient) tryWithRetries(modelFull string, req *http.Reques
 			continue
 		}
 
+		// Streams are committed to the caller on a successful status, before any of the
+		// body is read. Failed streams are read in full like any other error response.
+		if resp != nil && streaming && resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			if err := c.MetricsTracker.RecordErrorCode(modelFull, resp.StatusCode); err != nil {
+				slog.Error("Failed to record error code", "error", err)
+			}
+			slog.Info("📡 Streaming response", "model", modelFull, "status_code", resp.StatusCode)
+
+			// Answer in the format the caller sent, which differs after a provider switch
+			client, _ := originalCtx.Value(ClientKey).(*Client)
+			resp.Header = resp.Header.Clone()
+			if resp.Header == nil {
+				resp.Header = make(http.Header)
+			}
+			body, translated, err := client.translateStream(model.ClientProvider(req), modelFull, resp.Body)
+			if err != nil {
+				resp.Body.Close()
+				cancel()
+				return nil, err
+			}
+			if translated {
+				resp.Body = body
+				resp.Header.Del("Content-Length")
+				resp.ContentLength = -1
+			}
+			c.setModelHeaders(resp.Header, modelFull)
+
+			streamed = true
+			resp.Body = newStreamBody(resp.Body, modelFull, startTime, c.MetricsTracker, cancel)
+			return resp, nil
+		}
+
 		if resp != nil {
 			body, readErr := io.ReadAll(resp.Body)
 			closeErr := resp.Body.Close()
@@ -444,6 +501,18 @@ func (c *NotDiamondHttpClient) tryWithRetries(modelFull string, req *http.Reques
 				continue
 			}
 
+			// Rotate to the next key before the error counts against the model
+			if pool != nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusUnauthorized) {
+				pool.Throttle(apiKey, resp.StatusCode)
+				if pool.Available() {
+					client, _ := originalCtx.Value(ClientKey).(*Client)
+					lastErr = client.parseError(modelFull, resp.StatusCode, body)
+					slog.Info("🔑 Rotating API key", "model", modelFull, "status_code", resp.StatusCode)
+					rotations++
+					continue
+				}
+			}
+
 			lastStatusCode = resp.StatusCode
 			if err := c.MetricsTracker.RecordErrorCode(modelFull, resp.StatusCode); err != nil {
 				slog.Error("Failed to record error code", "error", err)
@@ -469,48 +538,36 @@ func (c *NotDiamondHttpClient) tryWithRetries(modelFull string, req *http.Reques
 					slog.Error("recording latency", "error", recErr)
 				}
 
-				return &http.Response{
-					Status:     resp.Status,
-					StatusCode: resp.StatusCode,
-					Header:     resp.Header,
-					Body:       io.NopCloser(bytes.NewBuffer(body)),
-				}, nil
-			}
+				// Read the usage before translation, which can lose its details
+				usage, hasUsage := response.ParseUsage(body)
 
-			// Special handling for 404 errors with Vertex AI, which might indicate a non-existent region
-			if resp.StatusCode == 404 && strings.HasPrefix(modelFull, "vertex/") {
-				modelParts := strings.Split(modelFull, "/")
-				if len(modelParts) > 2 {
-					region := modelParts[2]
-					slog.Error("❌ Region not found", "model", modelFull, "region", region, "status_code", resp.StatusCode)
-					lastErr = fmt.Errorf("region %s not found for model %s: %d %s",
-						region, modelParts[1], resp.StatusCode, http.StatusText(resp.StatusCode))
-				} else {
-					slog.Error("❌ Model or project not found", "model", modelFull, "status_code", resp.StatusCode)
-					lastErr = fmt.Errorf("model or project not found: %d %s",
-						resp.StatusCode, http.StatusText(resp.StatusCode))
+				// Answer in the format the caller sent, which differs after a provider switch
+				client, _ := originalCtx.Value(ClientKey).(*Client)
+				body, err := client.translateResponse(model.ClientProvider(req), modelFull, body)
+				if err != nil {
+					return nil, fmt.Errorf("failed to translate response of model %s: %w", modelFull, err)
 				}
-			} else {
-				// Parse error response body if possible
-				var errorResponse struct {
-					Error struct {
-						Message string `json:"message"`
-						Type    string `json:"type"`
-					} `json:"error"`
+				header := resp.Header.Clone()
+				header.Del("Content-Length")
+				if header == nil {
+					header = make(http.Header)
 				}
-				if unmarshalErr := json.Unmarshal(body, &errorResponse); unmarshalErr == nil && errorResponse.Error.Message != "" {
-					lastErr = fmt.Errorf("with status %d (%s): %s",
-						resp.StatusCode,
-						http.StatusText(resp.StatusCode),
-						errorResponse.Error.Message)
-				} else {
-					// Fallback to raw body if can't parse error response
-					lastErr = fmt.Errorf("with status %d (%s): %s",
-						resp.StatusCode,
-						http.StatusText(resp.StatusCode),
-						string(body))
+				if hasUsage {
+					response.SetUsageHeader(header, usage)
 				}
+				c.setModelHeaders(header, modelFull)
+
+				return &http.Response{
+					Status:        resp.Status,
+					StatusCode:    resp.StatusCode,
+					Header:        header,
+					Body:          io.NopCloser(bytes.NewBuffer(body)),
+					ContentLength: int64(len(body)),
+				}, nil
 			}
+
+			client, _ := originalCtx.Value(ClientKey).(*Client)
+			lastErr = client.parseError(modelFull, resp.StatusCode, body)
 			slog.Error("❌ Request", "failed", lastErr)
 		}
 
@@ -619,280 +676,314 @@ func CombineMessages(modelMessages []model.Message, userMessages []model.Message
 	return combinedMessages, nil
 }
 
-func transformRequestForProvider(originalBody []byte, nextProvider, nextModel string, client *Client) ([]byte, error) {
-	var jsonData []byte
-	var err error
+// errNoClient is returned when no configured client serves a provider.
+var errNoClient = errors.New("no client found")
 
-	switch nextProvider {
-	case "azure", "openai":
-		jsonData, err = transformToOpenAIFormat(originalBody, nextProvider, nextModel)
-	case "vertex":
-		jsonData, err = transformToVertexFormat(originalBody, nextModel, client)
-	default:
-		return nil, fmt.Errorf("unsupported provider: %s", nextProvider)
-	}
+// providers returns the built-in providers merged with the configured custom ones.
+func (c *NotDiamondHttpClient) providers() model.Providers {
+	return clients.Registry(c.Config)
+}
 
-	return jsonData, err
+// providers returns the built-in providers merged with the configured custom ones.
+func (c *Client) providers() model.Providers {
+	return clients.Registry(c.config())
 }
 
-// transformToOpenAIFormat transforms the request body to OpenAI/Azure format
-func transformToOpenAIFormat(originalBody []byte, provider, modelName string) ([]byte, error) {
-	var payload map[string]interface{}
-
-	// Determine if the original payload is from Vertex AI by examining its structure
-	var vertexCheck struct {
-		Contents []struct {
-			Role  string `json:"role"`
-			Parts []struct {
-				Text string `json:"text"`
-			} `json:"parts"`
-		} `json:"contents"`
-	}
-
-	isVertex := false
-	if err := json.Unmarshal(originalBody, &vertexCheck); err == nil {
-		if len(vertexCheck.Contents) > 0 {
-			isVertex = true
-		}
+// config returns the client configuration, or an empty one if not set.
+func (c *Client) config() model.Config {
+	if c == nil || c.HttpClient == nil {
+		return model.Config{}
 	}
+	return c.HttpClient.Config
+}
 
-	// If coming from Vertex, transform to OpenAI format
-	if isVertex {
-		transformed, err := request.TransformFromVertexToOpenAI(originalBody)
-		if err != nil {
-			return nil, err
-		}
-		if err := json.Unmarshal(transformed, &payload); err != nil {
-			return nil, err
-		}
-	} else {
-		if err := json.Unmarshal(originalBody, &payload); err != nil {
-			return nil, err
-		}
+// provider looks up a provider by name.
+func (c *Client) provider(name string) (model.Provider, error) {
+	provider, ok := c.providers()[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported provider: %s", name)
 	}
+	return provider, nil
+}
 
-	// Update model name based on provider
-	if provider == "openai" {
-		payload["model"] = modelName
-	} else if provider == "azure" {
-		delete(payload, "model")
+// extractProvider returns the provider of the configured client matching the request
+// host. Without a client the provider is guessed from the URL and model name.
+func (c *Client) extractProvider(req *http.Request) (string, error) {
+	if c == nil {
+		return request.ExtractProviderFromRequest(req, c.providers()), nil
 	}
+	return request.ExtractProviderFromClients(req, c.Clients, c.providers())
+}
 
-	return json.Marshal(payload)
+// parseEnvelope parses the body of a request sent to one of the clients.
+func (c *Client) parseEnvelope(req *http.Request, body []byte) (*request.Envelope, error) {
+	provider, err := c.extractProvider(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract provider: %w", err)
+	}
+	env, err := request.ParseEnvelope(req, body, provider, c.providers())
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract model: %w", err)
+	}
+	return env, nil
 }
 
-// transformToVertexFormat transforms the request body to Vertex format
-func transformToVertexFormat(originalBody []byte, modelName string, client *Client) ([]byte, error) {
-	return request.TransformToVertexRequest(originalBody, modelName)
+// findClientRequest returns the first configured client request served by the provider.
+func (c *Client) findClientRequest(providerName string) *http.Request {
+	providers := c.providers()
+	for i := range c.Clients {
+		if request.ProviderOfClient(&c.Clients[i], providers) == providerName {
+			return &c.Clients[i]
+		}
+	}
+	return nil
 }
 
-// updateRequestURL updates the request URL based on the provider and model
-func updateRequestURL(req *http.Request, provider, modelName string, client *Client) error {
-	// Extract region if present in modelName (format: modelName/region)
-	modelParts := strings.Split(modelName, "/")
-	actualModelName := modelParts[0]
-	region := ""
-	if len(modelParts) > 1 {
-		region = modelParts[1]
+// newProviderRequest builds a request for the provider from its configured client,
+// with the body transformed and the URL and authentication updated.
+func (c *Client) newProviderRequest(ctx context.Context, providerName, modelName, region string, env *request.Envelope) (*http.Request, error) {
+	provider, err := c.provider(providerName)
+	if err != nil {
+		return nil, fmt.Errorf("%w for provider %s", errNoClient, providerName)
 	}
 
-	slog.Info("🔄 Updating request URL", "provider", provider, "model", actualModelName, "region", region, "original_url", req.URL.String())
+	clientReq := c.findClientRequest(providerName)
+	if clientReq == nil {
+		slog.Info("❌ No matching client found", "provider", providerName)
+		return nil, fmt.Errorf("%w for provider %s", errNoClient, providerName)
+	}
 
-	switch provider {
-	case "azure":
-		req.URL.Path = fmt.Sprintf("/openai/deployments/%s/chat/completions", actualModelName)
-		// Use API version from config or fall back to default
-		apiVersion := client.HttpClient.Config.AzureAPIVersion
-		if apiVersion == "" {
-			apiVersion = "2023-05-15"
-		}
-		req.URL.RawQuery = fmt.Sprintf("api-version=%s", apiVersion)
-
-		// Apply region if specified and exists in AzureRegions map
-		if region != "" && client.HttpClient.Config.AzureRegions != nil {
-			if endpoint, ok := client.HttpClient.Config.AzureRegions[region]; ok {
-				// Use the endpoint from the AzureRegions map
-				req.URL.Host = strings.TrimPrefix(strings.TrimPrefix(endpoint, "https://"), "http://")
-				req.URL.Scheme = "https"
-				slog.Info("🔄 Using Azure endpoint from AzureRegions map", "region", region, "endpoint", endpoint)
-			} else {
-				slog.Warn("🔄 Region not found in AzureRegions map, using default endpoint", "region", region)
-			}
-		}
-	case "vertex":
-		projectID := client.HttpClient.Config.VertexProjectID
+	// Transform request body for the target provider
+	modelRegion := modelName
+	if region != "" {
+		modelRegion += "/" + region
+	}
+	var jsonData []byte
+	if providerName == env.Provider {
+		// The caller's body is already in the provider's format, and encoding it again
+		// could mistake it for another format, e.g. Anthropic bodies for OpenAI ones
+		jsonData, err = c.sameProviderBody(env.Body, providerName, modelName, region)
+	} else {
+		jsonData, err = transformRequestForProvider(env.Body, providerName, modelRegion, c)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to transform request: %w", err)
+	}
 
-		// Check if project ID is valid
-		if projectID == "" {
-			return fmt.Errorf("vertex project ID is not set in the configuration")
+	// Vertex AI callers ask for a stream through the URL, not the body
+	if model.Streaming(ctx) {
+		if jsonData, err = request.WithStream(jsonData); err != nil {
+			return nil, fmt.Errorf("failed to transform request: %w", err)
 		}
+	}
 
-		// Log the project ID being used
-		slog.Info("🔄 Using project ID for Vertex AI", "project_id", projectID)
+	// Create a new request with the transformed body
+	// This ensures we're using the correct URL and headers from the target provider's client
+	newReq := clientReq.Clone(ctx)
+	newReq.Body = io.NopCloser(bytes.NewBuffer(jsonData))
+	newReq.ContentLength = int64(len(jsonData))
 
-		// Use specified region or fall back to config location
-		location := client.HttpClient.Config.VertexLocation
-		if region != "" {
-			location = region
-		}
+	// Initialize headers if nil
+	if newReq.Header == nil {
+		newReq.Header = make(http.Header)
+	}
+	newReq.Header.Set("Content-Type", "application/json")
 
-		// Log the location being used
-		slog.Info("🔄 Using location for Vertex AI", "location", location)
-
-		// Update the host with the region
-		req.URL.Host = fmt.Sprintf("%s-aiplatform.googleapis.com", location)
-		req.URL.Scheme = "https"
-
-		// Check if the path already contains a location and replace it
-		path := req.URL.Path
-		if strings.Contains(path, "/locations/") {
-			// Extract the existing path components
-			pathParts := strings.Split(path, "/")
-			for i, part := range pathParts {
-				if part == "locations" && i+1 < len(pathParts) {
-					// Replace the location in the path
-					oldLocation := pathParts[i+1]
-					pathParts[i+1] = location
-					path = strings.Join(pathParts, "/")
-					slog.Info("🔄 Replaced location in path", "old_location", oldLocation, "new_location", location)
-					break
-				}
-			}
-			req.URL.Path = path
-		} else {
-			// If path doesn't already have a location, construct a new path
-			newPath := fmt.Sprintf("/v1beta1/projects/%s/locations/%s/publishers/google/models/%s:generateContent",
-				projectID, location, actualModelName)
-			slog.Info("🔄 Constructed new path", "project_id", projectID, "location", location, "model", actualModelName)
-			req.URL.Path = newPath
-		}
+	if err := provider.UpdateURL(newReq, modelName, region, c.config()); err != nil {
+		return nil, fmt.Errorf("failed to update URL: %w", err)
+	}
 
-		slog.Info("🔄 Updated Vertex URL", "host", req.URL.Host, "path", req.URL.Path)
-	case "openai":
-		// No region-specific handling for OpenAI
+	if err := provider.Authenticate(ctx, newReq, region, c.config()); err != nil {
+		return nil, fmt.Errorf("failed to update authentication: %w", err)
 	}
 
-	slog.Info("🔄 Updated request URL", "new_url", req.URL.String())
-	return nil
+	return newReq, nil
 }
 
-// updateRequestAuth updates the request authentication based on the provider
-func updateRequestAuth(req *http.Request, provider string, ctx context.Context) error {
-	// Extract API key from either header format
-	var apiKey string
-	authHeader := req.Header.Get("Authorization")
-	if strings.HasPrefix(authHeader, "Bearer ") {
-		apiKey = strings.TrimPrefix(authHeader, "Bearer ")
-	} else {
-		apiKey = req.Header.Get("api-key")
-	}
-
-	switch provider {
-	case "openai":
-		req.Header.Set("Authorization", "Bearer "+apiKey)
-		req.Header.Del("api-key")
-	case "azure":
-		req.Header.Set("api-key", apiKey)
-		req.Header.Del("Authorization")
-	case "vertex":
-		credentials, err := google.FindDefaultCredentials(ctx, "https://www.googleapis.com/auth/cloud-platform")
-		if err != nil {
-			return fmt.Errorf("error getting credentials: %w", err)
-		}
-		token, err := credentials.TokenSource.Token()
-		if err != nil {
-			return fmt.Errorf("error getting token: %w", err)
-		}
-		req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+// setModelHeaders names the model that answered, and its region if it has one, on
+// a response header.
+func (c *NotDiamondHttpClient) setModelHeaders(header http.Header, modelFull string) {
+	header.Set(response.ModelHeader, modelFull)
+	if _, _, region := c.providers().SplitModel(modelFull); region != "" {
+		header.Set(response.RegionHeader, region)
 	}
-	return nil
 }
 
-// tryNextModel tries the next model.
-func tryNextModel(client *Client, modelFull string, messages []model.Message, ctx context.Context, originalReq *http.Request) (*http.Response, error) {
-	parts := strings.Split(modelFull, "/")
+// parseError builds an error from an unsuccessful response of the model's provider.
+func (c *Client) parseError(modelFull string, statusCode int, body []byte) error {
+	providerName, modelName, region := c.providers().SplitModel(modelFull)
+	provider, err := c.provider(providerName)
+	if err != nil {
+		return response.ParseError(statusCode, body)
+	}
+	return provider.ParseError(statusCode, body, modelName, region)
+}
 
-	// Handle model format with region: provider/model/region
-	nextProvider := parts[0]
-	nextModel := parts[1]
-	nextRegion := ""
+// translateResponse translates a successful response of the model's provider into
+// the format of the caller's provider. Responses of the caller's own provider are
+// returned unchanged.
+func (c *Client) translateResponse(callerProvider, modelFull string, body []byte) ([]byte, error) {
+	providerName, _, _ := c.providers().SplitModel(modelFull)
+	if callerProvider == "" || callerProvider == providerName {
+		return body, nil
+	}
 
-	// If we have a region specified
-	if len(parts) > 2 {
-		nextRegion = parts[2]
-		nextModel = nextModel + "/" + nextRegion
-		slog.Info("🔄 Trying model with region", "provider", nextProvider, "model", nextModel, "region", nextRegion)
-	} else {
-		slog.Info("🔄 Trying model without region", "provider", nextProvider, "model", nextModel)
+	provider, err := c.provider(providerName)
+	if err != nil {
+		return body, nil
+	}
+	caller, err := c.provider(callerProvider)
+	if err != nil {
+		return body, nil
 	}
 
-	var nextReq *http.Request
+	decoded, err := provider.DecodeResponse(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s response: %w", providerName, err)
+	}
+	encoded, err := caller.EncodeResponse(decoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s response: %w", callerProvider, err)
+	}
 
-	// Find matching client request
-	for _, clientReq := range client.Clients {
-		url := clientReq.URL.String()
-		switch nextProvider {
-		case "vertex":
-			if strings.Contains(url, "aiplatform.googleapis.com") {
-				nextReq = clientReq.Clone(ctx)
-				slog.Info("🔄 Fallback to", "model:", modelFull, "| URL:", nextReq.URL.String())
-				goto found
-			}
-		case "azure":
-			if strings.Contains(url, "azure") {
-				nextReq = clientReq.Clone(ctx)
-				slog.Info("🔄 Fallback to", "model:", modelFull, "| URL:", nextReq.URL.String())
-				goto found
-			}
-		case "openai":
-			if strings.Contains(url, "openai.com") {
-				nextReq = clientReq.Clone(ctx)
-				slog.Info("🔄 Fallback to", "model:", modelFull, "| URL:", nextReq.URL.String())
-				goto found
-			}
-		}
+	slog.Info("🔄 Translated response", "from", providerName, "to", callerProvider)
+	return encoded, nil
+}
+
+// translateStream translates a streamed response of the model's provider into the
+// format of the caller's provider. It reports false if the stream is passed through
+// unchanged, for the caller's own provider, and fails if either provider cannot
+// translate its streams.
+func (c *Client) translateStream(callerProvider, modelFull string, body io.ReadCloser) (io.ReadCloser, bool, error) {
+	from, to, err := streamProviders(callerProvider, modelFull, c.providers())
+	if err != nil {
+		return nil, false, err
+	}
+	if from == nil {
+		return body, false, nil
+	}
+
+	providerName, _, _ := c.providers().SplitModel(modelFull)
+	slog.Info("🔄 Translating stream", "from", providerName, "to", callerProvider)
+	return newTranslatedStream(body, from, to), true, nil
+}
+
+// streamProviders returns the decoder of the model's provider and the encoder of
+// the caller's provider for translating a stream. Both are nil if the stream is
+// the caller's own provider's and needs no translation.
+func streamProviders(callerProvider, modelFull string, providers model.Providers) (from, to model.StreamProvider, err error) {
+	providerName, _, _ := providers.SplitModel(modelFull)
+	if callerProvider == "" || callerProvider == providerName {
+		return nil, nil, nil
 	}
-found:
 
-	if nextReq == nil {
-		slog.Info("❌ No matching client found", "provider", nextProvider)
-		return nil, fmt.Errorf("no client found for provider %s", nextProvider)
+	from, ok := providers[providerName].(model.StreamProvider)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s streams can't be translated for %s callers", providerName, callerProvider)
+	}
+	to, ok = providers[callerProvider].(model.StreamProvider)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s streams can't be translated for %s callers", providerName, callerProvider)
 	}
+	return from, to, nil
+}
 
-	// Read and preserve the original request body
-	originalBody, err := io.ReadAll(originalReq.Body)
+// transformRequestForProvider transforms the request body for the provider, then
+// sets the parameters configured for the model. The model can have a region.
+func transformRequestForProvider(originalBody []byte, nextProvider, nextModel string, client *Client) ([]byte, error) {
+	provider, err := client.provider(nextProvider)
 	if err != nil {
 		return nil, err
 	}
-	originalReq.Body = io.NopCloser(bytes.NewBuffer(originalBody))
 
-	// Transform request body for the target provider
-	jsonData, err := transformRequestForProvider(originalBody, nextProvider, nextModel, client)
+	modelName, region := client.providers().SplitRegion(nextProvider, nextModel)
+	body, err := provider.EncodeRequest(originalBody, modelName, client.config())
 	if err != nil {
-		return nil, fmt.Errorf("failed to transform request: %w", err)
+		return nil, err
 	}
+	return client.applyModelParams(body, nextProvider, modelName, region)
+}
 
-	// Create a new request with the transformed body
-	// This ensures we're using the correct URL and headers from the target provider's client
-	newReq := nextReq.Clone(ctx)
-	newReq.Body = io.NopCloser(bytes.NewBuffer(jsonData))
-	newReq.ContentLength = int64(len(jsonData))
+// sameProviderBody returns the body of a caller of the provider for one of its
+// models. Only the model and the parameters configured for it change.
+func (c *Client) sameProviderBody(body []byte, provider, modelName, region string) ([]byte, error) {
+	body, err := request.WithModel(body, modelName)
+	if err != nil {
+		return nil, err
+	}
+	return c.applyModelParams(body, provider, modelName, region)
+}
 
-	// Initialize headers if nil
-	if newReq.Header == nil {
-		newReq.Header = make(http.Header)
+// applyModelParams sets the parameters configured for the model in a body in its
+// provider's format. Parameters of provider/model/region win over those of
+// provider/model.
+func (c *Client) applyModelParams(body []byte, provider, modelName, region string) ([]byte, error) {
+	config := c.config()
+	params := config.ModelParams[provider+"/"+modelName]
+	if regional, ok := config.ModelParams[provider+"/"+modelName+"/"+region]; ok && region != "" {
+		params = regional
 	}
-	newReq.Header.Set("Content-Type", "application/json")
+	body, err := request.ApplyModelParams(body, provider, modelName, params, config.UnsupportedParams)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply parameters of model %s: %w", provider+"/"+modelName, err)
+	}
+	return body, nil
+}
 
-	// Update request URL
-	if err := updateRequestURL(newReq, nextProvider, nextModel, client); err != nil {
-		return nil, fmt.Errorf("failed to update URL: %w", err)
+// transformToOpenAIFormat transforms the request body to OpenAI/Azure format
+func transformToOpenAIFormat(originalBody []byte, provider, modelName string) ([]byte, error) {
+	return transformRequestForProvider(originalBody, provider, modelName, nil)
+}
+
+// transformToVertexFormat transforms the request body to Vertex format
+func transformToVertexFormat(originalBody []byte, modelName string, client *Client) ([]byte, error) {
+	return transformRequestForProvider(originalBody, string(model.ClientTypeVertex), modelName, client)
+}
+
+// updateRequestURL updates the request URL based on the provider and model
+func updateRequestURL(req *http.Request, provider, modelName string, client *Client) error {
+	// Extract region if present in modelName (format: modelName/region)
+	actualModelName, region := client.providers().SplitRegion(provider, modelName)
+
+	slog.Info("🔄 Updating request URL", "provider", provider, "model", actualModelName, "region", region, "original_url", req.URL.String())
+
+	p, err := client.provider(provider)
+	if err != nil {
+		slog.Warn("🔄 Unknown provider, leaving URL unchanged", "provider", provider)
+		return nil
+	}
+	if err := p.UpdateURL(req, actualModelName, region, client.config()); err != nil {
+		return err
 	}
 
-	// Update authentication
-	if err := updateRequestAuth(newReq, nextProvider, ctx); err != nil {
-		return nil, fmt.Errorf("failed to update authentication: %w", err)
+	slog.Info("🔄 Updated request URL", "new_url", req.URL.String())
+	return nil
+}
+
+// updateRequestAuth updates the request authentication based on the provider
+func updateRequestAuth(req *http.Request, provider, region string, ctx context.Context, client *Client) error {
+	p, err := client.provider(provider)
+	if err != nil {
+		return nil
+	}
+	return p.Authenticate(ctx, req, region, client.config())
+}
+
+// tryNextModel tries the next model with the body of the parsed request.
+func tryNextModel(client *Client, modelFull string, env *request.Envelope, ctx context.Context) (*http.Response, error) {
+	nextProvider, nextModel, nextRegion := client.providers().SplitModel(modelFull)
+
+	if nextRegion != "" {
+		slog.Info("🔄 Trying model with region", "provider", nextProvider, "model", nextModel, "region", nextRegion)
+	} else {
+		slog.Info("🔄 Trying model without region", "provider", nextProvider, "model", nextModel)
+	}
+
+	newReq, err := client.newProviderRequest(ctx, nextProvider, nextModel, nextRegion, env)
+	if err != nil {
+		return nil, err
 	}
+	slog.Info("🔄 Fallback to", "model:", modelFull, "| URL:", newReq.URL.String())
 
 	// Use the client's HTTP client to make the request
 	return client.HttpClient.Client.Do(newReq)
@@ -3,10 +3,13 @@ package http_client
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"reflect"
 	"sort"
 	"strings"
@@ -15,11 +18,16 @@ import (
 
 	"net/http/httptest"
 
+	"github.com/Not-Diamond/go-notdiamond/pkg/clients"
+	"github.com/Not-Diamond/go-notdiamond/pkg/clients/bedrock"
+	"github.com/Not-Diamond/go-notdiamond/pkg/clients/openaicompat"
 	"github.com/Not-Diamond/go-notdiamond/pkg/http/request"
+	"github.com/Not-Diamond/go-notdiamond/pkg/http/response"
 	"github.com/Not-Diamond/go-notdiamond/pkg/metric"
 	"github.com/Not-Diamond/go-notdiamond/pkg/model"
 	"github.com/Not-Diamond/go-notdiamond/pkg/redis"
 	"github.com/alicebob/miniredis/v2"
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 )
 
@@ -122,7 +130,6 @@ func TestTryWithRetries(t *testing.T) {
 		backoff        map[string]float64
 		modelMessages  map[string][]model.Message
 		modelLatency   model.ModelLatency
-		messages       []model.Message
 		setupTransport func() *mockTransport
 		expectedCalls  int
 		expectError    bool
@@ -137,9 +144,6 @@ func TestTryWithRetries(t *testing.T) {
 			timeout: map[string]float64{
 				"openai/gpt-4": 0.1,
 			},
-			messages: []model.Message{
-				{"role": "user", "content": "Hello"},
-			},
 			modelLatency: model.ModelLatency{
 				"openai/gpt-4": &model.RollingAverageLatency{
 					AvgLatencyThreshold: 3.5,
@@ -178,9 +182,6 @@ func TestTryWithRetries(t *testing.T) {
 			backoff: map[string]float64{
 				"openai/gpt-4": 0.01,
 			},
-			messages: []model.Message{
-				{"role": "user", "content": "Hello"},
-			},
 			modelLatency: model.ModelLatency{
 				"openai/gpt-4": &model.RollingAverageLatency{
 					AvgLatencyThreshold: 3.5,
@@ -220,9 +221,6 @@ func TestTryWithRetries(t *testing.T) {
 			backoff: map[string]float64{
 				"openai/gpt-4": 0.01,
 			},
-			messages: []model.Message{
-				{"role": "user", "content": "Hello"},
-			},
 			modelLatency: model.ModelLatency{
 				"openai/gpt-4": &model.RollingAverageLatency{
 					AvgLatencyThreshold: 3.5,
@@ -251,9 +249,6 @@ func TestTryWithRetries(t *testing.T) {
 			timeout: map[string]float64{
 				"openai/gpt-4": 0.1,
 			},
-			messages: []model.Message{
-				{"role": "user", "content": "Hello"},
-			},
 			modelLatency: model.ModelLatency{
 				"openai/gpt-4": &model.RollingAverageLatency{
 					AvgLatencyThreshold: 3.5,
@@ -287,7 +282,8 @@ func TestTryWithRetries(t *testing.T) {
 			defer mr.Close()
 
 			transport := tt.setupTransport()
-			req, _ := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", bytes.NewBufferString(`{"model":"gpt-4","messages":[{"role":"user","content":"Hello"}]}`))
+			body := `{"model":"gpt-4","messages":[{"role":"user","content":"Hello"}]}`
+			req, _ := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", bytes.NewBufferString(body))
 			metrics, err := metric.NewTracker(mr.Addr())
 			if err != nil {
 				t.Fatalf("Failed to create metrics tracker: %v", err)
@@ -315,7 +311,12 @@ func TestTryWithRetries(t *testing.T) {
 				},
 			})
 
-			resp, err := httpClient.tryWithRetries(tt.modelFull, req, tt.messages, ctx)
+			env, err := request.ParseEnvelope(req, []byte(body), "openai", clients.Builtin())
+			if err != nil {
+				t.Fatalf("Failed to parse request: %v", err)
+			}
+
+			resp, err := httpClient.tryWithRetries(tt.modelFull, req, env, ctx)
 
 			if tt.expectError {
 				if err == nil {
@@ -394,7 +395,6 @@ func TestTryNextModel(t *testing.T) {
 	tests := []struct {
 		name          string
 		modelFull     string
-		messages      []model.Message
 		setupClient   func() (*Client, *mockTransport)
 		expectedURL   string
 		expectedBody  map[string]interface{}
@@ -406,9 +406,6 @@ func TestTryNextModel(t *testing.T) {
 		{
 			name:      "successful azure request",
 			modelFull: "azure/gpt-4",
-			messages: []model.Message{
-				{"role": "user", "content": "Hello"},
-			},
 			setupClient: func() (*Client, *mockTransport) {
 				// Set up miniredis
 				mr, err := miniredis.Run()
@@ -416,7 +413,7 @@ func TestTryNextModel(t *testing.T) {
 					t.Fatalf("Failed to create miniredis: %v", err)
 				}
 
-				req, _ := http.NewRequest("POST", "https://myresource.azure.openai.com", bytes.NewBufferString(`{"model":"gpt-4","messages":[{"role":"user","content":"Hello"}]}`))
+				req, _ := http.NewRequest("POST", "https://myresource.openai.azure.com", bytes.NewBufferString(`{"model":"gpt-4","messages":[{"role":"user","content":"Hello"}]}`))
 				req.Header.Set("api-key", "test-key")
 				transport := &mockTransport{
 					responses: []*http.Response{
@@ -426,7 +423,7 @@ func TestTryNextModel(t *testing.T) {
 						},
 					},
 					urlResponses: map[string]*http.Response{
-						"myresource.azure.openai.com": {
+						"myresource.openai.azure.com": {
 							StatusCode: 200,
 							Body:       io.NopCloser(bytes.NewBufferString(`{"choices":[{"message":{"content":"Hello"}}]}`)),
 						},
@@ -463,7 +460,7 @@ func TestTryNextModel(t *testing.T) {
 					},
 				}, transport
 			},
-			expectedURL: "https://myresource.azure.openai.com/openai/deployments/gpt-4/chat/completions?api-version=2023-05-15",
+			expectedURL: "https://myresource.openai.azure.com/openai/deployments/gpt-4/chat/completions?api-version=2023-05-15",
 			checkRequest: func(t *testing.T, req *http.Request) {
 				if req.Header.Get("api-key") != "test-key" {
 					t.Errorf("Expected api-key header to be 'test-key', got %q", req.Header.Get("api-key"))
@@ -471,17 +468,14 @@ func TestTryNextModel(t *testing.T) {
 				if req.Header.Get("Content-Type") != "application/json" {
 					t.Errorf("Expected Content-Type header to be 'application/json', got %q", req.Header.Get("Content-Type"))
 				}
-				if req.URL.String() != "https://myresource.azure.openai.com/openai/deployments/gpt-4/chat/completions?api-version=2023-05-15" {
-					t.Errorf("Expected URL %q, got %q", "https://myresource.azure.openai.com/openai/deployments/gpt-4/chat/completions?api-version=2023-05-15", req.URL.String())
+				if req.URL.String() != "https://myresource.openai.azure.com/openai/deployments/gpt-4/chat/completions?api-version=2023-05-15" {
+					t.Errorf("Expected URL %q, got %q", "https://myresource.openai.azure.com/openai/deployments/gpt-4/chat/completions?api-version=2023-05-15", req.URL.String())
 				}
 			},
 		},
 		{
 			name:      "successful openai request",
 			modelFull: "openai/gpt-4",
-			messages: []model.Message{
-				{"role": "user", "content": "Hello"},
-			},
 			setupClient: func() (*Client, *mockTransport) {
 				// Set up miniredis
 				mr, err := miniredis.Run()
@@ -549,9 +543,6 @@ func TestTryNextModel(t *testing.T) {
 		{
 			name:      "provider not found",
 			modelFull: "unknown/gpt-4",
-			messages: []model.Message{
-				{"role": "user", "content": "Hello"},
-			},
 			setupClient: func() (*Client, *mockTransport) {
 				// Set up miniredis
 				mr, err := miniredis.Run()
@@ -596,9 +587,6 @@ func TestTryNextModel(t *testing.T) {
 		{
 			name:      "http client error",
 			modelFull: "openai/gpt-4",
-			messages: []model.Message{
-				{"role": "user", "content": "Hello"},
-			},
 			setupClient: func() (*Client, *mockTransport) {
 				// Set up miniredis
 				mr, err := miniredis.Run()
@@ -652,14 +640,13 @@ func TestTryNextModel(t *testing.T) {
 			client, transport := tt.setupClient()
 			ctx := context.Background()
 
-			// Create a dummy request for testing with a body
-			req, err := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions",
-				bytes.NewBufferString(`{"model":"gpt-4","messages":[{"role":"user","content":"Hello"}]}`))
+			// Parse a dummy request for testing
+			env, err := request.ParseEnvelope(nil, []byte(`{"model":"gpt-4","messages":[{"role":"user","content":"Hello"}]}`), "openai", clients.Builtin())
 			if err != nil {
-				t.Fatalf("Failed to create request: %v", err)
+				t.Fatalf("Failed to parse request: %v", err)
 			}
 
-			resp, err := tryNextModel(client, tt.modelFull, tt.messages, ctx, req)
+			resp, err := tryNextModel(client, tt.modelFull, env, ctx)
 
 			if tt.expectedError != "" {
 				if err == nil {
@@ -759,7 +746,7 @@ func TestExtractProviderFromRequest(t *testing.T) {
 		},
 		{
 			name:     "Azure URL",
-			url:      "https://myresource.azure.openai.com/v1/chat/completions",
+			url:      "https://myresource.openai.azure.com/v1/chat/completions",
 			expected: "azure",
 		},
 		{
@@ -781,7 +768,7 @@ func TestExtractProviderFromRequest(t *testing.T) {
 				t.Fatalf("Failed to create request: %v", err)
 			}
 
-			got := request.ExtractProviderFromRequest(req)
+			got := request.ExtractProviderFromRequest(req, clients.Builtin())
 			if got != tt.expected {
 				t.Errorf("extractProviderFromRequest() = %v, want %v", got, tt.expected)
 			}
@@ -835,7 +822,7 @@ func TestExtractModelFromRequest(t *testing.T) {
 				t.Fatalf("Failed to create request: %v", err)
 			}
 
-			got, err := request.ExtractModelFromRequest(req)
+			got, err := request.ExtractModelFromRequest(req, clients.Builtin())
 			if (err != nil) != tt.wantErr {
 				t.Errorf("ExtractModelFromRequest() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -1013,122 +1000,1508 @@ func TestDo(t *testing.T) {
 					},
 				}
 
-				metrics, err := metric.NewTracker(mr.Addr())
-				if err != nil {
-					t.Fatalf("Failed to create metrics tracker: %v", err)
-				}
+				metrics, err := metric.NewTracker(mr.Addr())
+				if err != nil {
+					t.Fatalf("Failed to create metrics tracker: %v", err)
+				}
+
+				client := &NotDiamondHttpClient{
+					Client: &http.Client{Transport: transport},
+					Config: model.Config{
+						MaxRetries: map[string]int{
+							"openai/gpt-4":    3,
+							"openai/gpt-4/us": 3,
+							"openai/gpt-4/eu": 3,
+							"azure/gpt-4":     3,
+						},
+						Timeout: map[string]float64{
+							"openai/gpt-4":    30.0,
+							"openai/gpt-4/us": 30.0,
+							"openai/gpt-4/eu": 30.0,
+							"azure/gpt-4":     30.0,
+						},
+						ModelLatency: model.ModelLatency{
+							"openai/gpt-4": &model.RollingAverageLatency{
+								AvgLatencyThreshold: 3.5,
+								NoOfCalls:           5,
+								RecoveryTime:        5 * time.Minute,
+							},
+							"openai/gpt-4/us": &model.RollingAverageLatency{
+								AvgLatencyThreshold: 3.5,
+								NoOfCalls:           5,
+								RecoveryTime:        5 * time.Minute,
+							},
+							"openai/gpt-4/eu": &model.RollingAverageLatency{
+								AvgLatencyThreshold: 3.5,
+								NoOfCalls:           5,
+								RecoveryTime:        5 * time.Minute,
+							},
+							"azure/gpt-4": &model.RollingAverageLatency{
+								AvgLatencyThreshold: 3.5,
+								NoOfCalls:           5,
+								RecoveryTime:        5 * time.Minute,
+							},
+						},
+						RedisConfig: &redis.Config{
+							Addr: mr.Addr(),
+						},
+					},
+					MetricsTracker: metrics,
+				}
+
+				return client, transport
+			},
+			expectedCalls: 1,
+			expectError:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, transport := tt.setupClient()
+
+			// Create requests for both OpenAI and Azure
+			openaiReq, _ := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions",
+				bytes.NewBufferString(`{"model":"gpt-4","messages":[{"role":"user","content":"Hello"}]}`))
+			openaiReq.Header.Set("Authorization", "Bearer test-key")
+
+			azureReq, _ := http.NewRequest("POST", "https://myresource.openai.azure.com",
+				bytes.NewBufferString(`{"messages":[{"role":"user","content":"Hello"}]}`))
+			azureReq.Header.Set("api-key", "test-key")
+
+			// Create NotDiamondClient and add it to context
+			notDiamondClient := &Client{
+				HttpClient: client,
+				Clients:    []http.Request{*openaiReq, *azureReq}, // Add both client requests to the clients list
+				Models:     model.OrderedModels{"openai/gpt-4", "azure/gpt-4"},
+				ModelProviders: map[string]map[string]bool{
+					"openai": {
+						"gpt-4": true,
+					},
+					"azure": {
+						"gpt-4": true,
+					},
+				},
+				IsOrdered: true,
+			}
+			ctx := context.WithValue(context.Background(), ClientKey, notDiamondClient)
+			openaiReq = openaiReq.WithContext(ctx)
+
+			// Make the request
+			resp, err := client.Do(openaiReq)
+
+			if tt.expectError {
+				if err == nil {
+					t.Error("expected error but got none")
+				} else if tt.errorContains != "" && !strings.Contains(err.Error(), tt.errorContains) {
+					t.Errorf("expected error containing %q but got %q", tt.errorContains, err.Error())
+				}
+				return
+			}
+
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+
+			if resp == nil {
+				t.Error("expected response but got nil")
+				return
+			}
+
+			if transport.callCount != tt.expectedCalls {
+				t.Errorf("expected %d calls but got %d", tt.expectedCalls, transport.callCount)
+			}
+		})
+	}
+}
+
+func TestDoFallbackToProvider(t *testing.T) {
+	tests := []struct {
+		name        string
+		fallback    string
+		providers   model.Providers
+		clientURL   string
+		clientTag   string
+		clientKeys  map[string]string
+		response    string
+		wantURL     string
+		wantModel   interface{}
+		wantHeaders map[string]string
+	}{
+		{
+			name:      "OpenAI-compatible provider",
+			fallback:  "local/llama3",
+			providers: model.Providers{"local": openaicompat.Provider{BaseURL: "http://localhost:11434/v1"}},
+			clientURL: "http://localhost:11434/v1/chat/completions",
+			response:  `{"choices": [{"message": {"content": "Hi"}}]}`,
+			wantURL:   "http://localhost:11434/v1/chat/completions",
+			wantModel: "llama3",
+			wantHeaders: map[string]string{
+				"Authorization": "",
+			},
+		},
+		{
+			name:       "Gemini API key provider",
+			fallback:   "gemini/gemini-1.5-flash",
+			clientURL:  "https://generativelanguage.googleapis.com/v1beta/models/gemini-1.5-flash:generateContent",
+			clientKeys: map[string]string{"x-goog-api-key": "gemini-key"},
+			response:   `{"candidates": [{"content": {"parts": [{"text": "Hi"}]}}]}`,
+			wantURL:    "https://generativelanguage.googleapis.com/v1beta/models/gemini-1.5-flash:generateContent",
+			wantModel:  "gemini-1.5-flash",
+			wantHeaders: map[string]string{
+				"x-goog-api-key": "gemini-key",
+				"Authorization":  "",
+			},
+		},
+		{
+			name:       "Azure behind a gateway",
+			fallback:   "azure/gpt-4",
+			clientURL:  "https://llm-gateway.example.com/openai/deployments/gpt-4/chat/completions",
+			clientTag:  "azure",
+			clientKeys: map[string]string{"api-key": "azure-key"},
+			response:   `{"choices": [{"message": {"content": "Hi"}}]}`,
+			wantURL:    "https://llm-gateway.example.com/openai/deployments/gpt-4/chat/completions?api-version=2023-05-15",
+			wantModel:  nil,
+			wantHeaders: map[string]string{
+				"api-key": "azure-key",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr, err := miniredis.Run()
+			if err != nil {
+				t.Fatalf("Failed to create miniredis: %v", err)
+			}
+			defer mr.Close()
+
+			metrics, err := metric.NewTracker(mr.Addr())
+			if err != nil {
+				t.Fatalf("Failed to create metrics tracker: %v", err)
+			}
+
+			fallbackURL, _ := url.Parse(tt.clientURL)
+			transport := &mockTransport{
+				urlResponses: map[string]*http.Response{
+					"api.openai.com": {
+						StatusCode: 500,
+						Body:       io.NopCloser(bytes.NewBufferString(`{"error": {"message": "server error"}}`)),
+					},
+					fallbackURL.Host: {
+						StatusCode: 200,
+						Body:       io.NopCloser(bytes.NewBufferString(tt.response)),
+					},
+				},
+			}
+
+			client := &NotDiamondHttpClient{
+				Client: &http.Client{Transport: transport},
+				Config: model.Config{
+					MaxRetries: map[string]int{
+						"openai/gpt-4": 1,
+						tt.fallback:    1,
+					},
+					Providers: tt.providers,
+					RedisConfig: &redis.Config{
+						Addr: mr.Addr(),
+					},
+				},
+				MetricsTracker: metrics,
+			}
+
+			openaiReq, _ := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions",
+				bytes.NewBufferString(`{"model":"gpt-4","messages":[{"role":"user","content":"Hello"}]}`))
+			openaiReq.Header.Set("Authorization", "Bearer test-key")
+
+			fallbackReq, _ := http.NewRequest("POST", tt.clientURL, nil)
+			for key, value := range tt.clientKeys {
+				fallbackReq.Header.Set(key, value)
+			}
+			if tt.clientTag != "" {
+				fallbackReq = model.WithProvider(fallbackReq, tt.clientTag)
+			}
+
+			notDiamondClient := &Client{
+				HttpClient: client,
+				Clients:    []http.Request{*openaiReq, *fallbackReq},
+				Models:     model.OrderedModels{"openai/gpt-4", tt.fallback},
+				IsOrdered:  true,
+			}
+			ctx := context.WithValue(context.Background(), ClientKey, notDiamondClient)
+
+			resp, err := client.Do(openaiReq.WithContext(ctx))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("expected status 200, got %d", resp.StatusCode)
+			}
+
+			// The response is translated back to the caller's OpenAI format
+			var completion struct {
+				Choices []struct {
+					Message struct {
+						Role    string `json:"role"`
+						Content string `json:"content"`
+					} `json:"message"`
+				} `json:"choices"`
+			}
+			respBody, _ := io.ReadAll(resp.Body)
+			if err := json.Unmarshal(respBody, &completion); err != nil || len(completion.Choices) == 0 {
+				t.Fatalf("expected OpenAI response, got %s", respBody)
+			}
+			if completion.Choices[0].Message.Content != "Hi" {
+				t.Errorf("expected content %q, got %q", "Hi", completion.Choices[0].Message.Content)
+			}
+
+			last := transport.lastRequest
+			if last.URL.String() != tt.wantURL {
+				t.Errorf("expected request to %s, got %s", tt.wantURL, last.URL.String())
+			}
+			for key, want := range tt.wantHeaders {
+				if got := last.Header.Get(key); got != want {
+					t.Errorf("expected header %s = %q, got %q", key, want, got)
+				}
+			}
+
+			body, _ := io.ReadAll(last.Body)
+			var payload map[string]interface{}
+			if err := json.Unmarshal(body, &payload); err != nil {
+				t.Fatalf("failed to unmarshal request body: %v", err)
+			}
+			if payload["model"] != tt.wantModel {
+				t.Errorf("expected model %v, got %v", tt.wantModel, payload["model"])
+			}
+		})
+	}
+}
+
+func TestDoTranslatesResponseForVertexCaller(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to create miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	metrics, err := metric.NewTracker(mr.Addr())
+	if err != nil {
+		t.Fatalf("Failed to create metrics tracker: %v", err)
+	}
+
+	transport := &mockTransport{
+		urlResponses: map[string]*http.Response{
+			"aiplatform.googleapis.com": {
+				StatusCode: 503,
+				Body:       io.NopCloser(bytes.NewBufferString(`{"error": {"message": "unavailable"}}`)),
+			},
+			"api.openai.com": {
+				StatusCode: 200,
+				Body: io.NopCloser(bytes.NewBufferString(`{
+					"choices": [{"index": 0, "message": {"role": "assistant", "content": "Hi"}, "finish_reason": "stop"}],
+					"usage": {"prompt_tokens": 4, "completion_tokens": 1, "total_tokens": 5}
+				}`)),
+			},
+		},
+	}
+
+	client := &NotDiamondHttpClient{
+		Client: &http.Client{Transport: transport},
+		Config: model.Config{
+			VertexProjectID:   "test-project",
+			VertexLocation:    "us-central1",
+			VertexTokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "vertex-token"}),
+		},
+		MetricsTracker: metrics,
+	}
+
+	vertexURL := "https://us-central1-aiplatform.googleapis.com/v1beta1/projects/test-project/locations/us-central1/publishers/google/models/gemini-pro:generateContent"
+	vertexReq, _ := http.NewRequest("POST", vertexURL, nil)
+	openaiReq, _ := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", nil)
+	openaiReq.Header.Set("Authorization", "Bearer test-key")
+
+	notDiamondClient := &Client{
+		HttpClient: client,
+		Clients:    []http.Request{*vertexReq, *openaiReq},
+		Models:     model.OrderedModels{"vertex/gemini-pro", "openai/gpt-4o"},
+		IsOrdered:  true,
+	}
+	ctx := context.WithValue(context.Background(), ClientKey, notDiamondClient)
+
+	req, _ := http.NewRequestWithContext(ctx, "POST", vertexURL,
+		bytes.NewBufferString(`{"model":"gemini-pro","contents":[{"role":"user","parts":[{"text":"Hello"}]}]}`))
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	respBody, _ := io.ReadAll(resp.Body)
+	var got, want map[string]interface{}
+	if err := json.Unmarshal(respBody, &got); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	json.Unmarshal([]byte(`{
+		"candidates": [{"index": 0, "content": {"role": "model", "parts": [{"text": "Hi"}]}, "finishReason": "STOP"}],
+		"usageMetadata": {"promptTokenCount": 4, "candidatesTokenCount": 1, "totalTokenCount": 5}
+	}`), &want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("response = %s, want Vertex format", respBody)
+	}
+	if usage, ok := response.UsageFromHeader(resp.Header); !ok || usage != (response.Usage{PromptTokens: 4, CompletionTokens: 1, TotalTokens: 5}) {
+		t.Errorf("usage = %+v, %v, want the usage of the OpenAI response", usage, ok)
+	}
+	if answered := resp.Header.Get(response.ModelHeader); answered != "openai/gpt-4o" {
+		t.Errorf("%s = %q, want openai/gpt-4o", response.ModelHeader, answered)
+	}
+	if resp.ContentLength != int64(len(respBody)) {
+		t.Errorf("ContentLength = %d, want %d", resp.ContentLength, len(respBody))
+	}
+}
+
+func TestDoFallsBackBetweenGeminiAndVertex(t *testing.T) {
+	geminiURL := "https://generativelanguage.googleapis.com/v1beta/models/gemini-1.5-flash:generateContent"
+	vertexURL := "https://us-central1-aiplatform.googleapis.com/v1/projects/test-project/locations/us-central1/publishers/google/models/gemini-pro:generateContent"
+	contents := `[{"role":"user","parts":[{"text":"Hello"}]}]`
+
+	tests := []struct {
+		name      string
+		callerURL string
+		body      string
+		models    model.OrderedModels
+		failing   string
+		wantHost  string
+		wantModel string
+	}{
+		{
+			name:      "Gemini to Vertex",
+			callerURL: geminiURL,
+			body:      `{"model":"gemini-1.5-flash","contents":` + contents + `,"safetySettings":[{"category":"HARM_CATEGORY_HARASSMENT","threshold":"BLOCK_NONE"}]}`,
+			models:    model.OrderedModels{"gemini/gemini-1.5-flash", "vertex/gemini-pro"},
+			failing:   "generativelanguage.googleapis.com",
+			wantHost:  "us-central1-aiplatform.googleapis.com",
+			wantModel: "gemini-pro",
+		},
+		{
+			name:      "Vertex to Gemini",
+			callerURL: vertexURL,
+			body:      `{"model":"gemini-pro","contents":` + contents + `,"safetySettings":[{"category":"HARM_CATEGORY_HARASSMENT","threshold":"BLOCK_NONE"}]}`,
+			models:    model.OrderedModels{"vertex/gemini-pro", "gemini/gemini-1.5-flash"},
+			failing:   "aiplatform.googleapis.com",
+			wantHost:  "generativelanguage.googleapis.com",
+			wantModel: "gemini-1.5-flash",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr, err := miniredis.Run()
+			if err != nil {
+				t.Fatalf("Failed to create miniredis: %v", err)
+			}
+			defer mr.Close()
+
+			metrics, err := metric.NewTracker(mr.Addr())
+			if err != nil {
+				t.Fatalf("Failed to create metrics tracker: %v", err)
+			}
+
+			transport := &mockTransport{
+				urlResponses: map[string]*http.Response{
+					tt.failing: {
+						StatusCode: 503,
+						Body:       io.NopCloser(bytes.NewBufferString(`{"error": {"message": "unavailable"}}`)),
+					},
+					tt.wantHost: {
+						StatusCode: 200,
+						Body:       io.NopCloser(bytes.NewBufferString(`{"candidates": [{"content": {"role": "model", "parts": [{"text": "Hi"}]}, "finishReason": "STOP"}]}`)),
+					},
+				},
+			}
+
+			client := &NotDiamondHttpClient{
+				Client: &http.Client{Transport: transport},
+				Config: model.Config{
+					MaxRetries:        map[string]int{string(tt.models[0]): 1, string(tt.models[1]): 1},
+					VertexProjectID:   "test-project",
+					VertexLocation:    "us-central1",
+					VertexTokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "vertex-token"}),
+				},
+				MetricsTracker: metrics,
+			}
+
+			geminiReq, _ := http.NewRequest("POST", geminiURL, nil)
+			geminiReq.Header.Set("x-goog-api-key", "gemini-key")
+			vertexReq, _ := http.NewRequest("POST", vertexURL, nil)
+
+			notDiamondClient := &Client{
+				HttpClient: client,
+				Clients:    []http.Request{*geminiReq, *vertexReq},
+				Models:     tt.models,
+				IsOrdered:  true,
+			}
+			ctx := context.WithValue(context.Background(), ClientKey, notDiamondClient)
+
+			req, _ := http.NewRequestWithContext(ctx, "POST", tt.callerURL, bytes.NewBufferString(tt.body))
+			if tt.callerURL == geminiURL {
+				req.Header.Set("x-goog-api-key", "gemini-key")
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("expected status 200, got %d", resp.StatusCode)
+			}
+
+			last := transport.lastRequest
+			if last.URL.Host != tt.wantHost {
+				t.Fatalf("expected request to %s, got %s", tt.wantHost, last.URL.Host)
+			}
+
+			// The caller's contents and fields reach the fallback, only the model changes
+			body, _ := io.ReadAll(last.Body)
+			var payload map[string]json.RawMessage
+			if err := json.Unmarshal(body, &payload); err != nil {
+				t.Fatalf("failed to unmarshal request body: %v", err)
+			}
+			var gotContents, wantContents interface{}
+			json.Unmarshal(payload["contents"], &gotContents)
+			json.Unmarshal([]byte(contents), &wantContents)
+			if !reflect.DeepEqual(gotContents, wantContents) {
+				t.Errorf("expected contents %s, got %s", contents, payload["contents"])
+			}
+			if _, ok := payload["safetySettings"]; !ok {
+				t.Errorf("request body %s lost safetySettings", body)
+			}
+			if string(payload["model"]) != `"`+tt.wantModel+`"` {
+				t.Errorf("expected model %q, got %s", tt.wantModel, payload["model"])
+			}
+		})
+	}
+}
+
+func TestDoFallsBackToVertexEmbeddings(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to create miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	metrics, err := metric.NewTracker(mr.Addr())
+	if err != nil {
+		t.Fatalf("Failed to create metrics tracker: %v", err)
+	}
+
+	transport := &mockTransport{
+		urlResponses: map[string]*http.Response{
+			"api.openai.com": {
+				StatusCode: 503,
+				Body:       io.NopCloser(bytes.NewBufferString(`{"error": {"message": "unavailable"}}`)),
+			},
+			"aiplatform.googleapis.com": {
+				StatusCode: 200,
+				Body: io.NopCloser(bytes.NewBufferString(`{"predictions": [
+					{"embeddings": {"values": [0.1, 0.2], "statistics": {"token_count": 2}}},
+					{"embeddings": {"values": [0.3, 0.4], "statistics": {"token_count": 3}}}
+				]}`)),
+			},
+		},
+	}
+
+	client := &NotDiamondHttpClient{
+		Client: &http.Client{Transport: transport},
+		Config: model.Config{
+			VertexProjectID:   "test-project",
+			VertexLocation:    "us-central1",
+			VertexTokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "vertex-token"}),
+			EmbeddingDimensions: map[string]int{
+				"openai/text-embedding-3-small": 768,
+				"vertex/text-embedding-005":     768,
+				"vertex/gemini-embedding-001":   3072,
+			},
+		},
+		MetricsTracker: metrics,
+	}
+
+	vertexURL := "https://us-central1-aiplatform.googleapis.com/v1/projects/test-project/locations/us-central1/publishers/google/models/gemini-pro:generateContent"
+	vertexReq, _ := http.NewRequest("POST", vertexURL, nil)
+	openaiReq, _ := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", nil)
+	openaiReq.Header.Set("Authorization", "Bearer test-key")
+
+	notDiamondClient := &Client{
+		HttpClient: client,
+		Clients:    []http.Request{*openaiReq, *vertexReq},
+		Models:     model.OrderedModels{"openai/text-embedding-3-small", "vertex/gemini-embedding-001", "vertex/text-embedding-005"},
+		IsOrdered:  true,
+	}
+	ctx := context.WithValue(context.Background(), ClientKey, notDiamondClient)
+
+	req, _ := http.NewRequestWithContext(ctx, "POST", "https://api.openai.com/v1/embeddings",
+		bytes.NewBufferString(`{"model":"text-embedding-3-small","input":["first","second"]}`))
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	wantURL := "https://us-central1-aiplatform.googleapis.com/v1/projects/test-project/locations/us-central1/publishers/google/models/text-embedding-005:predict"
+	if got := transport.lastRequest.URL.String(); got != wantURL {
+		t.Errorf("url = %s, want %s", got, wantURL)
+	}
+	sentBody, _ := io.ReadAll(transport.lastRequest.Body)
+	if want := `{"instances":[{"content":"first"},{"content":"second"}]}`; string(sentBody) != want {
+		t.Errorf("request body = %s, want %s", sentBody, want)
+	}
+
+	respBody, _ := io.ReadAll(resp.Body)
+	var got, want map[string]interface{}
+	if err := json.Unmarshal(respBody, &got); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	json.Unmarshal([]byte(`{
+		"object": "list",
+		"data": [
+			{"object": "embedding", "index": 0, "embedding": [0.1, 0.2]},
+			{"object": "embedding", "index": 1, "embedding": [0.3, 0.4]}
+		],
+		"usage": {"prompt_tokens": 5, "total_tokens": 5}
+	}`), &want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("response = %s, want OpenAI embeddings format", respBody)
+	}
+}
+
+func TestDoServesAnthropicCaller(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to create miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	metrics, err := metric.NewTracker(mr.Addr())
+	if err != nil {
+		t.Fatalf("Failed to create metrics tracker: %v", err)
+	}
+
+	transport := &mockTransport{
+		urlResponses: map[string]*http.Response{
+			"api.openai.com": {
+				StatusCode: 200,
+				Body: io.NopCloser(bytes.NewBufferString(`{
+					"id": "chatcmpl-1",
+					"model": "gpt-4o",
+					"choices": [{"index": 0, "message": {"role": "assistant", "content": "Bonjour"}, "finish_reason": "stop"}],
+					"usage": {"prompt_tokens": 12, "completion_tokens": 2, "total_tokens": 14}
+				}`)),
+			},
+		},
+	}
+
+	client := &NotDiamondHttpClient{
+		Client:         &http.Client{Transport: transport},
+		MetricsTracker: metrics,
+	}
+
+	openaiReq, _ := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", nil)
+	openaiReq.Header.Set("Authorization", "Bearer test-key")
+
+	notDiamondClient := &Client{
+		HttpClient: client,
+		Clients:    []http.Request{*openaiReq},
+		Models:     model.OrderedModels{"openai/gpt-4o"},
+		IsOrdered:  true,
+	}
+	ctx := context.WithValue(context.Background(), ClientKey, notDiamondClient)
+
+	req, _ := http.NewRequestWithContext(ctx, "POST", "https://api.anthropic.com/v1/messages",
+		bytes.NewBufferString(`{"model":"openai/gpt-4o","system":"Answer in French","max_tokens":100,"messages":[{"role":"user","content":[{"type":"text","text":"Hello"}]}]}`))
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sentBody, _ := io.ReadAll(transport.lastRequest.Body)
+	var sent, wantSent map[string]interface{}
+	json.Unmarshal(sentBody, &sent)
+	json.Unmarshal([]byte(`{
+		"model": "gpt-4o",
+		"max_tokens": 100,
+		"messages": [{"role": "system", "content": "Answer in French"}, {"role": "user", "content": "Hello"}]
+	}`), &wantSent)
+	if !reflect.DeepEqual(sent, wantSent) {
+		t.Errorf("request body = %s, want OpenAI format", sentBody)
+	}
+
+	respBody, _ := io.ReadAll(resp.Body)
+	var got, want map[string]interface{}
+	if err := json.Unmarshal(respBody, &got); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	json.Unmarshal([]byte(`{
+		"id": "chatcmpl-1",
+		"type": "message",
+		"role": "assistant",
+		"model": "gpt-4o",
+		"content": [{"type": "text", "text": "Bonjour"}],
+		"stop_reason": "end_turn",
+		"stop_sequence": null,
+		"usage": {"input_tokens": 12, "output_tokens": 2}
+	}`), &want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("response = %s, want Anthropic format", respBody)
+	}
+}
+
+func TestDoRetriesAnthropicCallerUnchanged(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to create miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	metrics, err := metric.NewTracker(mr.Addr())
+	if err != nil {
+		t.Fatalf("Failed to create metrics tracker: %v", err)
+	}
+
+	transport := &mockTransport{
+		responses: []*http.Response{
+			{StatusCode: 500, Body: io.NopCloser(bytes.NewBufferString(`{"type": "error", "error": {"type": "api_error", "message": "server error"}}`))},
+			{StatusCode: 200, Body: io.NopCloser(bytes.NewBufferString(`{"id": "msg_1", "type": "message", "role": "assistant", "content": [{"type": "text", "text": "Hi"}], "stop_reason": "end_turn"}`))},
+		},
+	}
+
+	client := &NotDiamondHttpClient{
+		Client:         &http.Client{Transport: transport},
+		Config:         model.Config{MaxRetries: map[string]int{"anthropic/claude-3-5-sonnet": 2}},
+		MetricsTracker: metrics,
+	}
+
+	anthropicReq, _ := http.NewRequest("POST", "https://api.anthropic.com/v1/messages", nil)
+	anthropicReq.Header.Set("x-api-key", "test-key")
+	notDiamondClient := &Client{
+		HttpClient: client,
+		Clients:    []http.Request{*anthropicReq},
+		Models:     model.OrderedModels{"anthropic/claude-3-5-sonnet"},
+		IsOrdered:  true,
+	}
+	ctx := context.WithValue(context.Background(), ClientKey, notDiamondClient)
+
+	// Nothing in the body tells it from an OpenAI one but the caller's provider
+	body := `{"model": "claude-3-5-sonnet", "max_tokens": 1024, "metadata": {"user_id": "u1"}, "messages": [{"role": "user", "content": "Hello"}]}`
+	req, _ := http.NewRequestWithContext(ctx, "POST", "https://api.anthropic.com/v1/messages", bytes.NewBufferString(body))
+	req.Header.Set("x-api-key", "test-key")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	if transport.callCount != 2 {
+		t.Fatalf("requests = %d, want 2", transport.callCount)
+	}
+	sentBody, _ := io.ReadAll(transport.lastRequest.Body)
+	if string(sentBody) != body {
+		t.Errorf("retried body = %s, want %s", sentBody, body)
+	}
+}
+
+func TestDoUsesParsedRequest(t *testing.T) {
+	body := `{"model":"gpt-4o","messages":[{"role":"user","content":"Hello"}]}`
+
+	tests := []struct {
+		name        string
+		parsedBody  string
+		expectError bool
+	}{
+		// The parsed request names a configured model, the body does not
+		{name: "parsed request of the body is used", parsedBody: body},
+		{name: "parsed request of another body is ignored", parsedBody: `{"model":"gpt-4o-mini"}`, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr, err := miniredis.Run()
+			if err != nil {
+				t.Fatalf("Failed to create miniredis: %v", err)
+			}
+			defer mr.Close()
+
+			metrics, err := metric.NewTracker(mr.Addr())
+			if err != nil {
+				t.Fatalf("Failed to create metrics tracker: %v", err)
+			}
+
+			transport := &mockTransport{
+				responses: []*http.Response{{
+					StatusCode: 200,
+					Body:       io.NopCloser(bytes.NewBufferString(`{"choices":[{"message":{"role":"assistant","content":"Hi"}}]}`)),
+				}},
+			}
+			client := &NotDiamondHttpClient{
+				Client:         &http.Client{Transport: transport},
+				MetricsTracker: metrics,
+			}
+
+			openaiReq, _ := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", nil)
+			notDiamondClient := &Client{
+				HttpClient: client,
+				Clients:    []http.Request{*openaiReq},
+				Models:     model.OrderedModels{"openai/gpt-4o-mini"},
+				IsOrdered:  true,
+			}
+
+			env := &request.Envelope{
+				Body:          []byte(tt.parsedBody),
+				Provider:      "openai",
+				ModelProvider: "openai",
+				Model:         "gpt-4o-mini",
+			}
+			ctx := request.WithEnvelope(context.WithValue(context.Background(), ClientKey, notDiamondClient), env)
+
+			req, _ := http.NewRequestWithContext(ctx, "POST", "https://api.openai.com/v1/chat/completions", bytes.NewBufferString(body))
+			_, err = client.Do(req)
+			if tt.expectError {
+				if err == nil || !strings.Contains(err.Error(), "openai/gpt-4o is not in the configured model list") {
+					t.Errorf("error = %v, want the model of the body to be refused", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestDoAppliesModelParams(t *testing.T) {
+	temperature := 0.2
+
+	tests := []struct {
+		name     string
+		url      string
+		body     string
+		failing  string // Model that fails before model answers
+		model    string
+		params   model.ModelParams
+		wantSent string
+	}{
+		{
+			name:     "caller's own provider",
+			url:      "https://api.openai.com/v1/chat/completions",
+			body:     `{"model":"o3-mini","messages":[{"role":"user","content":"Hello"}],"max_tokens":100000}`,
+			model:    "openai/o3-mini",
+			params:   model.ModelParams{ReasoningEffort: "low", MaxOutputTokens: 65536},
+			wantSent: `{"model":"o3-mini","messages":[{"role":"user","content":"Hello"}],"max_completion_tokens":65536,"reasoning_effort":"low"}`,
+		},
+		{
+			name:     "fallback within the caller's provider",
+			url:      "https://api.openai.com/v1/chat/completions",
+			body:     `{"model":"gpt-4o","messages":[{"role":"user","content":"Hello"}]}`,
+			failing:  "openai/gpt-4o",
+			model:    "openai/o3-mini",
+			params:   model.ModelParams{ReasoningEffort: "low"},
+			wantSent: `{"model":"o3-mini","messages":[{"role":"user","content":"Hello"}],"reasoning_effort":"low"}`,
+		},
+		{
+			name:   "translated for another provider",
+			url:    "https://api.anthropic.com/v1/messages",
+			body:   `{"model":"openai/gpt-4o","system":"Be brief","max_tokens":100,"metadata":{"user_id":"user-1"},"messages":[{"role":"user","content":"Hello"}]}`,
+			model:  "openai/gpt-4o",
+			params: model.ModelParams{Temperature: &temperature, Patches: []model.ParamPatch{{Op: model.ParamPatchDelete, Path: "/user"}}},
+			wantSent: `{
+				"model": "gpt-4o",
+				"max_tokens": 100,
+				"temperature": 0.2,
+				"messages": [{"role": "system", "content": "Be brief"}, {"role": "user", "content": "Hello"}]
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr, err := miniredis.Run()
+			if err != nil {
+				t.Fatalf("Failed to create miniredis: %v", err)
+			}
+			defer mr.Close()
+
+			metrics, err := metric.NewTracker(mr.Addr())
+			if err != nil {
+				t.Fatalf("Failed to create metrics tracker: %v", err)
+			}
+
+			transport := &mockTransport{
+				responses: []*http.Response{{
+					StatusCode: 200,
+					Body:       io.NopCloser(bytes.NewBufferString(`{"choices":[{"message":{"role":"assistant","content":"Hi"},"finish_reason":"stop"}]}`)),
+				}},
+			}
+			models := model.OrderedModels{tt.model}
+			if tt.failing != "" {
+				transport.responses = append([]*http.Response{{
+					StatusCode: 500,
+					Body:       io.NopCloser(bytes.NewBufferString(`{"error": {"message": "server error"}}`)),
+				}}, transport.responses...)
+				models = model.OrderedModels{tt.failing, tt.model}
+			}
+			client := &NotDiamondHttpClient{
+				Client: &http.Client{Transport: transport},
+				Config: model.Config{
+					MaxRetries:  map[string]int{tt.failing: 1},
+					ModelParams: map[string]model.ModelParams{tt.model: tt.params},
+				},
+				MetricsTracker: metrics,
+			}
+
+			openaiReq, _ := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", nil)
+			notDiamondClient := &Client{
+				HttpClient: client,
+				Clients:    []http.Request{*openaiReq},
+				Models:     models,
+				IsOrdered:  true,
+			}
+			ctx := context.WithValue(context.Background(), ClientKey, notDiamondClient)
+
+			req, _ := http.NewRequestWithContext(ctx, "POST", tt.url, bytes.NewBufferString(tt.body))
+			if _, err := client.Do(req); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			sentBody, _ := io.ReadAll(transport.lastRequest.Body)
+			var sent, wantSent map[string]interface{}
+			json.Unmarshal(sentBody, &sent)
+			json.Unmarshal([]byte(tt.wantSent), &wantSent)
+			if !reflect.DeepEqual(sent, wantSent) {
+				t.Errorf("request body = %s, want %s", sentBody, tt.wantSent)
+			}
+		})
+	}
+}
+
+func TestDoSignsFinalBedrockBody(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to create miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	metrics, err := metric.NewTracker(mr.Addr())
+	if err != nil {
+		t.Fatalf("Failed to create metrics tracker: %v", err)
+	}
+
+	transport := &mockTransport{
+		responses: []*http.Response{{
+			StatusCode: 200,
+			Body:       io.NopCloser(bytes.NewBufferString(`{"output": {"message": {"role": "assistant", "content": [{"text": "Hi"}]}}, "stopReason": "end_turn"}`)),
+		}},
+	}
+	temperature := 0.2
+	client := &NotDiamondHttpClient{
+		Client: &http.Client{Transport: transport},
+		Config: model.Config{
+			Providers: model.Providers{"bedrock": bedrock.Provider{Credentials: &bedrock.Credentials{
+				AccessKeyID:     "AKIDEXAMPLE",
+				SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+			}}},
+			ModelParams: map[string]model.ModelParams{"bedrock/amazon.nova-lite-v1:0": {Temperature: &temperature}},
+		},
+		MetricsTracker: metrics,
+	}
+
+	bedrockURL := "https://bedrock-runtime.us-east-1.amazonaws.com/model/amazon.nova-lite-v1:0/converse"
+	bedrockReq, _ := http.NewRequest("POST", bedrockURL, nil)
+	notDiamondClient := &Client{
+		HttpClient: client,
+		Clients:    []http.Request{*bedrockReq},
+		Models:     model.OrderedModels{"bedrock/amazon.nova-lite-v1:0/us-east-1"},
+		IsOrdered:  true,
+	}
+	ctx := context.WithValue(context.Background(), ClientKey, notDiamondClient)
+
+	req, _ := http.NewRequestWithContext(ctx, "POST", bedrockURL,
+		bytes.NewBufferString(`{"model":"bedrock/amazon.nova-lite-v1:0/us-east-1","messages":[{"role":"user","content":[{"text":"Hello"}]}]}`))
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if region := resp.Header.Get(response.RegionHeader); region != "us-east-1" {
+		t.Errorf("%s = %q, want us-east-1", response.RegionHeader, region)
+	}
+
+	sentBody, _ := io.ReadAll(transport.lastRequest.Body)
+	if !strings.Contains(string(sentBody), `"temperature":0.2`) {
+		t.Errorf("request body = %s, want the temperature of the model applied", sentBody)
+	}
+	want := fmt.Sprintf("%x", sha256.Sum256(sentBody))
+	if got := transport.lastRequest.Header.Get("X-Amz-Content-Sha256"); got != want {
+		t.Errorf("X-Amz-Content-Sha256 = %s, want the hash of the sent body %s", got, want)
+	}
+}
+
+func TestEmbeddingFallbacks(t *testing.T) {
+	models := []string{"openai/text-embedding-3-small", "vertex/gemini-embedding-001/us-central1", "vertex/text-embedding-005", "azure/custom-embedding"}
+	dimensions := map[string]int{
+		"openai/text-embedding-3-small": 1536,
+		"vertex/gemini-embedding-001":   3072,
+		"vertex/text-embedding-005":     768,
+	}
+
+	tests := []struct {
+		name   string
+		body   string
+		config model.Config
+		want   []string
+	}{
+		{
+			name:   "mismatched sizes are refused",
+			body:   `{"model": "text-embedding-3-small", "input": "Hello"}`,
+			config: model.Config{EmbeddingDimensions: dimensions},
+			want:   []string{"openai/text-embedding-3-small", "azure/custom-embedding"},
+		},
+		{
+			name:   "requested dimensions keep larger models",
+			body:   `{"model": "text-embedding-3-small", "input": "Hello", "dimensions": 1024}`,
+			config: model.Config{EmbeddingDimensions: dimensions},
+			want:   []string{"openai/text-embedding-3-small", "vertex/gemini-embedding-001/us-central1", "azure/custom-embedding"},
+		},
+		{
+			name:   "mismatch allowed",
+			body:   `{"model": "text-embedding-3-small", "input": "Hello"}`,
+			config: model.Config{EmbeddingDimensions: dimensions, AllowEmbeddingDimensionMismatch: true},
+			want:   models,
+		},
+		{
+			name: "unknown sizes",
+			body: `{"model": "text-embedding-3-small", "input": "Hello"}`,
+			want: models,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, err := request.ParseEnvelope(nil, []byte(tt.body), "openai", clients.Builtin())
+			if err != nil {
+				t.Fatalf("Failed to parse request: %v", err)
+			}
+			got := embeddingFallbacks(models, env, tt.config)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("embeddingFallbacks() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// streamTransport answers requests to the stream host with a body the test writes
+// to, and requests to any other host with a 500.
+type streamTransport struct {
+	streamHost string
+	body       io.ReadCloser
+	hosts      []string
+	urls       []string
+	bodies     []string
+}
+
+func (m *streamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	m.hosts = append(m.hosts, req.URL.Host)
+	m.urls = append(m.urls, req.URL.String())
+	if req.Body != nil {
+		body, _ := io.ReadAll(req.Body)
+		m.bodies = append(m.bodies, string(body))
+	}
+	if req.URL.Host != m.streamHost {
+		return &http.Response{
+			StatusCode: http.StatusInternalServerError,
+			Body:       io.NopCloser(bytes.NewBufferString(`{"error": {"message": "server error"}}`)),
+			Header:     make(http.Header),
+		}, nil
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       m.body,
+		Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+	}, nil
+}
+
+func TestDoStreamsResponse(t *testing.T) {
+	tests := []struct {
+		name       string
+		models     model.OrderedModels
+		streamHost string
+		breakOff   bool
+		wantHosts  []string
+		wantModel  string
+		wantStatus string
+	}{
+		{
+			name:       "stream passed through",
+			models:     model.OrderedModels{"openai/gpt-4o"},
+			streamHost: "api.openai.com",
+			wantHosts:  []string{"api.openai.com"},
+			wantModel:  "openai/gpt-4o",
+			wantStatus: "success",
+		},
+		{
+			name:       "fallback before the first byte",
+			models:     model.OrderedModels{"openai/gpt-4o", "local/llama3"},
+			streamHost: "localhost:11434",
+			wantHosts:  []string{"api.openai.com", "localhost:11434"},
+			wantModel:  "local/llama3",
+			wantStatus: "success",
+		},
+		{
+			name:       "stream broken off",
+			models:     model.OrderedModels{"openai/gpt-4o"},
+			streamHost: "api.openai.com",
+			breakOff:   true,
+			wantHosts:  []string{"api.openai.com"},
+			wantModel:  "openai/gpt-4o",
+			wantStatus: "failed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr, err := miniredis.Run()
+			if err != nil {
+				t.Fatalf("Failed to create miniredis: %v", err)
+			}
+			defer mr.Close()
+
+			metrics, err := metric.NewTracker(mr.Addr())
+			if err != nil {
+				t.Fatalf("Failed to create metrics tracker: %v", err)
+			}
+
+			streamReader, streamWriter := io.Pipe()
+			transport := &streamTransport{streamHost: tt.streamHost, body: streamReader}
+			client := &NotDiamondHttpClient{
+				Client: &http.Client{Transport: transport},
+				Config: model.Config{
+					Providers: model.Providers{"local": openaicompat.Provider{BaseURL: "http://localhost:11434/v1"}},
+				},
+				MetricsTracker: metrics,
+			}
+
+			openaiReq, _ := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", nil)
+			openaiReq.Header.Set("Authorization", "Bearer test-key")
+			localReq, _ := http.NewRequest("POST", "http://localhost:11434/v1/chat/completions", nil)
+			notDiamondClient := &Client{
+				HttpClient: client,
+				Clients:    []http.Request{*openaiReq, *localReq},
+				Models:     tt.models,
+				IsOrdered:  true,
+			}
+			ctx := context.WithValue(context.Background(), ClientKey, notDiamondClient)
+
+			req, _ := http.NewRequestWithContext(ctx, "POST", "https://api.openai.com/v1/chat/completions",
+				bytes.NewBufferString(`{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"Hello"}]}`))
+			req.Header.Set("Authorization", "Bearer test-key")
+
+			// Do returns before the stream has produced anything
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(transport.hosts, tt.wantHosts) {
+				t.Errorf("hosts = %v, want %v", transport.hosts, tt.wantHosts)
+			}
+
+			// Chunks reach the caller as they are written
+			first := "data: {\"choices\":[{\"delta\":{\"content\":\"Hi\"}}]}\n\n"
+			go streamWriter.Write([]byte(first))
+			chunk := make([]byte, len(first))
+			if _, err := io.ReadFull(resp.Body, chunk); err != nil {
+				t.Fatalf("failed to read first chunk: %v", err)
+			}
+			if string(chunk) != first {
+				t.Errorf("first chunk = %q, want %q", chunk, first)
+			}
+
+			if latencies, _ := mr.ZMembers("latency:" + tt.wantModel); len(latencies) != 0 {
+				t.Errorf("latency recorded before the stream ended: %v", latencies)
+			}
+
+			go func() {
+				streamWriter.Write([]byte("data: [DONE]\n\n"))
+				if tt.breakOff {
+					streamWriter.CloseWithError(errors.New("connection reset"))
+					return
+				}
+				streamWriter.Close()
+			}()
+			io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			latencies, _ := mr.ZMembers("latency:" + tt.wantModel)
+			if len(latencies) != 1 || !strings.Contains(latencies[0], `"status":"`+tt.wantStatus+`"`) {
+				t.Errorf("latencies = %v, want one with status %s", latencies, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestDoTranslatesStream(t *testing.T) {
+	vertexURL := "https://us-central1-aiplatform.googleapis.com/v1beta1/projects/test-project/locations/us-central1/publishers/google/models/gemini-pro:generateContent"
+	vertexStreamURL := "https://us-central1-aiplatform.googleapis.com/v1beta1/projects/test-project/locations/us-central1/publishers/google/models/gemini-pro:streamGenerateContent?alt=sse"
+
+	tests := []struct {
+		name       string
+		url        string
+		body       string
+		models     model.OrderedModels
+		streamHost string
+		stream     string
+		wantURL    string
+		wantBody   string
+		want       string
+	}{
+		{
+			name:       "vertex stream for openai caller",
+			url:        "https://api.openai.com/v1/chat/completions",
+			body:       `{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"Hello"}]}`,
+			models:     model.OrderedModels{"openai/gpt-4o", "vertex/gemini-pro"},
+			streamHost: "us-central1-aiplatform.googleapis.com",
+			stream: "data: {\"candidates\": [{\"content\": {\"role\": \"model\", \"parts\": [{\"text\": \"Hi\"}]}}]}\r\n\r\n" +
+				"data: {\"candidates\": [{\"content\": {\"role\": \"model\", \"parts\": [{\"text\": \"!\"}]}, \"finishReason\": \"STOP\"}]}\r\n\r\n",
+			wantURL: vertexStreamURL,
+			want: "data: {\"choices\":[{\"delta\":{\"content\":\"Hi\",\"role\":\"assistant\"},\"finish_reason\":null,\"index\":0}],\"object\":\"chat.completion.chunk\"}\n\n" +
+				"data: {\"choices\":[{\"delta\":{\"content\":\"!\",\"role\":\"assistant\"},\"finish_reason\":\"stop\",\"index\":0}],\"object\":\"chat.completion.chunk\"}\n\n" +
+				"data: [DONE]\n\n",
+		},
+		{
+			name:       "vertex stream with tool calls for openai caller",
+			url:        "https://api.openai.com/v1/chat/completions",
+			body:       `{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"Hello"}]}`,
+			models:     model.OrderedModels{"openai/gpt-4o", "vertex/gemini-pro"},
+			streamHost: "us-central1-aiplatform.googleapis.com",
+			stream: "data: {\"candidates\": [{\"content\": {\"role\": \"model\", \"parts\": [{\"functionCall\": {\"name\": \"a\", \"args\": {}}}]}}]}\n\n" +
+				"data: {\"candidates\": [{\"content\": {\"role\": \"model\", \"parts\": [{\"functionCall\": {\"name\": \"b\", \"args\": {}}}]}, \"finishReason\": \"STOP\"}]}\n\n",
+			wantURL: vertexStreamURL,
+			want: "data: {\"choices\":[{\"delta\":{\"content\":\"\",\"role\":\"assistant\",\"tool_calls\":[{\"function\":{\"arguments\":\"{}\",\"name\":\"a\"},\"id\":\"call_0\",\"index\":0,\"type\":\"function\"}]},\"finish_reason\":null,\"index\":0}],\"object\":\"chat.completion.chunk\"}\n\n" +
+				"data: {\"choices\":[{\"delta\":{\"content\":\"\",\"role\":\"assistant\",\"tool_calls\":[{\"function\":{\"arguments\":\"{}\",\"name\":\"b\"},\"id\":\"call_1\",\"index\":1,\"type\":\"function\"}]},\"finish_reason\":\"tool_calls\",\"index\":0}],\"object\":\"chat.completion.chunk\"}\n\n" +
+				"data: [DONE]\n\n",
+		},
+		{
+			name:       "openai stream for vertex caller",
+			url:        vertexStreamURL,
+			body:       `{"model":"gemini-pro","contents":[{"role":"user","parts":[{"text":"Hello"}]}]}`,
+			models:     model.OrderedModels{"vertex/gemini-pro/us-east1", "openai/gpt-4o"},
+			streamHost: "api.openai.com",
+			stream: "data: {\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\",\"content\":\"\"},\"finish_reason\":null}]}\n\n" +
+				"data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hi\"},\"finish_reason\":null}]}\n\n" +
+				"data: {\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\n" +
+				"data: [DONE]\n\n",
+			wantURL:  "https://api.openai.com/v1/chat/completions",
+			wantBody: `"stream":true`,
+			want: "data: {\"candidates\":[{\"content\":{\"parts\":[{\"text\":\"Hi\"}],\"role\":\"model\"},\"index\":0}]}\n\n" +
+				"data: {\"candidates\":[{\"content\":{\"parts\":[{\"text\":\"\"}],\"role\":\"model\"},\"finishReason\":\"STOP\",\"index\":0}]}\n\n",
+		},
+		{
+			name:       "anthropic stream for openai caller",
+			url:        "https://api.openai.com/v1/chat/completions",
+			body:       `{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"Hello"}]}`,
+			models:     model.OrderedModels{"openai/gpt-4o", "anthropic/claude-3-5-sonnet"},
+			streamHost: "api.anthropic.com",
+			stream: "event: message_start\ndata: {\"type\": \"message_start\", \"message\": {\"id\": \"msg_1\", \"model\": \"claude-3-5-sonnet\", \"usage\": {\"input_tokens\": 5}}}\n\n" +
+				"event: content_block_start\ndata: {\"type\": \"content_block_start\", \"index\": 0, \"content_block\": {\"type\": \"text\", \"text\": \"\"}}\n\n" +
+				"event: content_block_delta\ndata: {\"type\": \"content_block_delta\", \"index\": 0, \"delta\": {\"type\": \"text_delta\", \"text\": \"Hi\"}}\n\n" +
+				"event: content_block_stop\ndata: {\"type\": \"content_block_stop\", \"index\": 0}\n\n" +
+				"event: message_delta\ndata: {\"type\": \"message_delta\", \"delta\": {\"stop_reason\": \"end_turn\"}, \"usage\": {\"output_tokens\": 1}}\n\n" +
+				"event: message_stop\ndata: {\"type\": \"message_stop\"}\n\n",
+			wantURL:  "https://api.anthropic.com/v1/messages",
+			wantBody: `"stream":true`,
+			want: "data: {\"choices\":[{\"delta\":{\"content\":\"\",\"role\":\"assistant\"},\"finish_reason\":null,\"index\":0}],\"id\":\"msg_1\",\"model\":\"claude-3-5-sonnet\",\"object\":\"chat.completion.chunk\"}\n\n" +
+				"data: {\"choices\":[{\"delta\":{\"content\":\"Hi\"},\"finish_reason\":null,\"index\":0}],\"id\":\"msg_1\",\"model\":\"claude-3-5-sonnet\",\"object\":\"chat.completion.chunk\"}\n\n" +
+				"data: {\"choices\":[{\"delta\":{},\"finish_reason\":\"stop\",\"index\":0}],\"id\":\"msg_1\",\"model\":\"claude-3-5-sonnet\",\"object\":\"chat.completion.chunk\",\"usage\":{\"completion_tokens\":1,\"prompt_tokens\":5,\"total_tokens\":6}}\n\n" +
+				"data: [DONE]\n\n",
+		},
+		{
+			name:       "openai stream for anthropic caller",
+			url:        "https://api.anthropic.com/v1/messages",
+			body:       `{"model":"claude-3-5-sonnet","max_tokens":100,"stream":true,"messages":[{"role":"user","content":"Hello"}]}`,
+			models:     model.OrderedModels{"anthropic/claude-3-5-sonnet", "openai/gpt-4o"},
+			streamHost: "api.openai.com",
+			stream: "data: {\"id\":\"chatcmpl-1\",\"model\":\"gpt-4o\",\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\",\"content\":\"\"},\"finish_reason\":null}]}\n\n" +
+				"data: {\"id\":\"chatcmpl-1\",\"model\":\"gpt-4o\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hi\"},\"finish_reason\":null}]}\n\n" +
+				"data: {\"id\":\"chatcmpl-1\",\"model\":\"gpt-4o\",\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\n" +
+				"data: [DONE]\n\n",
+			wantURL:  "https://api.openai.com/v1/chat/completions",
+			wantBody: `"stream":true`,
+			want: "event: message_start\ndata: {\"message\":{\"content\":[],\"id\":\"chatcmpl-1\",\"model\":\"gpt-4o\",\"role\":\"assistant\",\"stop_reason\":null,\"stop_sequence\":null,\"type\":\"message\",\"usage\":{\"input_tokens\":0,\"output_tokens\":0}},\"type\":\"message_start\"}\n\n" +
+				"event: content_block_start\ndata: {\"content_block\":{\"text\":\"\",\"type\":\"text\"},\"index\":0,\"type\":\"content_block_start\"}\n\n" +
+				"event: content_block_delta\ndata: {\"delta\":{\"text\":\"Hi\",\"type\":\"text_delta\"},\"index\":0,\"type\":\"content_block_delta\"}\n\n" +
+				"event: content_block_stop\ndata: {\"index\":0,\"type\":\"content_block_stop\"}\n\n" +
+				"event: message_delta\ndata: {\"delta\":{\"stop_reason\":\"end_turn\",\"stop_sequence\":null},\"type\":\"message_delta\",\"usage\":{\"input_tokens\":0,\"output_tokens\":0}}\n\n" +
+				"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr, err := miniredis.Run()
+			if err != nil {
+				t.Fatalf("Failed to create miniredis: %v", err)
+			}
+			defer mr.Close()
+
+			metrics, err := metric.NewTracker(mr.Addr())
+			if err != nil {
+				t.Fatalf("Failed to create metrics tracker: %v", err)
+			}
+
+			transport := &streamTransport{streamHost: tt.streamHost, body: io.NopCloser(strings.NewReader(tt.stream))}
+			client := &NotDiamondHttpClient{
+				Client: &http.Client{Transport: transport},
+				Config: model.Config{
+					VertexProjectID:   "test-project",
+					VertexLocation:    "us-central1",
+					VertexTokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "vertex-token"}),
+				},
+				MetricsTracker: metrics,
+			}
+
+			vertexReq, _ := http.NewRequest("POST", vertexURL, nil)
+			openaiReq, _ := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", nil)
+			openaiReq.Header.Set("Authorization", "Bearer test-key")
+			anthropicReq, _ := http.NewRequest("POST", "https://api.anthropic.com/v1/messages", nil)
+			anthropicReq.Header.Set("x-api-key", "test-key")
+			notDiamondClient := &Client{
+				HttpClient: client,
+				Clients:    []http.Request{*vertexReq, *openaiReq, *anthropicReq},
+				Models:     tt.models,
+				IsOrdered:  true,
+			}
+			ctx := context.WithValue(context.Background(), ClientKey, notDiamondClient)
+
+			req, _ := http.NewRequestWithContext(ctx, "POST", tt.url, bytes.NewBufferString(tt.body))
+			req.Header.Set("Authorization", "Bearer test-key")
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatalf("failed to read stream: %v", err)
+			}
+
+			last := len(transport.urls) - 1
+			if transport.urls[last] != tt.wantURL {
+				t.Errorf("url = %s, want %s", transport.urls[last], tt.wantURL)
+			}
+			if !strings.Contains(transport.bodies[last], tt.wantBody) {
+				t.Errorf("request body = %s, want it to contain %s", transport.bodies[last], tt.wantBody)
+			}
+			if string(got) != tt.want {
+				t.Errorf("stream = %q, want %q", got, tt.want)
+			}
+			if resp.ContentLength != -1 {
+				t.Errorf("ContentLength = %d, want -1", resp.ContentLength)
+			}
+		})
+	}
+}
+
+func TestStreamFallbacks(t *testing.T) {
+	models := []string{"openai/gpt-4o", "bedrock/amazon.nova-lite-v1:0/us-east-1", "anthropic/claude-3-5-sonnet", "vertex/gemini-pro/us-central1"}
+
+	tests := []struct {
+		name           string
+		callerProvider string
+		want           []string
+	}{
+		{
+			name:           "models without stream translation skipped",
+			callerProvider: "openai",
+			want:           []string{"openai/gpt-4o", "anthropic/claude-3-5-sonnet", "vertex/gemini-pro/us-central1"},
+		},
+		{
+			name:           "anthropic caller",
+			callerProvider: "anthropic",
+			want:           []string{"openai/gpt-4o", "anthropic/claude-3-5-sonnet", "vertex/gemini-pro/us-central1"},
+		},
+		{
+			name:           "caller without stream translation keeps its own provider",
+			callerProvider: "bedrock",
+			want:           []string{"bedrock/amazon.nova-lite-v1:0/us-east-1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := streamFallbacks(models, tt.callerProvider, clients.Builtin())
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("streamFallbacks() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
 
-				client := &NotDiamondHttpClient{
-					Client: &http.Client{Transport: transport},
-					Config: model.Config{
-						MaxRetries: map[string]int{
-							"openai/gpt-4":    3,
-							"openai/gpt-4/us": 3,
-							"openai/gpt-4/eu": 3,
-							"azure/gpt-4":     3,
-						},
-						Timeout: map[string]float64{
-							"openai/gpt-4":    30.0,
-							"openai/gpt-4/us": 30.0,
-							"openai/gpt-4/eu": 30.0,
-							"azure/gpt-4":     30.0,
-						},
-						ModelLatency: model.ModelLatency{
-							"openai/gpt-4": &model.RollingAverageLatency{
-								AvgLatencyThreshold: 3.5,
-								NoOfCalls:           5,
-								RecoveryTime:        5 * time.Minute,
-							},
-							"openai/gpt-4/us": &model.RollingAverageLatency{
-								AvgLatencyThreshold: 3.5,
-								NoOfCalls:           5,
-								RecoveryTime:        5 * time.Minute,
-							},
-							"openai/gpt-4/eu": &model.RollingAverageLatency{
-								AvgLatencyThreshold: 3.5,
-								NoOfCalls:           5,
-								RecoveryTime:        5 * time.Minute,
-							},
-							"azure/gpt-4": &model.RollingAverageLatency{
-								AvgLatencyThreshold: 3.5,
-								NoOfCalls:           5,
-								RecoveryTime:        5 * time.Minute,
-							},
-						},
-						RedisConfig: &redis.Config{
-							Addr: mr.Addr(),
-						},
-					},
-					MetricsTracker: metrics,
-				}
+// keyTransport answers with the status configured for the bearer key of the request.
+type keyTransport struct {
+	statuses map[string]int
+	keys     []string
+}
 
-				return client, transport
+func (m *keyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	m.keys = append(m.keys, key)
+	if req.Body != nil {
+		if body, _ := io.ReadAll(req.Body); len(body) == 0 {
+			return nil, errors.New("empty request body")
+		}
+	}
+
+	status, ok := m.statuses[key]
+	if !ok {
+		status = http.StatusOK
+	}
+	body := `{"choices": [{"message": {"content": "Hi"}}]}`
+	if status != http.StatusOK {
+		body = `{"error": {"message": "rejected"}}`
+	}
+	return &http.Response{
+		StatusCode: status,
+		Body:       io.NopCloser(bytes.NewBufferString(body)),
+		Header:     make(http.Header),
+	}, nil
+}
+
+func TestDoRotatesPoolKeys(t *testing.T) {
+	tests := []struct {
+		name       string
+		pool       model.KeyPool
+		statuses   map[string]int
+		requests   int
+		wantKeys   []string
+		wantErr    bool
+		wantErrors int
+	}{
+		{
+			name:     "round robin across requests",
+			pool:     model.KeyPool{Keys: []string{"key-a", "key-b"}},
+			requests: 3,
+			wantKeys: []string{"key-a", "key-b", "key-a"},
+		},
+		{
+			name:     "rate limited key is rotated",
+			pool:     model.KeyPool{Keys: []string{"key-a", "key-b"}},
+			statuses: map[string]int{"key-a": http.StatusTooManyRequests},
+			requests: 2,
+			wantKeys: []string{"key-a", "key-b", "key-b"},
+		},
+		{
+			name:     "unauthorized key is rotated",
+			pool:     model.KeyPool{Keys: []string{"key-a", "key-b"}},
+			statuses: map[string]int{"key-a": http.StatusUnauthorized},
+			requests: 1,
+			wantKeys: []string{"key-a", "key-b"},
+		},
+		{
+			name: "all keys rate limited",
+			pool: model.KeyPool{Keys: []string{"key-a", "key-b"}},
+			statuses: map[string]int{
+				"key-a": http.StatusTooManyRequests,
+				"key-b": http.StatusTooManyRequests,
 			},
-			expectedCalls: 1,
-			expectError:   false,
+			requests:   1,
+			wantKeys:   []string{"key-a", "key-b"},
+			wantErr:    true,
+			wantErrors: 1,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			client, transport := tt.setupClient()
+			mr, err := miniredis.Run()
+			if err != nil {
+				t.Fatalf("Failed to create miniredis: %v", err)
+			}
+			defer mr.Close()
 
-			// Create requests for both OpenAI and Azure
-			openaiReq, _ := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions",
-				bytes.NewBufferString(`{"model":"gpt-4","messages":[{"role":"user","content":"Hello"}]}`))
-			openaiReq.Header.Set("Authorization", "Bearer test-key")
+			metrics, err := metric.NewTracker(mr.Addr())
+			if err != nil {
+				t.Fatalf("Failed to create metrics tracker: %v", err)
+			}
 
-			azureReq, _ := http.NewRequest("POST", "https://myresource.azure.openai.com",
-				bytes.NewBufferString(`{"messages":[{"role":"user","content":"Hello"}]}`))
-			azureReq.Header.Set("api-key", "test-key")
+			transport := &keyTransport{statuses: tt.statuses}
+			client := &NotDiamondHttpClient{
+				Client: &http.Client{Transport: transport},
+				Config: model.Config{
+					MaxRetries: map[string]int{"openai/gpt-4": 1},
+					KeyPools:   map[string]model.KeyPool{"openai": tt.pool},
+					RedisConfig: &redis.Config{
+						Addr: mr.Addr(),
+					},
+				},
+				MetricsTracker: metrics,
+			}
 
-			// Create NotDiamondClient and add it to context
+			openaiReq, _ := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", nil)
+			openaiReq.Header.Set("Authorization", "Bearer client-key")
 			notDiamondClient := &Client{
 				HttpClient: client,
-				Clients:    []http.Request{*openaiReq, *azureReq}, // Add both client requests to the clients list
-				Models:     model.OrderedModels{"openai/gpt-4", "azure/gpt-4"},
-				ModelProviders: map[string]map[string]bool{
-					"openai": {
-						"gpt-4": true,
-					},
-					"azure": {
-						"gpt-4": true,
-					},
-				},
-				IsOrdered: true,
+				Clients:    []http.Request{*openaiReq},
+				Models:     model.OrderedModels{"openai/gpt-4"},
+				IsOrdered:  true,
 			}
 			ctx := context.WithValue(context.Background(), ClientKey, notDiamondClient)
-			openaiReq = openaiReq.WithContext(ctx)
 
-			// Make the request
-			resp, err := client.Do(openaiReq)
+			for i := 0; i < tt.requests; i++ {
+				req, _ := http.NewRequestWithContext(ctx, "POST", "https://api.openai.com/v1/chat/completions",
+					bytes.NewBufferString(`{"model":"gpt-4","messages":[{"role":"user","content":"Hello"}]}`))
+				req.Header.Set("Authorization", "Bearer client-key")
 
-			if tt.expectError {
-				if err == nil {
-					t.Error("expected error but got none")
-				} else if tt.errorContains != "" && !strings.Contains(err.Error(), tt.errorContains) {
-					t.Errorf("expected error containing %q but got %q", tt.errorContains, err.Error())
+				resp, err := client.Do(req)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("Do() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if err == nil && resp.StatusCode != http.StatusOK {
+					t.Fatalf("expected status 200, got %d", resp.StatusCode)
 				}
-				return
 			}
 
-			if err != nil {
-				t.Errorf("unexpected error: %v", err)
-				return
+			if !reflect.DeepEqual(transport.keys, tt.wantKeys) {
+				t.Errorf("keys = %v, want %v", transport.keys, tt.wantKeys)
 			}
 
-			if resp == nil {
-				t.Error("expected response but got nil")
-				return
+			// Rotated errors are not counted against the model
+			errorEntries, _ := mr.ZMembers("errors:openai/gpt-4")
+			errorCounts := 0
+			for _, entry := range errorEntries {
+				if strings.Contains(entry, `"status_code":429`) {
+					errorCounts++
+				}
 			}
-
-			if transport.callCount != tt.expectedCalls {
-				t.Errorf("expected %d calls but got %d", tt.expectedCalls, transport.callCount)
+			if errorCounts != tt.wantErrors {
+				t.Errorf("recorded 429 errors = %d, want %d", errorCounts, tt.wantErrors)
 			}
 		})
 	}
 }
 
+func TestDoUnconfiguredHost(t *testing.T) {
+	openaiReq, _ := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", nil)
+	notDiamondClient := &Client{
+		HttpClient: &NotDiamondHttpClient{Client: &http.Client{Transport: &mockTransport{}}},
+		Clients:    []http.Request{*openaiReq},
+		Models:     model.OrderedModels{"openai/gpt-4"},
+		IsOrdered:  true,
+	}
+	ctx := context.WithValue(context.Background(), ClientKey, notDiamondClient)
+
+	// The host contains "openai" but is not a configured client
+	req, _ := http.NewRequestWithContext(ctx, "POST", "https://openai.example.com/v1/chat/completions",
+		bytes.NewBufferString(`{"model":"gpt-4","messages":[{"role":"user","content":"Hello"}]}`))
+
+	_, err := notDiamondClient.HttpClient.Do(req)
+	if err == nil || !strings.Contains(err.Error(), "no configured client matches host openai.example.com") {
+		t.Errorf("expected unconfigured host error, got %v", err)
+	}
+}
+
 func TestDoWithLatencies(t *testing.T) {
 	tests := []struct {
 		name          string
@@ -1400,7 +2773,7 @@ func TestDoWithLatencies(t *testing.T) {
 				bytes.NewBufferString(`{"model":"gpt-4","messages":[{"role":"user","content":"Hello"}]}`))
 			openaiReq.Header.Set("Authorization", "Bearer test-key")
 
-			azureReq, _ := http.NewRequest("POST", "https://myresource.azure.openai.com",
+			azureReq, _ := http.NewRequest("POST", "https://myresource.openai.azure.com",
 				bytes.NewBufferString(`{"messages":[{"role":"user","content":"Hello"}]}`))
 			azureReq.Header.Set("api-key", "test-key")
 
@@ -1493,8 +2866,8 @@ func TestNewNotDiamondHttpClient(t *testing.T) {
 					Addr: s.Addr(),
 				},
 				AzureAPIVersion: "2023-05-15",
-				AzureRegions: map[string]string{
-					"eastus": "https://eastus-resource.openai.azure.com",
+				AzureRegions: map[string]model.AzureRegion{
+					"eastus": {Endpoint: "https://eastus-resource.openai.azure.com"},
 				},
 			},
 			wantErr: false,
@@ -1729,8 +3102,8 @@ func TestUpdateRequestURL(t *testing.T) {
 				HttpClient: &NotDiamondHttpClient{
 					Config: model.Config{
 						AzureAPIVersion: "2023-05-15",
-						AzureRegions: map[string]string{
-							"eastus": "https://eastus-resource.openai.azure.com",
+						AzureRegions: map[string]model.AzureRegion{
+							"eastus": {Endpoint: "https://eastus-resource.openai.azure.com"},
 						},
 					},
 				},
@@ -1746,23 +3119,19 @@ func TestUpdateRequestURL(t *testing.T) {
 			name:      "Azure provider with region not in AzureRegions map",
 			req:       mustNewRequest("POST", "https://myresource.openai.azure.com/v1/chat/completions", nil),
 			provider:  "azure",
-			modelName: "gpt-4",
+			modelName: "gpt-4/westus",
 			client: &Client{
 				HttpClient: &NotDiamondHttpClient{
 					Config: model.Config{
 						AzureAPIVersion: "2023-05-15",
-						AzureRegions: map[string]string{
-							"eastus": "https://eastus-resource.openai.azure.com",
+						AzureRegions: map[string]model.AzureRegion{
+							"eastus": {Endpoint: "https://eastus-resource.openai.azure.com"},
 						},
 					},
 				},
 			},
-			wantURL:    "https://myresource.openai.azure.com/openai/deployments/gpt-4/chat/completions?api-version=2023-05-15",
-			wantScheme: "https",
-			wantHost:   "myresource.openai.azure.com",
-			wantPath:   "/openai/deployments/gpt-4/chat/completions",
-			wantQuery:  "api-version=2023-05-15",
-			wantErr:    false,
+			wantErr:     true,
+			errContains: "azure region westus is not configured in AzureRegions",
 		},
 		{
 			name:       "Vertex provider with project ID and location",
@@ -1790,6 +3159,32 @@ func TestUpdateRequestURL(t *testing.T) {
 			wantQuery:  "",
 			wantErr:    false,
 		},
+		{
+			name:       "Vertex provider switching model",
+			req:        mustNewRequest("POST", "https://us-central1-aiplatform.googleapis.com/v1/projects/test-project/locations/us-central1/publishers/google/models/gemini-pro:generateContent", nil),
+			provider:   "vertex",
+			modelName:  "gemini-1.5-flash/us-east4",
+			client:     &Client{HttpClient: &NotDiamondHttpClient{Config: model.Config{VertexProjectID: "test-project", VertexLocation: "us-central1"}}},
+			wantURL:    "https://us-east4-aiplatform.googleapis.com/v1/projects/test-project/locations/us-east4/publishers/google/models/gemini-1.5-flash:generateContent",
+			wantScheme: "https",
+			wantHost:   "us-east4-aiplatform.googleapis.com",
+			wantPath:   "/v1/projects/test-project/locations/us-east4/publishers/google/models/gemini-1.5-flash:generateContent",
+			wantQuery:  "",
+			wantErr:    false,
+		},
+		{
+			name:       "Vertex provider embeddings",
+			req:        mustNewRequest("POST", "https://us-central1-aiplatform.googleapis.com/v1/projects/test-project/locations/us-central1/publishers/google/models/gemini-pro:generateContent", nil).WithContext(model.WithEmbeddings(context.Background())),
+			provider:   "vertex",
+			modelName:  "text-embedding-005",
+			client:     &Client{HttpClient: &NotDiamondHttpClient{Config: model.Config{VertexProjectID: "test-project", VertexLocation: "us-central1"}}},
+			wantURL:    "https://us-central1-aiplatform.googleapis.com/v1/projects/test-project/locations/us-central1/publishers/google/models/text-embedding-005:predict",
+			wantScheme: "https",
+			wantHost:   "us-central1-aiplatform.googleapis.com",
+			wantPath:   "/v1/projects/test-project/locations/us-central1/publishers/google/models/text-embedding-005:predict",
+			wantQuery:  "",
+			wantErr:    false,
+		},
 		{
 			name:        "Vertex provider without project ID",
 			req:         mustNewRequest("POST", "https://us-central1-aiplatform.googleapis.com/v1/projects/test-project/locations/us-central1/publishers/google/models/gemini-pro:generateContent", nil),
@@ -1804,6 +3199,19 @@ func TestUpdateRequestURL(t *testing.T) {
 			wantErr:     true,
 			errContains: "vertex project ID is not set in the configuration",
 		},
+		{
+			name:       "Anthropic provider",
+			req:        mustNewRequest("POST", "https://api.anthropic.com/v1/complete?beta=true", nil),
+			provider:   "anthropic",
+			modelName:  "claude-3-haiku",
+			client:     &Client{HttpClient: &NotDiamondHttpClient{Config: model.Config{}}},
+			wantURL:    "https://api.anthropic.com/v1/messages",
+			wantScheme: "https",
+			wantHost:   "api.anthropic.com",
+			wantPath:   "/v1/messages",
+			wantQuery:  "",
+			wantErr:    false,
+		},
 		{
 			name:       "Unsupported provider",
 			req:        mustNewRequest("POST", "https://api.example.com/v1/completions", nil),
@@ -1891,6 +3299,36 @@ func TestUpdateRequestAuth(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "Anthropic provider from OpenAI key",
+			req: func() *http.Request {
+				req := httptest.NewRequest("POST", "https://api.anthropic.com/v1/messages", nil)
+				req.Header.Set("Authorization", "Bearer test-anthropic-key")
+				return req
+			}(),
+			provider: "anthropic",
+			ctx:      context.Background(),
+			wantHeaders: map[string]string{
+				"x-api-key":         "test-anthropic-key",
+				"anthropic-version": "2023-06-01",
+				"Authorization":     "",
+			},
+			wantErr: false,
+		},
+		{
+			name: "OpenAI provider from Anthropic key",
+			req: func() *http.Request {
+				req := httptest.NewRequest("POST", "https://api.openai.com/v1/chat/completions", nil)
+				req.Header.Set("x-api-key", "test-key")
+				return req
+			}(),
+			provider: "openai",
+			ctx:      context.Background(),
+			wantHeaders: map[string]string{
+				"Authorization": "Bearer test-key",
+			},
+			wantErr: false,
+		},
 		{
 			name:     "Vertex provider",
 			req:      httptest.NewRequest("POST", "https://us-central1-aiplatform.googleapis.com/v1/projects/test-project/locations/us-central1/publishers/google/models/gemini-pro:generateContent", nil),
@@ -1903,15 +3341,18 @@ func TestUpdateRequestAuth(t *testing.T) {
 		},
 	}
 
+	// Vertex tokens come from the configured token source instead of the default credentials
+	client := &Client{
+		HttpClient: &NotDiamondHttpClient{
+			Config: model.Config{
+				VertexTokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "mock-token"}),
+			},
+		},
+	}
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			// For Vertex tests, we need to mock the credentials
-			if tt.provider == "vertex" {
-				// In a real test, we would mock this, but for now we'll just skip this test
-				t.Skip("Skipping vertex test as we can't mock google.FindDefaultCredentials")
-			}
-
-			err := updateRequestAuth(tt.req, tt.provider, tt.ctx)
+			err := updateRequestAuth(tt.req, tt.provider, "", tt.ctx, client)
 
 			// Check error
 			if (err != nil) != tt.wantErr {
@@ -2247,6 +3688,78 @@ func TestTransformRequestForProvider(t *testing.T) {
 				return true
 			},
 		},
+		{
+			name:         "transform to Anthropic format",
+			originalBody: `{"model":"gpt-4","messages":[{"role":"system","content":"Be brief"},{"role":"user","content":"Hello"}]}`,
+			nextProvider: "anthropic",
+			nextModel:    "claude-3-haiku",
+			setupClient:  func() *Client { return &Client{} },
+			expectError:  false,
+			checkResult: func(t *testing.T, result []byte) bool {
+				var data map[string]interface{}
+				if err := json.Unmarshal(result, &data); err != nil {
+					t.Fatalf("Failed to unmarshal result: %v", err)
+					return false
+				}
+
+				if model, ok := data["model"].(string); !ok || model != "claude-3-haiku" {
+					t.Errorf("Expected model 'claude-3-haiku', got %v", data["model"])
+					return false
+				}
+
+				if system, ok := data["system"].(string); !ok || system != "Be brief" {
+					t.Errorf("Expected system 'Be brief', got %v", data["system"])
+					return false
+				}
+
+				if maxTokens, ok := data["max_tokens"].(float64); !ok || maxTokens != 1024 {
+					t.Errorf("Expected max_tokens 1024, got %v", data["max_tokens"])
+					return false
+				}
+
+				messages, ok := data["messages"].([]interface{})
+				if !ok || len(messages) != 1 {
+					t.Errorf("Expected 1 message, got %v", data["messages"])
+					return false
+				}
+
+				return true
+			},
+		},
+		{
+			name:         "transform Anthropic payload to OpenAI format",
+			originalBody: `{"model":"claude-3-haiku","system":"Be brief","max_tokens":100,"messages":[{"role":"user","content":"Hello"}]}`,
+			nextProvider: "openai",
+			nextModel:    "gpt-4",
+			setupClient:  func() *Client { return &Client{} },
+			expectError:  false,
+			checkResult: func(t *testing.T, result []byte) bool {
+				var data map[string]interface{}
+				if err := json.Unmarshal(result, &data); err != nil {
+					t.Fatalf("Failed to unmarshal result: %v", err)
+					return false
+				}
+
+				if _, exists := data["system"]; exists {
+					t.Errorf("Expected system field to be moved into messages, got %v", data["system"])
+					return false
+				}
+
+				messages, ok := data["messages"].([]interface{})
+				if !ok || len(messages) != 2 {
+					t.Errorf("Expected 2 messages, got %v", data["messages"])
+					return false
+				}
+
+				first, ok := messages[0].(map[string]interface{})
+				if !ok || first["role"] != "system" || first["content"] != "Be brief" {
+					t.Errorf("Expected first message to be the system prompt, got %v", messages[0])
+					return false
+				}
+
+				return true
+			},
+		},
 		{
 			name:         "unsupported provider",
 			originalBody: `{"model":"gpt-4","messages":[{"role":"user","content":"Hello"}]}`,
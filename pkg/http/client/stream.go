@@ -0,0 +1,180 @@
+package http_client
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/Not-Diamond/go-notdiamond/pkg/metric"
+	"github.com/Not-Diamond/go-notdiamond/pkg/model"
+)
+
+// streamBody passes a streamed response through to the caller as it arrives. The
+// latency of the whole stream is recorded when it ends, and the request context
+// is released when the stream ends or is closed.
+type streamBody struct {
+	io.ReadCloser
+	modelFull      string
+	startTime      time.Time
+	metricsTracker *metric.Tracker
+	release        func()
+	once           sync.Once
+}
+
+// newStreamBody wraps the body of a streamed response.
+func newStreamBody(body io.ReadCloser, modelFull string, startTime time.Time, metricsTracker *metric.Tracker, release func()) *streamBody {
+	return &streamBody{
+		ReadCloser:     body,
+		modelFull:      modelFull,
+		startTime:      startTime,
+		metricsTracker: metricsTracker,
+		release:        release,
+	}
+}
+
+func (b *streamBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		b.end(err)
+	}
+	return n, err
+}
+
+func (b *streamBody) Close() error {
+	err := b.ReadCloser.Close()
+	// A stream closed before its end was abandoned by the caller, which says
+	// nothing about the model, so no latency is recorded.
+	b.once.Do(b.release)
+	return err
+}
+
+// end records the latency of the stream once, as failed if it broke off.
+func (b *streamBody) end(err error) {
+	b.once.Do(func() {
+		defer b.release()
+
+		status := "success"
+		if !errors.Is(err, io.EOF) {
+			status = "failed"
+			slog.Error("❌ Stream failed", "model", b.modelFull, "error", err)
+		}
+
+		elapsed := time.Since(b.startTime).Seconds()
+		slog.Info("📡 Stream ended", "model", b.modelFull, "status", status, "latency", elapsed)
+		if b.metricsTracker == nil {
+			return
+		}
+		if recErr := b.metricsTracker.RecordLatency(b.modelFull, elapsed, status); recErr != nil {
+			slog.Error("recording latency", "error", recErr)
+		}
+	})
+}
+
+// translatedStream is a streamed response whose SSE events are translated from
+// one provider's format to another's as they arrive.
+type translatedStream struct {
+	*io.PipeReader
+	body io.ReadCloser
+}
+
+// newTranslatedStream translates the events of body with the decoder of the
+// responding provider and the encoder of the caller's provider.
+func newTranslatedStream(body io.ReadCloser, from, to model.StreamProvider) *translatedStream {
+	if stateful, ok := from.(model.StatefulStreamProvider); ok {
+		from = stateful.NewStream()
+	}
+	if stateful, ok := to.(model.StatefulStreamProvider); ok {
+		to = stateful.NewStream()
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer body.Close()
+		pw.CloseWithError(translateEvents(pw, body, from, to))
+	}()
+	return &translatedStream{PipeReader: pr, body: body}
+}
+
+func (s *translatedStream) Close() error {
+	s.PipeReader.Close()
+	return s.body.Close()
+}
+
+// translateEvents copies the SSE events of r to w, translating the data of each
+// event. Fields other than data are dropped. The caller's end of stream event is
+// written once r is exhausted.
+func translateEvents(w io.Writer, r io.Reader, from, to model.StreamProvider) error {
+	reader := bufio.NewReader(r)
+	var data [][]byte
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		eof := err != nil
+
+		line = bytes.TrimRight(line, "\r\n")
+		if value, ok := bytes.CutPrefix(line, []byte("data:")); ok {
+			data = append(data, bytes.TrimSpace(value))
+		}
+		if (len(line) == 0 || eof) && len(data) > 0 {
+			if err := translateEvent(w, bytes.Join(data, []byte("\n")), from, to); err != nil {
+				return err
+			}
+			data = nil
+		}
+
+		if eof {
+			return writeEvent(w, to, []byte("[DONE]"))
+		}
+	}
+}
+
+// translateEvent writes the translation of the data of one event to w.
+func translateEvent(w io.Writer, data []byte, from, to model.StreamProvider) error {
+	decoded, err := from.DecodeStreamEvent(data)
+	if err != nil {
+		return fmt.Errorf("failed to decode stream event: %w", err)
+	}
+	if decoded == nil {
+		return nil
+	}
+	return writeEvent(w, to, decoded)
+}
+
+// writeEvent encodes an OpenAI chunk for the caller and writes it as an event, or
+// as the named events of a model.StreamEventsEncoder.
+func writeEvent(w io.Writer, to model.StreamProvider, data []byte) error {
+	if encoder, ok := to.(model.StreamEventsEncoder); ok {
+		events, err := encoder.EncodeStreamEvents(data)
+		if err != nil {
+			return fmt.Errorf("failed to encode stream event: %w", err)
+		}
+		for _, event := range events {
+			if event.Type != "" {
+				if _, err := fmt.Fprintf(w, "event: %s\n", event.Type); err != nil {
+					return err
+				}
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", event.Data); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	encoded, err := to.EncodeStreamEvent(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode stream event: %w", err)
+	}
+	if encoded == nil {
+		return nil
+	}
+	_, err = fmt.Fprintf(w, "data: %s\n\n", encoded)
+	return err
+}
@@ -0,0 +1,528 @@
+package request
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Not-Diamond/go-notdiamond/pkg/model"
+)
+
+// anthropicBlock is a content block of an Anthropic message: text, an image or
+// document, a tool use of the assistant or a tool result of the user.
+type anthropicBlock struct {
+	Type   string `json:"type"`
+	Text   string `json:"text"`
+	Source *struct {
+		Type      string `json:"type"`
+		MediaType string `json:"media_type"`
+		Data      string `json:"data"`
+		URL       string `json:"url"`
+	} `json:"source"`
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Input     json.RawMessage `json:"input"`
+	ToolUseID string          `json:"tool_use_id"`
+	Content   json.RawMessage `json:"content"`
+}
+
+// anthropicMessage is a message of an Anthropic body, its content either a string
+// or a list of content blocks.
+type anthropicMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+// anthropicOnlyBlocks are the content block types OpenAI has no block for.
+var anthropicOnlyBlocks = map[string]bool{
+	"image":             true,
+	"document":          true,
+	"tool_use":          true,
+	"tool_result":       true,
+	"thinking":          true,
+	"redacted_thinking": true,
+}
+
+// hasAnthropicFeatures reports whether the messages, tools or tool choice of a
+// request can only be Anthropic's: content blocks OpenAI has no block for, tools
+// with an input_schema, or a tool choice other than a function.
+func hasAnthropicFeatures(payload map[string]json.RawMessage) bool {
+	var messages []struct {
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(payload["messages"], &messages); err == nil {
+		for _, msg := range messages {
+			var blocks []anthropicBlock
+			if err := json.Unmarshal(msg.Content, &blocks); err != nil {
+				continue
+			}
+			for _, block := range blocks {
+				if anthropicOnlyBlocks[block.Type] {
+					return true
+				}
+			}
+		}
+	}
+
+	var tools []map[string]json.RawMessage
+	if err := json.Unmarshal(payload["tools"], &tools); err == nil {
+		for _, tool := range tools {
+			if _, ok := tool["input_schema"]; ok {
+				return true
+			}
+		}
+	}
+
+	var toolChoice struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(payload["tool_choice"], &toolChoice); err == nil && toolChoice.Type != "" && toolChoice.Type != "function" {
+		return true
+	}
+	return false
+}
+
+// openAIMessagesFromAnthropic transforms an Anthropic message into OpenAI messages.
+// Tool uses become tool calls of the assistant message, and tool results become
+// tool messages ahead of the rest of the user's message. Text-only content is
+// flattened into a string, thinking blocks are dropped.
+func openAIMessagesFromAnthropic(role string, content json.RawMessage) ([]map[string]interface{}, error) {
+	var text string
+	if err := json.Unmarshal(content, &text); err == nil || len(content) == 0 {
+		return []map[string]interface{}{{"role": role, "content": text}}, nil
+	}
+
+	var blocks []anthropicBlock
+	if err := json.Unmarshal(content, &blocks); err != nil {
+		return nil, fmt.Errorf("invalid Anthropic message content: %w", err)
+	}
+
+	var messages []map[string]interface{}
+	var parts []model.ContentPart
+	var toolCalls []map[string]interface{}
+	textOnly := true
+	for _, block := range blocks {
+		switch block.Type {
+		case "text":
+			parts = append(parts, model.TextPart(block.Text))
+		case "image", "document":
+			part, err := openAIContentPartFromAnthropic(block)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, part)
+			textOnly = false
+		case "tool_use":
+			toolCalls = append(toolCalls, openAIToolCallFromAnthropic(block))
+		case "tool_result":
+			messages = append(messages, map[string]interface{}{
+				"role":         "tool",
+				"tool_call_id": block.ToolUseID,
+				"content":      anthropicText(block.Content),
+			})
+		case "thinking", "redacted_thinking":
+		default:
+			return nil, fmt.Errorf("unsupported Anthropic content block: %s", block.Type)
+		}
+	}
+
+	if len(parts) == 0 && len(toolCalls) == 0 {
+		return messages, nil
+	}
+	message := map[string]interface{}{"role": role}
+	if textOnly {
+		texts := make([]string, len(parts))
+		for i, part := range parts {
+			texts[i] = part.Text
+		}
+		message["content"] = strings.Join(texts, "\n")
+	} else {
+		message["content"] = parts
+	}
+	if len(toolCalls) > 0 {
+		message["tool_calls"] = toolCalls
+	}
+	return append(messages, message), nil
+}
+
+// openAIMessagesFromAnthropicPayload transforms the system prompt and messages of
+// an Anthropic body into OpenAI messages, the system prompt first.
+func openAIMessagesFromAnthropicPayload(system json.RawMessage, anthropicMessages []anthropicMessage) ([]map[string]interface{}, error) {
+	messages := make([]map[string]interface{}, 0, len(anthropicMessages)+1)
+	if text := anthropicText(system); text != "" {
+		messages = append(messages, map[string]interface{}{
+			"role":    "system",
+			"content": text,
+		})
+	}
+	for _, msg := range anthropicMessages {
+		converted, err := openAIMessagesFromAnthropic(msg.Role, msg.Content)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, converted...)
+	}
+	return messages, nil
+}
+
+// openAIContentPartFromAnthropic transforms an Anthropic image or document block
+// into an OpenAI content part. Base64 sources become data URLs, images referenced
+// by URL keep their URL.
+func openAIContentPartFromAnthropic(block anthropicBlock) (model.ContentPart, error) {
+	if block.Source == nil {
+		return model.ContentPart{}, fmt.Errorf("%s content block has no source", block.Type)
+	}
+	switch {
+	case block.Source.Type == "base64" && block.Type == "image":
+		return model.ImageURLPart("data:" + block.Source.MediaType + ";base64," + block.Source.Data), nil
+	case block.Source.Type == "url" && block.Type == "image":
+		return model.ImageURLPart(block.Source.URL), nil
+	case block.Source.Type == "base64":
+		return model.ContentPart{
+			Type: model.ContentPartFile,
+			File: &model.File{FileData: "data:" + block.Source.MediaType + ";base64," + block.Source.Data},
+		}, nil
+	default:
+		return model.ContentPart{}, fmt.Errorf("unsupported source of %s content block: %s", block.Type, block.Source.Type)
+	}
+}
+
+// openAIToolCallFromAnthropic builds an OpenAI tool call from an Anthropic tool use.
+func openAIToolCallFromAnthropic(block anthropicBlock) map[string]interface{} {
+	arguments := "{}"
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, block.Input); err == nil && compact.String() != "null" {
+		arguments = compact.String()
+	}
+	return map[string]interface{}{
+		"id":   block.ID,
+		"type": "function",
+		"function": map[string]interface{}{
+			"name":      block.Name,
+			"arguments": arguments,
+		},
+	}
+}
+
+// openAIToolsFromAnthropic transforms Anthropic client tools into OpenAI function
+// tools. Server tools, such as web search, have no OpenAI equivalent.
+func openAIToolsFromAnthropic(raw json.RawMessage) ([]map[string]interface{}, error) {
+	var tools []struct {
+		Type        string          `json:"type"`
+		Name        string          `json:"name"`
+		Description string          `json:"description"`
+		InputSchema json.RawMessage `json:"input_schema"`
+	}
+	if err := json.Unmarshal(raw, &tools); err != nil {
+		return nil, fmt.Errorf("invalid Anthropic tools: %w", err)
+	}
+
+	result := make([]map[string]interface{}, 0, len(tools))
+	for _, tool := range tools {
+		if tool.Type != "" && tool.Type != "custom" {
+			return nil, fmt.Errorf("unsupported Anthropic tool %s of type %s", tool.Name, tool.Type)
+		}
+		function := map[string]interface{}{"name": tool.Name}
+		if tool.Description != "" {
+			function["description"] = tool.Description
+		}
+		if len(tool.InputSchema) > 0 {
+			function["parameters"] = tool.InputSchema
+		}
+		result = append(result, map[string]interface{}{
+			"type":     "function",
+			"function": function,
+		})
+	}
+	return result, nil
+}
+
+// openAIToolChoiceFromAnthropic transforms an Anthropic tool choice into an OpenAI
+// tool choice, and reports whether parallel tool use is disabled.
+func openAIToolChoiceFromAnthropic(raw json.RawMessage) (interface{}, bool) {
+	var toolChoice struct {
+		Type                   string `json:"type"`
+		Name                   string `json:"name"`
+		DisableParallelToolUse bool   `json:"disable_parallel_tool_use"`
+	}
+	if err := json.Unmarshal(raw, &toolChoice); err != nil {
+		return nil, false
+	}
+	switch toolChoice.Type {
+	case "auto":
+		return "auto", toolChoice.DisableParallelToolUse
+	case "any":
+		return "required", toolChoice.DisableParallelToolUse
+	case "none":
+		return "none", false
+	case "tool":
+		return map[string]interface{}{
+			"type":     "function",
+			"function": map[string]interface{}{"name": toolChoice.Name},
+		}, toolChoice.DisableParallelToolUse
+	default:
+		return nil, false
+	}
+}
+
+// anthropicToolUse builds an Anthropic tool use block from an OpenAI tool call.
+func anthropicToolUse(call openAIToolCall) (map[string]interface{}, error) {
+	input := map[string]interface{}{}
+	if call.Function.Arguments != "" {
+		if err := json.Unmarshal([]byte(call.Function.Arguments), &input); err != nil {
+			return nil, fmt.Errorf("invalid arguments of tool call %s: %w", call.ID, err)
+		}
+	}
+	return map[string]interface{}{
+		"type":  "tool_use",
+		"id":    call.ID,
+		"name":  call.Function.Name,
+		"input": input,
+	}, nil
+}
+
+// PrependAnthropicMessages puts OpenAI messages before the messages of an Anthropic
+// body. The body's own messages and other fields are left unchanged, except that
+// a system message replaces its system prompt.
+func PrependAnthropicMessages(body []byte, messages []model.Message) ([]byte, error) {
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal body: %w", err)
+	}
+
+	var callerMessages []json.RawMessage
+	if err := json.Unmarshal(payload["messages"], &callerMessages); err != nil {
+		return nil, fmt.Errorf("invalid messages: %w", err)
+	}
+
+	combined := make([]interface{}, 0, len(messages)+len(callerMessages))
+	for _, msg := range messages {
+		if msg.Role() == "system" {
+			system, err := json.Marshal(msg.Text())
+			if err != nil {
+				return nil, err
+			}
+			payload["system"] = system
+			continue
+		}
+		combined = append(combined, map[string]interface{}{
+			"role":    msg.Role(),
+			"content": msg.Text(),
+		})
+	}
+	for _, msg := range callerMessages {
+		combined = append(combined, msg)
+	}
+
+	var err error
+	if payload["messages"], err = json.Marshal(combined); err != nil {
+		return nil, err
+	}
+	return json.Marshal(payload)
+}
+
+// anthropicMessagesFromOpenAI transforms OpenAI messages, other than system
+// messages, into Anthropic messages. Tool calls become tool use blocks of the
+// assistant turn and tool results become tool result blocks of a user turn.
+// Messages without content are dropped and consecutive messages of one role are
+// merged, the Messages API rejects both.
+func anthropicMessagesFromOpenAI(messages []openAIMessage) ([]map[string]interface{}, error) {
+	var turns []map[string]interface{}
+	for _, msg := range messages {
+		var role string
+		var blocks []map[string]interface{}
+		switch msg.Role {
+		case "system", "developer":
+			continue
+		case "tool":
+			role = "user"
+			block := map[string]interface{}{
+				"type":        "tool_result",
+				"tool_use_id": msg.ToolCallID,
+			}
+			if text := anthropicText(msg.Content); text != "" {
+				block["content"] = text
+			}
+			blocks = append(blocks, block)
+		case "assistant":
+			role = "assistant"
+			var err error
+			if blocks, err = anthropicContentBlocks(msg.Content); err != nil {
+				return nil, err
+			}
+			for _, call := range msg.ToolCalls {
+				block, err := anthropicToolUse(call)
+				if err != nil {
+					return nil, err
+				}
+				blocks = append(blocks, block)
+			}
+		default:
+			role = "user"
+			var err error
+			if blocks, err = anthropicContentBlocks(msg.Content); err != nil {
+				return nil, err
+			}
+		}
+		if len(blocks) == 0 {
+			continue
+		}
+
+		if n := len(turns); n > 0 && turns[n-1]["role"] == role {
+			turns[n-1]["content"] = append(turns[n-1]["content"].([]map[string]interface{}), blocks...)
+			continue
+		}
+		turns = append(turns, map[string]interface{}{
+			"role":    role,
+			"content": blocks,
+		})
+	}
+
+	// Turns of a single text block are sent as a string
+	for _, turn := range turns {
+		if blocks := turn["content"].([]map[string]interface{}); len(blocks) == 1 && blocks[0]["type"] == "text" {
+			turn["content"] = blocks[0]["text"]
+		}
+	}
+	if turns == nil {
+		turns = []map[string]interface{}{}
+	}
+	return turns, nil
+}
+
+// anthropicContentBlocks transforms OpenAI message content, a string or a list of
+// content parts, into Anthropic content blocks. Empty text has no block.
+func anthropicContentBlocks(content json.RawMessage) ([]map[string]interface{}, error) {
+	if len(content) == 0 || string(content) == "null" {
+		return nil, nil
+	}
+
+	var text string
+	if err := json.Unmarshal(content, &text); err == nil {
+		if text == "" {
+			return nil, nil
+		}
+		return []map[string]interface{}{{"type": "text", "text": text}}, nil
+	}
+
+	var contentParts []model.ContentPart
+	if err := json.Unmarshal(content, &contentParts); err != nil {
+		return nil, fmt.Errorf("invalid message content: %w", err)
+	}
+
+	blocks := make([]map[string]interface{}, 0, len(contentParts))
+	for _, part := range contentParts {
+		if part.Type == model.ContentPartText && part.Text == "" {
+			continue
+		}
+		block, err := anthropicContentBlock(part)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+// anthropicContentBlock transforms an OpenAI content part into an Anthropic content
+// block. Embedded images and files become base64 image and document blocks,
+// images referenced by URL keep their URL.
+func anthropicContentBlock(part model.ContentPart) (map[string]interface{}, error) {
+	switch part.Type {
+	case model.ContentPartText:
+		return map[string]interface{}{"type": "text", "text": part.Text}, nil
+	case model.ContentPartImageURL:
+		if part.ImageURL == nil {
+			return nil, fmt.Errorf("image_url content part has no image_url")
+		}
+		if mimeType, data, ok := model.ParseDataURL(part.ImageURL.URL); ok {
+			return anthropicBase64Block("image", mimeType, data), nil
+		}
+		return map[string]interface{}{
+			"type":   "image",
+			"source": map[string]interface{}{"type": "url", "url": part.ImageURL.URL},
+		}, nil
+	case model.ContentPartFile:
+		if part.File == nil {
+			return nil, fmt.Errorf("file content part has no file")
+		}
+		mimeType, data, ok := model.ParseDataURL(part.File.FileData)
+		if !ok {
+			return nil, fmt.Errorf("file %s cannot be sent to Anthropic, only files embedded as data URLs can", part.File.FileID)
+		}
+		return anthropicBase64Block("document", mimeType, data), nil
+	default:
+		return nil, fmt.Errorf("unsupported content part type %q", part.Type)
+	}
+}
+
+// anthropicBase64Block builds an image or document block of base64 encoded data.
+func anthropicBase64Block(blockType, mimeType, data string) map[string]interface{} {
+	return map[string]interface{}{
+		"type": blockType,
+		"source": map[string]interface{}{
+			"type":       "base64",
+			"media_type": mimeType,
+			"data":       data,
+		},
+	}
+}
+
+// anthropicTools transforms OpenAI function tools into Anthropic client tools.
+// Tools without parameters take an empty object, input_schema is required.
+func anthropicTools(tools []openAITool) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(tools))
+	for _, tool := range tools {
+		if tool.Type != "function" {
+			continue
+		}
+		anthropicTool := map[string]interface{}{
+			"name":         tool.Function.Name,
+			"input_schema": tool.Function.Parameters,
+		}
+		if tool.Function.Parameters == nil {
+			anthropicTool["input_schema"] = map[string]interface{}{"type": "object"}
+		}
+		if tool.Function.Description != "" {
+			anthropicTool["description"] = tool.Function.Description
+		}
+		result = append(result, anthropicTool)
+	}
+	return result
+}
+
+// anthropicToolChoice transforms an OpenAI tool_choice into an Anthropic tool
+// choice. Disabled parallel tool calls are carried by the tool choice, which is
+// auto if the request has none.
+func anthropicToolChoice(toolChoice json.RawMessage, disableParallel bool) map[string]interface{} {
+	var choice map[string]interface{}
+	var mode string
+	if err := json.Unmarshal(toolChoice, &mode); err == nil {
+		switch mode {
+		case "none":
+			return map[string]interface{}{"type": "none"}
+		case "auto":
+			choice = map[string]interface{}{"type": "auto"}
+		case "required":
+			choice = map[string]interface{}{"type": "any"}
+		}
+	} else {
+		var function struct {
+			Function struct {
+				Name string `json:"name"`
+			} `json:"function"`
+		}
+		if err := json.Unmarshal(toolChoice, &function); err == nil && function.Function.Name != "" {
+			choice = map[string]interface{}{"type": "tool", "name": function.Function.Name}
+		}
+	}
+
+	if disableParallel {
+		if choice == nil {
+			choice = map[string]interface{}{"type": "auto"}
+		}
+		choice["disable_parallel_tool_use"] = true
+	}
+	return choice
+}
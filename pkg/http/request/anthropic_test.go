@@ -0,0 +1,130 @@
+package request
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/Not-Diamond/go-notdiamond/pkg/model"
+)
+
+func TestIsAnthropicPayload(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want bool
+	}{
+		{
+			name: "system prompt",
+			body: `{"system": "Be brief", "messages": [{"role": "user", "content": "Hello"}]}`,
+			want: true,
+		},
+		{
+			name: "tool result block",
+			body: `{"messages": [{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "toolu_1", "content": "Sunny"}]}]}`,
+			want: true,
+		},
+		{
+			name: "image block",
+			body: `{"messages": [{"role": "user", "content": [{"type": "image", "source": {"type": "url", "url": "https://example.com/cat.png"}}]}]}`,
+			want: true,
+		},
+		{
+			name: "tool with input schema",
+			body: `{"messages": [{"role": "user", "content": "Hello"}], "tools": [{"name": "get_weather", "input_schema": {"type": "object"}}]}`,
+			want: true,
+		},
+		{
+			name: "openai tools and text parts",
+			body: `{
+				"messages": [{"role": "user", "content": [{"type": "text", "text": "Hello"}]}],
+				"tools": [{"type": "function", "function": {"name": "get_weather"}}],
+				"tool_choice": {"type": "function", "function": {"name": "get_weather"}}
+			}`,
+			want: false,
+		},
+		{
+			name: "vertex body",
+			body: `{"contents": [{"role": "user", "parts": [{"text": "Hello"}]}]}`,
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsAnthropicPayload([]byte(tt.body)); got != tt.want {
+				t.Errorf("IsAnthropicPayload() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrependAnthropicMessages(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		messages []model.Message
+		expected string
+	}{
+		{
+			name: "system message replaces system prompt",
+			body: `{
+				"model": "openai/gpt-4o",
+				"system": "Caller prompt",
+				"max_tokens": 100,
+				"messages": [{"role": "user", "content": [{"type": "text", "text": "Hello"}]}]
+			}`,
+			messages: []model.Message{
+				{"role": "system", "content": "Model prompt"},
+				{"role": "user", "content": "Example question"},
+				{"role": "assistant", "content": "Example answer"},
+			},
+			expected: `{
+				"model": "openai/gpt-4o",
+				"system": "Model prompt",
+				"max_tokens": 100,
+				"messages": [
+					{"role": "user", "content": "Example question"},
+					{"role": "assistant", "content": "Example answer"},
+					{"role": "user", "content": [{"type": "text", "text": "Hello"}]}
+				]
+			}`,
+		},
+		{
+			name: "system prompt kept",
+			body: `{"system": "Caller prompt", "messages": [{"role": "user", "content": "Hello"}]}`,
+			messages: []model.Message{
+				{"role": "user", "content": "Example question"},
+				{"role": "assistant", "content": "Example answer"},
+			},
+			expected: `{
+				"system": "Caller prompt",
+				"messages": [
+					{"role": "user", "content": "Example question"},
+					{"role": "assistant", "content": "Example answer"},
+					{"role": "user", "content": "Hello"}
+				]
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := PrependAnthropicMessages([]byte(tt.body), tt.messages)
+			if err != nil {
+				t.Fatalf("PrependAnthropicMessages() error = %v", err)
+			}
+
+			var gotJSON, expectedJSON map[string]interface{}
+			if err := json.Unmarshal(got, &gotJSON); err != nil {
+				t.Fatalf("Failed to unmarshal result: %v", err)
+			}
+			if err := json.Unmarshal([]byte(tt.expected), &expectedJSON); err != nil {
+				t.Fatalf("Failed to unmarshal expected: %v", err)
+			}
+			if !reflect.DeepEqual(gotJSON, expectedJSON) {
+				t.Errorf("PrependAnthropicMessages() = %s, want %s", got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package request
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/Not-Diamond/go-notdiamond/pkg/model"
+)
+
+// vertexContentParts transforms OpenAI message content, a string or a list of
+// content parts, into Vertex AI parts. Empty content has no parts.
+func vertexContentParts(content json.RawMessage) ([]map[string]interface{}, error) {
+	if len(content) == 0 || string(content) == "null" {
+		return nil, nil
+	}
+
+	var text string
+	if err := json.Unmarshal(content, &text); err == nil {
+		if text == "" {
+			return nil, nil
+		}
+		return []map[string]interface{}{{"text": text}}, nil
+	}
+
+	var contentParts []model.ContentPart
+	if err := json.Unmarshal(content, &contentParts); err != nil {
+		return nil, fmt.Errorf("invalid message content: %w", err)
+	}
+
+	parts := make([]map[string]interface{}, 0, len(contentParts))
+	for _, contentPart := range contentParts {
+		part, err := vertexContentPart(contentPart)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+	}
+	return parts, nil
+}
+
+// vertexContentPart transforms an OpenAI content part into a Vertex AI part.
+// Embedded images and files become inlineData, images referenced by URL become
+// fileData.
+func vertexContentPart(part model.ContentPart) (map[string]interface{}, error) {
+	switch part.Type {
+	case model.ContentPartText:
+		return map[string]interface{}{"text": part.Text}, nil
+	case model.ContentPartImageURL:
+		if part.ImageURL == nil {
+			return nil, fmt.Errorf("image_url content part has no image_url")
+		}
+		if mimeType, data, ok := model.ParseDataURL(part.ImageURL.URL); ok {
+			return vertexInlineData(mimeType, data), nil
+		}
+		return map[string]interface{}{
+			"fileData": map[string]interface{}{
+				"mimeType": imageMimeType(part.ImageURL.URL),
+				"fileUri":  part.ImageURL.URL,
+			},
+		}, nil
+	case model.ContentPartFile:
+		if part.File == nil {
+			return nil, fmt.Errorf("file content part has no file")
+		}
+		mimeType, data, ok := model.ParseDataURL(part.File.FileData)
+		if !ok {
+			return nil, fmt.Errorf("file %s cannot be sent to Vertex AI, only files embedded as data URLs can", part.File.FileID)
+		}
+		return vertexInlineData(mimeType, data), nil
+	default:
+		return nil, fmt.Errorf("unsupported content part type %q", part.Type)
+	}
+}
+
+// vertexInlineData builds an inlineData part of base64 encoded data.
+func vertexInlineData(mimeType, data string) map[string]interface{} {
+	return map[string]interface{}{
+		"inlineData": map[string]interface{}{
+			"mimeType": mimeType,
+			"data":     data,
+		},
+	}
+}
+
+// imageMimeType guesses the MIME type of an image URL from its extension, which
+// Vertex AI requires for fileData. JPEG is assumed for URLs without one.
+func imageMimeType(imageURL string) string {
+	u, err := url.Parse(imageURL)
+	if err != nil {
+		return "image/jpeg"
+	}
+	mimeType, _, _ := strings.Cut(mime.TypeByExtension(path.Ext(u.Path)), ";")
+	if !strings.HasPrefix(mimeType, "image/") {
+		return "image/jpeg"
+	}
+	return mimeType
+}
+
+// openAIContent transforms the non-function parts of a Vertex AI content into
+// OpenAI message content: a string if all parts are text, a list of content parts
+// otherwise. It returns nil if there are no such parts.
+func openAIContent(parts []vertexPart) (interface{}, error) {
+	var contentParts []model.ContentPart
+	var texts []string
+	textOnly := true
+	for _, part := range parts {
+		switch {
+		case part.FunctionCall != nil, part.FunctionResponse != nil:
+			continue
+		case part.InlineData != nil:
+			textOnly = false
+			contentParts = append(contentParts, openAIInlineData(part.InlineData.MimeType, part.InlineData.Data))
+		case part.FileData != nil:
+			textOnly = false
+			contentPart, err := openAIFileData(part.FileData.MimeType, part.FileData.FileURI)
+			if err != nil {
+				return nil, err
+			}
+			contentParts = append(contentParts, contentPart)
+		default:
+			texts = append(texts, part.Text)
+			contentParts = append(contentParts, model.TextPart(part.Text))
+		}
+	}
+
+	if len(contentParts) == 0 {
+		return nil, nil
+	}
+	if textOnly {
+		return strings.Join(texts, ""), nil
+	}
+	return contentParts, nil
+}
+
+// openAIInlineData transforms Vertex AI inline data into an image_url content part
+// for images, or a file content part otherwise.
+func openAIInlineData(mimeType, data string) model.ContentPart {
+	dataURL := "data:" + mimeType + ";base64," + data
+	if strings.HasPrefix(mimeType, "image/") {
+		return model.ImageURLPart(dataURL)
+	}
+
+	filename := "file"
+	if extensions, _ := mime.ExtensionsByType(mimeType); len(extensions) > 0 {
+		filename += extensions[0]
+	}
+	return model.ContentPart{Type: model.ContentPartFile, File: &model.File{FileData: dataURL, Filename: filename}}
+}
+
+// openAIFileData transforms Vertex AI file data into an image_url content part.
+// OpenAI only fetches images over HTTP(S), so other files cannot be sent.
+func openAIFileData(mimeType, fileURI string) (model.ContentPart, error) {
+	if !strings.HasPrefix(mimeType, "image/") ||
+		!(strings.HasPrefix(fileURI, "https://") || strings.HasPrefix(fileURI, "http://")) {
+		return model.ContentPart{}, fmt.Errorf("file %s (%s) cannot be sent to OpenAI, only images over HTTP(S) can", fileURI, mimeType)
+	}
+	return model.ImageURLPart(fileURI), nil
+}
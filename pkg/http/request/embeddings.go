@@ -0,0 +1,282 @@
+package request
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// IsEmbeddingsPayload reports whether the body asks for embeddings: an OpenAI
+// request with input instead of messages, or a Vertex AI predict request.
+func IsEmbeddingsPayload(body []byte) bool {
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return false
+	}
+	return isEmbeddingsFields(payload)
+}
+
+// isEmbeddingsFields is IsEmbeddingsPayload on the top-level fields of a body.
+func isEmbeddingsFields(payload map[string]json.RawMessage) bool {
+	if _, ok := payload["instances"]; ok {
+		return true
+	}
+	_, hasInput := payload["input"]
+	_, hasMessages := payload["messages"]
+	return hasInput && !hasMessages
+}
+
+// isVertexEmbeddingsRequest reports whether the body is a Vertex AI predict request.
+func isVertexEmbeddingsRequest(body []byte) bool {
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return false
+	}
+	_, ok := payload["instances"]
+	return ok
+}
+
+// IsEmbeddingsRequest reports whether the request asks for embeddings, either by
+// calling an embeddings endpoint or through its body.
+func IsEmbeddingsRequest(req *http.Request, body []byte) bool {
+	if req != nil && (strings.HasSuffix(req.URL.Path, "/embeddings") || strings.HasSuffix(req.URL.Path, ":predict")) {
+		return true
+	}
+	return IsEmbeddingsPayload(body)
+}
+
+// EmbeddingDimensions returns the vector size an OpenAI or Vertex AI embeddings
+// request asks for. It reports false if the request leaves it to the model.
+func EmbeddingDimensions(body []byte) (int, bool) {
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return 0, false
+	}
+	return embeddingDimensions(payload)
+}
+
+// embeddingDimensions is EmbeddingDimensions on the top-level fields of a body.
+func embeddingDimensions(payload map[string]json.RawMessage) (int, bool) {
+	var dimensions *int
+	if err := json.Unmarshal(payload["dimensions"], &dimensions); err == nil && dimensions != nil {
+		return *dimensions, true
+	}
+	var parameters struct {
+		OutputDimensionality *int `json:"outputDimensionality"`
+	}
+	if err := json.Unmarshal(payload["parameters"], &parameters); err == nil && parameters.OutputDimensionality != nil {
+		return *parameters.OutputDimensionality, true
+	}
+	return 0, false
+}
+
+// vertexEmbeddingInstance is a text to embed in a Vertex AI predict request.
+type vertexEmbeddingInstance struct {
+	Content  string `json:"content"`
+	TaskType string `json:"task_type,omitempty"`
+	Title    string `json:"title,omitempty"`
+}
+
+// vertexEmbeddingsRequest transforms an OpenAI embeddings request to a Vertex AI
+// predict request, with one instance per input in the same order. Vertex AI
+// bodies are returned without their model field.
+func vertexEmbeddingsRequest(body []byte) ([]byte, error) {
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal embeddings request: %v", err)
+	}
+	if _, ok := payload["instances"]; ok {
+		delete(payload, "model")
+		return json.Marshal(payload)
+	}
+
+	var format string
+	if raw, ok := payload["encoding_format"]; ok && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &format); err != nil {
+			return nil, fmt.Errorf("invalid encoding_format: %v", err)
+		}
+	}
+	if format != "" && format != "float" {
+		return nil, fmt.Errorf("encoding_format %s is not supported by Vertex AI", format)
+	}
+
+	inputs, err := embeddingInputs(payload["input"])
+	if err != nil {
+		return nil, err
+	}
+	instances := make([]vertexEmbeddingInstance, len(inputs))
+	for i, input := range inputs {
+		instances[i] = vertexEmbeddingInstance{Content: input}
+	}
+
+	result := map[string]interface{}{"instances": instances}
+	if dimensions, ok := payload["dimensions"]; ok && string(dimensions) != "null" {
+		result["parameters"] = map[string]interface{}{"outputDimensionality": dimensions}
+	}
+	return json.Marshal(result)
+}
+
+// embeddingInputs reads the input of an OpenAI embeddings request, a string or an
+// array of strings. Token arrays are not supported, other providers tokenize
+// differently.
+func embeddingInputs(raw json.RawMessage) ([]string, error) {
+	var input string
+	if err := json.Unmarshal(raw, &input); err == nil {
+		return []string{input}, nil
+	}
+	var inputs []string
+	if err := json.Unmarshal(raw, &inputs); err != nil {
+		return nil, fmt.Errorf("embeddings input must be a string or an array of strings")
+	}
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("embeddings input is empty")
+	}
+	return inputs, nil
+}
+
+// openAIEmbeddingsRequest transforms a Vertex AI predict request to an OpenAI
+// embeddings request, with one input per instance in the same order.
+func openAIEmbeddingsRequest(body []byte) (map[string]interface{}, error) {
+	var payload struct {
+		Instances  []vertexEmbeddingInstance `json:"instances"`
+		Parameters struct {
+			OutputDimensionality json.RawMessage `json:"outputDimensionality"`
+		} `json:"parameters"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal Vertex AI embeddings request: %v", err)
+	}
+	if len(payload.Instances) == 0 {
+		return nil, fmt.Errorf("embeddings request has no instances")
+	}
+
+	inputs := make([]string, len(payload.Instances))
+	for i, instance := range payload.Instances {
+		inputs[i] = instance.Content
+	}
+	result := map[string]interface{}{"input": inputs}
+	if dimensions := payload.Parameters.OutputDimensionality; len(dimensions) > 0 && string(dimensions) != "null" {
+		result["dimensions"] = dimensions
+	}
+	return result, nil
+}
+
+// unsupportedOpenAIEmbeddingsParams returns the fields of a Vertex AI predict
+// request that are lost when it is sent to OpenAI.
+func unsupportedOpenAIEmbeddingsParams(payload map[string]json.RawMessage) []string {
+	var params []string
+	var instances []map[string]json.RawMessage
+	if err := json.Unmarshal(payload["instances"], &instances); err == nil {
+		seen := make(map[string]bool)
+		for _, instance := range instances {
+			for field := range instance {
+				if field != "content" && !seen[field] {
+					seen[field] = true
+					params = append(params, "instances."+field)
+				}
+			}
+		}
+	}
+	var parameters map[string]json.RawMessage
+	if err := json.Unmarshal(payload["parameters"], &parameters); err == nil {
+		for field := range parameters {
+			if field != "outputDimensionality" {
+				params = append(params, "parameters."+field)
+			}
+		}
+	}
+	sort.Strings(params)
+	return params
+}
+
+// vertexEmbeddingsResponse is a Vertex AI predict response of an embedding model.
+type vertexEmbeddingsResponse struct {
+	Predictions []struct {
+		Embeddings struct {
+			Values     json.RawMessage `json:"values"`
+			Statistics struct {
+				TokenCount float64 `json:"token_count"`
+			} `json:"statistics"`
+		} `json:"embeddings"`
+	} `json:"predictions"`
+}
+
+// openAIEmbeddingsResponse is an OpenAI embeddings response.
+type openAIEmbeddingsResponse struct {
+	Data []struct {
+		Index     int             `json:"index"`
+		Embedding json.RawMessage `json:"embedding"`
+	} `json:"data"`
+}
+
+// isVertexEmbeddingsResponse reports whether the body is a Vertex AI predict response.
+func isVertexEmbeddingsResponse(body []byte) bool {
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return false
+	}
+	_, ok := payload["predictions"]
+	return ok
+}
+
+// isOpenAIEmbeddingsResponse reports whether the body is an OpenAI embeddings response.
+func isOpenAIEmbeddingsResponse(body []byte) bool {
+	var payload struct {
+		Object string `json:"object"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return false
+	}
+	return payload.Object == "list"
+}
+
+// openAIEmbeddingsFromVertex transforms a Vertex AI predict response to an OpenAI
+// embeddings response. Each prediction keeps its position as index, and the token
+// counts of all predictions add up to the usage.
+func openAIEmbeddingsFromVertex(body []byte) ([]byte, error) {
+	var vertexResponse vertexEmbeddingsResponse
+	if err := json.Unmarshal(body, &vertexResponse); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal Vertex AI embeddings response: %v", err)
+	}
+
+	data := make([]map[string]interface{}, len(vertexResponse.Predictions))
+	tokens := 0
+	for i, prediction := range vertexResponse.Predictions {
+		data[i] = map[string]interface{}{
+			"object":    "embedding",
+			"index":     i,
+			"embedding": prediction.Embeddings.Values,
+		}
+		tokens += int(prediction.Embeddings.Statistics.TokenCount)
+	}
+	return json.Marshal(map[string]interface{}{
+		"object": "list",
+		"data":   data,
+		"usage": map[string]interface{}{
+			"prompt_tokens": tokens,
+			"total_tokens":  tokens,
+		},
+	})
+}
+
+// vertexEmbeddingsFromOpenAI transforms an OpenAI embeddings response to a Vertex
+// AI predict response, with the embeddings ordered by their index. OpenAI only
+// counts the tokens of all inputs together, so the predictions have no statistics.
+func vertexEmbeddingsFromOpenAI(body []byte) ([]byte, error) {
+	var openAIResponse openAIEmbeddingsResponse
+	if err := json.Unmarshal(body, &openAIResponse); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal OpenAI embeddings response: %v", err)
+	}
+
+	data := openAIResponse.Data
+	sort.SliceStable(data, func(i, j int) bool { return data[i].Index < data[j].Index })
+	predictions := make([]map[string]interface{}, len(data))
+	for i, embedding := range data {
+		predictions[i] = map[string]interface{}{
+			"embeddings": map[string]interface{}{"values": embedding.Embedding},
+		}
+	}
+	return json.Marshal(map[string]interface{}{"predictions": predictions})
+}
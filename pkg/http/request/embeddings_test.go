@@ -0,0 +1,211 @@
+package request
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIsEmbeddingsRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		body string
+		want bool
+	}{
+		{
+			name: "openai embeddings endpoint",
+			url:  "https://api.openai.com/v1/embeddings",
+			body: `{"model": "text-embedding-3-small"}`,
+			want: true,
+		},
+		{
+			name: "vertex predict endpoint",
+			url:  "https://us-central1-aiplatform.googleapis.com/v1/projects/p/locations/us-central1/publishers/google/models/text-embedding-005:predict",
+			body: `{}`,
+			want: true,
+		},
+		{
+			name: "openai embeddings body",
+			url:  "https://api.openai.com/v1/chat/completions",
+			body: `{"model": "text-embedding-3-small", "input": "Hello"}`,
+			want: true,
+		},
+		{
+			name: "vertex embeddings body",
+			url:  "https://us-central1-aiplatform.googleapis.com/v1/projects/p/locations/us-central1/publishers/google/models/gemini-pro:generateContent",
+			body: `{"instances": [{"content": "Hello"}]}`,
+			want: true,
+		},
+		{
+			name: "chat request",
+			url:  "https://api.openai.com/v1/chat/completions",
+			body: `{"model": "gpt-4o", "messages": [{"role": "user", "content": "Hello"}]}`,
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", tt.url, nil)
+			if got := IsEmbeddingsRequest(req, []byte(tt.body)); got != tt.want {
+				t.Errorf("IsEmbeddingsRequest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmbeddingDimensions(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		want   int
+		wantOK bool
+	}{
+		{name: "openai dimensions", body: `{"input": "Hello", "dimensions": 256}`, want: 256, wantOK: true},
+		{name: "vertex output dimensionality", body: `{"instances": [], "parameters": {"outputDimensionality": 512}}`, want: 512, wantOK: true},
+		{name: "model default", body: `{"input": "Hello"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := EmbeddingDimensions([]byte(tt.body))
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("EmbeddingDimensions() = %d, %v, want %d, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestEmbeddingsRequestTransforms(t *testing.T) {
+	tests := []struct {
+		name        string
+		transform   func([]byte) ([]byte, error)
+		body        string
+		want        string
+		errContains string
+	}{
+		{
+			name:      "openai to vertex",
+			transform: func(body []byte) ([]byte, error) { return TransformToVertexRequest(body, "text-embedding-005") },
+			body:      `{"model": "text-embedding-3-small", "input": ["first", "second"], "dimensions": 256, "encoding_format": "float"}`,
+			want:      `{"instances": [{"content": "first"}, {"content": "second"}], "parameters": {"outputDimensionality": 256}}`,
+		},
+		{
+			name:      "single openai input to vertex",
+			transform: func(body []byte) ([]byte, error) { return TransformToVertexRequest(body, "text-embedding-005") },
+			body:      `{"model": "text-embedding-3-small", "input": "Hello"}`,
+			want:      `{"instances": [{"content": "Hello"}]}`,
+		},
+		{
+			name:      "vertex to vertex",
+			transform: func(body []byte) ([]byte, error) { return TransformToVertexRequest(body, "text-embedding-005") },
+			body:      `{"model": "text-embedding-004", "instances": [{"content": "Hello", "task_type": "RETRIEVAL_QUERY"}]}`,
+			want:      `{"instances": [{"content": "Hello", "task_type": "RETRIEVAL_QUERY"}]}`,
+		},
+		{
+			name:      "vertex to openai",
+			transform: func(body []byte) ([]byte, error) { return TransformToOpenAIRequest(body, "text-embedding-3-small") },
+			body:      `{"instances": [{"content": "first"}, {"content": "second"}], "parameters": {"outputDimensionality": 256}}`,
+			want:      `{"model": "text-embedding-3-small", "input": ["first", "second"], "dimensions": 256}`,
+		},
+		{
+			name:        "token input",
+			transform:   func(body []byte) ([]byte, error) { return TransformToVertexRequest(body, "text-embedding-005") },
+			body:        `{"model": "text-embedding-3-small", "input": [[9906, 1917]]}`,
+			errContains: "must be a string or an array of strings",
+		},
+		{
+			name:        "base64 encoding",
+			transform:   func(body []byte) ([]byte, error) { return TransformToVertexRequest(body, "text-embedding-005") },
+			body:        `{"model": "text-embedding-3-small", "input": "Hello", "encoding_format": "base64"}`,
+			errContains: "encoding_format base64 is not supported",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.transform([]byte(tt.body))
+			if tt.errContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+					t.Fatalf("error = %v, want error containing %q", err, tt.errContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assertJSONEqual(t, got, tt.want)
+		})
+	}
+}
+
+func TestEmbeddingsResponseTransforms(t *testing.T) {
+	tests := []struct {
+		name      string
+		transform func([]byte) ([]byte, error)
+		body      string
+		want      string
+	}{
+		{
+			name:      "vertex to openai",
+			transform: TransformFromVertexResponse,
+			body: `{"predictions": [
+				{"embeddings": {"values": [0.1, -0.2], "statistics": {"token_count": 2, "truncated": false}}},
+				{"embeddings": {"values": [0.3, 0.4], "statistics": {"token_count": 3, "truncated": false}}}
+			], "metadata": {"billableCharacterCount": 11}}`,
+			want: `{
+				"object": "list",
+				"data": [
+					{"object": "embedding", "index": 0, "embedding": [0.1, -0.2]},
+					{"object": "embedding", "index": 1, "embedding": [0.3, 0.4]}
+				],
+				"usage": {"prompt_tokens": 5, "total_tokens": 5}
+			}`,
+		},
+		{
+			name:      "openai to vertex in index order",
+			transform: TransformToVertexResponse,
+			body: `{
+				"object": "list",
+				"data": [
+					{"object": "embedding", "index": 1, "embedding": [0.3, 0.4]},
+					{"object": "embedding", "index": 0, "embedding": [0.1, -0.2]}
+				],
+				"model": "text-embedding-3-small",
+				"usage": {"prompt_tokens": 5, "total_tokens": 5}
+			}`,
+			want: `{"predictions": [
+				{"embeddings": {"values": [0.1, -0.2]}},
+				{"embeddings": {"values": [0.3, 0.4]}}
+			]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.transform([]byte(tt.body))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assertJSONEqual(t, got, tt.want)
+		})
+	}
+}
+
+// assertJSONEqual fails the test if got and want are not the same JSON value.
+func assertJSONEqual(t *testing.T, got []byte, want string) {
+	t.Helper()
+	var gotValue, wantValue interface{}
+	if err := json.Unmarshal(got, &gotValue); err != nil {
+		t.Fatalf("failed to unmarshal result: %v", err)
+	}
+	if err := json.Unmarshal([]byte(want), &wantValue); err != nil {
+		t.Fatalf("failed to unmarshal expected result: %v", err)
+	}
+	if !reflect.DeepEqual(gotValue, wantValue) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
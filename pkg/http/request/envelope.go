@@ -0,0 +1,123 @@
+package request
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Not-Diamond/go-notdiamond/pkg/model"
+)
+
+// Envelope is a request body parsed once, together with what the client needs to
+// know about it. It is carried through all attempts of a request, so the body is
+// not read and parsed again for each of them.
+type Envelope struct {
+	// Body is the body as the caller sent it.
+	Body []byte
+	// Fields are the top-level fields of the body.
+	Fields map[string]json.RawMessage
+	// Provider is the provider of the caller.
+	Provider string
+	// ModelProvider is the provider the model is prefixed with, or Provider if the
+	// model has no prefix.
+	ModelProvider string
+	// Model is the model without its provider and region.
+	Model string
+	// Region is the region of the model, or "" if the request names none.
+	Region string
+	// Messages are the messages of the body in OpenAI format.
+	Messages []model.Message
+	// Stream reports whether the request asks for a streamed response.
+	Stream bool
+	// Embeddings reports whether the request asks for embeddings.
+	Embeddings bool
+}
+
+// ParseEnvelope parses the body of a request from a caller of the given provider.
+// The provider prefix of the model is looked up in providers. The request is only
+// used for its URL, its body is left unread.
+func ParseEnvelope(req *http.Request, body []byte, provider string, providers model.Providers) (*Envelope, error) {
+	if len(body) == 0 {
+		return nil, fmt.Errorf("empty request body")
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal body: %w", err)
+	}
+
+	var modelStr *string
+	if err := json.Unmarshal(fields["model"], &modelStr); err != nil || modelStr == nil {
+		return nil, fmt.Errorf("model field not found or not a string")
+	}
+	modelProvider, modelName, region := splitModel(*modelStr, provider, providers)
+	if modelProvider == "" {
+		modelProvider = provider
+	}
+
+	env := &Envelope{
+		Body:          body,
+		Fields:        fields,
+		Provider:      provider,
+		ModelProvider: modelProvider,
+		Model:         modelName,
+		Region:        region,
+		Messages:      messagesFromFields(fields),
+		Stream:        isStreamingFields(fields),
+		Embeddings:    isEmbeddingsFields(fields),
+	}
+	if req != nil && req.URL != nil {
+		env.Stream = env.Stream || strings.HasSuffix(req.URL.Path, ":streamGenerateContent") || strings.HasSuffix(req.URL.Path, "/converse-stream")
+		env.Embeddings = env.Embeddings || strings.HasSuffix(req.URL.Path, "/embeddings") || strings.HasSuffix(req.URL.Path, ":predict")
+	}
+	return env, nil
+}
+
+// RequestedModel returns the model the caller asked for, in provider/model or
+// provider/model/region format.
+func (e *Envelope) RequestedModel() string {
+	if e.Region != "" {
+		return e.ModelProvider + "/" + e.Model + "/" + e.Region
+	}
+	return e.ModelProvider + "/" + e.Model
+}
+
+// IsAnthropic reports whether the body uses Anthropic Messages API fields, see
+// IsAnthropicPayload.
+func (e *Envelope) IsAnthropic() bool {
+	return isAnthropicFields(e.Fields)
+}
+
+// EmbeddingDimensions returns the vector size an embeddings request asks for. It
+// reports false if the request leaves it to the model.
+func (e *Envelope) EmbeddingDimensions() (int, bool) {
+	return embeddingDimensions(e.Fields)
+}
+
+type envelopeKey struct{}
+
+// WithEnvelope returns a context carrying the parsed request.
+func WithEnvelope(ctx context.Context, env *Envelope) context.Context {
+	return context.WithValue(ctx, envelopeKey{}, env)
+}
+
+// EnvelopeFromContext returns the parsed request carried by the context, if any.
+func EnvelopeFromContext(ctx context.Context) (*Envelope, bool) {
+	env, ok := ctx.Value(envelopeKey{}).(*Envelope)
+	return env, ok
+}
+
+// splitModel splits a model string in provider/model, model/region or
+// provider/model/region format. The provider is "" if the first part names none
+// of the given providers, the model is then one of the caller's provider. Only
+// models of regional providers have a region, see model.Providers.SplitModel.
+func splitModel(modelStr, callerProvider string, providers model.Providers) (provider, modelName, region string) {
+	if prefix, rest, ok := strings.Cut(modelStr, "/"); ok && isProviderName(prefix, providers) {
+		modelName, region = providers.SplitRegion(prefix, rest)
+		return prefix, modelName, region
+	}
+	modelName, region = providers.SplitRegion(callerProvider, modelStr)
+	return "", modelName, region
+}
@@ -0,0 +1,210 @@
+package request
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Not-Diamond/go-notdiamond/pkg/model"
+)
+
+func TestParseEnvelope(t *testing.T) {
+	tests := []struct {
+		name          string
+		url           string
+		body          string
+		provider      string
+		want          Envelope
+		errContains   string
+		wantRequested string
+	}{
+		{
+			name:     "openai chat",
+			url:      "https://api.openai.com/v1/chat/completions",
+			body:     `{"model": "gpt-4o", "messages": [{"role": "user", "content": "Hello"}], "stream": true}`,
+			provider: "openai",
+			want: Envelope{
+				Provider:      "openai",
+				ModelProvider: "openai",
+				Model:         "gpt-4o",
+				Messages:      []model.Message{{"role": "user", "content": "Hello"}},
+				Stream:        true,
+			},
+			wantRequested: "openai/gpt-4o",
+		},
+		{
+			name:     "model of another provider with region",
+			url:      "https://api.openai.com/v1/chat/completions",
+			body:     `{"model": "vertex/gemini-pro/us-central1", "messages": [{"role": "user", "content": "Hello"}]}`,
+			provider: "openai",
+			want: Envelope{
+				Provider:      "openai",
+				ModelProvider: "vertex",
+				Model:         "gemini-pro",
+				Region:        "us-central1",
+				Messages:      []model.Message{{"role": "user", "content": "Hello"}},
+			},
+			wantRequested: "vertex/gemini-pro/us-central1",
+		},
+		{
+			name:     "vertex stream",
+			url:      "https://us-central1-aiplatform.googleapis.com/v1/projects/p/locations/us-central1/publishers/google/models/gemini-pro:streamGenerateContent",
+			body:     `{"model": "gemini-pro/us-central1", "systemInstruction": {"parts": [{"text": "Be brief"}]}, "contents": [{"role": "user", "parts": [{"text": "Hello"}]}]}`,
+			provider: "vertex",
+			want: Envelope{
+				Provider:      "vertex",
+				ModelProvider: "vertex",
+				Model:         "gemini-pro",
+				Region:        "us-central1",
+				Messages: []model.Message{
+					{"role": "system", "content": "Be brief"},
+					{"role": "user", "content": "Hello"},
+				},
+				Stream: true,
+			},
+			wantRequested: "vertex/gemini-pro/us-central1",
+		},
+		{
+			name:     "bedrock stream",
+			url:      "https://bedrock-runtime.us-east-1.amazonaws.com/model/amazon.nova-lite-v1:0/converse-stream",
+			body:     `{"model": "openai/gpt-4o", "messages": [{"role": "user", "content": [{"text": "Hello"}]}]}`,
+			provider: "bedrock",
+			want: Envelope{
+				Provider:      "bedrock",
+				ModelProvider: "openai",
+				Model:         "gpt-4o",
+				Messages: []model.Message{
+					{"role": "user", "content": []interface{}{map[string]interface{}{"text": "Hello"}}},
+				},
+				Stream: true,
+			},
+			wantRequested: "openai/gpt-4o",
+		},
+		{
+			name:     "anthropic caller",
+			url:      "https://api.anthropic.com/v1/messages",
+			body:     `{"model": "openai/gpt-4o", "system": "Be brief", "max_tokens": 100, "messages": [{"role": "user", "content": [{"type": "text", "text": "Hello"}]}]}`,
+			provider: "anthropic",
+			want: Envelope{
+				Provider:      "anthropic",
+				ModelProvider: "openai",
+				Model:         "gpt-4o",
+				Messages: []model.Message{
+					{"role": "system", "content": "Be brief"},
+					{"role": "user", "content": "Hello"},
+				},
+			},
+			wantRequested: "openai/gpt-4o",
+		},
+		{
+			name:     "embeddings",
+			url:      "https://api.openai.com/v1/embeddings",
+			body:     `{"model": "text-embedding-3-small", "input": "Hello"}`,
+			provider: "openai",
+			want: Envelope{
+				Provider:      "openai",
+				ModelProvider: "openai",
+				Model:         "text-embedding-3-small",
+				Embeddings:    true,
+			},
+			wantRequested: "openai/text-embedding-3-small",
+		},
+		{
+			name:        "empty body",
+			url:         "https://api.openai.com/v1/chat/completions",
+			provider:    "openai",
+			errContains: "empty request body",
+		},
+		{
+			name:        "invalid body",
+			url:         "https://api.openai.com/v1/chat/completions",
+			body:        `{invalid`,
+			provider:    "openai",
+			errContains: "failed to unmarshal body",
+		},
+		{
+			name:        "missing model",
+			url:         "https://api.openai.com/v1/chat/completions",
+			body:        `{"messages": [{"role": "user", "content": "Hello"}]}`,
+			provider:    "openai",
+			errContains: "model field not found or not a string",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", tt.url, nil)
+			got, err := ParseEnvelope(req, []byte(tt.body), tt.provider, testProviders)
+			if tt.errContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+					t.Fatalf("ParseEnvelope() error = %v, want error containing %q", err, tt.errContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseEnvelope() unexpected error: %v", err)
+			}
+
+			if string(got.Body) != tt.body {
+				t.Errorf("Body = %s, want %s", got.Body, tt.body)
+			}
+			if _, ok := got.Fields["model"]; !ok {
+				t.Errorf("Fields = %v, want the model field", got.Fields)
+			}
+			got.Body, got.Fields = nil, nil
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("ParseEnvelope() = %+v, want %+v", *got, tt.want)
+			}
+			if requested := got.RequestedModel(); requested != tt.wantRequested {
+				t.Errorf("RequestedModel() = %s, want %s", requested, tt.wantRequested)
+			}
+		})
+	}
+}
+
+func TestEnvelopeContext(t *testing.T) {
+	if _, ok := EnvelopeFromContext(context.Background()); ok {
+		t.Fatal("EnvelopeFromContext() found an envelope in an empty context")
+	}
+
+	env := &Envelope{Model: "gpt-4o"}
+	got, ok := EnvelopeFromContext(WithEnvelope(context.Background(), env))
+	if !ok || got != env {
+		t.Errorf("EnvelopeFromContext() = %v, %v, want %v, true", got, ok, env)
+	}
+}
+
+func TestSplitModel(t *testing.T) {
+	providers := model.Providers{
+		"openai": hostProvider{host: "openai.com"},
+		"vertex": hostProvider{host: "aiplatform.googleapis.com", regional: true},
+		"local":  hostProvider{host: "localhost"},
+	}
+
+	tests := []struct {
+		name           string
+		modelStr       string
+		callerProvider string
+		wantProvider   string
+		wantModel      string
+		wantRegion     string
+	}{
+		{name: "provider and model", modelStr: "openai/gpt-4o", wantProvider: "openai", wantModel: "gpt-4o"},
+		{name: "regional provider with region", modelStr: "vertex/gemini-pro/us-east4", wantProvider: "vertex", wantModel: "gemini-pro", wantRegion: "us-east4"},
+		{name: "model name with slash", modelStr: "local/meta-llama/Llama-3-70b", wantProvider: "local", wantModel: "meta-llama/Llama-3-70b"},
+		{name: "caller's regional provider", modelStr: "gemini-pro/us-east4", callerProvider: "vertex", wantModel: "gemini-pro", wantRegion: "us-east4"},
+		{name: "caller's provider without regions", modelStr: "meta-llama/Llama-3-70b", callerProvider: "local", wantModel: "meta-llama/Llama-3-70b"},
+		{name: "model only", modelStr: "gpt-4o", callerProvider: "openai", wantModel: "gpt-4o"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, modelName, region := splitModel(tt.modelStr, tt.callerProvider, providers)
+			if provider != tt.wantProvider || modelName != tt.wantModel || region != tt.wantRegion {
+				t.Errorf("splitModel() = %q, %q, %q, want %q, %q, %q", provider, modelName, region, tt.wantProvider, tt.wantModel, tt.wantRegion)
+			}
+		})
+	}
+}
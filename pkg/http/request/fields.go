@@ -0,0 +1,125 @@
+package request
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+)
+
+// openAIRequestFields are the fields of an OpenAI chat completion request. They
+// are either translated for other providers or have no equivalent there, so only
+// fields outside this list are passed on unchanged.
+var openAIRequestFields = map[string]bool{
+	"model":                 true,
+	"messages":              true,
+	"temperature":           true,
+	"max_tokens":            true,
+	"max_completion_tokens": true,
+	"top_p":                 true,
+	"top_k":                 true,
+	"stop":                  true,
+	"n":                     true,
+	"seed":                  true,
+	"presence_penalty":      true,
+	"frequency_penalty":     true,
+	"logit_bias":            true,
+	"logprobs":              true,
+	"top_logprobs":          true,
+	"tools":                 true,
+	"tool_choice":           true,
+	"parallel_tool_calls":   true,
+	"functions":             true,
+	"function_call":         true,
+	"response_format":       true,
+	"stream":                true,
+	"stream_options":        true,
+	"user":                  true,
+	"metadata":              true,
+	"store":                 true,
+	"service_tier":          true,
+	"reasoning_effort":      true,
+	"modalities":            true,
+	"audio":                 true,
+	"prediction":            true,
+}
+
+// vertexRequestFields are the fields of a Vertex AI generateContent request, which
+// are translated for other providers or have no equivalent there.
+var vertexRequestFields = map[string]bool{
+	"contents":          true,
+	"systemInstruction": true,
+	"generationConfig":  true,
+	"tools":             true,
+	"toolConfig":        true,
+	"safetySettings":    true,
+	"cachedContent":     true,
+	"labels":            true,
+}
+
+// unknownFields returns the top-level fields of a body that are not among the
+// known fields of its format.
+func unknownFields(body []byte, known map[string]bool) map[string]json.RawMessage {
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil
+	}
+
+	fields := make(map[string]json.RawMessage)
+	for key, value := range payload {
+		if !known[key] {
+			fields[key] = value
+		}
+	}
+	return fields
+}
+
+// withFields adds fields to a body that it does not have yet.
+func withFields(body []byte, fields map[string]json.RawMessage) ([]byte, error) {
+	if len(fields) == 0 {
+		return body, nil
+	}
+
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+	for key, value := range fields {
+		if _, ok := payload[key]; ok {
+			continue
+		}
+		payload[key] = value
+		slog.Info("➕ Passing on field", "key", key)
+	}
+	return json.Marshal(payload)
+}
+
+// WithModel replaces the model of a body that names one, leaving its other fields
+// as they are. Bodies without a model, whose providers take it from the URL, are
+// returned unchanged.
+func WithModel(body []byte, modelName string) ([]byte, error) {
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal body: %w", err)
+	}
+	if _, ok := payload["model"]; !ok {
+		return body, nil
+	}
+	return withModel(body, modelName)
+}
+
+// withModel sets the model field of a body, leaving its other fields as they are.
+func withModel(body []byte, modelName string) ([]byte, error) {
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal body: %w", err)
+	}
+	value, err := json.Marshal(modelName)
+	if err != nil {
+		return nil, err
+	}
+	if string(payload["model"]) == string(value) {
+		return body, nil
+	}
+	payload["model"] = value
+	return json.Marshal(payload)
+}
@@ -0,0 +1,282 @@
+package request
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Not-Diamond/go-notdiamond/pkg/model"
+)
+
+// modelParamFields are the JSON Pointers of the model parameters in the request
+// format of each provider. A format without a pointer for a parameter doesn't
+// support it.
+var modelParamFields = map[string]map[string]string{
+	"openai": {
+		"temperature":       "/temperature",
+		"max_tokens":        "/max_tokens",
+		"top_p":             "/top_p",
+		"presence_penalty":  "/presence_penalty",
+		"frequency_penalty": "/frequency_penalty",
+		"reasoning_effort":  "/reasoning_effort",
+	},
+	"openai-reasoning": {
+		"max_tokens":       "/max_completion_tokens",
+		"reasoning_effort": "/reasoning_effort",
+	},
+	"vertex": {
+		"temperature":       "/generationConfig/temperature",
+		"max_tokens":        "/generationConfig/maxOutputTokens",
+		"top_p":             "/generationConfig/topP",
+		"presence_penalty":  "/generationConfig/presencePenalty",
+		"frequency_penalty": "/generationConfig/frequencyPenalty",
+	},
+	"anthropic": {
+		"temperature": "/temperature",
+		"max_tokens":  "/max_tokens",
+		"top_p":       "/top_p",
+	},
+	"bedrock": {
+		"temperature": "/inferenceConfig/temperature",
+		"max_tokens":  "/inferenceConfig/maxTokens",
+		"top_p":       "/inferenceConfig/topP",
+	},
+}
+
+// modelParamValues reads the model parameters, in the order they are set. A
+// parameter that is not configured reports false.
+var modelParamValues = []struct {
+	name  string
+	value func(model.ModelParams) (interface{}, bool)
+}{
+	{"temperature", func(p model.ModelParams) (interface{}, bool) {
+		if p.Temperature == nil {
+			return nil, false
+		}
+		return *p.Temperature, true
+	}},
+	{"max_tokens", func(p model.ModelParams) (interface{}, bool) {
+		if p.MaxTokens == nil {
+			return nil, false
+		}
+		return *p.MaxTokens, true
+	}},
+	{"top_p", func(p model.ModelParams) (interface{}, bool) {
+		if p.TopP == nil {
+			return nil, false
+		}
+		return *p.TopP, true
+	}},
+	{"presence_penalty", func(p model.ModelParams) (interface{}, bool) {
+		if p.PresencePenalty == nil {
+			return nil, false
+		}
+		return *p.PresencePenalty, true
+	}},
+	{"frequency_penalty", func(p model.ModelParams) (interface{}, bool) {
+		if p.FrequencyPenalty == nil {
+			return nil, false
+		}
+		return *p.FrequencyPenalty, true
+	}},
+	{"reasoning_effort", func(p model.ModelParams) (interface{}, bool) {
+		return p.ReasoningEffort, p.ReasoningEffort != ""
+	}},
+}
+
+// openAIReasoningModels are the name prefixes of OpenAI reasoning models.
+var openAIReasoningModels = []string{"o1", "o3", "o4", "gpt-5"}
+
+// isOpenAIReasoningModel reports whether the model is an OpenAI reasoning model,
+// such as o3-mini or gpt-5.
+func isOpenAIReasoningModel(modelName string) bool {
+	for _, prefix := range openAIReasoningModels {
+		if modelName == prefix || strings.HasPrefix(modelName, prefix+"-") || strings.HasPrefix(modelName, prefix+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// paramsFormat returns the request format of a body translated for the provider.
+// Embeddings bodies have no model parameters, Vertex AI bodies are recognised by
+// their contents and other providers are taken to speak OpenAI's format.
+func paramsFormat(provider string, payload map[string]interface{}) string {
+	_, hasMessages := payload["messages"]
+	_, hasInput := payload["input"]
+	if _, ok := payload["instances"]; ok || hasInput && !hasMessages {
+		return "embeddings"
+	}
+	switch provider {
+	case string(model.ClientTypeAnthropic):
+		return "anthropic"
+	case string(model.ClientTypeBedrock):
+		return "bedrock"
+	}
+	if _, ok := payload["contents"]; ok {
+		return "vertex"
+	}
+	return "openai"
+}
+
+// ApplyModelParams sets the parameters of a model in a body translated for its
+// provider, lowers its max output tokens to the model's limit and applies the
+// patches last. OpenAI reasoning models get max_tokens as max_completion_tokens.
+// Parameters the provider has no field for are handled by the policy. The body is
+// returned unchanged if there is nothing to do.
+func ApplyModelParams(body []byte, provider, modelName string, params model.ModelParams, policy model.UnsupportedParamsPolicy) ([]byte, error) {
+	// Decode numbers as json.Number so that large integers are kept exactly
+	var payload map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&payload); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal body: %w", err)
+	}
+
+	format := paramsFormat(provider, payload)
+	changed := false
+
+	// OpenAI reasoning models refuse max_tokens
+	if format == "openai" && (params.Reasoning || isOpenAIReasoningModel(modelName)) {
+		format = "openai-reasoning"
+		if value, ok := payload["max_tokens"]; ok {
+			delete(payload, "max_tokens")
+			if _, ok := payload["max_completion_tokens"]; !ok {
+				payload["max_completion_tokens"] = value
+			}
+			changed = true
+		}
+	}
+	fields := modelParamFields[format]
+
+	var unsupported []string
+	for _, param := range modelParamValues {
+		value, ok := param.value(params)
+		if !ok {
+			continue
+		}
+		pointer, supported := fields[param.name]
+		if !supported {
+			unsupported = append(unsupported, param.name)
+			continue
+		}
+		if err := setPointer(payload, pointer, value); err != nil {
+			return nil, err
+		}
+		changed = true
+	}
+	if err := CheckUnsupportedParams(unsupported, provider, policy); err != nil {
+		return nil, err
+	}
+
+	if params.MaxOutputTokens > 0 {
+		pointers := []string{fields["max_tokens"]}
+		if format == "openai" {
+			pointers = append(pointers, "/max_completion_tokens")
+		}
+		for _, pointer := range pointers {
+			value, ok := getPointer(payload, pointer)
+			if !ok {
+				continue
+			}
+			if tokens, err := json.Number(fmt.Sprint(value)).Int64(); err == nil && tokens > int64(params.MaxOutputTokens) {
+				if err := setPointer(payload, pointer, params.MaxOutputTokens); err != nil {
+					return nil, err
+				}
+				changed = true
+			}
+		}
+	}
+
+	for _, patch := range params.Patches {
+		var err error
+		switch patch.Op {
+		case model.ParamPatchSet:
+			err = setPointer(payload, patch.Path, patch.Value)
+		case model.ParamPatchDelete:
+			err = deletePointer(payload, patch.Path)
+		default:
+			err = fmt.Errorf("unknown patch operation: %s", patch.Op)
+		}
+		if err != nil {
+			return nil, err
+		}
+		changed = true
+	}
+
+	if !changed {
+		return body, nil
+	}
+	return json.Marshal(payload)
+}
+
+// pointerTokens splits a JSON Pointer into its unescaped reference tokens.
+func pointerTokens(pointer string) ([]string, error) {
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON Pointer %q: must start with /", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+	return tokens, nil
+}
+
+// getPointer returns the value at a JSON Pointer through objects.
+func getPointer(payload map[string]interface{}, pointer string) (interface{}, bool) {
+	tokens, err := pointerTokens(pointer)
+	if err != nil {
+		return nil, false
+	}
+	var value interface{} = payload
+	for _, token := range tokens {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[token]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// setPointer sets the value at a JSON Pointer, creating the objects on its path.
+func setPointer(payload map[string]interface{}, pointer string, value interface{}) error {
+	tokens, err := pointerTokens(pointer)
+	if err != nil {
+		return err
+	}
+	object := payload
+	for _, token := range tokens[:len(tokens)-1] {
+		next, ok := object[token]
+		if !ok || next == nil {
+			next = make(map[string]interface{})
+			object[token] = next
+		}
+		if object, ok = next.(map[string]interface{}); !ok {
+			return fmt.Errorf("cannot set %s: %s is not an object", pointer, token)
+		}
+	}
+	object[tokens[len(tokens)-1]] = value
+	return nil
+}
+
+// deletePointer deletes the value at a JSON Pointer, if present.
+func deletePointer(payload map[string]interface{}, pointer string) error {
+	tokens, err := pointerTokens(pointer)
+	if err != nil {
+		return err
+	}
+	object := payload
+	for _, token := range tokens[:len(tokens)-1] {
+		next, ok := object[token].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		object = next
+	}
+	delete(object, tokens[len(tokens)-1])
+	return nil
+}
@@ -0,0 +1,134 @@
+package request
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Not-Diamond/go-notdiamond/pkg/model"
+)
+
+func TestApplyModelParams(t *testing.T) {
+	temperature, presencePenalty := 0.2, 0.5
+	maxTokens := 2048
+
+	tests := []struct {
+		name        string
+		provider    string
+		modelName   string
+		body        string
+		params      model.ModelParams
+		policy      model.UnsupportedParamsPolicy
+		want        string
+		errContains string
+	}{
+		{
+			name:      "openai override and clamp",
+			provider:  "openai",
+			modelName: "gpt-4o",
+			body:      `{"model": "gpt-4o", "messages": [], "temperature": 1, "max_tokens": 50000, "seed": 9007199254740993}`,
+			params:    model.ModelParams{Temperature: &temperature, MaxOutputTokens: 16384},
+			want:      `{"model": "gpt-4o", "messages": [], "temperature": 0.2, "max_tokens": 16384, "seed": 9007199254740993}`,
+		},
+		{
+			name:      "openai reasoning model by name",
+			provider:  "openai",
+			modelName: "o3-mini",
+			body:      `{"model": "o3-mini", "messages": [], "max_tokens": 1000}`,
+			params:    model.ModelParams{ReasoningEffort: "high"},
+			want:      `{"model": "o3-mini", "messages": [], "max_completion_tokens": 1000, "reasoning_effort": "high"}`,
+		},
+		{
+			name:      "azure deployment marked as reasoning model",
+			provider:  "azure",
+			modelName: "reasoner",
+			body:      `{"messages": [], "max_tokens": 1000}`,
+			params:    model.ModelParams{Reasoning: true, MaxTokens: &maxTokens},
+			want:      `{"messages": [], "max_completion_tokens": 2048}`,
+		},
+		{
+			name:      "vertex params and patches",
+			provider:  "vertex",
+			modelName: "gemini-2.5-flash",
+			body: `{
+				"contents": [{"role": "user", "parts": [{"text": "Hello"}]}],
+				"generationConfig": {"maxOutputTokens": 100000},
+				"safetySettings": [{"category": "HARM_CATEGORY_HARASSMENT", "threshold": "BLOCK_NONE"}]
+			}`,
+			params: model.ModelParams{
+				Temperature:     &temperature,
+				MaxOutputTokens: 65536,
+				Patches: []model.ParamPatch{
+					{Op: model.ParamPatchSet, Path: "/generationConfig/thinkingConfig/thinkingBudget", Value: 1024},
+					{Op: model.ParamPatchDelete, Path: "/safetySettings"},
+				},
+			},
+			want: `{
+				"contents": [{"role": "user", "parts": [{"text": "Hello"}]}],
+				"generationConfig": {"maxOutputTokens": 65536, "temperature": 0.2, "thinkingConfig": {"thinkingBudget": 1024}}
+			}`,
+		},
+		{
+			name:      "anthropic drops unsupported params",
+			provider:  "anthropic",
+			modelName: "claude-sonnet-4-5",
+			body:      `{"model": "claude-sonnet-4-5", "messages": [], "max_tokens": 4096}`,
+			params:    model.ModelParams{Temperature: &temperature, PresencePenalty: &presencePenalty},
+			want:      `{"model": "claude-sonnet-4-5", "messages": [], "max_tokens": 4096, "temperature": 0.2}`,
+		},
+		{
+			name:        "anthropic fails on unsupported params",
+			provider:    "anthropic",
+			modelName:   "claude-sonnet-4-5",
+			body:        `{"model": "claude-sonnet-4-5", "messages": [], "max_tokens": 4096}`,
+			params:      model.ModelParams{PresencePenalty: &presencePenalty},
+			policy:      model.UnsupportedParamsFail,
+			errContains: "parameters not supported by anthropic: presence_penalty",
+		},
+		{
+			name:      "bedrock inference config",
+			provider:  "bedrock",
+			modelName: "anthropic.claude-3-5-sonnet-20240620-v1:0",
+			body:      `{"messages": [], "inferenceConfig": {"maxTokens": 8192}}`,
+			params:    model.ModelParams{MaxOutputTokens: 4096},
+			want:      `{"messages": [], "inferenceConfig": {"maxTokens": 4096}}`,
+		},
+		{
+			name:        "patch through a field that is not an object",
+			provider:    "openai",
+			modelName:   "gpt-4o",
+			body:        `{"model": "gpt-4o", "messages": [], "stop": "END"}`,
+			params:      model.ModelParams{Patches: []model.ParamPatch{{Op: model.ParamPatchSet, Path: "/stop/0", Value: "DONE"}}},
+			errContains: "stop is not an object",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ApplyModelParams([]byte(tt.body), tt.provider, tt.modelName, tt.params, tt.policy)
+			if tt.errContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+					t.Fatalf("ApplyModelParams() error = %v, want error containing %q", err, tt.errContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ApplyModelParams() unexpected error: %v", err)
+			}
+			if strings.Contains(tt.want, "9007199254740993") && !strings.Contains(string(got), "9007199254740993") {
+				t.Errorf("ApplyModelParams() = %s, want the seed kept exactly", got)
+			}
+			assertJSONEqual(t, got, tt.want)
+		})
+	}
+}
+
+func TestApplyModelParamsUnchanged(t *testing.T) {
+	body := `{"model": "gpt-4o",  "messages": [], "max_tokens": 100}`
+	got, err := ApplyModelParams([]byte(body), "openai", "gpt-4o", model.ModelParams{MaxOutputTokens: 16384}, "")
+	if err != nil {
+		t.Fatalf("ApplyModelParams() unexpected error: %v", err)
+	}
+	if string(got) != body {
+		t.Errorf("ApplyModelParams() = %s, want the body unchanged", got)
+	}
+}
@@ -0,0 +1,298 @@
+package request
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/Not-Diamond/go-notdiamond/pkg/model"
+)
+
+// generationParam maps an OpenAI request parameter to a Vertex AI generationConfig
+// field.
+type generationParam struct {
+	openAI string
+	vertex string
+	// toVertexOnly params are not mapped back to OpenAI, because OpenAI does not
+	// support them or has another param for the field
+	toVertexOnly bool
+}
+
+// generationParams maps the generation parameters between OpenAI and Vertex AI.
+// The first param set for a Vertex AI field wins.
+var generationParams = []generationParam{
+	{openAI: "temperature", vertex: "temperature"},
+	{openAI: "top_p", vertex: "topP"},
+	{openAI: "top_k", vertex: "topK", toVertexOnly: true},
+	{openAI: "max_completion_tokens", vertex: "maxOutputTokens", toVertexOnly: true},
+	{openAI: "max_tokens", vertex: "maxOutputTokens"},
+	{openAI: "n", vertex: "candidateCount"},
+	{openAI: "presence_penalty", vertex: "presencePenalty"},
+	{openAI: "frequency_penalty", vertex: "frequencyPenalty"},
+	{openAI: "seed", vertex: "seed"},
+	{openAI: "stop", vertex: "stopSequences"},
+	{openAI: "logprobs", vertex: "responseLogprobs"},
+	{openAI: "top_logprobs", vertex: "logprobs"},
+}
+
+// openAIParamsWithoutVertex are the OpenAI request parameters Vertex AI has no
+// equivalent for.
+var openAIParamsWithoutVertex = []string{
+	"logit_bias",
+	"parallel_tool_calls",
+	"functions",
+	"function_call",
+	"user",
+	"metadata",
+	"store",
+	"service_tier",
+	"reasoning_effort",
+	"modalities",
+	"audio",
+	"prediction",
+}
+
+// openAIParamsWithoutAnthropic are the OpenAI request parameters the Anthropic
+// Messages API has no equivalent for.
+var openAIParamsWithoutAnthropic = []string{
+	"seed",
+	"presence_penalty",
+	"frequency_penalty",
+	"logit_bias",
+	"logprobs",
+	"top_logprobs",
+	"response_format",
+	"functions",
+	"function_call",
+	"metadata",
+	"store",
+	"service_tier",
+	"reasoning_effort",
+	"modalities",
+	"audio",
+	"prediction",
+}
+
+// openAIParamsWithoutBedrock are the OpenAI request parameters the Bedrock
+// Converse translation has no equivalent for.
+var openAIParamsWithoutBedrock = []string{
+	"top_k",
+	"seed",
+	"presence_penalty",
+	"frequency_penalty",
+	"logit_bias",
+	"logprobs",
+	"top_logprobs",
+	"tools",
+	"tool_choice",
+	"parallel_tool_calls",
+	"functions",
+	"function_call",
+	"response_format",
+	"user",
+	"metadata",
+	"store",
+	"service_tier",
+	"reasoning_effort",
+	"modalities",
+	"audio",
+	"prediction",
+}
+
+// vertexGenerationConfig maps the generation parameters of an OpenAI request to a
+// Vertex AI generationConfig. Parameters that are absent stay absent.
+func vertexGenerationConfig(payload map[string]json.RawMessage) map[string]interface{} {
+	config := make(map[string]interface{})
+	for _, param := range generationParams {
+		value, ok := payload[param.openAI]
+		if !ok || string(value) == "null" {
+			continue
+		}
+		if _, ok := config[param.vertex]; ok {
+			continue
+		}
+		// OpenAI takes a single stop sequence as a string
+		if param.openAI == "stop" && strings.HasPrefix(strings.TrimSpace(string(value)), `"`) {
+			value = json.RawMessage("[" + string(value) + "]")
+		}
+		config[param.vertex] = value
+	}
+	return config
+}
+
+// openAIGenerationParams maps a Vertex AI generationConfig to OpenAI request
+// parameters. Fields that are absent stay absent.
+func openAIGenerationParams(config map[string]json.RawMessage) map[string]interface{} {
+	params := make(map[string]interface{})
+	for _, param := range generationParams {
+		if param.toVertexOnly {
+			continue
+		}
+		if value, ok := config[param.vertex]; ok && string(value) != "null" {
+			params[param.openAI] = value
+		}
+	}
+	return params
+}
+
+// UnsupportedVertexParams returns the parameters of an OpenAI request that are
+// lost when it is sent to Vertex AI. Other bodies have none.
+func UnsupportedVertexParams(body []byte) []string {
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil
+	}
+	if _, ok := payload["messages"]; !ok {
+		// Vertex AI embedding models take no user
+		if value, ok := payload["user"]; ok && string(value) != "null" && IsEmbeddingsPayload(body) {
+			return []string{"user"}
+		}
+		return nil
+	}
+
+	var params []string
+	for _, param := range openAIParamsWithoutVertex {
+		if value, ok := payload[param]; ok && string(value) != "null" {
+			params = append(params, param)
+		}
+	}
+	return params
+}
+
+// UnsupportedAnthropicParams returns the parameters of an OpenAI or Vertex AI
+// request that are lost when it is sent to Anthropic. Anthropic bodies have none.
+func UnsupportedAnthropicParams(body []byte) []string {
+	if IsAnthropicPayload(body) {
+		return nil
+	}
+	// Vertex AI bodies are translated through OpenAI format, losing its losses too
+	params := UnsupportedOpenAIParams(body)
+	transformed, err := TransformToOpenAIRequest(body, "")
+	if err != nil {
+		return params
+	}
+
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(transformed, &payload); err != nil {
+		return params
+	}
+	params = append(params, presentParams(payload, openAIParamsWithoutAnthropic)...)
+	sort.Strings(params)
+	return params
+}
+
+// UnsupportedBedrockParams returns the parameters of an OpenAI, Vertex AI or
+// Anthropic request that are lost when it is sent to Bedrock. Only the text of
+// messages is sent, so tool calls, tool results and other content parts are lost.
+func UnsupportedBedrockParams(body []byte) []string {
+	// Vertex AI and Anthropic bodies are translated through OpenAI format
+	params := UnsupportedOpenAIParams(body)
+	transformed, err := TransformToOpenAIRequest(body, "")
+	if err != nil {
+		return params
+	}
+
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(transformed, &payload); err != nil {
+		return params
+	}
+	params = append(params, presentParams(payload, openAIParamsWithoutBedrock)...)
+
+	var messages []openAIMessage
+	if err := json.Unmarshal(payload["messages"], &messages); err != nil {
+		return params
+	}
+	lost := make(map[string]bool)
+	for _, message := range messages {
+		if len(message.ToolCalls) > 0 {
+			lost["messages.tool_calls"] = true
+		}
+		if message.ToolCallID != "" {
+			lost["messages.tool_call_id"] = true
+		}
+		var parts []model.ContentPart
+		if err := json.Unmarshal(message.Content, &parts); err != nil {
+			continue
+		}
+		for _, part := range parts {
+			if part.Type != model.ContentPartText {
+				lost["messages.content."+part.Type] = true
+			}
+		}
+	}
+	for param := range lost {
+		params = append(params, param)
+	}
+	sort.Strings(params)
+	return params
+}
+
+// presentParams returns the params a payload sets to something other than null,
+// n only if it asks for more than one choice.
+func presentParams(payload map[string]json.RawMessage, without []string) []string {
+	var params []string
+	if value, ok := payload["n"]; ok && string(value) != "null" && string(value) != "1" {
+		params = append(params, "n")
+	}
+	for _, param := range without {
+		if value, ok := payload[param]; ok && string(value) != "null" {
+			params = append(params, param)
+		}
+	}
+	return params
+}
+
+// UnsupportedOpenAIParams returns the parameters of a Vertex AI request that are
+// lost when it is sent to OpenAI. Other bodies have none.
+func UnsupportedOpenAIParams(body []byte) []string {
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil
+	}
+	if _, ok := payload["instances"]; ok {
+		return unsupportedOpenAIEmbeddingsParams(payload)
+	}
+	if _, ok := payload["contents"]; !ok {
+		return nil
+	}
+
+	var params []string
+	for _, field := range []string{"safetySettings", "cachedContent", "labels"} {
+		if _, ok := payload[field]; ok {
+			params = append(params, field)
+		}
+	}
+
+	var config map[string]json.RawMessage
+	if err := json.Unmarshal(payload["generationConfig"], &config); err == nil {
+		mapped := map[string]bool{"responseMimeType": true, "responseSchema": true}
+		for _, param := range generationParams {
+			if !param.toVertexOnly {
+				mapped[param.vertex] = true
+			}
+		}
+		for field := range config {
+			if !mapped[field] {
+				params = append(params, "generationConfig."+field)
+			}
+		}
+	}
+	sort.Strings(params)
+	return params
+}
+
+// CheckUnsupportedParams applies the policy to the parameters a request loses when
+// it is sent to the provider. They are dropped with a warning, or are an error if
+// the policy is model.UnsupportedParamsFail.
+func CheckUnsupportedParams(params []string, provider string, policy model.UnsupportedParamsPolicy) error {
+	if len(params) == 0 {
+		return nil
+	}
+	if policy == model.UnsupportedParamsFail {
+		return fmt.Errorf("parameters not supported by %s: %s", provider, strings.Join(params, ", "))
+	}
+	slog.Warn("⚠️ Dropping parameters not supported by provider", "provider", provider, "params", params)
+	return nil
+}
@@ -0,0 +1,114 @@
+package request
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Not-Diamond/go-notdiamond/pkg/model"
+)
+
+func TestUnsupportedParams(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		wantVertex    []string
+		wantOpenAI    []string
+		wantAnthropic []string
+		wantBedrock   []string
+	}{
+		{
+			name:          "openai request",
+			body:          `{"messages": [{"role": "user", "content": "Hello"}], "temperature": 0.2, "user": "user-1", "logit_bias": {"50256": -100}, "metadata": null}`,
+			wantVertex:    []string{"logit_bias", "user"},
+			wantAnthropic: []string{"logit_bias"},
+			wantBedrock:   []string{"logit_bias", "user"},
+		},
+		{
+			name: "openai request with tools and images",
+			body: `{
+				"messages": [
+					{"role": "user", "content": [{"type": "text", "text": "Weather here?"}, {"type": "image_url", "image_url": {"url": "https://example.com/city.png"}}]},
+					{"role": "assistant", "content": null, "tool_calls": [{"id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": "{}"}}]},
+					{"role": "tool", "tool_call_id": "call_1", "content": "Sunny"}
+				],
+				"tools": [{"type": "function", "function": {"name": "get_weather"}}],
+				"response_format": {"type": "json_object"},
+				"n": 2,
+				"presence_penalty": 0.5
+			}`,
+			wantAnthropic: []string{"n", "presence_penalty", "response_format"},
+			wantBedrock:   []string{"messages.content.image_url", "messages.tool_call_id", "messages.tool_calls", "n", "presence_penalty", "response_format", "tools"},
+		},
+		{
+			name: "vertex request",
+			body: `{
+				"contents": [{"role": "user", "parts": [{"text": "Hello"}]}],
+				"safetySettings": [{"category": "HARM_CATEGORY_HARASSMENT", "threshold": "BLOCK_NONE"}],
+				"generationConfig": {"temperature": 0.2, "topK": 40, "responseMimeType": "application/json", "thinkingConfig": {"thinkingBudget": 0}}
+			}`,
+			wantOpenAI:    []string{"generationConfig.thinkingConfig", "generationConfig.topK", "safetySettings"},
+			wantAnthropic: []string{"generationConfig.thinkingConfig", "generationConfig.topK", "response_format", "safetySettings"},
+			wantBedrock:   []string{"generationConfig.thinkingConfig", "generationConfig.topK", "response_format", "safetySettings"},
+		},
+		{
+			name:        "openai embeddings request",
+			body:        `{"model": "text-embedding-3-small", "input": ["Hello"], "user": "user-1"}`,
+			wantVertex:  []string{"user"},
+			wantBedrock: []string{"user"},
+		},
+		{
+			name:          "vertex embeddings request",
+			body:          `{"instances": [{"content": "Hello", "task_type": "RETRIEVAL_QUERY"}], "parameters": {"outputDimensionality": 256, "autoTruncate": false}}`,
+			wantOpenAI:    []string{"instances.task_type", "parameters.autoTruncate"},
+			wantAnthropic: []string{"instances.task_type", "parameters.autoTruncate"},
+			wantBedrock:   []string{"instances.task_type", "parameters.autoTruncate"},
+		},
+		{
+			name:          "nothing lost for vertex",
+			body:          `{"messages": [{"role": "user", "content": "Hello"}], "max_tokens": 100, "seed": 7}`,
+			wantAnthropic: []string{"seed"},
+			wantBedrock:   []string{"seed"},
+		},
+		{
+			name: "anthropic request",
+			body: `{"model": "claude-3-haiku", "max_tokens": 100, "system": "Be brief", "messages": [{"role": "user", "content": [{"type": "text", "text": "Hello"}]}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := UnsupportedVertexParams([]byte(tt.body)); !reflect.DeepEqual(got, tt.wantVertex) {
+				t.Errorf("UnsupportedVertexParams() = %v, want %v", got, tt.wantVertex)
+			}
+			if got := UnsupportedOpenAIParams([]byte(tt.body)); !reflect.DeepEqual(got, tt.wantOpenAI) {
+				t.Errorf("UnsupportedOpenAIParams() = %v, want %v", got, tt.wantOpenAI)
+			}
+			if got := UnsupportedAnthropicParams([]byte(tt.body)); !reflect.DeepEqual(got, tt.wantAnthropic) {
+				t.Errorf("UnsupportedAnthropicParams() = %v, want %v", got, tt.wantAnthropic)
+			}
+			if got := UnsupportedBedrockParams([]byte(tt.body)); !reflect.DeepEqual(got, tt.wantBedrock) {
+				t.Errorf("UnsupportedBedrockParams() = %v, want %v", got, tt.wantBedrock)
+			}
+		})
+	}
+}
+
+func TestCheckUnsupportedParams(t *testing.T) {
+	params := []string{"logit_bias", "user"}
+
+	if err := CheckUnsupportedParams(params, "vertex", ""); err != nil {
+		t.Errorf("CheckUnsupportedParams() with default policy error = %v", err)
+	}
+	if err := CheckUnsupportedParams(params, "vertex", model.UnsupportedParamsDrop); err != nil {
+		t.Errorf("CheckUnsupportedParams() with drop policy error = %v", err)
+	}
+	if err := CheckUnsupportedParams(nil, "vertex", model.UnsupportedParamsFail); err != nil {
+		t.Errorf("CheckUnsupportedParams() without params error = %v", err)
+	}
+
+	err := CheckUnsupportedParams(params, "vertex", model.UnsupportedParamsFail)
+	if err == nil || !strings.Contains(err.Error(), "parameters not supported by vertex: logit_bias, user") {
+		t.Errorf("CheckUnsupportedParams() with fail policy error = %v", err)
+	}
+}
@@ -13,7 +13,9 @@ import (
 )
 
 // ExtractModelFromRequest extracts the model from the request body.
-func ExtractModelFromRequest(req *http.Request) (string, error) {
+// A leading provider prefix is stripped if it names one of the given providers,
+// usually the registry of clients.Registry.
+func ExtractModelFromRequest(req *http.Request, providers model.Providers) (string, error) {
 	if req == nil {
 		return "", fmt.Errorf("request is nil")
 	}
@@ -45,40 +47,123 @@ func ExtractModelFromRequest(req *http.Request) (string, error) {
 		return "", fmt.Errorf("model field not found or not a string")
 	}
 
-	// Check if the model string contains a region (format: model/region)
-	parts := strings.Split(modelStr, "/")
+	_, modelName, region := splitModel(modelStr, "", providers)
+	if region != "" {
+		return modelName + "/" + region, nil // Return model/region
+	}
+	return modelName, nil
+}
+
+// ExtractProviderFromModel returns the provider the model of the body is prefixed
+// with, in provider/model or provider/model/region format. It returns "" if the
+// model has no provider prefix.
+func ExtractProviderFromModel(body []byte, providers model.Providers) string {
+	var payload struct {
+		Model string `json:"model"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return ""
+	}
+	provider, _, _ := splitModel(payload.Model, "", providers)
+	return provider
+}
+
+// isProviderName reports whether name is one of the given providers.
+func isProviderName(name string, providers model.Providers) bool {
+	_, ok := providers[name]
+	return ok
+}
+
+// ProviderOfClient returns the provider serving a configured client: its tag if it
+// was tagged with model.WithProvider, otherwise the first provider whose MatchesHost
+// accepts the client host.
+func ProviderOfClient(client *http.Request, providers model.Providers) string {
+	if provider := model.ClientProvider(client); provider != "" {
+		return provider
+	}
+	if client.URL == nil {
+		return ""
+	}
+	for _, name := range providers.Names() {
+		if providers[name].MatchesHost(client.URL.Host) {
+			return name
+		}
+	}
+	return ""
+}
+
+// ExtractProviderFromClients returns the provider of the configured clients whose
+// URL host is exactly the request host. When a gateway host serves several
+// providers, tagged clients win over host matching: the request's own tag first,
+// then the tagged client with the request path, then the first tagged client.
+// Requests to an Anthropic Messages API host without such a client come from
+// Anthropic callers.
+func ExtractProviderFromClients(req *http.Request, clients []http.Request, providers model.Providers) (string, error) {
+	if req.URL == nil {
+		return "", fmt.Errorf("request URL is nil")
+	}
+
+	var matched []*http.Request
+	for i := range clients {
+		if clients[i].URL != nil && clients[i].URL.Host == req.URL.Host {
+			matched = append(matched, &clients[i])
+		}
+	}
 
-	// If it's in provider/model format, extract just the model part
-	if len(parts) == 2 && (parts[0] == "openai" || parts[0] == "azure" || parts[0] == "vertex") {
-		return parts[1], nil // Return just the model part
+	if len(matched) == 0 {
+		// Callers of the Anthropic Messages API are served without an Anthropic client
+		anthropic, ok := providers[string(model.ClientTypeAnthropic)]
+		if ok && anthropic.MatchesHost(req.URL.Host) && strings.HasSuffix(req.URL.Path, "/v1/messages") {
+			slog.Info("🔍 No configured client matches host, serving as Anthropic Messages API caller",
+				"host", req.URL.Host, "path", req.URL.Path)
+			return string(model.ClientTypeAnthropic), nil
+		}
+		return "", fmt.Errorf("no configured client matches host %s", req.URL.Host)
 	}
 
-	// If it's in model/region format, keep it as is to preserve the region information
-	if len(parts) == 2 && parts[0] != "openai" && parts[0] != "azure" && parts[0] != "vertex" {
-		return modelStr, nil // Return model/region
+	if provider := model.ClientProvider(req); provider != "" {
+		for _, client := range matched {
+			if model.ClientProvider(client) == provider {
+				return provider, nil
+			}
+		}
 	}
 
-	// If it's in provider/model/region format, extract model/region
-	if len(parts) == 3 {
-		return parts[1] + "/" + parts[2], nil // Return model/region
+	tagged := ""
+	for _, client := range matched {
+		provider := model.ClientProvider(client)
+		if provider == "" {
+			continue
+		}
+		if client.URL.Path == req.URL.Path {
+			return provider, nil
+		}
+		if tagged == "" {
+			tagged = provider
+		}
+	}
+	if tagged != "" {
+		return tagged, nil
 	}
 
-	return modelStr, nil
+	provider := ProviderOfClient(matched[0], providers)
+	if provider == "" {
+		return "", fmt.Errorf("no provider recognises host %s, tag its client with model.WithProvider", req.URL.Host)
+	}
+	return provider, nil
 }
 
 // ExtractProviderFromRequest extracts the provider from the request URL or model name.
-func ExtractProviderFromRequest(req *http.Request) string {
+// The URL host is matched against the given providers first; if none matches, the
+// provider prefix of the model name in the request body is used.
+func ExtractProviderFromRequest(req *http.Request, providers model.Providers) string {
 	// First try to extract from URL
-	url := req.URL.String()
-	if strings.Contains(url, "azure") {
-		return "azure"
-	} else if strings.Contains(url, "openai.com") {
-		return "openai"
-	} else if strings.Contains(url, "aiplatform.googleapis.com") ||
-		strings.Contains(url, "-aiplatform.googleapis.com") ||
-		strings.Contains(url, "aiplatform.googleapiss.com") ||
-		strings.Contains(url, "-aiplatform.googleapiss.com") {
-		return "vertex"
+	if req.URL != nil {
+		for _, name := range providers.Names() {
+			if providers[name].MatchesHost(req.URL.Host) {
+				return name
+			}
+		}
 	}
 
 	// If not found in URL, try to extract from model name in the request body
@@ -112,9 +197,8 @@ func ExtractProviderFromRequest(req *http.Request) string {
 
 	// If it's in provider/model format or provider/model/region format
 	if len(parts) >= 2 {
-		provider := parts[0]
-		if provider == "vertex" || provider == "azure" || provider == "openai" {
-			return provider
+		if _, ok := providers[parts[0]]; ok {
+			return parts[0]
 		}
 	}
 
@@ -131,112 +215,136 @@ func ExtractMessagesFromRequest(req *http.Request) []model.Message {
 
 	req.Body = io.NopCloser(bytes.NewBuffer(body))
 
-	provider := ExtractProviderFromRequest(req)
-	switch provider {
-	case "vertex":
-		return extractVertexMessages(body)
-	default:
-		return extractOpenAIMessages(body)
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil
+	}
+	return messagesFromFields(payload)
+}
+
+// messagesFromFields extracts the messages from the top-level fields of a body.
+func messagesFromFields(payload map[string]json.RawMessage) []model.Message {
+	// Vertex AI bodies carry contents instead of messages
+	if _, ok := payload["contents"]; ok {
+		return vertexMessages(payload)
 	}
+	// Anthropic bodies carry the system prompt apart and content blocks of their own
+	if isAnthropicFields(payload) {
+		return anthropicMessages(payload)
+	}
+	return openAIMessages(payload)
 }
 
 // extractOpenAIMessages extracts messages from OpenAI/Azure format
 func extractOpenAIMessages(body []byte) []model.Message {
-	var payload struct {
-		Messages []model.Message `json:"messages"`
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil
+	}
+	return openAIMessages(payload)
+}
+
+// openAIMessages extracts the messages of an OpenAI/Azure body from its fields.
+func openAIMessages(payload map[string]json.RawMessage) []model.Message {
+	var messages []model.Message
+	if err := json.Unmarshal(payload["messages"], &messages); err != nil {
+		return nil
+	}
+	return messages
+}
+
+// anthropicMessages extracts the messages of an Anthropic body from its fields,
+// in OpenAI format.
+func anthropicMessages(payload map[string]json.RawMessage) []model.Message {
+	var callerMessages []anthropicMessage
+	if err := json.Unmarshal(payload["messages"], &callerMessages); err != nil {
+		slog.Error("❌ Failed to extract Anthropic messages", "error", err)
+		return nil
+	}
+
+	converted, err := openAIMessagesFromAnthropicPayload(payload["system"], callerMessages)
+	if err != nil {
+		slog.Error("❌ Failed to extract Anthropic messages", "error", err)
+		return nil
 	}
-	err := json.Unmarshal(body, &payload)
+
+	// Round-trip through JSON so that the messages look like parsed OpenAI ones
+	data, err := json.Marshal(converted)
 	if err != nil {
 		return nil
 	}
-	return payload.Messages
+	var messages []model.Message
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return nil
+	}
+	return messages
 }
 
 // extractVertexMessages extracts messages from Vertex AI format
 func extractVertexMessages(body []byte) []model.Message {
-	var payload struct {
-		Contents []struct {
-			Role  string `json:"role"`
-			Parts []struct {
-				Text string `json:"text"`
-			} `json:"parts"`
-		} `json:"contents"`
-	}
-	err := json.Unmarshal(body, &payload)
-	if err != nil {
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(body, &payload); err != nil {
 		return nil
 	}
+	return vertexMessages(payload)
+}
 
-	// Check if contents field exists in the JSON
-	var rawPayload map[string]interface{}
-	if err := json.Unmarshal(body, &rawPayload); err != nil {
+// vertexMessages extracts the messages of a Vertex AI body from its fields.
+func vertexMessages(payload map[string]json.RawMessage) []model.Message {
+	rawContents, exists := payload["contents"]
+	if !exists {
 		return nil
 	}
-	if _, exists := rawPayload["contents"]; !exists {
+
+	var contents []struct {
+		Role  string       `json:"role"`
+		Parts []vertexPart `json:"parts"`
+	}
+	if err := json.Unmarshal(rawContents, &contents); err != nil {
 		return nil
 	}
+	var systemInstruction *struct {
+		Parts []vertexPart `json:"parts"`
+	}
+	if raw, ok := payload["systemInstruction"]; ok {
+		if err := json.Unmarshal(raw, &systemInstruction); err != nil {
+			return nil
+		}
+	}
 
 	messages := make([]model.Message, 0)
-	for _, content := range payload.Contents {
-		if len(content.Parts) > 0 {
+	if systemInstruction != nil {
+		systemContent, err := openAIContent(systemInstruction.Parts)
+		if err != nil {
+			return nil
+		}
+		if systemContent != nil {
+			messages = append(messages, model.Message{
+				"role":    "system",
+				"content": systemContent,
+			})
+		}
+	}
+	for _, content := range contents {
+		messageContent, err := openAIContent(content.Parts)
+		if err != nil {
+			return nil
+		}
+		if messageContent != nil {
 			messages = append(messages, model.Message{
 				"role":    content.Role,
-				"content": content.Parts[0].Text,
+				"content": messageContent,
 			})
 		}
 	}
 	return messages
 }
 
-// TransformToVertexRequest transforms OpenAI format to Vertex AI format
+// TransformToVertexRequest transforms OpenAI format to Vertex AI format. Vertex AI
+// has no stream field, streamed requests call streamGenerateContent instead.
 func TransformToVertexRequest(body []byte, model string) ([]byte, error) {
-	var openAIPayload struct {
-		Messages    []map[string]string    `json:"messages"`
-		Temperature float64                `json:"temperature"`
-		MaxTokens   int                    `json:"max_tokens"`
-		TopP        float64                `json:"top_p"`
-		TopK        int                    `json:"top_k"`
-		Stream      bool                   `json:"stream"`
-		Stop        []string               `json:"stop"`
-		Extra       map[string]interface{} `json:"extra,omitempty"`
-	}
-
-	if err := json.Unmarshal(body, &openAIPayload); err != nil {
-		slog.Error("❌ Failed to unmarshal OpenAI payload",
-			"error", err,
-			"body", string(body))
-		return nil, fmt.Errorf("failed to unmarshal OpenAI payload: %v, body: %s", err, string(body))
-	}
-
-	// Convert OpenAI messages to Vertex AI format
-	var contents []map[string]interface{}
-	for _, msg := range openAIPayload.Messages {
-		role := msg["role"]
-		content := msg["content"]
-
-		if role == "assistant" {
-			role = "model" // Vertex AI uses "model" instead of "assistant"
-		} else if role == "system" {
-			role = "user" // Vertex AI doesn't support system role, treat as user
-		}
-
-		contents = append(contents, map[string]interface{}{
-			"role": role,
-			"parts": []map[string]interface{}{
-				{
-					"text": content,
-				},
-			},
-		})
-	}
-
-	// Build the request payload according to Vertex AI's format
-	type VertexPayload struct {
-		Model            string                   `json:"model"`
-		Contents         []map[string]interface{} `json:"contents"`
-		GenerationConfig map[string]interface{}   `json:"generationConfig"`
-		StopSequences    []string                 `json:"stopSequences,omitempty"`
-		Extra            map[string]interface{}   `json:"extra,omitempty"`
+	if IsEmbeddingsPayload(body) {
+		return vertexEmbeddingsRequest(body)
 	}
 
 	// Extract just the model name if it contains a provider prefix or region
@@ -260,51 +368,72 @@ func TransformToVertexRequest(body []byte, model string) ([]byte, error) {
 		slog.Info("⚠️ No model specified, defaulting to gemini-pro")
 	}
 
-	slog.Info("🔄 Transforming to Vertex format", "model", modelName)
+	// Bodies already in Vertex AI format, as Gemini API callers send them, are
+	// passed on with only their model changed
+	if isVertexContents(body) {
+		return vertexContentsRequest(body, modelName)
+	}
 
-	vertexPayload := VertexPayload{
-		Model:    modelName,
-		Contents: contents,
-		GenerationConfig: map[string]interface{}{
-			"temperature":     openAIPayload.Temperature,
-			"maxOutputTokens": openAIPayload.MaxTokens,
-			"topP":            openAIPayload.TopP,
-			"topK":            openAIPayload.TopK,
-		},
-		Extra: openAIPayload.Extra,
+	var openAIPayload struct {
+		Messages       []openAIMessage `json:"messages"`
+		Tools          []openAITool    `json:"tools"`
+		ToolChoice     json.RawMessage `json:"tool_choice"`
+		ResponseFormat json.RawMessage `json:"response_format"`
 	}
 
-	// Set defaults if values are not provided
-	if openAIPayload.Temperature == 0 {
-		vertexPayload.GenerationConfig["temperature"] = 0.7
+	if err := json.Unmarshal(body, &openAIPayload); err != nil {
+		slog.Error("❌ Failed to unmarshal OpenAI payload",
+			"error", err,
+			"body", string(body))
+		return nil, fmt.Errorf("failed to unmarshal OpenAI payload: %v, body: %s", err, string(body))
 	}
-	if openAIPayload.MaxTokens == 0 {
-		vertexPayload.GenerationConfig["maxOutputTokens"] = 1024
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(body, &params); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal OpenAI payload: %v", err)
 	}
-	if openAIPayload.TopP == 0 {
-		vertexPayload.GenerationConfig["topP"] = 0.95
+
+	contents, systemParts, err := vertexContents(openAIPayload.Messages)
+	if err != nil {
+		return nil, err
 	}
-	if openAIPayload.TopK == 0 {
-		vertexPayload.GenerationConfig["topK"] = 40
+
+	// Build the request payload according to Vertex AI's format
+	type VertexPayload struct {
+		Model             string                   `json:"model"`
+		Contents          []map[string]interface{} `json:"contents"`
+		SystemInstruction map[string]interface{}   `json:"systemInstruction,omitempty"`
+		GenerationConfig  map[string]interface{}   `json:"generationConfig,omitempty"`
+		Tools             []map[string]interface{} `json:"tools,omitempty"`
+		ToolConfig        map[string]interface{}   `json:"toolConfig,omitempty"`
 	}
 
-	if len(openAIPayload.Stop) > 0 {
-		vertexPayload.StopSequences = openAIPayload.Stop
+	slog.Info("🔄 Transforming to Vertex format", "model", modelName)
+
+	tools, err := vertexTools(openAIPayload.Tools)
+	if err != nil {
+		return nil, err
+	}
+	responseMimeType, responseSchema, err := vertexResponseFormat(openAIPayload.ResponseFormat)
+	if err != nil {
+		return nil, err
 	}
 
-	// Initialize Extra if nil
-	if vertexPayload.Extra == nil {
-		vertexPayload.Extra = make(map[string]interface{})
+	vertexPayload := VertexPayload{
+		Model:            modelName,
+		Contents:         contents,
+		GenerationConfig: vertexGenerationConfig(params),
+		Tools:            tools,
+		ToolConfig:       vertexToolConfig(openAIPayload.ToolChoice),
+	}
+	if len(systemParts) > 0 {
+		vertexPayload.SystemInstruction = map[string]interface{}{"parts": systemParts}
 	}
 
-	// Copy any extra parameters
-	for k, v := range openAIPayload.Extra {
-		// Skip fields we already handle
-		if k == "model" || k == "contents" || k == "generationConfig" || k == "stopSequences" {
-			continue
-		}
-		vertexPayload.Extra[k] = v
-		slog.Info("➕ Added extra parameter", "key", k, "value", v)
+	if responseMimeType != "" {
+		vertexPayload.GenerationConfig["responseMimeType"] = responseMimeType
+	}
+	if responseSchema != nil {
+		vertexPayload.GenerationConfig["responseSchema"] = responseSchema
 	}
 
 	result, err := json.Marshal(vertexPayload)
@@ -315,18 +444,125 @@ func TransformToVertexRequest(body []byte, model string) ([]byte, error) {
 		return nil, fmt.Errorf("failed to marshal Vertex payload: %v", err)
 	}
 
-	return result, nil
+	// Fields that are not OpenAI's are passed on as they are
+	return withFields(result, unknownFields(body, openAIRequestFields))
+}
+
+// isVertexContents reports whether the body is a request in Vertex AI format.
+func isVertexContents(body []byte) bool {
+	var payload struct {
+		Contents []json.RawMessage `json:"contents"`
+	}
+	return json.Unmarshal(body, &payload) == nil && len(payload.Contents) > 0
+}
+
+// vertexContentsRequest sets the model of a request in Vertex AI format and drops
+// its stream field, Vertex AI streams through the URL.
+func vertexContentsRequest(body []byte, modelName string) ([]byte, error) {
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal Vertex payload: %v", err)
+	}
+	if _, ok := payload["stream"]; ok {
+		delete(payload, "stream")
+		var err error
+		if body, err = json.Marshal(payload); err != nil {
+			return nil, fmt.Errorf("failed to marshal Vertex payload: %v", err)
+		}
+	}
+	return withModel(body, modelName)
+}
+
+// vertexContents transforms OpenAI messages into Vertex AI contents and the parts
+// of the system instruction.
+func vertexContents(messages []openAIMessage) ([]map[string]interface{}, []map[string]interface{}, error) {
+	var contents []map[string]interface{}
+	var systemParts []map[string]interface{}
+	toolNames := make(map[string]string)
+	for _, msg := range messages {
+		role := msg.Role
+
+		// System prompts go into the system instruction, Vertex AI has no system role
+		if role == "system" || role == "developer" {
+			parts, err := vertexContentParts(msg.Content)
+			if err != nil {
+				return nil, nil, err
+			}
+			systemParts = append(systemParts, parts...)
+			continue
+		}
+
+		// Tool results become function responses in a user turn
+		if role == "tool" {
+			name := toolNames[msg.ToolCallID]
+			if name == "" {
+				name = msg.Name
+			}
+			part := vertexFunctionResponse(name, anthropicText(msg.Content))
+			contents = appendVertexTurn(contents, "user", []map[string]interface{}{part})
+			continue
+		}
+
+		if role == "assistant" {
+			role = "model" // Vertex AI uses "model" instead of "assistant"
+		}
+
+		parts, err := vertexContentParts(msg.Content)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(parts) == 0 && len(msg.ToolCalls) == 0 {
+			parts = append(parts, map[string]interface{}{"text": ""})
+		}
+		for _, call := range msg.ToolCalls {
+			part, err := vertexFunctionCall(call)
+			if err != nil {
+				return nil, nil, err
+			}
+			toolNames[call.ID] = call.Function.Name
+			parts = append(parts, part)
+		}
+
+		contents = appendVertexTurn(contents, role, parts)
+	}
+	return contents, systemParts, nil
+}
+
+// appendVertexTurn appends a turn to Vertex AI contents, merging it into the last
+// turn if that has the same role. Vertex AI rejects consecutive turns of one role.
+func appendVertexTurn(contents []map[string]interface{}, role string, parts []map[string]interface{}) []map[string]interface{} {
+	if n := len(contents); n > 0 && contents[n-1]["role"] == role {
+		contents[n-1]["parts"] = append(contents[n-1]["parts"].([]map[string]interface{}), parts...)
+		return contents
+	}
+	return append(contents, map[string]interface{}{
+		"role":  role,
+		"parts": parts,
+	})
+}
+
+// vertexFinishReasons maps Vertex AI finish reasons to OpenAI finish reasons.
+var vertexFinishReasons = map[string]string{
+	"STOP":               "stop",
+	"MAX_TOKENS":         "length",
+	"SAFETY":             "content_filter",
+	"RECITATION":         "content_filter",
+	"BLOCKLIST":          "content_filter",
+	"PROHIBITED_CONTENT": "content_filter",
+	"SPII":               "content_filter",
 }
 
 // TransformFromVertexResponse transforms Vertex AI response to OpenAI format
 func TransformFromVertexResponse(body []byte) ([]byte, error) {
+	if isVertexEmbeddingsResponse(body) {
+		return openAIEmbeddingsFromVertex(body)
+	}
+
 	var vertexResponse struct {
 		Candidates []struct {
 			Content struct {
-				Parts []struct {
-					Text string `json:"text"`
-				} `json:"parts"`
-				Role string `json:"role"`
+				Parts []vertexPart `json:"parts"`
+				Role  string       `json:"role"`
 			} `json:"content"`
 			FinishReason  string `json:"finishReason"`
 			SafetyRatings []struct {
@@ -335,42 +571,202 @@ func TransformFromVertexResponse(body []byte) ([]byte, error) {
 			} `json:"safetyRatings"`
 		} `json:"candidates"`
 		UsageMetadata struct {
-			PromptTokenCount     int `json:"promptTokenCount"`
-			CandidatesTokenCount int `json:"candidatesTokenCount"`
-			TotalTokenCount      int `json:"totalTokenCount"`
+			PromptTokenCount        int `json:"promptTokenCount"`
+			CandidatesTokenCount    int `json:"candidatesTokenCount"`
+			TotalTokenCount         int `json:"totalTokenCount"`
+			CachedContentTokenCount int `json:"cachedContentTokenCount"`
+			ThoughtsTokenCount      int `json:"thoughtsTokenCount"`
 		} `json:"usageMetadata"`
+		ModelVersion string `json:"modelVersion"`
 	}
 
 	if err := json.Unmarshal(body, &vertexResponse); err != nil {
 		return nil, err
 	}
 
+	// OpenAI counts reasoning tokens as completion tokens, Vertex AI apart from them
+	usageMetadata := vertexResponse.UsageMetadata
+	usage := map[string]interface{}{
+		"prompt_tokens":     usageMetadata.PromptTokenCount,
+		"completion_tokens": usageMetadata.CandidatesTokenCount + usageMetadata.ThoughtsTokenCount,
+		"total_tokens":      usageMetadata.TotalTokenCount,
+	}
+	if usageMetadata.CachedContentTokenCount > 0 {
+		usage["prompt_tokens_details"] = map[string]interface{}{"cached_tokens": usageMetadata.CachedContentTokenCount}
+	}
+	if usageMetadata.ThoughtsTokenCount > 0 {
+		usage["completion_tokens_details"] = map[string]interface{}{"reasoning_tokens": usageMetadata.ThoughtsTokenCount}
+	}
+
 	openAIResponse := map[string]interface{}{
+		"object":  "chat.completion",
 		"choices": make([]map[string]interface{}, 0, len(vertexResponse.Candidates)),
-		"usage": map[string]interface{}{
-			"prompt_tokens":     vertexResponse.UsageMetadata.PromptTokenCount,
-			"completion_tokens": vertexResponse.UsageMetadata.CandidatesTokenCount,
-			"total_tokens":      vertexResponse.UsageMetadata.TotalTokenCount,
-		},
+		"usage":   usage,
+	}
+	if vertexResponse.ModelVersion != "" {
+		openAIResponse["model"] = vertexResponse.ModelVersion
 	}
 
 	for i, candidate := range vertexResponse.Candidates {
-		if len(candidate.Content.Parts) > 0 {
-			choice := map[string]interface{}{
-				"index": i,
-				"message": map[string]interface{}{
-					"role":    candidate.Content.Role,
-					"content": candidate.Content.Parts[0].Text,
-				},
-				"finish_reason": strings.ToLower(candidate.FinishReason),
+		finishReason, ok := vertexFinishReasons[candidate.FinishReason]
+		if !ok {
+			finishReason = strings.ToLower(candidate.FinishReason)
+		}
+
+		// Candidates blocked by safety filters have no parts but still answer the request
+		if len(candidate.Content.Parts) == 0 && finishReason != "content_filter" {
+			continue
+		}
+
+		message := map[string]interface{}{
+			"role": "assistant",
+		}
+		texts := make([]string, 0, len(candidate.Content.Parts))
+		var toolCalls []map[string]interface{}
+		for _, part := range candidate.Content.Parts {
+			if part.FunctionCall != nil {
+				id := openAIToolCallID(len(toolCalls))
+				toolCalls = append(toolCalls, openAIToolCallFromVertex(id, part.FunctionCall.Name, part.FunctionCall.Args))
+				continue
+			}
+			texts = append(texts, part.Text)
+		}
+
+		if len(toolCalls) > 0 {
+			message["tool_calls"] = toolCalls
+			message["content"] = nil
+			if len(texts) > 0 {
+				message["content"] = strings.Join(texts, "")
 			}
-			openAIResponse["choices"] = append(openAIResponse["choices"].([]map[string]interface{}), choice)
+			if finishReason == "stop" {
+				finishReason = "tool_calls"
+			}
+		} else {
+			message["content"] = strings.Join(texts, "")
+		}
+
+		choice := map[string]interface{}{
+			"index":         i,
+			"message":       message,
+			"finish_reason": finishReason,
 		}
+		openAIResponse["choices"] = append(openAIResponse["choices"].([]map[string]interface{}), choice)
 	}
 
 	return json.Marshal(openAIResponse)
 }
 
+// openAIResponse is the part of an OpenAI chat completion that is translated
+// into other providers' response formats.
+type openAIResponse struct {
+	ID      string `json:"id"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Index   int `json:"index"`
+		Message struct {
+			Role      string           `json:"role"`
+			Content   json.RawMessage  `json:"content"`
+			ToolCalls []openAIToolCall `json:"tool_calls"`
+		} `json:"message"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage struct {
+		PromptTokens        int `json:"prompt_tokens"`
+		CompletionTokens    int `json:"completion_tokens"`
+		TotalTokens         int `json:"total_tokens"`
+		PromptTokensDetails struct {
+			CachedTokens int `json:"cached_tokens"`
+		} `json:"prompt_tokens_details"`
+		CompletionTokensDetails struct {
+			ReasoningTokens int `json:"reasoning_tokens"`
+		} `json:"completion_tokens_details"`
+	} `json:"usage"`
+}
+
+// parseOpenAIResponse parses an OpenAI chat completion, filling in the total tokens if missing.
+func parseOpenAIResponse(body []byte) (*openAIResponse, error) {
+	var response openAIResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	if response.Usage.TotalTokens == 0 {
+		response.Usage.TotalTokens = response.Usage.PromptTokens + response.Usage.CompletionTokens
+	}
+	return &response, nil
+}
+
+// openAIToVertexFinishReasons maps OpenAI finish reasons to Vertex AI finish reasons.
+var openAIToVertexFinishReasons = map[string]string{
+	"stop":           "STOP",
+	"length":         "MAX_TOKENS",
+	"content_filter": "SAFETY",
+	"tool_calls":     "STOP",
+}
+
+// TransformToVertexResponse transforms OpenAI response to Vertex AI format
+func TransformToVertexResponse(body []byte) ([]byte, error) {
+	if isOpenAIEmbeddingsResponse(body) {
+		return vertexEmbeddingsFromOpenAI(body)
+	}
+
+	response, err := parseOpenAIResponse(body)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make([]map[string]interface{}, 0, len(response.Choices))
+	for _, choice := range response.Choices {
+		finishReason, ok := openAIToVertexFinishReasons[choice.FinishReason]
+		if !ok {
+			finishReason = strings.ToUpper(choice.FinishReason)
+		}
+
+		var parts []map[string]interface{}
+		if text := anthropicText(choice.Message.Content); text != "" || len(choice.Message.ToolCalls) == 0 {
+			parts = append(parts, map[string]interface{}{"text": text})
+		}
+		for _, call := range choice.Message.ToolCalls {
+			part, err := vertexFunctionCall(call)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, part)
+		}
+
+		candidates = append(candidates, map[string]interface{}{
+			"index": choice.Index,
+			"content": map[string]interface{}{
+				"role":  "model",
+				"parts": parts,
+			},
+			"finishReason": finishReason,
+		})
+	}
+
+	usage := response.Usage
+	usageMetadata := map[string]interface{}{
+		"promptTokenCount":     usage.PromptTokens,
+		"candidatesTokenCount": usage.CompletionTokens - usage.CompletionTokensDetails.ReasoningTokens,
+		"totalTokenCount":      usage.TotalTokens,
+	}
+	if usage.PromptTokensDetails.CachedTokens > 0 {
+		usageMetadata["cachedContentTokenCount"] = usage.PromptTokensDetails.CachedTokens
+	}
+	if usage.CompletionTokensDetails.ReasoningTokens > 0 {
+		usageMetadata["thoughtsTokenCount"] = usage.CompletionTokensDetails.ReasoningTokens
+	}
+
+	vertexResponse := map[string]interface{}{
+		"candidates":    candidates,
+		"usageMetadata": usageMetadata,
+	}
+	if response.Model != "" {
+		vertexResponse["modelVersion"] = response.Model
+	}
+
+	return json.Marshal(vertexResponse)
+}
+
 // TransformFromVertexToOpenAI transforms Vertex AI format to OpenAI format
 func TransformFromVertexToOpenAI(body []byte) ([]byte, error) {
 	if len(body) == 0 {
@@ -380,12 +776,15 @@ func TransformFromVertexToOpenAI(body []byte) ([]byte, error) {
 
 	var vertexPayload struct {
 		Contents []struct {
-			Role  string `json:"role"`
-			Parts []struct {
-				Text string `json:"text"`
-			} `json:"parts"`
+			Role  string       `json:"role"`
+			Parts []vertexPart `json:"parts"`
 		} `json:"contents"`
-		GenerationConfig map[string]interface{} `json:"generationConfig"`
+		SystemInstruction *struct {
+			Parts []vertexPart `json:"parts"`
+		} `json:"systemInstruction"`
+		GenerationConfig map[string]json.RawMessage `json:"generationConfig"`
+		Tools            []vertexTool               `json:"tools"`
+		ToolConfig       map[string]interface{}     `json:"toolConfig"`
 	}
 
 	if err := json.Unmarshal(body, &vertexPayload); err != nil {
@@ -395,19 +794,67 @@ func TransformFromVertexToOpenAI(body []byte) ([]byte, error) {
 		return nil, fmt.Errorf("failed to unmarshal Vertex payload: %v", err)
 	}
 
-	// Convert Vertex messages to OpenAI format
-	messages := make([]map[string]string, 0, len(vertexPayload.Contents))
+	// Convert Vertex messages to OpenAI format. Vertex AI has no tool call IDs, so
+	// function responses are matched to the earliest unanswered call of the function.
+	messages := make([]map[string]interface{}, 0, len(vertexPayload.Contents)+1)
+	if vertexPayload.SystemInstruction != nil {
+		systemContent, err := openAIContent(vertexPayload.SystemInstruction.Parts)
+		if err != nil {
+			return nil, err
+		}
+		if systemContent != nil {
+			messages = append(messages, map[string]interface{}{
+				"role":    "system",
+				"content": systemContent,
+			})
+		}
+	}
+	pendingCalls := make(map[string][]string)
+	calls := 0
 	for _, content := range vertexPayload.Contents {
 		role := content.Role
 		if role == "model" {
 			role = "assistant"
 		}
-		if len(content.Parts) > 0 {
-			message := map[string]string{
-				"role":    role,
-				"content": content.Parts[0].Text,
+
+		messageContent, err := openAIContent(content.Parts)
+		if err != nil {
+			return nil, err
+		}
+
+		var toolCalls []map[string]interface{}
+		for _, part := range content.Parts {
+			switch {
+			case part.FunctionCall != nil:
+				id := openAIToolCallID(calls)
+				calls++
+				pendingCalls[part.FunctionCall.Name] = append(pendingCalls[part.FunctionCall.Name], id)
+				toolCalls = append(toolCalls, openAIToolCallFromVertex(id, part.FunctionCall.Name, part.FunctionCall.Args))
+			case part.FunctionResponse != nil:
+				name := part.FunctionResponse.Name
+				id := name
+				if pending := pendingCalls[name]; len(pending) > 0 {
+					id, pendingCalls[name] = pending[0], pending[1:]
+				}
+				messages = append(messages, map[string]interface{}{
+					"role":         "tool",
+					"tool_call_id": id,
+					"content":      toolResultContent(part.FunctionResponse.Response),
+				})
 			}
-			messages = append(messages, message)
+		}
+
+		if len(toolCalls) > 0 {
+			messages = append(messages, map[string]interface{}{
+				"role":       role,
+				"content":    messageContent,
+				"tool_calls": toolCalls,
+			})
+		} else if messageContent != nil {
+			messages = append(messages, map[string]interface{}{
+				"role":    role,
+				"content": messageContent,
+			})
 		}
 	}
 
@@ -415,19 +862,299 @@ func TransformFromVertexToOpenAI(body []byte) ([]byte, error) {
 	openaiPayload := map[string]interface{}{
 		"messages": messages,
 	}
+	if tools := openAITools(vertexPayload.Tools); len(tools) > 0 {
+		openaiPayload["tools"] = tools
+	}
+	if toolChoice := openAIToolChoice(vertexPayload.ToolConfig); toolChoice != nil {
+		openaiPayload["tool_choice"] = toolChoice
+	}
 
 	// Map generation config to OpenAI parameters
-	if vertexPayload.GenerationConfig != nil {
-		if temp, ok := vertexPayload.GenerationConfig["temperature"].(float64); ok {
-			openaiPayload["temperature"] = temp
-		}
-		if maxTokens, ok := vertexPayload.GenerationConfig["maxOutputTokens"].(float64); ok {
-			openaiPayload["max_tokens"] = int(maxTokens)
-		}
-		if topP, ok := vertexPayload.GenerationConfig["topP"].(float64); ok {
-			openaiPayload["top_p"] = topP
+	for param, value := range openAIGenerationParams(vertexPayload.GenerationConfig) {
+		openaiPayload[param] = value
+	}
+	if responseFormat := openAIResponseFormat(vertexPayload.GenerationConfig); responseFormat != nil {
+		openaiPayload["response_format"] = responseFormat
+	}
+
+	result, err := json.Marshal(openaiPayload)
+	if err != nil {
+		slog.Error("❌ Failed to marshal OpenAI payload",
+			"error", err,
+			"payload", openaiPayload)
+		return nil, fmt.Errorf("failed to marshal OpenAI payload: %v", err)
+	}
+
+	// Fields that are not Vertex AI's are passed on as they are
+	return withFields(result, unknownFields(body, vertexRequestFields))
+}
+
+// IsAnthropicPayload reports whether the body uses Anthropic Messages API fields
+// that OpenAI does not understand: a top-level system prompt, stop_sequences, or
+// Anthropic content blocks, tools or tool choice.
+func IsAnthropicPayload(body []byte) bool {
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return false
+	}
+	return isAnthropicFields(payload)
+}
+
+// isAnthropicFields is IsAnthropicPayload on the top-level fields of a body.
+func isAnthropicFields(payload map[string]json.RawMessage) bool {
+	if _, ok := payload["messages"]; !ok {
+		return false
+	}
+	_, hasSystem := payload["system"]
+	_, hasStopSequences := payload["stop_sequences"]
+	return hasSystem || hasStopSequences || hasAnthropicFeatures(payload)
+}
+
+// anthropicText flattens an Anthropic content value, which is either a string
+// or a list of content blocks, into plain text.
+func anthropicText(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text
+	}
+
+	var blocks []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		return ""
+	}
+
+	parts := make([]string, 0, len(blocks))
+	for _, block := range blocks {
+		if block.Type == "text" {
+			parts = append(parts, block.Text)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// defaultAnthropicMaxTokens is the max_tokens of requests that don't set one, the
+// Messages API requires it.
+const defaultAnthropicMaxTokens = 1024
+
+// TransformToAnthropicRequest transforms OpenAI or Vertex AI format to Anthropic Messages API format.
+// Bodies already in Anthropic format are passed on unchanged, except for their model.
+func TransformToAnthropicRequest(body []byte, model string) ([]byte, error) {
+	if len(body) == 0 {
+		return nil, fmt.Errorf("empty body received")
+	}
+
+	// Extract just the model name if it contains a provider prefix or region
+	modelName := model
+	if strings.Contains(model, "/") {
+		parts := strings.Split(model, "/")
+		if parts[0] == "anthropic" && len(parts) > 1 {
+			modelName = parts[1]
+		} else {
+			modelName = parts[0]
+		}
+	}
+
+	if IsAnthropicPayload(body) {
+		return withModel(body, modelName)
+	}
+
+	// Vertex payloads are first normalised to OpenAI format
+	var vertexCheck struct {
+		Contents []json.RawMessage `json:"contents"`
+	}
+	if err := json.Unmarshal(body, &vertexCheck); err == nil && len(vertexCheck.Contents) > 0 {
+		transformed, err := TransformFromVertexToOpenAI(body)
+		if err != nil {
+			return nil, err
+		}
+		body = transformed
+	}
+
+	var openAIPayload struct {
+		Messages            []openAIMessage `json:"messages"`
+		System              json.RawMessage `json:"system"`
+		Temperature         *float64        `json:"temperature"`
+		MaxTokens           *int            `json:"max_tokens"`
+		MaxCompletionTokens *int            `json:"max_completion_tokens"`
+		TopP                *float64        `json:"top_p"`
+		TopK                *int            `json:"top_k"`
+		Stream              bool            `json:"stream"`
+		Stop                json.RawMessage `json:"stop"`
+		Tools               []openAITool    `json:"tools"`
+		ToolChoice          json.RawMessage `json:"tool_choice"`
+		ParallelToolCalls   *bool           `json:"parallel_tool_calls"`
+		User                string          `json:"user"`
+	}
+
+	if err := json.Unmarshal(body, &openAIPayload); err != nil {
+		slog.Error("❌ Failed to unmarshal OpenAI payload",
+			"error", err,
+			"body", string(body))
+		return nil, fmt.Errorf("failed to unmarshal OpenAI payload: %v, body: %s", err, string(body))
+	}
+
+	// Anthropic takes the system prompt as a top-level field
+	systemPrompts := make([]string, 0)
+	if text := anthropicText(openAIPayload.System); text != "" {
+		systemPrompts = append(systemPrompts, text)
+	}
+	for _, msg := range openAIPayload.Messages {
+		if msg.Role == "system" || msg.Role == "developer" {
+			systemPrompts = append(systemPrompts, anthropicText(msg.Content))
+		}
+	}
+
+	messages, err := anthropicMessagesFromOpenAI(openAIPayload.Messages)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("🔄 Transforming to Anthropic format", "model", modelName)
+
+	anthropicPayload := map[string]interface{}{
+		"model":    modelName,
+		"messages": messages,
+	}
+
+	// max_tokens is required by the Messages API
+	switch {
+	case openAIPayload.MaxCompletionTokens != nil:
+		anthropicPayload["max_tokens"] = *openAIPayload.MaxCompletionTokens
+	case openAIPayload.MaxTokens != nil:
+		anthropicPayload["max_tokens"] = *openAIPayload.MaxTokens
+	default:
+		anthropicPayload["max_tokens"] = defaultAnthropicMaxTokens
+	}
+
+	if len(systemPrompts) > 0 {
+		anthropicPayload["system"] = strings.Join(systemPrompts, "\n")
+	}
+	if openAIPayload.Temperature != nil {
+		anthropicPayload["temperature"] = *openAIPayload.Temperature
+	}
+	if openAIPayload.TopP != nil {
+		anthropicPayload["top_p"] = *openAIPayload.TopP
+	}
+	if openAIPayload.TopK != nil {
+		anthropicPayload["top_k"] = *openAIPayload.TopK
+	}
+	if openAIPayload.Stream {
+		anthropicPayload["stream"] = true
+	}
+	if openAIPayload.User != "" {
+		anthropicPayload["metadata"] = map[string]interface{}{"user_id": openAIPayload.User}
+	}
+	if tools := anthropicTools(openAIPayload.Tools); len(tools) > 0 {
+		anthropicPayload["tools"] = tools
+	}
+	disableParallel := openAIPayload.ParallelToolCalls != nil && !*openAIPayload.ParallelToolCalls
+	if toolChoice := anthropicToolChoice(openAIPayload.ToolChoice, disableParallel); toolChoice != nil {
+		anthropicPayload["tool_choice"] = toolChoice
+	}
+
+	// OpenAI accepts stop as a single string or a list
+	if len(openAIPayload.Stop) > 0 {
+		var stop []string
+		if err := json.Unmarshal(openAIPayload.Stop, &stop); err != nil {
+			var single string
+			if err := json.Unmarshal(openAIPayload.Stop, &single); err == nil && single != "" {
+				stop = []string{single}
+			}
+		}
+		if len(stop) > 0 {
+			anthropicPayload["stop_sequences"] = stop
+		}
+	}
+
+	result, err := json.Marshal(anthropicPayload)
+	if err != nil {
+		slog.Error("❌ Failed to marshal Anthropic payload",
+			"error", err,
+			"payload", anthropicPayload)
+		return nil, fmt.Errorf("failed to marshal Anthropic payload: %v", err)
+	}
+
+	return result, nil
+}
+
+// TransformFromAnthropicToOpenAI transforms Anthropic Messages API format to OpenAI format
+func TransformFromAnthropicToOpenAI(body []byte) ([]byte, error) {
+	if len(body) == 0 {
+		slog.Error("❌ Empty body received")
+		return nil, fmt.Errorf("empty body received")
+	}
+
+	var anthropicPayload struct {
+		System        json.RawMessage    `json:"system"`
+		Messages      []anthropicMessage `json:"messages"`
+		MaxTokens     *int               `json:"max_tokens"`
+		Temperature   *float64           `json:"temperature"`
+		TopP          *float64           `json:"top_p"`
+		TopK          *int               `json:"top_k"`
+		StopSequences []string           `json:"stop_sequences"`
+		Stream        bool               `json:"stream"`
+		Tools         json.RawMessage    `json:"tools"`
+		ToolChoice    json.RawMessage    `json:"tool_choice"`
+		Metadata      struct {
+			UserID string `json:"user_id"`
+		} `json:"metadata"`
+	}
+
+	if err := json.Unmarshal(body, &anthropicPayload); err != nil {
+		slog.Error("❌ Failed to unmarshal Anthropic payload",
+			"error", err,
+			"body", string(body))
+		return nil, fmt.Errorf("failed to unmarshal Anthropic payload: %v", err)
+	}
+
+	messages, err := openAIMessagesFromAnthropicPayload(anthropicPayload.System, anthropicPayload.Messages)
+	if err != nil {
+		return nil, err
+	}
+
+	openaiPayload := map[string]interface{}{
+		"messages": messages,
+	}
+	if anthropicPayload.MaxTokens != nil {
+		openaiPayload["max_tokens"] = *anthropicPayload.MaxTokens
+	}
+	if anthropicPayload.Temperature != nil {
+		openaiPayload["temperature"] = *anthropicPayload.Temperature
+	}
+	if anthropicPayload.TopP != nil {
+		openaiPayload["top_p"] = *anthropicPayload.TopP
+	}
+	if anthropicPayload.TopK != nil {
+		openaiPayload["top_k"] = *anthropicPayload.TopK
+	}
+	if len(anthropicPayload.StopSequences) > 0 {
+		openaiPayload["stop"] = anthropicPayload.StopSequences
+	}
+	if anthropicPayload.Stream {
+		openaiPayload["stream"] = true
+	}
+	if anthropicPayload.Metadata.UserID != "" {
+		openaiPayload["user"] = anthropicPayload.Metadata.UserID
+	}
+	if len(anthropicPayload.Tools) > 0 && string(anthropicPayload.Tools) != "null" {
+		tools, err := openAIToolsFromAnthropic(anthropicPayload.Tools)
+		if err != nil {
+			return nil, err
+		}
+		openaiPayload["tools"] = tools
+	}
+	if toolChoice, sequential := openAIToolChoiceFromAnthropic(anthropicPayload.ToolChoice); toolChoice != nil {
+		openaiPayload["tool_choice"] = toolChoice
+		if sequential {
+			openaiPayload["parallel_tool_calls"] = false
 		}
-		// Intentionally skip topK as it's not supported by OpenAI/Azure
 	}
 
 	result, err := json.Marshal(openaiPayload)
@@ -440,3 +1167,543 @@ func TransformFromVertexToOpenAI(body []byte) ([]byte, error) {
 
 	return result, nil
 }
+
+// anthropicFinishReasons maps Anthropic stop reasons to OpenAI finish reasons.
+var anthropicFinishReasons = map[string]string{
+	"end_turn":      "stop",
+	"stop_sequence": "stop",
+	"max_tokens":    "length",
+	"tool_use":      "tool_calls",
+}
+
+// TransformFromAnthropicResponse transforms Anthropic Messages API response to OpenAI format
+func TransformFromAnthropicResponse(body []byte) ([]byte, error) {
+	var anthropicResponse struct {
+		ID         string          `json:"id"`
+		Model      string          `json:"model"`
+		Role       string          `json:"role"`
+		Content    json.RawMessage `json:"content"`
+		StopReason string          `json:"stop_reason"`
+		Usage      struct {
+			InputTokens  int `json:"input_tokens"`
+			OutputTokens int `json:"output_tokens"`
+		} `json:"usage"`
+	}
+
+	if err := json.Unmarshal(body, &anthropicResponse); err != nil {
+		return nil, err
+	}
+
+	finishReason, ok := anthropicFinishReasons[anthropicResponse.StopReason]
+	if !ok {
+		finishReason = anthropicResponse.StopReason
+	}
+
+	role := anthropicResponse.Role
+	if role == "" {
+		role = "assistant"
+	}
+
+	message := map[string]interface{}{
+		"role":    role,
+		"content": anthropicText(anthropicResponse.Content),
+	}
+	var blocks []anthropicBlock
+	if err := json.Unmarshal(anthropicResponse.Content, &blocks); err == nil {
+		var toolCalls []map[string]interface{}
+		for _, block := range blocks {
+			if block.Type == "tool_use" {
+				toolCalls = append(toolCalls, openAIToolCallFromAnthropic(block))
+			}
+		}
+		if len(toolCalls) > 0 {
+			message["tool_calls"] = toolCalls
+			if message["content"] == "" {
+				message["content"] = nil
+			}
+		}
+	}
+
+	openAIResponse := map[string]interface{}{
+		"id":     anthropicResponse.ID,
+		"object": "chat.completion",
+		"model":  anthropicResponse.Model,
+		"choices": []map[string]interface{}{
+			{
+				"index":         0,
+				"message":       message,
+				"finish_reason": finishReason,
+			},
+		},
+		"usage": map[string]interface{}{
+			"prompt_tokens":     anthropicResponse.Usage.InputTokens,
+			"completion_tokens": anthropicResponse.Usage.OutputTokens,
+			"total_tokens":      anthropicResponse.Usage.InputTokens + anthropicResponse.Usage.OutputTokens,
+		},
+	}
+
+	return json.Marshal(openAIResponse)
+}
+
+// openAIToAnthropicFinishReasons maps OpenAI finish reasons to Anthropic stop reasons.
+var openAIToAnthropicFinishReasons = map[string]string{
+	"stop":           "end_turn",
+	"length":         "max_tokens",
+	"tool_calls":     "tool_use",
+	"content_filter": "end_turn",
+}
+
+// TransformToAnthropicResponse transforms OpenAI response to Anthropic Messages API format.
+// Only the first choice is kept, Anthropic returns a single message.
+func TransformToAnthropicResponse(body []byte) ([]byte, error) {
+	response, err := parseOpenAIResponse(body)
+	if err != nil {
+		return nil, err
+	}
+
+	content := []map[string]interface{}{}
+	var stopReason interface{}
+	if len(response.Choices) > 0 {
+		choice := response.Choices[0]
+		if text := anthropicText(choice.Message.Content); text != "" {
+			content = append(content, map[string]interface{}{"type": "text", "text": text})
+		}
+		for _, call := range choice.Message.ToolCalls {
+			block, err := anthropicToolUse(call)
+			if err != nil {
+				return nil, err
+			}
+			content = append(content, block)
+		}
+		if reason, ok := openAIToAnthropicFinishReasons[choice.FinishReason]; ok {
+			stopReason = reason
+		} else if choice.FinishReason != "" {
+			stopReason = choice.FinishReason
+		}
+	}
+
+	anthropicResponse := map[string]interface{}{
+		"id":            response.ID,
+		"type":          "message",
+		"role":          "assistant",
+		"model":         response.Model,
+		"content":       content,
+		"stop_reason":   stopReason,
+		"stop_sequence": nil,
+		"usage": map[string]interface{}{
+			"input_tokens":  response.Usage.PromptTokens,
+			"output_tokens": response.Usage.CompletionTokens,
+		},
+	}
+
+	return json.Marshal(anthropicResponse)
+}
+
+// TransformToBedrockRequest transforms OpenAI, Vertex AI or Anthropic format to the
+// Bedrock Converse API format. The model is not part of the body, Bedrock takes it from the URL.
+func TransformToBedrockRequest(body []byte) ([]byte, error) {
+	if len(body) == 0 {
+		return nil, fmt.Errorf("empty body received")
+	}
+
+	// Vertex and Anthropic payloads are first normalised to OpenAI format
+	body, err := TransformToOpenAIRequest(body, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var openAIPayload struct {
+		Messages []struct {
+			Role    string          `json:"role"`
+			Content json.RawMessage `json:"content"`
+		} `json:"messages"`
+		Temperature         *float64        `json:"temperature"`
+		MaxTokens           *int            `json:"max_tokens"`
+		MaxCompletionTokens *int            `json:"max_completion_tokens"`
+		TopP                *float64        `json:"top_p"`
+		Stop                json.RawMessage `json:"stop"`
+	}
+
+	if err := json.Unmarshal(body, &openAIPayload); err != nil {
+		slog.Error("❌ Failed to unmarshal OpenAI payload",
+			"error", err,
+			"body", string(body))
+		return nil, fmt.Errorf("failed to unmarshal OpenAI payload: %v, body: %s", err, string(body))
+	}
+
+	// Converse takes system prompts as a top-level list and expects alternating
+	// user/assistant turns, so adjacent messages with the same role are merged
+	system := make([]map[string]interface{}, 0)
+	messages := make([]map[string]interface{}, 0, len(openAIPayload.Messages))
+	for _, msg := range openAIPayload.Messages {
+		block := map[string]interface{}{"text": anthropicText(msg.Content)}
+		if msg.Role == "system" {
+			system = append(system, block)
+			continue
+		}
+
+		if n := len(messages); n > 0 && messages[n-1]["role"] == msg.Role {
+			messages[n-1]["content"] = append(messages[n-1]["content"].([]map[string]interface{}), block)
+			continue
+		}
+		messages = append(messages, map[string]interface{}{
+			"role":    msg.Role,
+			"content": []map[string]interface{}{block},
+		})
+	}
+
+	bedrockPayload := map[string]interface{}{
+		"messages": messages,
+	}
+	if len(system) > 0 {
+		bedrockPayload["system"] = system
+	}
+
+	inferenceConfig := map[string]interface{}{}
+	switch {
+	case openAIPayload.MaxCompletionTokens != nil:
+		inferenceConfig["maxTokens"] = *openAIPayload.MaxCompletionTokens
+	case openAIPayload.MaxTokens != nil:
+		inferenceConfig["maxTokens"] = *openAIPayload.MaxTokens
+	}
+	if openAIPayload.Temperature != nil {
+		inferenceConfig["temperature"] = *openAIPayload.Temperature
+	}
+	if openAIPayload.TopP != nil {
+		inferenceConfig["topP"] = *openAIPayload.TopP
+	}
+
+	// OpenAI accepts stop as a single string or a list
+	if len(openAIPayload.Stop) > 0 {
+		var stop []string
+		if err := json.Unmarshal(openAIPayload.Stop, &stop); err != nil {
+			var single string
+			if err := json.Unmarshal(openAIPayload.Stop, &single); err == nil && single != "" {
+				stop = []string{single}
+			}
+		}
+		if len(stop) > 0 {
+			inferenceConfig["stopSequences"] = stop
+		}
+	}
+	if len(inferenceConfig) > 0 {
+		bedrockPayload["inferenceConfig"] = inferenceConfig
+	}
+
+	result, err := json.Marshal(bedrockPayload)
+	if err != nil {
+		slog.Error("❌ Failed to marshal Bedrock payload",
+			"error", err,
+			"payload", bedrockPayload)
+		return nil, fmt.Errorf("failed to marshal Bedrock payload: %v", err)
+	}
+
+	return result, nil
+}
+
+// bedrockFinishReasons maps Bedrock Converse stop reasons to OpenAI finish reasons.
+var bedrockFinishReasons = map[string]string{
+	"end_turn":             "stop",
+	"stop_sequence":        "stop",
+	"max_tokens":           "length",
+	"tool_use":             "tool_calls",
+	"guardrail_intervened": "content_filter",
+	"content_filtered":     "content_filter",
+}
+
+// TransformFromBedrockResponse transforms Bedrock Converse API response to OpenAI format
+func TransformFromBedrockResponse(body []byte) ([]byte, error) {
+	var bedrockResponse struct {
+		Output struct {
+			Message struct {
+				Role    string `json:"role"`
+				Content []struct {
+					Text string `json:"text"`
+				} `json:"content"`
+			} `json:"message"`
+		} `json:"output"`
+		StopReason string `json:"stopReason"`
+		Usage      struct {
+			InputTokens  int `json:"inputTokens"`
+			OutputTokens int `json:"outputTokens"`
+			TotalTokens  int `json:"totalTokens"`
+		} `json:"usage"`
+	}
+
+	if err := json.Unmarshal(body, &bedrockResponse); err != nil {
+		return nil, err
+	}
+
+	finishReason, ok := bedrockFinishReasons[bedrockResponse.StopReason]
+	if !ok {
+		finishReason = bedrockResponse.StopReason
+	}
+
+	role := bedrockResponse.Output.Message.Role
+	if role == "" {
+		role = "assistant"
+	}
+
+	texts := make([]string, 0, len(bedrockResponse.Output.Message.Content))
+	for _, block := range bedrockResponse.Output.Message.Content {
+		if block.Text != "" {
+			texts = append(texts, block.Text)
+		}
+	}
+
+	totalTokens := bedrockResponse.Usage.TotalTokens
+	if totalTokens == 0 {
+		totalTokens = bedrockResponse.Usage.InputTokens + bedrockResponse.Usage.OutputTokens
+	}
+
+	openAIResponse := map[string]interface{}{
+		"object": "chat.completion",
+		"choices": []map[string]interface{}{
+			{
+				"index": 0,
+				"message": map[string]interface{}{
+					"role":    role,
+					"content": strings.Join(texts, "\n"),
+				},
+				"finish_reason": finishReason,
+			},
+		},
+		"usage": map[string]interface{}{
+			"prompt_tokens":     bedrockResponse.Usage.InputTokens,
+			"completion_tokens": bedrockResponse.Usage.OutputTokens,
+			"total_tokens":      totalTokens,
+		},
+	}
+
+	return json.Marshal(openAIResponse)
+}
+
+// openAIToBedrockFinishReasons maps OpenAI finish reasons to Bedrock stop reasons.
+var openAIToBedrockFinishReasons = map[string]string{
+	"stop":           "end_turn",
+	"length":         "max_tokens",
+	"tool_calls":     "tool_use",
+	"content_filter": "content_filtered",
+}
+
+// TransformToBedrockResponse transforms OpenAI response to Bedrock Converse API format.
+// Only the first choice is kept, Bedrock returns a single message.
+func TransformToBedrockResponse(body []byte) ([]byte, error) {
+	response, err := parseOpenAIResponse(body)
+	if err != nil {
+		return nil, err
+	}
+
+	content := []map[string]interface{}{}
+	stopReason := ""
+	if len(response.Choices) > 0 {
+		choice := response.Choices[0]
+		if text := anthropicText(choice.Message.Content); text != "" {
+			content = append(content, map[string]interface{}{"text": text})
+		}
+		var ok bool
+		if stopReason, ok = openAIToBedrockFinishReasons[choice.FinishReason]; !ok {
+			stopReason = choice.FinishReason
+		}
+	}
+
+	bedrockResponse := map[string]interface{}{
+		"output": map[string]interface{}{
+			"message": map[string]interface{}{
+				"role":    "assistant",
+				"content": content,
+			},
+		},
+		"stopReason": stopReason,
+		"usage": map[string]interface{}{
+			"inputTokens":  response.Usage.PromptTokens,
+			"outputTokens": response.Usage.CompletionTokens,
+			"totalTokens":  response.Usage.TotalTokens,
+		},
+	}
+
+	return json.Marshal(bedrockResponse)
+}
+
+// IsStreamingRequest reports whether the request asks for a streamed (SSE) response,
+// through the stream field of its body or by calling Vertex AI's streamGenerateContent.
+func IsStreamingRequest(req *http.Request, body []byte) bool {
+	if req != nil && strings.HasSuffix(req.URL.Path, ":streamGenerateContent") {
+		return true
+	}
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return false
+	}
+	return isStreamingFields(payload)
+}
+
+// isStreamingFields reports whether the top-level fields of a body ask for a
+// streamed response.
+func isStreamingFields(payload map[string]json.RawMessage) bool {
+	var stream bool
+	return json.Unmarshal(payload["stream"], &stream) == nil && stream
+}
+
+// WithStream sets the stream field of OpenAI and Anthropic bodies, which is lost
+// when the request comes from a Vertex AI caller. Bodies that already stream are
+// returned unchanged, as are other bodies, whose providers stream from a different
+// endpoint.
+func WithStream(body []byte) ([]byte, error) {
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+	if _, ok := payload["messages"]; !ok || string(payload["stream"]) == "true" {
+		return body, nil
+	}
+	payload["stream"] = json.RawMessage("true")
+	return json.Marshal(payload)
+}
+
+// WithMessages replaces the messages of an OpenAI or Anthropic body, leaving its
+// other fields unchanged.
+func WithMessages(body []byte, messages []model.Message) ([]byte, error) {
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal body: %w", err)
+	}
+
+	rawMessages, err := json.Marshal(messages)
+	if err != nil {
+		return nil, err
+	}
+	payload["messages"] = rawMessages
+	return json.Marshal(payload)
+}
+
+// PrependVertexMessages puts OpenAI messages before the contents of a Vertex AI
+// body. The body's own contents and other fields are left unchanged, except that
+// a system message replaces its systemInstruction.
+func PrependVertexMessages(body []byte, messages []model.Message) ([]byte, error) {
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal body: %w", err)
+	}
+
+	var callerContents []struct {
+		Role  string                   `json:"role"`
+		Parts []map[string]interface{} `json:"parts"`
+	}
+	if err := json.Unmarshal(payload["contents"], &callerContents); err != nil {
+		return nil, fmt.Errorf("invalid contents: %w", err)
+	}
+
+	rawMessages, err := json.Marshal(messages)
+	if err != nil {
+		return nil, err
+	}
+	var openAIMessages []openAIMessage
+	if err := json.Unmarshal(rawMessages, &openAIMessages); err != nil {
+		return nil, fmt.Errorf("invalid messages: %w", err)
+	}
+	contents, systemParts, err := vertexContents(openAIMessages)
+	if err != nil {
+		return nil, err
+	}
+	for _, content := range callerContents {
+		contents = appendVertexTurn(contents, content.Role, content.Parts)
+	}
+
+	if payload["contents"], err = json.Marshal(contents); err != nil {
+		return nil, err
+	}
+	if len(systemParts) > 0 {
+		if payload["systemInstruction"], err = json.Marshal(map[string]interface{}{"parts": systemParts}); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(payload)
+}
+
+// TransformFromVertexStreamEvent transforms a streamed Vertex AI response into an
+// OpenAI chat.completion.chunk. Tool calls are numbered within the event, streams
+// are translated with a VertexStreamDecoder to number them across all events.
+func TransformFromVertexStreamEvent(data []byte) ([]byte, error) {
+	return NewVertexStreamDecoder().Decode(data)
+}
+
+// TransformToVertexStreamEvent transforms an OpenAI chat.completion.chunk into a
+// streamed Vertex AI response. Tool calls are only sent once their choice finishes
+// within the chunk, streams are translated with a VertexStreamEncoder to collect
+// their arguments across chunks.
+func TransformToVertexStreamEvent(data []byte) ([]byte, error) {
+	return NewVertexStreamEncoder().Encode(data)
+}
+
+// ExtractAPIKey extracts the API key from whichever auth header the request carries.
+func ExtractAPIKey(req *http.Request) string {
+	authHeader := req.Header.Get("Authorization")
+	if strings.HasPrefix(authHeader, "Bearer ") {
+		return strings.TrimPrefix(authHeader, "Bearer ")
+	}
+	if apiKey := req.Header.Get("api-key"); apiKey != "" {
+		return apiKey
+	}
+	return req.Header.Get("x-api-key")
+}
+
+// TransformToOpenAIRequest transforms OpenAI, Vertex AI or Anthropic format to OpenAI format.
+// The model field is set to model, or removed when model is empty (Azure takes it from the URL).
+func TransformToOpenAIRequest(body []byte, model string) ([]byte, error) {
+	var payload map[string]interface{}
+
+	// Determine if the original payload is from Vertex AI by examining its structure
+	var vertexCheck struct {
+		Contents []struct {
+			Role  string `json:"role"`
+			Parts []struct {
+				Text string `json:"text"`
+			} `json:"parts"`
+		} `json:"contents"`
+	}
+
+	isVertex := false
+	if err := json.Unmarshal(body, &vertexCheck); err == nil {
+		if len(vertexCheck.Contents) > 0 {
+			isVertex = true
+		}
+	}
+
+	// If coming from Vertex or Anthropic, transform to OpenAI format
+	if isVertexEmbeddingsRequest(body) {
+		transformed, err := openAIEmbeddingsRequest(body)
+		if err != nil {
+			return nil, err
+		}
+		payload = transformed
+	} else if isVertex {
+		transformed, err := TransformFromVertexToOpenAI(body)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(transformed, &payload); err != nil {
+			return nil, err
+		}
+	} else if IsAnthropicPayload(body) {
+		transformed, err := TransformFromAnthropicToOpenAI(body)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(transformed, &payload); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return nil, err
+		}
+	}
+
+	if model != "" {
+		payload["model"] = model
+	} else {
+		delete(payload, "model")
+	}
+
+	return json.Marshal(payload)
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/Not-Diamond/go-notdiamond/pkg/model"
@@ -29,6 +30,20 @@ func TestExtractMessagesFromRequest(t *testing.T) {
 				{"role": "assistant", "content": "Hi there"},
 			},
 		},
+		{
+			name: "anthropic messages",
+			payload: []byte(`{
+				"system": "Be brief",
+				"max_tokens": 100,
+				"messages": [
+					{"role": "user", "content": [{"type": "text", "text": "Hello"}]}
+				]
+			}`),
+			expected: []model.Message{
+				{"role": "system", "content": "Be brief"},
+				{"role": "user", "content": "Hello"},
+			},
+		},
 		{
 			name:     "empty messages array",
 			payload:  []byte(`{"messages": []}`),
@@ -71,6 +86,28 @@ func TestExtractMessagesFromRequest(t *testing.T) {
 	}
 }
 
+// hostProvider is a minimal model.Provider matching a host substring.
+type hostProvider struct {
+	model.Provider
+	host     string
+	regional bool
+}
+
+func (p hostProvider) MatchesHost(host string) bool {
+	return strings.Contains(host, p.host)
+}
+
+func (p hostProvider) Regional() bool {
+	return p.regional
+}
+
+var testProviders = model.Providers{
+	"openai":    hostProvider{host: "openai.com"},
+	"azure":     hostProvider{host: "azure", regional: true},
+	"vertex":    hostProvider{host: "aiplatform.googleapis.com", regional: true},
+	"anthropic": hostProvider{host: "anthropic.com"},
+}
+
 func TestExtractProviderFromRequest(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -87,6 +124,11 @@ func TestExtractProviderFromRequest(t *testing.T) {
 			url:      "https://myresource.azure.openai.com/openai/deployments/gpt-4/chat/completions",
 			expected: "azure",
 		},
+		{
+			name:     "Anthropic URL",
+			url:      "https://api.anthropic.com/v1/messages",
+			expected: "anthropic",
+		},
 		{
 			name:     "Invalid URL",
 			url:      "https://api.example.com/v1/chat/completions",
@@ -106,7 +148,7 @@ func TestExtractProviderFromRequest(t *testing.T) {
 				t.Fatalf("Failed to create request: %v", err)
 			}
 
-			got := ExtractProviderFromRequest(req)
+			got := ExtractProviderFromRequest(req, testProviders)
 			if got != tt.expected {
 				t.Errorf("ExtractProviderFromRequest() = %v, want %v", got, tt.expected)
 			}
@@ -114,6 +156,131 @@ func TestExtractProviderFromRequest(t *testing.T) {
 	}
 }
 
+func TestExtractProviderFromClients(t *testing.T) {
+	newClient := func(rawURL string, provider string) http.Request {
+		req, err := http.NewRequest("POST", rawURL, nil)
+		if err != nil {
+			t.Fatalf("Failed to create client request: %v", err)
+		}
+		if provider != "" {
+			req = model.WithProvider(req, provider)
+		}
+		return *req
+	}
+
+	clients := []http.Request{
+		newClient("https://api.openai.com/v1/chat/completions", ""),
+		newClient("https://llm-gateway.example.com/openai/deployments/gpt-4/chat/completions", "azure"),
+		newClient("https://api.example.com/v1/chat/completions", ""),
+		newClient("https://shared-gateway.example.com/openai/v1/chat/completions", ""),
+		newClient("https://shared-gateway.example.com/openai/v1/chat/completions", "openai"),
+		newClient("https://shared-gateway.example.com/anthropic/v1/messages", "anthropic"),
+	}
+
+	tests := []struct {
+		name        string
+		url         string
+		tag         string
+		expected    string
+		errContains string
+	}{
+		{
+			name:     "untagged client matched by provider host",
+			url:      "https://api.openai.com/v1/chat/completions",
+			expected: "openai",
+		},
+		{
+			name:     "tagged client on custom domain",
+			url:      "https://llm-gateway.example.com/openai/deployments/gpt-4/chat/completions",
+			expected: "azure",
+		},
+		{
+			name:        "host containing a provider name but no configured client",
+			url:         "https://azure-status.example.com/v1/chat/completions",
+			errContains: "no configured client matches host azure-status.example.com",
+		},
+		{
+			name:     "anthropic caller without anthropic client",
+			url:      "https://api.anthropic.com/v1/messages",
+			expected: "anthropic",
+		},
+		{
+			name:        "anthropic path on a host without client",
+			url:         "https://gateway.example.com/v1/messages",
+			errContains: "no configured client matches host gateway.example.com",
+		},
+		{
+			name:     "gateway host serving several providers, matched on path",
+			url:      "https://shared-gateway.example.com/anthropic/v1/messages",
+			expected: "anthropic",
+		},
+		{
+			name:     "gateway host serving several providers, first tagged client",
+			url:      "https://shared-gateway.example.com/v1/other",
+			expected: "openai",
+		},
+		{
+			name:     "gateway host serving several providers, tagged request",
+			url:      "https://shared-gateway.example.com/openai/v1/chat/completions",
+			tag:      "anthropic",
+			expected: "anthropic",
+		},
+		{
+			name:        "untagged client on unknown host",
+			url:         "https://api.example.com/v1/chat/completions",
+			errContains: "no provider recognises host api.example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", tt.url, nil)
+			if err != nil {
+				t.Fatalf("Failed to create request: %v", err)
+			}
+			if tt.tag != "" {
+				req = model.WithProvider(req, tt.tag)
+			}
+
+			got, err := ExtractProviderFromClients(req, clients, testProviders)
+			if tt.errContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+					t.Errorf("ExtractProviderFromClients() error = %v, want error containing %q", err, tt.errContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ExtractProviderFromClients() unexpected error = %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("ExtractProviderFromClients() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestExtractProviderFromModel(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{name: "provider and model", body: `{"model": "openai/gpt-4o"}`, expected: "openai"},
+		{name: "provider, model and region", body: `{"model": "vertex/gemini-pro/us-east4"}`, expected: "vertex"},
+		{name: "model and region", body: `{"model": "gpt-4o/eastus"}`, expected: ""},
+		{name: "model only", body: `{"model": "claude-3-haiku"}`, expected: ""},
+		{name: "invalid json", body: `{invalid json}`, expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExtractProviderFromModel([]byte(tt.body), testProviders); got != tt.expected {
+				t.Errorf("ExtractProviderFromModel() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
 func TestExtractModelFromRequest(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -127,6 +294,24 @@ func TestExtractModelFromRequest(t *testing.T) {
 			expected: "gpt-4",
 			wantErr:  false,
 		},
+		{
+			name:     "built-in provider prefix",
+			payload:  []byte(`{"model": "openai/gpt-4"}`),
+			expected: "gpt-4",
+			wantErr:  false,
+		},
+		{
+			name:     "registered provider prefix",
+			payload:  []byte(`{"model": "local/llama3"}`),
+			expected: "llama3",
+			wantErr:  false,
+		},
+		{
+			name:     "model with region",
+			payload:  []byte(`{"model": "gpt-4/eastus"}`),
+			expected: "gpt-4/eastus",
+			wantErr:  false,
+		},
 		{
 			name:     "missing model field",
 			payload:  []byte(`{"other": "field"}`),
@@ -160,7 +345,7 @@ func TestExtractModelFromRequest(t *testing.T) {
 				t.Fatalf("Failed to create request: %v", err)
 			}
 
-			got, err := ExtractModelFromRequest(req)
+			got, err := ExtractModelFromRequest(req, model.Providers{"openai": nil, "local": nil})
 			if (err != nil) != tt.wantErr {
 				t.Errorf("ExtractModelFromRequest() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -349,7 +534,7 @@ func TestExtractVertexMessages(t *testing.T) {
 			expected: nil,
 		},
 		{
-			name: "multiple parts (should take first)",
+			name: "multiple text parts are joined",
 			payload: []byte(`{
 				"contents": [
 					{
@@ -366,7 +551,42 @@ func TestExtractVertexMessages(t *testing.T) {
 				]
 			}`),
 			expected: []model.Message{
-				{"role": "user", "content": "First message"},
+				{"role": "user", "content": "First messageSecond message"},
+			},
+		},
+		{
+			name: "system instruction",
+			payload: []byte(`{
+				"systemInstruction": {"parts": [{"text": "Be brief"}]},
+				"contents": [
+					{"role": "user", "parts": [{"text": "Hello"}]}
+				]
+			}`),
+			expected: []model.Message{
+				{"role": "system", "content": "Be brief"},
+				{"role": "user", "content": "Hello"},
+			},
+		},
+		{
+			name: "image parts",
+			payload: []byte(`{
+				"contents": [
+					{
+						"role": "user",
+						"parts": [
+							{"text": "What is this?"},
+							{"inlineData": {"mimeType": "image/png", "data": "iVBORw0KGgo="}},
+							{"fileData": {"mimeType": "image/jpeg", "fileUri": "https://example.com/cat.jpg"}}
+						]
+					}
+				]
+			}`),
+			expected: []model.Message{
+				{"role": "user", "content": []model.ContentPart{
+					model.TextPart("What is this?"),
+					model.ImageURLPart("data:image/png;base64,iVBORw0KGgo="),
+					model.ImageURLPart("https://example.com/cat.jpg"),
+				}},
 			},
 		},
 		{
@@ -440,22 +660,33 @@ func TestTransformToVertexRequest(t *testing.T) {
 			model: "chat-bison",
 			expected: `{
 				"model": "chat-bison",
+				"systemInstruction": {
+					"parts": [{"text": "You are helpful"}]
+				},
 				"contents": [
-					{
-						"role": "user",
-						"parts": [{"text": "You are helpful"}]
-					},
 					{
 						"role": "user",
 						"parts": [{"text": "Hello"}]
 					}
-				],
-				"generationConfig": {
-					"temperature": 0.7,
-					"maxOutputTokens": 1024,
-					"topP": 0.95,
-					"topK": 40
-				}
+				]
+			}`,
+			expectError: false,
+		},
+		{
+			name: "already in Vertex format",
+			payload: []byte(`{
+				"model": "gemini-1.5-flash",
+				"contents": [{"role": "user", "parts": [{"text": "Hello"}]}],
+				"generationConfig": {"temperature": 0.5},
+				"safetySettings": [{"category": "HARM_CATEGORY_HARASSMENT", "threshold": "BLOCK_NONE"}],
+				"stream": true
+			}`),
+			model: "vertex/gemini-pro",
+			expected: `{
+				"model": "gemini-pro",
+				"contents": [{"role": "user", "parts": [{"text": "Hello"}]}],
+				"generationConfig": {"temperature": 0.5},
+				"safetySettings": [{"category": "HARM_CATEGORY_HARASSMENT", "threshold": "BLOCK_NONE"}]
 			}`,
 			expectError: false,
 		},
@@ -475,12 +706,39 @@ func TestTransformToVertexRequest(t *testing.T) {
 					}
 				],
 				"generationConfig": {
-					"temperature": 0.7,
-					"maxOutputTokens": 1024,
-					"topP": 0.95,
-					"topK": 40
-				},
-				"stopSequences": ["END", "STOP"]
+					"stopSequences": ["END", "STOP"]
+				}
+			}`,
+			expectError: false,
+		},
+		{
+			name: "generation parameters",
+			payload: []byte(`{
+				"messages": [{"role": "user", "content": "Hello"}],
+				"max_tokens": 100,
+				"max_completion_tokens": 200,
+				"n": 2,
+				"presence_penalty": 0.5,
+				"frequency_penalty": -0.5,
+				"seed": 9007199254740993,
+				"stop": "END",
+				"logprobs": true,
+				"top_logprobs": 3
+			}`),
+			model: "gemini-pro",
+			expected: `{
+				"model": "gemini-pro",
+				"contents": [{"role": "user", "parts": [{"text": "Hello"}]}],
+				"generationConfig": {
+					"maxOutputTokens": 200,
+					"candidateCount": 2,
+					"presencePenalty": 0.5,
+					"frequencyPenalty": -0.5,
+					"seed": 9007199254740993,
+					"stopSequences": ["END"],
+					"responseLogprobs": true,
+					"logprobs": 3
+				}
 			}`,
 			expectError: false,
 		},
@@ -501,18 +759,203 @@ func TestTransformToVertexRequest(t *testing.T) {
 						"parts": [{"text": "Hello"}]
 					}
 				],
-				"generationConfig": {
-					"temperature": 0.7,
-					"maxOutputTokens": 1024,
-					"topP": 0.95,
-					"topK": 40
-				},
 				"extra": {
 					"custom_param": "value"
 				}
 			}`,
 			expectError: false,
 		},
+		{
+			name: "fields unknown to OpenAI are passed on",
+			payload: []byte(`{
+				"messages": [{"role": "user", "content": "Hello"}],
+				"stream": true,
+				"user": "user-1",
+				"safetySettings": [{"category": "HARM_CATEGORY_HARASSMENT", "threshold": "BLOCK_NONE"}],
+				"labels": {"team": "search"}
+			}`),
+			model: "gemini-pro",
+			expected: `{
+				"model": "gemini-pro",
+				"contents": [{"role": "user", "parts": [{"text": "Hello"}]}],
+				"safetySettings": [{"category": "HARM_CATEGORY_HARASSMENT", "threshold": "BLOCK_NONE"}],
+				"labels": {"team": "search"}
+			}`,
+			expectError: false,
+		},
+		{
+			name: "adjacent turns of one role are merged",
+			payload: []byte(`{
+				"messages": [
+					{"role": "system", "content": "You are helpful"},
+					{"role": "developer", "content": "Answer in French"},
+					{"role": "user", "content": "Hello"},
+					{"role": "user", "content": "Are you there?"},
+					{"role": "assistant", "content": "Bonjour"}
+				]
+			}`),
+			model: "gemini-pro",
+			expected: `{
+				"model": "gemini-pro",
+				"systemInstruction": {
+					"parts": [{"text": "You are helpful"}, {"text": "Answer in French"}]
+				},
+				"contents": [
+					{"role": "user", "parts": [{"text": "Hello"}, {"text": "Are you there?"}]},
+					{"role": "model", "parts": [{"text": "Bonjour"}]}
+				]
+			}`,
+			expectError: false,
+		},
+		{
+			name: "tool calling conversation",
+			payload: []byte(`{
+				"messages": [
+					{"role": "user", "content": "Weather in Paris and Rome?"},
+					{"role": "assistant", "content": null, "tool_calls": [
+						{"id": "call_a", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}},
+						{"id": "call_b", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Rome\"}"}}
+					]},
+					{"role": "tool", "tool_call_id": "call_a", "content": "{\"temperature\":18}"},
+					{"role": "tool", "tool_call_id": "call_b", "content": "sunny"}
+				],
+				"tools": [{"type": "function", "function": {
+					"name": "get_weather",
+					"description": "Get the weather",
+					"parameters": {"type": "object", "properties": {"city": {"type": "string"}}, "additionalProperties": false}
+				}}],
+				"tool_choice": {"type": "function", "function": {"name": "get_weather"}}
+			}`),
+			model: "gemini-pro",
+			expected: `{
+				"model": "gemini-pro",
+				"contents": [
+					{"role": "user", "parts": [{"text": "Weather in Paris and Rome?"}]},
+					{"role": "model", "parts": [
+						{"functionCall": {"name": "get_weather", "args": {"city": "Paris"}}},
+						{"functionCall": {"name": "get_weather", "args": {"city": "Rome"}}}
+					]},
+					{"role": "user", "parts": [
+						{"functionResponse": {"name": "get_weather", "response": {"temperature": 18}}},
+						{"functionResponse": {"name": "get_weather", "response": {"content": "sunny"}}}
+					]}
+				],
+				"tools": [{"functionDeclarations": [{
+					"name": "get_weather",
+					"description": "Get the weather",
+					"parameters": {"type": "object", "properties": {"city": {"type": "string"}}}
+				}]}],
+				"toolConfig": {"functionCallingConfig": {"mode": "ANY", "allowedFunctionNames": ["get_weather"]}}
+			}`,
+			expectError: false,
+		},
+		{
+			name: "multimodal content",
+			payload: []byte(`{
+				"messages": [
+					{"role": "user", "content": [
+						{"type": "text", "text": "Compare these"},
+						{"type": "image_url", "image_url": {"url": "data:image/png;base64,iVBORw0KGgo="}},
+						{"type": "image_url", "image_url": {"url": "https://example.com/cat.webp", "detail": "high"}},
+						{"type": "image_url", "image_url": {"url": "gs://bucket/dog"}},
+						{"type": "file", "file": {"filename": "spec.pdf", "file_data": "data:application/pdf;base64,JVBERi0="}}
+					]}
+				]
+			}`),
+			model: "gemini-pro",
+			expected: `{
+				"model": "gemini-pro",
+				"contents": [
+					{"role": "user", "parts": [
+						{"text": "Compare these"},
+						{"inlineData": {"mimeType": "image/png", "data": "iVBORw0KGgo="}},
+						{"fileData": {"mimeType": "image/webp", "fileUri": "https://example.com/cat.webp"}},
+						{"fileData": {"mimeType": "image/jpeg", "fileUri": "gs://bucket/dog"}},
+						{"inlineData": {"mimeType": "application/pdf", "data": "JVBERi0="}}
+					]}
+				]
+			}`,
+			expectError: false,
+		},
+		{
+			name: "json schema response format",
+			payload: []byte(`{
+				"messages": [{"role": "user", "content": "Extract the person"}],
+				"response_format": {"type": "json_schema", "json_schema": {
+					"name": "person",
+					"strict": true,
+					"schema": {
+						"type": "object",
+						"properties": {"name": {"type": "string"}, "age": {"type": ["integer", "null"]}},
+						"required": ["name", "age"],
+						"additionalProperties": false
+					}
+				}}
+			}`),
+			model: "gemini-pro",
+			expected: `{
+				"model": "gemini-pro",
+				"contents": [{"role": "user", "parts": [{"text": "Extract the person"}]}],
+				"generationConfig": {
+					"responseMimeType": "application/json",
+					"responseSchema": {
+						"type": "object",
+						"properties": {"name": {"type": "string"}, "age": {"type": "integer", "nullable": true}},
+						"required": ["name", "age"]
+					}
+				}
+			}`,
+			expectError: false,
+		},
+		{
+			name: "json object response format",
+			payload: []byte(`{
+				"messages": [{"role": "user", "content": "Answer in JSON"}],
+				"response_format": {"type": "json_object"}
+			}`),
+			model: "gemini-pro",
+			expected: `{
+				"model": "gemini-pro",
+				"contents": [{"role": "user", "parts": [{"text": "Answer in JSON"}]}],
+				"generationConfig": {
+					"responseMimeType": "application/json"
+				}
+			}`,
+			expectError: false,
+		},
+		{
+			name: "unsupported response schema",
+			payload: []byte(`{
+				"messages": [{"role": "user", "content": "List tags"}],
+				"response_format": {"type": "json_schema", "json_schema": {
+					"name": "tags",
+					"schema": {"type": "array", "items": {"type": "string"}, "uniqueItems": true}
+				}}
+			}`),
+			model:       "gemini-pro",
+			expectError: true,
+		},
+		{
+			name: "uploaded file",
+			payload: []byte(`{
+				"messages": [
+					{"role": "user", "content": [{"type": "file", "file": {"file_id": "file-abc"}}]}
+				]
+			}`),
+			model:       "gemini-pro",
+			expectError: true,
+		},
+		{
+			name: "invalid tool call arguments",
+			payload: []byte(`{
+				"messages": [
+					{"role": "user", "content": "Weather in Paris?"},
+					{"role": "assistant", "tool_calls": [{"id": "call_a", "type": "function", "function": {"name": "get_weather", "arguments": "{city"}}]}
+				]
+			}`),
+			model:       "gemini-pro",
+			expectError: true,
+		},
 		{
 			name:        "invalid json",
 			payload:     []byte(`{invalid json}`),
@@ -582,10 +1025,11 @@ func TestTransformFromVertexResponse(t *testing.T) {
 				}
 			}`),
 			expected: `{
+				"object": "chat.completion",
 				"choices": [{
 					"index": 0,
 					"message": {
-						"role": "model",
+						"role": "assistant",
 						"content": "Hello there"
 					},
 					"finish_reason": "stop"
@@ -599,36 +1043,96 @@ func TestTransformFromVertexResponse(t *testing.T) {
 			expectError: false,
 		},
 		{
-			name: "multiple candidates",
+			name: "cached and thought tokens",
 			input: []byte(`{
-				"candidates": [
-					{
-						"content": {
-							"parts": [{"text": "Response 1"}],
-							"role": "model"
-						},
-						"finishReason": "STOP"
-					},
-					{
-						"content": {
-							"parts": [{"text": "Response 2"}],
-							"role": "model"
-						},
-						"finishReason": "LENGTH"
-					}
-				],
+				"candidates": [{
+					"content": {"parts": [{"text": "42"}], "role": "model"},
+					"finishReason": "STOP"
+				}],
 				"usageMetadata": {
-					"promptTokenCount": 10,
-					"candidatesTokenCount": 10,
-					"totalTokenCount": 20
+					"promptTokenCount": 100,
+					"candidatesTokenCount": 20,
+					"totalTokenCount": 150,
+					"cachedContentTokenCount": 60,
+					"thoughtsTokenCount": 30
 				}
 			}`),
 			expected: `{
+				"object": "chat.completion",
+				"choices": [{
+					"index": 0,
+					"message": {"role": "assistant", "content": "42"},
+					"finish_reason": "stop"
+				}],
+				"usage": {
+					"prompt_tokens": 100,
+					"completion_tokens": 50,
+					"total_tokens": 150,
+					"prompt_tokens_details": {"cached_tokens": 60},
+					"completion_tokens_details": {"reasoning_tokens": 30}
+				}
+			}`,
+			expectError: false,
+		},
+		{
+			name: "function call",
+			input: []byte(`{
+				"candidates": [{
+					"content": {
+						"parts": [{"functionCall": {"name": "get_weather", "args": {"city": "Paris"}}}],
+						"role": "model"
+					},
+					"finishReason": "STOP"
+				}],
+				"usageMetadata": {"promptTokenCount": 10, "candidatesTokenCount": 5, "totalTokenCount": 15}
+			}`),
+			expected: `{
+				"object": "chat.completion",
+				"choices": [{
+					"index": 0,
+					"message": {
+						"role": "assistant",
+						"content": null,
+						"tool_calls": [{"id": "call_0", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}}]
+					},
+					"finish_reason": "tool_calls"
+				}],
+				"usage": {"prompt_tokens": 10, "completion_tokens": 5, "total_tokens": 15}
+			}`,
+			expectError: false,
+		},
+		{
+			name: "multiple candidates",
+			input: []byte(`{
+				"candidates": [
+					{
+						"content": {
+							"parts": [{"text": "Response 1"}],
+							"role": "model"
+						},
+						"finishReason": "STOP"
+					},
+					{
+						"content": {
+							"parts": [{"text": "Response 2"}],
+							"role": "model"
+						},
+						"finishReason": "LENGTH"
+					}
+				],
+				"usageMetadata": {
+					"promptTokenCount": 10,
+					"candidatesTokenCount": 10,
+					"totalTokenCount": 20
+				}
+			}`),
+			expected: `{
+				"object": "chat.completion",
 				"choices": [
 					{
 						"index": 0,
 						"message": {
-							"role": "model",
+							"role": "assistant",
 							"content": "Response 1"
 						},
 						"finish_reason": "stop"
@@ -636,7 +1140,7 @@ func TestTransformFromVertexResponse(t *testing.T) {
 					{
 						"index": 1,
 						"message": {
-							"role": "model",
+							"role": "assistant",
 							"content": "Response 2"
 						},
 						"finish_reason": "length"
@@ -650,6 +1154,42 @@ func TestTransformFromVertexResponse(t *testing.T) {
 			}`,
 			expectError: false,
 		},
+		{
+			name: "finish reason, parts and model version",
+			input: []byte(`{
+				"candidates": [{
+					"content": {
+						"parts": [{"text": "Hello "}, {"text": "there"}],
+						"role": "model"
+					},
+					"finishReason": "MAX_TOKENS"
+				}],
+				"usageMetadata": {
+					"promptTokenCount": 10,
+					"candidatesTokenCount": 2,
+					"totalTokenCount": 12
+				},
+				"modelVersion": "gemini-1.5-flash-002"
+			}`),
+			expected: `{
+				"object": "chat.completion",
+				"model": "gemini-1.5-flash-002",
+				"choices": [{
+					"index": 0,
+					"message": {
+						"role": "assistant",
+						"content": "Hello there"
+					},
+					"finish_reason": "length"
+				}],
+				"usage": {
+					"prompt_tokens": 10,
+					"completion_tokens": 2,
+					"total_tokens": 12
+				}
+			}`,
+			expectError: false,
+		},
 		{
 			name: "empty parts array",
 			input: []byte(`{
@@ -667,6 +1207,7 @@ func TestTransformFromVertexResponse(t *testing.T) {
 				}
 			}`),
 			expected: `{
+				"object": "chat.completion",
 				"choices": [],
 				"usage": {
 					"prompt_tokens": 10,
@@ -676,6 +1217,42 @@ func TestTransformFromVertexResponse(t *testing.T) {
 			}`,
 			expectError: false,
 		},
+		{
+			name: "candidate blocked by safety filters",
+			input: []byte(`{
+				"candidates": [{
+					"finishReason": "SAFETY",
+					"safetyRatings": [
+						{"category": "HARM_CATEGORY_DANGEROUS_CONTENT", "probability": "HIGH"}
+					]
+				}, {
+					"content": {"role": "model"},
+					"finishReason": "PROHIBITED_CONTENT"
+				}],
+				"usageMetadata": {
+					"promptTokenCount": 10,
+					"totalTokenCount": 10
+				}
+			}`),
+			expected: `{
+				"object": "chat.completion",
+				"choices": [{
+					"index": 0,
+					"message": {"role": "assistant", "content": ""},
+					"finish_reason": "content_filter"
+				}, {
+					"index": 1,
+					"message": {"role": "assistant", "content": ""},
+					"finish_reason": "content_filter"
+				}],
+				"usage": {
+					"prompt_tokens": 10,
+					"completion_tokens": 0,
+					"total_tokens": 10
+				}
+			}`,
+			expectError: false,
+		},
 		{
 			name:        "invalid json",
 			input:       []byte(`{invalid json}`),
@@ -716,7 +1293,7 @@ func TestTransformFromVertexResponse(t *testing.T) {
 	}
 }
 
-func TestTransformFromVertexToOpenAI(t *testing.T) {
+func TestTransformToVertexResponse(t *testing.T) {
 	tests := []struct {
 		name        string
 		input       []byte
@@ -724,116 +1301,138 @@ func TestTransformFromVertexToOpenAI(t *testing.T) {
 		expectError bool
 	}{
 		{
-			name: "valid single message",
+			name: "valid response",
 			input: []byte(`{
-				"contents": [
-					{
-						"role": "user",
-						"parts": [{"text": "Hello"}]
-					}
-				],
-				"generationConfig": {
-					"temperature": 0.8,
-					"maxOutputTokens": 100,
-					"topP": 0.9,
-					"topK": 40
-				}
+				"id": "chatcmpl-1",
+				"object": "chat.completion",
+				"model": "gpt-4o",
+				"choices": [{
+					"index": 0,
+					"message": {"role": "assistant", "content": "Hello there"},
+					"finish_reason": "length"
+				}],
+				"usage": {"prompt_tokens": 10, "completion_tokens": 5, "total_tokens": 15}
 			}`),
 			expected: `{
-				"messages": [
-					{"role": "user", "content": "Hello"}
-				],
-				"temperature": 0.8,
-				"max_tokens": 100,
-				"top_p": 0.9
+				"candidates": [{
+					"index": 0,
+					"content": {
+						"role": "model",
+						"parts": [{"text": "Hello there"}]
+					},
+					"finishReason": "MAX_TOKENS"
+				}],
+				"usageMetadata": {
+					"promptTokenCount": 10,
+					"candidatesTokenCount": 5,
+					"totalTokenCount": 15
+				},
+				"modelVersion": "gpt-4o"
 			}`,
 			expectError: false,
 		},
 		{
-			name: "multiple messages with model role conversion",
+			name: "content parts and missing total tokens",
 			input: []byte(`{
-				"contents": [
-					{
-						"role": "user",
-						"parts": [{"text": "Hello"}]
-					},
-					{
-						"role": "model",
-						"parts": [{"text": "Hi there"}]
-					}
-				]
+				"choices": [{
+					"index": 0,
+					"message": {"role": "assistant", "content": [{"type": "text", "text": "Hi"}]},
+					"finish_reason": "stop"
+				}],
+				"usage": {"prompt_tokens": 3, "completion_tokens": 1}
 			}`),
 			expected: `{
-				"messages": [
-					{"role": "user", "content": "Hello"},
-					{"role": "assistant", "content": "Hi there"}
-				]
+				"candidates": [{
+					"index": 0,
+					"content": {
+						"role": "model",
+						"parts": [{"text": "Hi"}]
+					},
+					"finishReason": "STOP"
+				}],
+				"usageMetadata": {
+					"promptTokenCount": 3,
+					"candidatesTokenCount": 1,
+					"totalTokenCount": 4
+				}
 			}`,
 			expectError: false,
 		},
 		{
-			name: "empty parts array should be skipped",
+			name: "cached and reasoning tokens",
 			input: []byte(`{
-				"contents": [
-					{
-						"role": "user",
-						"parts": []
-					},
-					{
-						"role": "model",
-						"parts": [{"text": "Response"}]
-					}
-				]
+				"choices": [{
+					"index": 0,
+					"message": {"role": "assistant", "content": "42"},
+					"finish_reason": "stop"
+				}],
+				"usage": {
+					"prompt_tokens": 100,
+					"completion_tokens": 50,
+					"total_tokens": 150,
+					"prompt_tokens_details": {"cached_tokens": 60},
+					"completion_tokens_details": {"reasoning_tokens": 30}
+				}
 			}`),
 			expected: `{
-				"messages": [
-					{"role": "assistant", "content": "Response"}
-				]
+				"candidates": [{
+					"index": 0,
+					"content": {"role": "model", "parts": [{"text": "42"}]},
+					"finishReason": "STOP"
+				}],
+				"usageMetadata": {
+					"promptTokenCount": 100,
+					"candidatesTokenCount": 20,
+					"totalTokenCount": 150,
+					"cachedContentTokenCount": 60,
+					"thoughtsTokenCount": 30
+				}
 			}`,
 			expectError: false,
 		},
 		{
-			name: "with default generation config",
+			name: "tool calls",
 			input: []byte(`{
-				"contents": [
-					{
-						"role": "user",
-						"parts": [{"text": "Hello"}]
-					}
-				],
-				"generationConfig": {}
+				"choices": [{
+					"index": 0,
+					"message": {
+						"role": "assistant",
+						"content": null,
+						"tool_calls": [{"id": "call_a", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}}]
+					},
+					"finish_reason": "tool_calls"
+				}],
+				"usage": {"prompt_tokens": 10, "completion_tokens": 5, "total_tokens": 15}
 			}`),
 			expected: `{
-				"messages": [
-					{"role": "user", "content": "Hello"}
-				]
+				"candidates": [{
+					"index": 0,
+					"content": {
+						"role": "model",
+						"parts": [{"functionCall": {"name": "get_weather", "args": {"city": "Paris"}}}]
+					},
+					"finishReason": "STOP"
+				}],
+				"usageMetadata": {"promptTokenCount": 10, "candidatesTokenCount": 5, "totalTokenCount": 15}
 			}`,
 			expectError: false,
 		},
 		{
 			name:        "invalid json",
 			input:       []byte(`{invalid json}`),
-			expected:    "",
-			expectError: true,
-		},
-		{
-			name:        "empty input",
-			input:       []byte{},
-			expected:    "",
 			expectError: true,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := TransformFromVertexToOpenAI(tt.input)
+			got, err := TransformToVertexResponse(tt.input)
 			if (err != nil) != tt.expectError {
-				t.Errorf("TransformFromVertexToOpenAI() error = %v, expectError %v", err, tt.expectError)
+				t.Errorf("TransformToVertexResponse() error = %v, expectError %v", err, tt.expectError)
 				return
 			}
 
 			if !tt.expectError {
-				// Normalize the JSON for comparison
 				var gotJSON, expectedJSON map[string]interface{}
 				if err := json.Unmarshal(got, &gotJSON); err != nil {
 					t.Fatalf("Failed to unmarshal result: %v", err)
@@ -841,11 +1440,1273 @@ func TestTransformFromVertexToOpenAI(t *testing.T) {
 				if err := json.Unmarshal([]byte(tt.expected), &expectedJSON); err != nil {
 					t.Fatalf("Failed to unmarshal expected: %v", err)
 				}
-
 				if !reflect.DeepEqual(gotJSON, expectedJSON) {
-					t.Errorf("TransformFromVertexToOpenAI() = %v, want %v", string(got), tt.expected)
+					t.Errorf("TransformToVertexResponse() = %v, want %v", string(got), tt.expected)
 				}
 			}
 		})
 	}
 }
+
+func TestTransformFromVertexStreamEvent(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       []byte
+		expected    string
+		expectError bool
+	}{
+		{
+			name: "text chunk",
+			input: []byte(`{
+				"candidates": [{"content": {"role": "model", "parts": [{"text": "Hel"}, {"text": "lo"}]}}],
+				"modelVersion": "gemini-1.5-pro",
+				"responseId": "resp-1"
+			}`),
+			expected: `{
+				"id": "resp-1",
+				"object": "chat.completion.chunk",
+				"model": "gemini-1.5-pro",
+				"choices": [{"index": 0, "delta": {"role": "assistant", "content": "Hello"}, "finish_reason": null}]
+			}`,
+		},
+		{
+			name: "last chunk",
+			input: []byte(`{
+				"candidates": [{"content": {"role": "model", "parts": [{"text": "!"}]}, "finishReason": "MAX_TOKENS"}],
+				"usageMetadata": {"promptTokenCount": 4, "candidatesTokenCount": 2, "totalTokenCount": 6}
+			}`),
+			expected: `{
+				"object": "chat.completion.chunk",
+				"choices": [{"index": 0, "delta": {"role": "assistant", "content": "!"}, "finish_reason": "length"}],
+				"usage": {"prompt_tokens": 4, "completion_tokens": 2, "total_tokens": 6}
+			}`,
+		},
+		{
+			name: "function call chunk",
+			input: []byte(`{
+				"candidates": [{"content": {"role": "model", "parts": [{"functionCall": {"name": "get_weather", "args": {"city": "Paris"}}}]}, "finishReason": "STOP"}]
+			}`),
+			expected: `{
+				"object": "chat.completion.chunk",
+				"choices": [{
+					"index": 0,
+					"delta": {
+						"role": "assistant",
+						"content": "",
+						"tool_calls": [{"index": 0, "id": "call_0", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}}]
+					},
+					"finish_reason": "tool_calls"
+				}]
+			}`,
+		},
+		{
+			name:        "invalid json",
+			input:       []byte(`{invalid json}`),
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := TransformFromVertexStreamEvent(tt.input)
+			if (err != nil) != tt.expectError {
+				t.Errorf("TransformFromVertexStreamEvent() error = %v, expectError %v", err, tt.expectError)
+				return
+			}
+
+			if !tt.expectError {
+				var gotJSON, expectedJSON map[string]interface{}
+				if err := json.Unmarshal(got, &gotJSON); err != nil {
+					t.Fatalf("Failed to unmarshal result: %v", err)
+				}
+				if err := json.Unmarshal([]byte(tt.expected), &expectedJSON); err != nil {
+					t.Fatalf("Failed to unmarshal expected: %v", err)
+				}
+				if !reflect.DeepEqual(gotJSON, expectedJSON) {
+					t.Errorf("TransformFromVertexStreamEvent() = %v, want %v", string(got), tt.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestTransformToVertexStreamEvent(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       []byte
+		expected    string
+		expectError bool
+	}{
+		{
+			name: "text chunk",
+			input: []byte(`{
+				"id": "chatcmpl-1",
+				"object": "chat.completion.chunk",
+				"model": "gpt-4o",
+				"choices": [{"index": 0, "delta": {"content": "Hello"}, "finish_reason": null}]
+			}`),
+			expected: `{
+				"candidates": [{"index": 0, "content": {"role": "model", "parts": [{"text": "Hello"}]}}],
+				"modelVersion": "gpt-4o",
+				"responseId": "chatcmpl-1"
+			}`,
+		},
+		{
+			name: "last chunk",
+			input: []byte(`{
+				"choices": [{"index": 0, "delta": {}, "finish_reason": "stop"}],
+				"usage": {"prompt_tokens": 4, "completion_tokens": 2}
+			}`),
+			expected: `{
+				"candidates": [{"index": 0, "content": {"role": "model", "parts": [{"text": ""}]}, "finishReason": "STOP"}],
+				"usageMetadata": {"promptTokenCount": 4, "candidatesTokenCount": 2, "totalTokenCount": 6}
+			}`,
+		},
+		{
+			name:  "role only chunk is dropped",
+			input: []byte(`{"choices": [{"index": 0, "delta": {"role": "assistant", "content": ""}, "finish_reason": null}]}`),
+		},
+		{
+			name:        "invalid json",
+			input:       []byte(`{invalid json}`),
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := TransformToVertexStreamEvent(tt.input)
+			if (err != nil) != tt.expectError {
+				t.Errorf("TransformToVertexStreamEvent() error = %v, expectError %v", err, tt.expectError)
+				return
+			}
+			if tt.expectError {
+				return
+			}
+
+			if tt.expected == "" {
+				if got != nil {
+					t.Errorf("TransformToVertexStreamEvent() = %s, want nil", got)
+				}
+				return
+			}
+			var gotJSON, expectedJSON map[string]interface{}
+			if err := json.Unmarshal(got, &gotJSON); err != nil {
+				t.Fatalf("Failed to unmarshal result: %v", err)
+			}
+			if err := json.Unmarshal([]byte(tt.expected), &expectedJSON); err != nil {
+				t.Fatalf("Failed to unmarshal expected: %v", err)
+			}
+			if !reflect.DeepEqual(gotJSON, expectedJSON) {
+				t.Errorf("TransformToVertexStreamEvent() = %v, want %v", string(got), tt.expected)
+			}
+		})
+	}
+}
+
+func TestIsStreamingRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		body string
+		want bool
+	}{
+		{
+			name: "stream field",
+			url:  "https://api.openai.com/v1/chat/completions",
+			body: `{"stream": true, "messages": []}`,
+			want: true,
+		},
+		{
+			name: "vertex stream method",
+			url:  "https://us-central1-aiplatform.googleapis.com/v1/projects/p/locations/us-central1/publishers/google/models/gemini-pro:streamGenerateContent?alt=sse",
+			body: `{"contents": []}`,
+			want: true,
+		},
+		{
+			name: "not streamed",
+			url:  "https://us-central1-aiplatform.googleapis.com/v1/projects/p/locations/us-central1/publishers/google/models/gemini-pro:generateContent",
+			body: `{"contents": []}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", tt.url, nil)
+			if got := IsStreamingRequest(req, []byte(tt.body)); got != tt.want {
+				t.Errorf("IsStreamingRequest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransformFromVertexToOpenAI(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       []byte
+		expected    string
+		expectError bool
+	}{
+		{
+			name: "valid single message",
+			input: []byte(`{
+				"contents": [
+					{
+						"role": "user",
+						"parts": [{"text": "Hello"}]
+					}
+				],
+				"generationConfig": {
+					"temperature": 0.8,
+					"maxOutputTokens": 100,
+					"topP": 0.9,
+					"topK": 40
+				}
+			}`),
+			expected: `{
+				"messages": [
+					{"role": "user", "content": "Hello"}
+				],
+				"temperature": 0.8,
+				"max_tokens": 100,
+				"top_p": 0.9
+			}`,
+			expectError: false,
+		},
+		{
+			name: "multiple messages with model role conversion",
+			input: []byte(`{
+				"contents": [
+					{
+						"role": "user",
+						"parts": [{"text": "Hello"}]
+					},
+					{
+						"role": "model",
+						"parts": [{"text": "Hi there"}]
+					}
+				]
+			}`),
+			expected: `{
+				"messages": [
+					{"role": "user", "content": "Hello"},
+					{"role": "assistant", "content": "Hi there"}
+				]
+			}`,
+			expectError: false,
+		},
+		{
+			name: "system instruction",
+			input: []byte(`{
+				"systemInstruction": {"parts": [{"text": "You are helpful"}]},
+				"contents": [
+					{"role": "user", "parts": [{"text": "Hello"}]}
+				]
+			}`),
+			expected: `{
+				"messages": [
+					{"role": "system", "content": "You are helpful"},
+					{"role": "user", "content": "Hello"}
+				]
+			}`,
+			expectError: false,
+		},
+		{
+			name: "generation config",
+			input: []byte(`{
+				"contents": [{"role": "user", "parts": [{"text": "Hello"}]}],
+				"generationConfig": {
+					"temperature": 0.2,
+					"topP": 0.9,
+					"topK": 40,
+					"maxOutputTokens": 100,
+					"candidateCount": 2,
+					"presencePenalty": 0.5,
+					"frequencyPenalty": -0.5,
+					"seed": 7,
+					"stopSequences": ["END"]
+				}
+			}`),
+			expected: `{
+				"messages": [{"role": "user", "content": "Hello"}],
+				"temperature": 0.2,
+				"top_p": 0.9,
+				"max_tokens": 100,
+				"n": 2,
+				"presence_penalty": 0.5,
+				"frequency_penalty": -0.5,
+				"seed": 7,
+				"stop": ["END"]
+			}`,
+			expectError: false,
+		},
+		{
+			name: "fields unknown to Vertex AI are passed on",
+			input: []byte(`{
+				"contents": [{"role": "user", "parts": [{"text": "Hello"}]}],
+				"safetySettings": [{"category": "HARM_CATEGORY_HARASSMENT", "threshold": "BLOCK_NONE"}],
+				"user": "user-1",
+				"seed": 42
+			}`),
+			expected: `{
+				"messages": [{"role": "user", "content": "Hello"}],
+				"user": "user-1",
+				"seed": 42
+			}`,
+			expectError: false,
+		},
+		{
+			name: "response schema",
+			input: []byte(`{
+				"contents": [{"role": "user", "parts": [{"text": "Extract the person"}]}],
+				"generationConfig": {
+					"responseMimeType": "application/json",
+					"responseSchema": {"type": "OBJECT", "properties": {"name": {"type": "STRING", "nullable": true}}}
+				}
+			}`),
+			expected: `{
+				"messages": [{"role": "user", "content": "Extract the person"}],
+				"response_format": {"type": "json_schema", "json_schema": {
+					"name": "response",
+					"schema": {"type": "object", "properties": {"name": {"type": ["string", "null"]}}}
+				}}
+			}`,
+			expectError: false,
+		},
+		{
+			name: "tool calling conversation",
+			input: []byte(`{
+				"contents": [
+					{"role": "user", "parts": [{"text": "Weather in Paris and Rome?"}]},
+					{"role": "model", "parts": [
+						{"functionCall": {"name": "get_weather", "args": {"city": "Paris"}}},
+						{"functionCall": {"name": "get_weather", "args": {"city": "Rome"}}}
+					]},
+					{"role": "user", "parts": [
+						{"functionResponse": {"name": "get_weather", "response": {"temperature": 18}}},
+						{"functionResponse": {"name": "get_weather", "response": {"content": "sunny"}}}
+					]}
+				],
+				"tools": [{"functionDeclarations": [{"name": "get_weather", "parameters": {"type": "object"}}]}],
+				"toolConfig": {"functionCallingConfig": {"mode": "ANY"}}
+			}`),
+			expected: `{
+				"messages": [
+					{"role": "user", "content": "Weather in Paris and Rome?"},
+					{"role": "assistant", "content": null, "tool_calls": [
+						{"id": "call_0", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}},
+						{"id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Rome\"}"}}
+					]},
+					{"role": "tool", "tool_call_id": "call_0", "content": "{\"temperature\":18}"},
+					{"role": "tool", "tool_call_id": "call_1", "content": "sunny"}
+				],
+				"tools": [{"type": "function", "function": {"name": "get_weather", "parameters": {"type": "object"}}}],
+				"tool_choice": "required"
+			}`,
+			expectError: false,
+		},
+		{
+			name: "multimodal content",
+			input: []byte(`{
+				"contents": [
+					{"role": "user", "parts": [
+						{"text": "Compare these"},
+						{"inlineData": {"mimeType": "image/png", "data": "iVBORw0KGgo="}},
+						{"fileData": {"mimeType": "image/webp", "fileUri": "https://example.com/cat.webp"}},
+						{"inlineData": {"mimeType": "application/pdf", "data": "JVBERi0="}}
+					]}
+				]
+			}`),
+			expected: `{
+				"messages": [
+					{"role": "user", "content": [
+						{"type": "text", "text": "Compare these"},
+						{"type": "image_url", "image_url": {"url": "data:image/png;base64,iVBORw0KGgo="}},
+						{"type": "image_url", "image_url": {"url": "https://example.com/cat.webp"}},
+						{"type": "file", "file": {"filename": "file.pdf", "file_data": "data:application/pdf;base64,JVBERi0="}}
+					]}
+				]
+			}`,
+			expectError: false,
+		},
+		{
+			name: "cloud storage file",
+			input: []byte(`{
+				"contents": [
+					{"role": "user", "parts": [{"fileData": {"mimeType": "video/mp4", "fileUri": "gs://bucket/clip.mp4"}}]}
+				]
+			}`),
+			expectError: true,
+		},
+		{
+			name: "empty parts array should be skipped",
+			input: []byte(`{
+				"contents": [
+					{
+						"role": "user",
+						"parts": []
+					},
+					{
+						"role": "model",
+						"parts": [{"text": "Response"}]
+					}
+				]
+			}`),
+			expected: `{
+				"messages": [
+					{"role": "assistant", "content": "Response"}
+				]
+			}`,
+			expectError: false,
+		},
+		{
+			name: "with default generation config",
+			input: []byte(`{
+				"contents": [
+					{
+						"role": "user",
+						"parts": [{"text": "Hello"}]
+					}
+				],
+				"generationConfig": {}
+			}`),
+			expected: `{
+				"messages": [
+					{"role": "user", "content": "Hello"}
+				]
+			}`,
+			expectError: false,
+		},
+		{
+			name:        "invalid json",
+			input:       []byte(`{invalid json}`),
+			expected:    "",
+			expectError: true,
+		},
+		{
+			name:        "empty input",
+			input:       []byte{},
+			expected:    "",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := TransformFromVertexToOpenAI(tt.input)
+			if (err != nil) != tt.expectError {
+				t.Errorf("TransformFromVertexToOpenAI() error = %v, expectError %v", err, tt.expectError)
+				return
+			}
+
+			if !tt.expectError {
+				// Normalize the JSON for comparison
+				var gotJSON, expectedJSON map[string]interface{}
+				if err := json.Unmarshal(got, &gotJSON); err != nil {
+					t.Fatalf("Failed to unmarshal result: %v", err)
+				}
+				if err := json.Unmarshal([]byte(tt.expected), &expectedJSON); err != nil {
+					t.Fatalf("Failed to unmarshal expected: %v", err)
+				}
+
+				if !reflect.DeepEqual(gotJSON, expectedJSON) {
+					t.Errorf("TransformFromVertexToOpenAI() = %v, want %v", string(got), tt.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestTransformToAnthropicRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		payload     []byte
+		model       string
+		expected    string
+		expectError bool
+	}{
+		{
+			name: "openai payload with system message",
+			payload: []byte(`{
+				"model": "gpt-4",
+				"messages": [
+					{"role": "system", "content": "You are helpful"},
+					{"role": "user", "content": "Hello"},
+					{"role": "assistant", "content": "Hi there"}
+				],
+				"temperature": 0.5,
+				"max_tokens": 200,
+				"stop": ["END"]
+			}`),
+			model: "claude-3-5-sonnet-latest",
+			expected: `{
+				"model": "claude-3-5-sonnet-latest",
+				"system": "You are helpful",
+				"messages": [
+					{"role": "user", "content": "Hello"},
+					{"role": "assistant", "content": "Hi there"}
+				],
+				"temperature": 0.5,
+				"max_tokens": 200,
+				"stop_sequences": ["END"]
+			}`,
+			expectError: false,
+		},
+		{
+			name: "default max tokens and single stop string",
+			payload: []byte(`{
+				"messages": [{"role": "user", "content": "Hello"}],
+				"stop": "END"
+			}`),
+			model: "anthropic/claude-3-haiku",
+			expected: `{
+				"model": "claude-3-haiku",
+				"messages": [
+					{"role": "user", "content": "Hello"}
+				],
+				"max_tokens": 1024,
+				"stop_sequences": ["END"]
+			}`,
+			expectError: false,
+		},
+		{
+			name: "vertex payload",
+			payload: []byte(`{
+				"contents": [
+					{"role": "user", "parts": [{"text": "Hello"}]},
+					{"role": "model", "parts": [{"text": "Hi there"}]}
+				],
+				"generationConfig": {"maxOutputTokens": 100, "topP": 0.9}
+			}`),
+			model: "claude-3-haiku",
+			expected: `{
+				"model": "claude-3-haiku",
+				"messages": [
+					{"role": "user", "content": "Hello"},
+					{"role": "assistant", "content": "Hi there"}
+				],
+				"max_tokens": 100,
+				"top_p": 0.9
+			}`,
+			expectError: false,
+		},
+		{
+			name: "tools, tool calls, tool results and images",
+			payload: []byte(`{
+				"messages": [
+					{"role": "developer", "content": "Use tools"},
+					{"role": "user", "content": [
+						{"type": "text", "text": "Weather here?"},
+						{"type": "image_url", "image_url": {"url": "data:image/png;base64,aGVsbG8="}},
+						{"type": "image_url", "image_url": {"url": "https://example.com/map.png"}}
+					]},
+					{"role": "assistant", "content": null, "tool_calls": [
+						{"id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}}
+					]},
+					{"role": "tool", "tool_call_id": "call_1", "content": "Sunny"},
+					{"role": "user", "content": "Thanks"},
+					{"role": "assistant", "content": ""}
+				],
+				"tools": [
+					{"type": "function", "function": {"name": "get_weather", "description": "Get the weather", "parameters": {"type": "object", "properties": {"city": {"type": "string"}}}}},
+					{"type": "function", "function": {"name": "get_time"}}
+				],
+				"tool_choice": "required",
+				"parallel_tool_calls": false,
+				"max_tokens": 100,
+				"max_completion_tokens": 300,
+				"user": "user-1"
+			}`),
+			model: "claude-3-haiku",
+			expected: `{
+				"model": "claude-3-haiku",
+				"system": "Use tools",
+				"messages": [
+					{"role": "user", "content": [
+						{"type": "text", "text": "Weather here?"},
+						{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "aGVsbG8="}},
+						{"type": "image", "source": {"type": "url", "url": "https://example.com/map.png"}}
+					]},
+					{"role": "assistant", "content": [
+						{"type": "tool_use", "id": "call_1", "name": "get_weather", "input": {"city": "Paris"}}
+					]},
+					{"role": "user", "content": [
+						{"type": "tool_result", "tool_use_id": "call_1", "content": "Sunny"},
+						{"type": "text", "text": "Thanks"}
+					]}
+				],
+				"tools": [
+					{"name": "get_weather", "description": "Get the weather", "input_schema": {"type": "object", "properties": {"city": {"type": "string"}}}},
+					{"name": "get_time", "input_schema": {"type": "object"}}
+				],
+				"tool_choice": {"type": "any", "disable_parallel_tool_use": true},
+				"max_tokens": 300,
+				"metadata": {"user_id": "user-1"}
+			}`,
+			expectError: false,
+		},
+		{
+			name: "anthropic payload passed on",
+			payload: []byte(`{
+				"model": "anthropic/claude-3-haiku",
+				"system": [{"type": "text", "text": "Be brief", "cache_control": {"type": "ephemeral"}}],
+				"max_tokens": 100,
+				"tools": [{"name": "get_weather", "input_schema": {"type": "object"}}],
+				"messages": [
+					{"role": "user", "content": "Weather?"},
+					{"role": "assistant", "content": [{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {}}]},
+					{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "toolu_1", "content": "Sunny"}]}
+				]
+			}`),
+			model: "anthropic/claude-3-haiku",
+			expected: `{
+				"model": "claude-3-haiku",
+				"system": [{"type": "text", "text": "Be brief", "cache_control": {"type": "ephemeral"}}],
+				"max_tokens": 100,
+				"tools": [{"name": "get_weather", "input_schema": {"type": "object"}}],
+				"messages": [
+					{"role": "user", "content": "Weather?"},
+					{"role": "assistant", "content": [{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {}}]},
+					{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "toolu_1", "content": "Sunny"}]}
+				]
+			}`,
+			expectError: false,
+		},
+		{
+			name: "file referenced by id",
+			payload: []byte(`{
+				"messages": [{"role": "user", "content": [{"type": "file", "file": {"file_id": "file-1"}}]}]
+			}`),
+			model:       "claude-3-haiku",
+			expectError: true,
+		},
+		{
+			name:        "invalid json",
+			payload:     []byte(`{invalid json}`),
+			model:       "claude-3-haiku",
+			expectError: true,
+		},
+		{
+			name:        "empty payload",
+			payload:     []byte{},
+			model:       "claude-3-haiku",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := TransformToAnthropicRequest(tt.payload, tt.model)
+			if (err != nil) != tt.expectError {
+				t.Errorf("TransformToAnthropicRequest() error = %v, expectError %v", err, tt.expectError)
+				return
+			}
+
+			if !tt.expectError {
+				var gotJSON, expectedJSON map[string]interface{}
+				if err := json.Unmarshal(got, &gotJSON); err != nil {
+					t.Fatalf("Failed to unmarshal result: %v", err)
+				}
+				if err := json.Unmarshal([]byte(tt.expected), &expectedJSON); err != nil {
+					t.Fatalf("Failed to unmarshal expected: %v", err)
+				}
+
+				if !reflect.DeepEqual(gotJSON, expectedJSON) {
+					t.Errorf("TransformToAnthropicRequest() = %v, want %v", string(got), tt.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestTransformFromAnthropicToOpenAI(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       []byte
+		expected    string
+		expectError bool
+	}{
+		{
+			name: "system prompt and content blocks",
+			input: []byte(`{
+				"model": "claude-3-haiku",
+				"system": "Be brief",
+				"max_tokens": 100,
+				"stop_sequences": ["END"],
+				"messages": [
+					{"role": "user", "content": [{"type": "text", "text": "Hello"}]}
+				]
+			}`),
+			expected: `{
+				"messages": [
+					{"role": "system", "content": "Be brief"},
+					{"role": "user", "content": "Hello"}
+				],
+				"max_tokens": 100,
+				"stop": ["END"]
+			}`,
+			expectError: false,
+		},
+		{
+			name: "images, tools and tool results",
+			input: []byte(`{
+				"model": "claude-3-haiku",
+				"max_tokens": 100,
+				"top_k": 40,
+				"metadata": {"user_id": "user-1"},
+				"tools": [{"name": "get_weather", "description": "Get the weather", "input_schema": {"type": "object", "properties": {"city": {"type": "string"}}}}],
+				"tool_choice": {"type": "any", "disable_parallel_tool_use": true},
+				"messages": [
+					{"role": "user", "content": [
+						{"type": "text", "text": "Weather here?"},
+						{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "aGVsbG8="}}
+					]},
+					{"role": "assistant", "content": [
+						{"type": "thinking", "thinking": "Look it up", "signature": "sig"},
+						{"type": "text", "text": "Checking"},
+						{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Paris"}}
+					]},
+					{"role": "user", "content": [
+						{"type": "tool_result", "tool_use_id": "toolu_1", "content": [{"type": "text", "text": "Sunny"}]},
+						{"type": "text", "text": "Thanks"}
+					]}
+				]
+			}`),
+			expected: `{
+				"messages": [
+					{"role": "user", "content": [
+						{"type": "text", "text": "Weather here?"},
+						{"type": "image_url", "image_url": {"url": "data:image/png;base64,aGVsbG8="}}
+					]},
+					{"role": "assistant", "content": "Checking", "tool_calls": [
+						{"id": "toolu_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}}
+					]},
+					{"role": "tool", "tool_call_id": "toolu_1", "content": "Sunny"},
+					{"role": "user", "content": "Thanks"}
+				],
+				"max_tokens": 100,
+				"top_k": 40,
+				"user": "user-1",
+				"tools": [{"type": "function", "function": {"name": "get_weather", "description": "Get the weather", "parameters": {"type": "object", "properties": {"city": {"type": "string"}}}}}],
+				"tool_choice": "required",
+				"parallel_tool_calls": false
+			}`,
+			expectError: false,
+		},
+		{
+			name: "server tool",
+			input: []byte(`{
+				"max_tokens": 100,
+				"tools": [{"type": "web_search_20250305", "name": "web_search"}],
+				"messages": [{"role": "user", "content": "Hello"}]
+			}`),
+			expectError: true,
+		},
+		{
+			name:        "invalid json",
+			input:       []byte(`{invalid json}`),
+			expectError: true,
+		},
+		{
+			name:        "empty input",
+			input:       []byte{},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := TransformFromAnthropicToOpenAI(tt.input)
+			if (err != nil) != tt.expectError {
+				t.Errorf("TransformFromAnthropicToOpenAI() error = %v, expectError %v", err, tt.expectError)
+				return
+			}
+
+			if !tt.expectError {
+				var gotJSON, expectedJSON map[string]interface{}
+				if err := json.Unmarshal(got, &gotJSON); err != nil {
+					t.Fatalf("Failed to unmarshal result: %v", err)
+				}
+				if err := json.Unmarshal([]byte(tt.expected), &expectedJSON); err != nil {
+					t.Fatalf("Failed to unmarshal expected: %v", err)
+				}
+
+				if !reflect.DeepEqual(gotJSON, expectedJSON) {
+					t.Errorf("TransformFromAnthropicToOpenAI() = %v, want %v", string(got), tt.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestTransformFromAnthropicResponse(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       []byte
+		expected    string
+		expectError bool
+	}{
+		{
+			name: "valid response",
+			input: []byte(`{
+				"id": "msg_01",
+				"type": "message",
+				"role": "assistant",
+				"model": "claude-3-haiku",
+				"content": [{"type": "text", "text": "Hello there"}],
+				"stop_reason": "max_tokens",
+				"usage": {"input_tokens": 10, "output_tokens": 5}
+			}`),
+			expected: `{
+				"id": "msg_01",
+				"object": "chat.completion",
+				"model": "claude-3-haiku",
+				"choices": [{
+					"index": 0,
+					"message": {
+						"role": "assistant",
+						"content": "Hello there"
+					},
+					"finish_reason": "length"
+				}],
+				"usage": {
+					"prompt_tokens": 10,
+					"completion_tokens": 5,
+					"total_tokens": 15
+				}
+			}`,
+			expectError: false,
+		},
+		{
+			name: "tool use",
+			input: []byte(`{
+				"id": "msg_02",
+				"type": "message",
+				"role": "assistant",
+				"model": "claude-3-haiku",
+				"content": [
+					{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Paris"}},
+					{"type": "tool_use", "id": "toolu_2", "name": "get_time", "input": {}}
+				],
+				"stop_reason": "tool_use",
+				"usage": {"input_tokens": 10, "output_tokens": 5}
+			}`),
+			expected: `{
+				"id": "msg_02",
+				"object": "chat.completion",
+				"model": "claude-3-haiku",
+				"choices": [{
+					"index": 0,
+					"message": {
+						"role": "assistant",
+						"content": null,
+						"tool_calls": [
+							{"id": "toolu_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}},
+							{"id": "toolu_2", "type": "function", "function": {"name": "get_time", "arguments": "{}"}}
+						]
+					},
+					"finish_reason": "tool_calls"
+				}],
+				"usage": {
+					"prompt_tokens": 10,
+					"completion_tokens": 5,
+					"total_tokens": 15
+				}
+			}`,
+			expectError: false,
+		},
+		{
+			name:        "invalid json",
+			input:       []byte(`{invalid json}`),
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := TransformFromAnthropicResponse(tt.input)
+			if (err != nil) != tt.expectError {
+				t.Errorf("TransformFromAnthropicResponse() error = %v, expectError %v", err, tt.expectError)
+				return
+			}
+
+			if !tt.expectError {
+				var gotJSON, expectedJSON map[string]interface{}
+				if err := json.Unmarshal(got, &gotJSON); err != nil {
+					t.Fatalf("Failed to unmarshal result: %v", err)
+				}
+				if err := json.Unmarshal([]byte(tt.expected), &expectedJSON); err != nil {
+					t.Fatalf("Failed to unmarshal expected: %v", err)
+				}
+
+				if !reflect.DeepEqual(gotJSON, expectedJSON) {
+					t.Errorf("TransformFromAnthropicResponse() = %v, want %v", string(got), tt.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestTransformToAnthropicResponse(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       []byte
+		expected    string
+		expectError bool
+	}{
+		{
+			name: "valid response",
+			input: []byte(`{
+				"id": "chatcmpl-1",
+				"object": "chat.completion",
+				"model": "gpt-4o",
+				"choices": [{
+					"index": 0,
+					"message": {"role": "assistant", "content": "Hello there"},
+					"finish_reason": "length"
+				}],
+				"usage": {"prompt_tokens": 10, "completion_tokens": 5, "total_tokens": 15}
+			}`),
+			expected: `{
+				"id": "chatcmpl-1",
+				"type": "message",
+				"role": "assistant",
+				"model": "gpt-4o",
+				"content": [{"type": "text", "text": "Hello there"}],
+				"stop_reason": "max_tokens",
+				"stop_sequence": null,
+				"usage": {"input_tokens": 10, "output_tokens": 5}
+			}`,
+			expectError: false,
+		},
+		{
+			name: "tool calls",
+			input: []byte(`{
+				"id": "chatcmpl-2",
+				"model": "gpt-4o",
+				"choices": [{
+					"index": 0,
+					"message": {"role": "assistant", "content": null, "tool_calls": [
+						{"id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}}
+					]},
+					"finish_reason": "tool_calls"
+				}],
+				"usage": {"prompt_tokens": 10, "completion_tokens": 5, "total_tokens": 15}
+			}`),
+			expected: `{
+				"id": "chatcmpl-2",
+				"type": "message",
+				"role": "assistant",
+				"model": "gpt-4o",
+				"content": [{"type": "tool_use", "id": "call_1", "name": "get_weather", "input": {"city": "Paris"}}],
+				"stop_reason": "tool_use",
+				"stop_sequence": null,
+				"usage": {"input_tokens": 10, "output_tokens": 5}
+			}`,
+			expectError: false,
+		},
+		{
+			name:        "invalid json",
+			input:       []byte(`{invalid json}`),
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := TransformToAnthropicResponse(tt.input)
+			if (err != nil) != tt.expectError {
+				t.Errorf("TransformToAnthropicResponse() error = %v, expectError %v", err, tt.expectError)
+				return
+			}
+
+			if !tt.expectError {
+				var gotJSON, expectedJSON map[string]interface{}
+				if err := json.Unmarshal(got, &gotJSON); err != nil {
+					t.Fatalf("Failed to unmarshal result: %v", err)
+				}
+				if err := json.Unmarshal([]byte(tt.expected), &expectedJSON); err != nil {
+					t.Fatalf("Failed to unmarshal expected: %v", err)
+				}
+				if !reflect.DeepEqual(gotJSON, expectedJSON) {
+					t.Errorf("TransformToAnthropicResponse() = %v, want %v", string(got), tt.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestTransformToBedrockRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       []byte
+		expected    string
+		expectError bool
+	}{
+		{
+			name: "OpenAI request with system prompt and parameters",
+			input: []byte(`{
+				"model": "gpt-4",
+				"messages": [
+					{"role": "system", "content": "You are helpful"},
+					{"role": "user", "content": "Hello"},
+					{"role": "user", "content": "Are you there?"},
+					{"role": "assistant", "content": "Yes"}
+				],
+				"max_tokens": 256,
+				"temperature": 0.2,
+				"stop": "END"
+			}`),
+			expected: `{
+				"system": [{"text": "You are helpful"}],
+				"messages": [
+					{"role": "user", "content": [{"text": "Hello"}, {"text": "Are you there?"}]},
+					{"role": "assistant", "content": [{"text": "Yes"}]}
+				],
+				"inferenceConfig": {
+					"maxTokens": 256,
+					"temperature": 0.2,
+					"stopSequences": ["END"]
+				}
+			}`,
+			expectError: false,
+		},
+		{
+			name: "Vertex request without parameters",
+			input: []byte(`{
+				"contents": [{"role": "user", "parts": [{"text": "Hi"}]}]
+			}`),
+			expected: `{
+				"messages": [
+					{"role": "user", "content": [{"text": "Hi"}]}
+				]
+			}`,
+			expectError: false,
+		},
+		{
+			name:        "empty body",
+			input:       []byte{},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := TransformToBedrockRequest(tt.input)
+			if (err != nil) != tt.expectError {
+				t.Errorf("TransformToBedrockRequest() error = %v, expectError %v", err, tt.expectError)
+				return
+			}
+
+			if !tt.expectError {
+				var gotJSON, expectedJSON map[string]interface{}
+				if err := json.Unmarshal(got, &gotJSON); err != nil {
+					t.Fatalf("Failed to unmarshal result: %v", err)
+				}
+				if err := json.Unmarshal([]byte(tt.expected), &expectedJSON); err != nil {
+					t.Fatalf("Failed to unmarshal expected: %v", err)
+				}
+
+				if !reflect.DeepEqual(gotJSON, expectedJSON) {
+					t.Errorf("TransformToBedrockRequest() = %v, want %v", string(got), tt.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestTransformFromBedrockResponse(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       []byte
+		expected    string
+		expectError bool
+	}{
+		{
+			name: "valid response",
+			input: []byte(`{
+				"output": {"message": {"role": "assistant", "content": [{"text": "Hello there"}]}},
+				"stopReason": "guardrail_intervened",
+				"usage": {"inputTokens": 10, "outputTokens": 5, "totalTokens": 15},
+				"metrics": {"latencyMs": 120}
+			}`),
+			expected: `{
+				"object": "chat.completion",
+				"choices": [{
+					"index": 0,
+					"message": {
+						"role": "assistant",
+						"content": "Hello there"
+					},
+					"finish_reason": "content_filter"
+				}],
+				"usage": {
+					"prompt_tokens": 10,
+					"completion_tokens": 5,
+					"total_tokens": 15
+				}
+			}`,
+			expectError: false,
+		},
+		{
+			name:        "invalid json",
+			input:       []byte(`{invalid json}`),
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := TransformFromBedrockResponse(tt.input)
+			if (err != nil) != tt.expectError {
+				t.Errorf("TransformFromBedrockResponse() error = %v, expectError %v", err, tt.expectError)
+				return
+			}
+
+			if !tt.expectError {
+				var gotJSON, expectedJSON map[string]interface{}
+				if err := json.Unmarshal(got, &gotJSON); err != nil {
+					t.Fatalf("Failed to unmarshal result: %v", err)
+				}
+				if err := json.Unmarshal([]byte(tt.expected), &expectedJSON); err != nil {
+					t.Fatalf("Failed to unmarshal expected: %v", err)
+				}
+
+				if !reflect.DeepEqual(gotJSON, expectedJSON) {
+					t.Errorf("TransformFromBedrockResponse() = %v, want %v", string(got), tt.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestTransformToBedrockResponse(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       []byte
+		expected    string
+		expectError bool
+	}{
+		{
+			name: "valid response",
+			input: []byte(`{
+				"id": "chatcmpl-1",
+				"object": "chat.completion",
+				"model": "gpt-4o",
+				"choices": [{
+					"index": 0,
+					"message": {"role": "assistant", "content": "Hello there"},
+					"finish_reason": "length"
+				}],
+				"usage": {"prompt_tokens": 10, "completion_tokens": 5, "total_tokens": 15}
+			}`),
+			expected: `{
+				"output": {
+					"message": {
+						"role": "assistant",
+						"content": [{"text": "Hello there"}]
+					}
+				},
+				"stopReason": "max_tokens",
+				"usage": {"inputTokens": 10, "outputTokens": 5, "totalTokens": 15}
+			}`,
+			expectError: false,
+		},
+		{
+			name:        "invalid json",
+			input:       []byte(`{invalid json}`),
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := TransformToBedrockResponse(tt.input)
+			if (err != nil) != tt.expectError {
+				t.Errorf("TransformToBedrockResponse() error = %v, expectError %v", err, tt.expectError)
+				return
+			}
+
+			if !tt.expectError {
+				var gotJSON, expectedJSON map[string]interface{}
+				if err := json.Unmarshal(got, &gotJSON); err != nil {
+					t.Fatalf("Failed to unmarshal result: %v", err)
+				}
+				if err := json.Unmarshal([]byte(tt.expected), &expectedJSON); err != nil {
+					t.Fatalf("Failed to unmarshal expected: %v", err)
+				}
+				if !reflect.DeepEqual(gotJSON, expectedJSON) {
+					t.Errorf("TransformToBedrockResponse() = %v, want %v", string(got), tt.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestWithStream(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "stream set",
+			body: `{"model":"gpt-4o","messages":[{"role":"user","content":"Hello"}]}`,
+			want: `{"messages":[{"role":"user","content":"Hello"}],"model":"gpt-4o","stream":true}`,
+		},
+		{
+			// Anthropic bodies are sent as the caller wrote them, large integers included
+			name: "streaming body unchanged",
+			body: `{"model": "claude-3-5-sonnet", "max_tokens": 1024, "metadata": {"user_id": "u1"}, "stream": true, "messages": [{"role": "user", "content": "Hello"}], "seed": 12345678901234567890}`,
+			want: `{"model": "claude-3-5-sonnet", "max_tokens": 1024, "metadata": {"user_id": "u1"}, "stream": true, "messages": [{"role": "user", "content": "Hello"}], "seed": 12345678901234567890}`,
+		},
+		{
+			name: "vertex body unchanged",
+			body: `{"contents":[{"role":"user","parts":[{"text":"Hello"}]}]}`,
+			want: `{"contents":[{"role":"user","parts":[{"text":"Hello"}]}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := WithStream([]byte(tt.body))
+			if err != nil {
+				t.Fatalf("WithStream() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("WithStream() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithModel(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "model replaced",
+			body: `{"model":"claude-3-5-sonnet","max_tokens":1024,"messages":[{"role":"user","content":"Hello"}]}`,
+			want: `{"max_tokens":1024,"messages":[{"role":"user","content":"Hello"}],"model":"claude-3-5-haiku"}`,
+		},
+		{
+			name: "body without model unchanged",
+			body: `{"messages": [{"role": "user", "content": [{"text": "Hello"}]}]}`,
+			want: `{"messages": [{"role": "user", "content": [{"text": "Hello"}]}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := WithModel([]byte(tt.body), "claude-3-5-haiku")
+			if err != nil {
+				t.Fatalf("WithModel() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("WithModel() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+package request
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// vertexSchemaKeywords are the JSON Schema keywords that the Vertex AI schema, an
+// OpenAPI 3.0 subset, shares with JSON Schema and that are copied as they are.
+var vertexSchemaKeywords = map[string]bool{
+	"format":           true,
+	"title":            true,
+	"description":      true,
+	"nullable":         true,
+	"default":          true,
+	"enum":             true,
+	"required":         true,
+	"minItems":         true,
+	"maxItems":         true,
+	"minProperties":    true,
+	"maxProperties":    true,
+	"minLength":        true,
+	"maxLength":        true,
+	"pattern":          true,
+	"example":          true,
+	"minimum":          true,
+	"maximum":          true,
+	"propertyOrdering": true,
+}
+
+// schemaConverter converts a JSON Schema into a Vertex AI schema, inlining the
+// local definitions referenced with $ref.
+type schemaConverter struct {
+	defs      map[string]interface{}
+	resolving map[string]bool
+}
+
+// toVertexSchema converts a JSON Schema into a Vertex AI schema. Type lists with
+// null become nullable, const becomes a single value enum, oneOf becomes anyOf and
+// local $ref definitions are inlined. Keywords Vertex AI has no equivalent for,
+// and recursive definitions, are an error rather than being dropped.
+func toVertexSchema(schema interface{}) (interface{}, error) {
+	converter := &schemaConverter{resolving: make(map[string]bool)}
+	if root, ok := schema.(map[string]interface{}); ok {
+		converter.defs, _ = root["$defs"].(map[string]interface{})
+		if converter.defs == nil {
+			converter.defs, _ = root["definitions"].(map[string]interface{})
+		}
+	}
+	return converter.convert(schema, "#")
+}
+
+func (c *schemaConverter) convert(schema interface{}, path string) (interface{}, error) {
+	s, ok := schema.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("schema at %s is not an object", path)
+	}
+
+	if ref, ok := s["$ref"].(string); ok {
+		name, ok := strings.CutPrefix(ref, "#/$defs/")
+		if !ok {
+			name, ok = strings.CutPrefix(ref, "#/definitions/")
+		}
+		if !ok {
+			return nil, fmt.Errorf("unsupported $ref %q at %s, only local definitions are supported", ref, path)
+		}
+		def, ok := c.defs[name]
+		if !ok {
+			return nil, fmt.Errorf("undefined $ref %q at %s", ref, path)
+		}
+		if c.resolving[name] {
+			return nil, fmt.Errorf("recursive $ref %q at %s is not supported by Vertex AI", ref, path)
+		}
+		c.resolving[name] = true
+		defer delete(c.resolving, name)
+		return c.convert(def, path)
+	}
+
+	keys := make([]string, 0, len(s))
+	for key := range s {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := make(map[string]interface{}, len(s))
+	for _, key := range keys {
+		value := s[key]
+		switch key {
+		case "$schema", "$id", "$comment", "$defs", "definitions":
+			continue
+		case "additionalProperties":
+			// Vertex AI never allows additional properties
+			if value != false {
+				return nil, fmt.Errorf("unsupported additionalProperties at %s, only false is supported", path)
+			}
+		case "type":
+			if err := convertSchemaType(result, value, path); err != nil {
+				return nil, err
+			}
+		case "const":
+			result["enum"] = []interface{}{value}
+		case "anyOf", "oneOf":
+			options, ok := value.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s at %s is not a list", key, path)
+			}
+			converted := make([]interface{}, len(options))
+			for i, option := range options {
+				var err error
+				if converted[i], err = c.convert(option, fmt.Sprintf("%s/%s/%d", path, key, i)); err != nil {
+					return nil, err
+				}
+			}
+			result["anyOf"] = converted
+		case "properties":
+			properties, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("properties at %s is not an object", path)
+			}
+			converted := make(map[string]interface{}, len(properties))
+			for name, property := range properties {
+				var err error
+				if converted[name], err = c.convert(property, path+"/properties/"+name); err != nil {
+					return nil, err
+				}
+			}
+			result["properties"] = converted
+		case "items":
+			converted, err := c.convert(value, path+"/items")
+			if err != nil {
+				return nil, err
+			}
+			result["items"] = converted
+		default:
+			if !vertexSchemaKeywords[key] {
+				return nil, fmt.Errorf("unsupported JSON schema keyword %q at %s", key, path)
+			}
+			result[key] = value
+		}
+	}
+	return result, nil
+}
+
+// convertSchemaType sets the type of a Vertex AI schema. A list of one type and
+// null becomes a nullable type.
+func convertSchemaType(result map[string]interface{}, value interface{}, path string) error {
+	types, ok := value.([]interface{})
+	if !ok {
+		result["type"] = value
+		return nil
+	}
+
+	var nonNull []interface{}
+	for _, t := range types {
+		if t == "null" {
+			result["nullable"] = true
+			continue
+		}
+		nonNull = append(nonNull, t)
+	}
+	if len(nonNull) != 1 {
+		return fmt.Errorf("unsupported type list %v at %s, only one type and null are supported", types, path)
+	}
+	result["type"] = nonNull[0]
+	return nil
+}
+
+// fromVertexSchema converts a Vertex AI schema into a JSON Schema. Types are
+// lowercased and nullable types become a type list with null.
+func fromVertexSchema(schema interface{}) interface{} {
+	s, ok := schema.(map[string]interface{})
+	if !ok {
+		return schema
+	}
+
+	result := make(map[string]interface{}, len(s))
+	for key, value := range s {
+		switch key {
+		case "nullable", "propertyOrdering":
+			continue
+		case "type":
+			if t, ok := value.(string); ok {
+				value = strings.ToLower(t)
+			}
+		case "properties":
+			if properties, ok := value.(map[string]interface{}); ok {
+				converted := make(map[string]interface{}, len(properties))
+				for name, property := range properties {
+					converted[name] = fromVertexSchema(property)
+				}
+				value = converted
+			}
+		case "items":
+			value = fromVertexSchema(value)
+		case "anyOf":
+			if options, ok := value.([]interface{}); ok {
+				converted := make([]interface{}, len(options))
+				for i, option := range options {
+					converted[i] = fromVertexSchema(option)
+				}
+				value = converted
+			}
+		}
+		result[key] = value
+	}
+
+	if s["nullable"] == true {
+		if t, ok := result["type"]; ok {
+			result["type"] = []interface{}{t, "null"}
+		}
+	}
+	return result
+}
+
+// vertexResponseFormat transforms an OpenAI response_format into the Vertex AI
+// responseMimeType and responseSchema. Text responses have neither.
+func vertexResponseFormat(responseFormat json.RawMessage) (string, interface{}, error) {
+	if len(responseFormat) == 0 || string(responseFormat) == "null" {
+		return "", nil, nil
+	}
+
+	var format struct {
+		Type       string `json:"type"`
+		JSONSchema struct {
+			Name   string      `json:"name"`
+			Schema interface{} `json:"schema"`
+		} `json:"json_schema"`
+	}
+	if err := json.Unmarshal(responseFormat, &format); err != nil {
+		return "", nil, fmt.Errorf("invalid response_format: %w", err)
+	}
+
+	switch format.Type {
+	case "", "text":
+		return "", nil, nil
+	case "json_object":
+		return "application/json", nil, nil
+	case "json_schema":
+		if format.JSONSchema.Schema == nil {
+			return "application/json", nil, nil
+		}
+		schema, err := toVertexSchema(format.JSONSchema.Schema)
+		if err != nil {
+			return "", nil, fmt.Errorf("response_format schema %s cannot be sent to Vertex AI: %w", format.JSONSchema.Name, err)
+		}
+		return "application/json", schema, nil
+	default:
+		return "", nil, fmt.Errorf("unsupported response_format type %q", format.Type)
+	}
+}
+
+// openAIResponseFormat transforms the Vertex AI responseMimeType and responseSchema
+// of a generation config into an OpenAI response_format, or nil for text responses.
+func openAIResponseFormat(generationConfig map[string]json.RawMessage) map[string]interface{} {
+	var mimeType string
+	if err := json.Unmarshal(generationConfig["responseMimeType"], &mimeType); err != nil || mimeType != "application/json" {
+		return nil
+	}
+
+	var schema interface{}
+	if err := json.Unmarshal(generationConfig["responseSchema"], &schema); err != nil || schema == nil {
+		return map[string]interface{}{"type": "json_object"}
+	}
+	return map[string]interface{}{
+		"type": "json_schema",
+		"json_schema": map[string]interface{}{
+			"name":   "response",
+			"schema": fromVertexSchema(schema),
+		},
+	}
+}
@@ -0,0 +1,135 @@
+package request
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestToVertexSchema(t *testing.T) {
+	tests := []struct {
+		name        string
+		schema      string
+		expected    string
+		errContains string
+	}{
+		{
+			name: "json schema differences",
+			schema: `{
+				"$schema": "https://json-schema.org/draft/2020-12/schema",
+				"type": "object",
+				"properties": {
+					"name": {"type": "string", "description": "Full name"},
+					"nickname": {"type": ["string", "null"]},
+					"kind": {"const": "person"},
+					"contact": {"oneOf": [{"type": "string", "format": "email"}, {"type": "integer"}]}
+				},
+				"required": ["name"],
+				"additionalProperties": false
+			}`,
+			expected: `{
+				"type": "object",
+				"properties": {
+					"name": {"type": "string", "description": "Full name"},
+					"nickname": {"type": "string", "nullable": true},
+					"kind": {"enum": ["person"]},
+					"contact": {"anyOf": [{"type": "string", "format": "email"}, {"type": "integer"}]}
+				},
+				"required": ["name"]
+			}`,
+		},
+		{
+			name: "local definitions are inlined",
+			schema: `{
+				"type": "array",
+				"items": {"$ref": "#/$defs/Step"},
+				"$defs": {"Step": {"type": "object", "properties": {"text": {"type": "string"}}}}
+			}`,
+			expected: `{
+				"type": "array",
+				"items": {"type": "object", "properties": {"text": {"type": "string"}}}
+			}`,
+		},
+		{
+			name: "recursive definition",
+			schema: `{
+				"$ref": "#/$defs/Node",
+				"$defs": {"Node": {"type": "object", "properties": {"children": {"type": "array", "items": {"$ref": "#/$defs/Node"}}}}}
+			}`,
+			errContains: `recursive $ref "#/$defs/Node" at #/properties/children/items`,
+		},
+		{
+			name:        "unsupported keyword",
+			schema:      `{"type": "object", "properties": {"tags": {"type": "array", "uniqueItems": true}}}`,
+			errContains: `unsupported JSON schema keyword "uniqueItems" at #/properties/tags`,
+		},
+		{
+			name:        "additional properties schema",
+			schema:      `{"type": "object", "additionalProperties": {"type": "string"}}`,
+			errContains: "unsupported additionalProperties at #",
+		},
+		{
+			name:        "several types",
+			schema:      `{"type": ["string", "integer"]}`,
+			errContains: "unsupported type list",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var schema interface{}
+			if err := json.Unmarshal([]byte(tt.schema), &schema); err != nil {
+				t.Fatalf("Failed to unmarshal schema: %v", err)
+			}
+
+			got, err := toVertexSchema(schema)
+			if tt.errContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+					t.Fatalf("toVertexSchema() error = %v, want error containing %q", err, tt.errContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("toVertexSchema() error = %v", err)
+			}
+
+			var expected interface{}
+			if err := json.Unmarshal([]byte(tt.expected), &expected); err != nil {
+				t.Fatalf("Failed to unmarshal expected: %v", err)
+			}
+			if !reflect.DeepEqual(got, expected) {
+				gotJSON, _ := json.Marshal(got)
+				t.Errorf("toVertexSchema() = %s, want %s", gotJSON, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFromVertexSchema(t *testing.T) {
+	var schema, expected interface{}
+	json.Unmarshal([]byte(`{
+		"type": "OBJECT",
+		"properties": {
+			"name": {"type": "STRING"},
+			"nickname": {"type": "STRING", "nullable": true},
+			"tags": {"type": "ARRAY", "items": {"type": "STRING"}}
+		},
+		"propertyOrdering": ["name", "nickname", "tags"],
+		"required": ["name"]
+	}`), &schema)
+	json.Unmarshal([]byte(`{
+		"type": "object",
+		"properties": {
+			"name": {"type": "string"},
+			"nickname": {"type": ["string", "null"]},
+			"tags": {"type": "array", "items": {"type": "string"}}
+		},
+		"required": ["name"]
+	}`), &expected)
+
+	if got := fromVertexSchema(schema); !reflect.DeepEqual(got, expected) {
+		gotJSON, _ := json.Marshal(got)
+		t.Errorf("fromVertexSchema() = %s", gotJSON)
+	}
+}
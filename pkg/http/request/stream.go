@@ -0,0 +1,595 @@
+package request
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Not-Diamond/go-notdiamond/pkg/model"
+)
+
+// VertexStreamDecoder translates the events of one streamed Vertex AI response into
+// OpenAI chat.completion.chunks. Vertex AI function calls have no ids, so tool calls
+// are numbered across the whole stream to keep their ids and indexes stable.
+type VertexStreamDecoder struct {
+	toolCalls map[int]int // Tool calls decoded so far, by candidate index
+}
+
+// NewVertexStreamDecoder returns a decoder for a single stream.
+func NewVertexStreamDecoder() *VertexStreamDecoder {
+	return &VertexStreamDecoder{toolCalls: make(map[int]int)}
+}
+
+// Decode transforms a streamed Vertex AI response into an OpenAI chunk.
+func (d *VertexStreamDecoder) Decode(data []byte) ([]byte, error) {
+	var vertexChunk struct {
+		Candidates []struct {
+			Index   int `json:"index"`
+			Content struct {
+				Parts []vertexPart `json:"parts"`
+			} `json:"content"`
+			FinishReason string `json:"finishReason"`
+		} `json:"candidates"`
+		UsageMetadata *struct {
+			PromptTokenCount     int `json:"promptTokenCount"`
+			CandidatesTokenCount int `json:"candidatesTokenCount"`
+			TotalTokenCount      int `json:"totalTokenCount"`
+		} `json:"usageMetadata"`
+		ModelVersion string `json:"modelVersion"`
+		ResponseID   string `json:"responseId"`
+	}
+	if err := json.Unmarshal(data, &vertexChunk); err != nil {
+		return nil, err
+	}
+
+	choices := make([]map[string]interface{}, 0, len(vertexChunk.Candidates))
+	for _, candidate := range vertexChunk.Candidates {
+		texts := make([]string, 0, len(candidate.Content.Parts))
+		var toolCalls []map[string]interface{}
+		for _, part := range candidate.Content.Parts {
+			if part.FunctionCall != nil {
+				n := d.toolCalls[candidate.Index]
+				d.toolCalls[candidate.Index]++
+				toolCall := openAIToolCallFromVertex(openAIToolCallID(n), part.FunctionCall.Name, part.FunctionCall.Args)
+				toolCall["index"] = n
+				toolCalls = append(toolCalls, toolCall)
+				continue
+			}
+			texts = append(texts, part.Text)
+		}
+
+		var finishReason interface{}
+		if candidate.FinishReason != "" {
+			reason, ok := vertexFinishReasons[candidate.FinishReason]
+			if !ok {
+				reason = strings.ToLower(candidate.FinishReason)
+			}
+			// The function calls may have come in earlier events of the stream
+			if reason == "stop" && d.toolCalls[candidate.Index] > 0 {
+				reason = "tool_calls"
+			}
+			finishReason = reason
+		}
+
+		delta := map[string]interface{}{
+			"role":    "assistant",
+			"content": strings.Join(texts, ""),
+		}
+		if len(toolCalls) > 0 {
+			delta["tool_calls"] = toolCalls
+		}
+		choices = append(choices, map[string]interface{}{
+			"index":         candidate.Index,
+			"delta":         delta,
+			"finish_reason": finishReason,
+		})
+	}
+
+	openAIChunk := map[string]interface{}{
+		"object":  "chat.completion.chunk",
+		"choices": choices,
+	}
+	if vertexChunk.ResponseID != "" {
+		openAIChunk["id"] = vertexChunk.ResponseID
+	}
+	if vertexChunk.ModelVersion != "" {
+		openAIChunk["model"] = vertexChunk.ModelVersion
+	}
+	if usage := vertexChunk.UsageMetadata; usage != nil {
+		openAIChunk["usage"] = map[string]interface{}{
+			"prompt_tokens":     usage.PromptTokenCount,
+			"completion_tokens": usage.CandidatesTokenCount,
+			"total_tokens":      usage.TotalTokenCount,
+		}
+	}
+
+	return json.Marshal(openAIChunk)
+}
+
+// openAIToolCallDelta is a fragment of a tool call in an OpenAI chunk. The first
+// fragment of a call carries its id and name, later ones more of its arguments.
+type openAIToolCallDelta struct {
+	Index    int    `json:"index"`
+	ID       string `json:"id"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// VertexStreamEncoder translates the OpenAI chunks of one stream into streamed
+// Vertex AI responses. OpenAI streams tool call arguments in fragments while
+// Vertex AI sends whole function calls, so tool calls are collected and sent when
+// their choice finishes, or when the stream ends.
+type VertexStreamEncoder struct {
+	toolCalls map[int]map[int]*openAIToolCall // Unsent tool calls, by choice and tool call index
+}
+
+// NewVertexStreamEncoder returns an encoder for a single stream.
+func NewVertexStreamEncoder() *VertexStreamEncoder {
+	return &VertexStreamEncoder{toolCalls: make(map[int]map[int]*openAIToolCall)}
+}
+
+// Encode transforms an OpenAI chunk into a streamed Vertex AI response. Chunks
+// without content, function calls, finish reason or usage, such as the first chunk
+// carrying only the role or chunks with tool call fragments, are dropped. The
+// [DONE] event sends the tool calls of choices that never finished.
+func (e *VertexStreamEncoder) Encode(data []byte) ([]byte, error) {
+	if string(data) == "[DONE]" {
+		return e.flush()
+	}
+
+	var openAIChunk struct {
+		ID      string `json:"id"`
+		Model   string `json:"model"`
+		Choices []struct {
+			Index int `json:"index"`
+			Delta struct {
+				Content   string                `json:"content"`
+				ToolCalls []openAIToolCallDelta `json:"tool_calls"`
+			} `json:"delta"`
+			FinishReason string `json:"finish_reason"`
+		} `json:"choices"`
+		Usage *struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+			TotalTokens      int `json:"total_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.Unmarshal(data, &openAIChunk); err != nil {
+		return nil, err
+	}
+
+	candidates := make([]map[string]interface{}, 0, len(openAIChunk.Choices))
+	for _, choice := range openAIChunk.Choices {
+		e.collect(choice.Index, choice.Delta.ToolCalls)
+
+		var functionCalls []map[string]interface{}
+		if choice.FinishReason != "" {
+			var err error
+			if functionCalls, err = e.take(choice.Index); err != nil {
+				return nil, err
+			}
+		}
+		if choice.Delta.Content == "" && len(functionCalls) == 0 && choice.FinishReason == "" {
+			continue
+		}
+
+		var parts []map[string]interface{}
+		if choice.Delta.Content != "" || len(functionCalls) == 0 {
+			parts = append(parts, map[string]interface{}{"text": choice.Delta.Content})
+		}
+		parts = append(parts, functionCalls...)
+
+		candidate := map[string]interface{}{
+			"index": choice.Index,
+			"content": map[string]interface{}{
+				"role":  "model",
+				"parts": parts,
+			},
+		}
+		if choice.FinishReason != "" {
+			finishReason, ok := openAIToVertexFinishReasons[choice.FinishReason]
+			if !ok {
+				finishReason = strings.ToUpper(choice.FinishReason)
+			}
+			candidate["finishReason"] = finishReason
+		}
+		candidates = append(candidates, candidate)
+	}
+	if len(candidates) == 0 && openAIChunk.Usage == nil {
+		return nil, nil
+	}
+
+	vertexChunk := map[string]interface{}{
+		"candidates": candidates,
+	}
+	if usage := openAIChunk.Usage; usage != nil {
+		totalTokens := usage.TotalTokens
+		if totalTokens == 0 {
+			totalTokens = usage.PromptTokens + usage.CompletionTokens
+		}
+		vertexChunk["usageMetadata"] = map[string]interface{}{
+			"promptTokenCount":     usage.PromptTokens,
+			"candidatesTokenCount": usage.CompletionTokens,
+			"totalTokenCount":      totalTokens,
+		}
+	}
+	if openAIChunk.Model != "" {
+		vertexChunk["modelVersion"] = openAIChunk.Model
+	}
+	if openAIChunk.ID != "" {
+		vertexChunk["responseId"] = openAIChunk.ID
+	}
+
+	return json.Marshal(vertexChunk)
+}
+
+// collect adds the tool call fragments of a choice to its unsent tool calls.
+func (e *VertexStreamEncoder) collect(choice int, deltas []openAIToolCallDelta) {
+	for _, delta := range deltas {
+		calls, ok := e.toolCalls[choice]
+		if !ok {
+			calls = make(map[int]*openAIToolCall)
+			e.toolCalls[choice] = calls
+		}
+		call, ok := calls[delta.Index]
+		if !ok {
+			call = &openAIToolCall{Type: "function"}
+			calls[delta.Index] = call
+		}
+		if delta.ID != "" {
+			call.ID = delta.ID
+		}
+		if delta.Function.Name != "" {
+			call.Function.Name = delta.Function.Name
+		}
+		call.Function.Arguments += delta.Function.Arguments
+	}
+}
+
+// take returns the unsent tool calls of a choice as functionCall parts, in the
+// order of their indexes, and forgets them.
+func (e *VertexStreamEncoder) take(choice int) ([]map[string]interface{}, error) {
+	calls := e.toolCalls[choice]
+	delete(e.toolCalls, choice)
+
+	indexes := make([]int, 0, len(calls))
+	for index := range calls {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	parts := make([]map[string]interface{}, 0, len(calls))
+	for _, index := range indexes {
+		part, err := vertexFunctionCall(*calls[index])
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+	}
+	return parts, nil
+}
+
+// flush returns a streamed Vertex AI response with the tool calls of the choices
+// that never finished, or nil if there are none.
+func (e *VertexStreamEncoder) flush() ([]byte, error) {
+	choices := make([]int, 0, len(e.toolCalls))
+	for choice := range e.toolCalls {
+		choices = append(choices, choice)
+	}
+	if len(choices) == 0 {
+		return nil, nil
+	}
+	sort.Ints(choices)
+
+	candidates := make([]map[string]interface{}, 0, len(choices))
+	for _, choice := range choices {
+		parts, err := e.take(choice)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, map[string]interface{}{
+			"index": choice,
+			"content": map[string]interface{}{
+				"role":  "model",
+				"parts": parts,
+			},
+		})
+	}
+	return json.Marshal(map[string]interface{}{"candidates": candidates})
+}
+
+// AnthropicStreamDecoder translates the events of one streamed Anthropic response
+// into OpenAI chat.completion.chunks. The id, model and prompt tokens only come with
+// message_start, and tool_use blocks are numbered as tool calls across the stream.
+type AnthropicStreamDecoder struct {
+	id          string
+	model       string
+	inputTokens int
+	toolCalls   map[int]int // Tool call index of tool_use blocks, by content block index
+}
+
+// NewAnthropicStreamDecoder returns a decoder for a single stream.
+func NewAnthropicStreamDecoder() *AnthropicStreamDecoder {
+	return &AnthropicStreamDecoder{toolCalls: make(map[int]int)}
+}
+
+// Decode transforms a streamed Anthropic event into an OpenAI chunk. Events without
+// an OpenAI equivalent, such as ping, content_block_stop and message_stop, or
+// thinking deltas, are dropped. An error event is returned as an error.
+func (d *AnthropicStreamDecoder) Decode(data []byte) ([]byte, error) {
+	var event struct {
+		Type    string `json:"type"`
+		Index   int    `json:"index"`
+		Message struct {
+			ID    string `json:"id"`
+			Model string `json:"model"`
+			Usage struct {
+				InputTokens int `json:"input_tokens"`
+			} `json:"usage"`
+		} `json:"message"`
+		ContentBlock anthropicBlock `json:"content_block"`
+		Delta        struct {
+			Type        string `json:"type"`
+			Text        string `json:"text"`
+			PartialJSON string `json:"partial_json"`
+			StopReason  string `json:"stop_reason"`
+		} `json:"delta"`
+		Usage struct {
+			OutputTokens int `json:"output_tokens"`
+		} `json:"usage"`
+		Error struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil, err
+	}
+
+	switch event.Type {
+	case "message_start":
+		d.id = event.Message.ID
+		d.model = event.Message.Model
+		d.inputTokens = event.Message.Usage.InputTokens
+		return d.chunk(map[string]interface{}{"role": "assistant", "content": ""}, nil, nil)
+
+	case "content_block_start":
+		switch event.ContentBlock.Type {
+		case "tool_use":
+			n := len(d.toolCalls)
+			d.toolCalls[event.Index] = n
+			toolCall := map[string]interface{}{
+				"index": n,
+				"id":    event.ContentBlock.ID,
+				"type":  "function",
+				"function": map[string]interface{}{
+					"name":      event.ContentBlock.Name,
+					"arguments": "",
+				},
+			}
+			return d.chunk(map[string]interface{}{"tool_calls": []map[string]interface{}{toolCall}}, nil, nil)
+		case "text":
+			if event.ContentBlock.Text != "" {
+				return d.chunk(map[string]interface{}{"content": event.ContentBlock.Text}, nil, nil)
+			}
+		}
+		return nil, nil
+
+	case "content_block_delta":
+		switch event.Delta.Type {
+		case "text_delta":
+			return d.chunk(map[string]interface{}{"content": event.Delta.Text}, nil, nil)
+		case "input_json_delta":
+			toolCall := map[string]interface{}{
+				"index":    d.toolCalls[event.Index],
+				"function": map[string]interface{}{"arguments": event.Delta.PartialJSON},
+			}
+			return d.chunk(map[string]interface{}{"tool_calls": []map[string]interface{}{toolCall}}, nil, nil)
+		}
+		return nil, nil
+
+	case "message_delta":
+		finishReason, ok := anthropicFinishReasons[event.Delta.StopReason]
+		if !ok {
+			finishReason = event.Delta.StopReason
+		}
+		usage := map[string]interface{}{
+			"prompt_tokens":     d.inputTokens,
+			"completion_tokens": event.Usage.OutputTokens,
+			"total_tokens":      d.inputTokens + event.Usage.OutputTokens,
+		}
+		return d.chunk(map[string]interface{}{}, finishReason, usage)
+
+	case "error":
+		return nil, fmt.Errorf("anthropic stream error: %s: %s", event.Error.Type, event.Error.Message)
+	}
+	return nil, nil
+}
+
+// chunk returns an OpenAI chunk with a single choice.
+func (d *AnthropicStreamDecoder) chunk(delta map[string]interface{}, finishReason interface{}, usage map[string]interface{}) ([]byte, error) {
+	openAIChunk := map[string]interface{}{
+		"object": "chat.completion.chunk",
+		"choices": []map[string]interface{}{{
+			"index":         0,
+			"delta":         delta,
+			"finish_reason": finishReason,
+		}},
+	}
+	if d.id != "" {
+		openAIChunk["id"] = d.id
+	}
+	if d.model != "" {
+		openAIChunk["model"] = d.model
+	}
+	if usage != nil {
+		openAIChunk["usage"] = usage
+	}
+	return json.Marshal(openAIChunk)
+}
+
+// AnthropicStreamEncoder translates the OpenAI chunks of one stream into the events
+// of a streamed Anthropic message. Content and tool calls become content blocks,
+// each closed when the next one starts. The stop reason and usage are sent with
+// message_delta at the end of the stream, since OpenAI sends usage after the last
+// choice. Only the first choice is kept, Anthropic streams a single message.
+type AnthropicStreamEncoder struct {
+	started      bool
+	blocks       int         // Content blocks started so far
+	open         string      // Type of the open content block, "" if none
+	toolBlocks   map[int]int // Content block index of tool calls, by tool call index
+	stopReason   interface{}
+	inputTokens  int
+	outputTokens int
+}
+
+// NewAnthropicStreamEncoder returns an encoder for a single stream.
+func NewAnthropicStreamEncoder() *AnthropicStreamEncoder {
+	return &AnthropicStreamEncoder{toolBlocks: make(map[int]int)}
+}
+
+// Encode transforms an OpenAI chunk into Anthropic events, starting the message
+// with the first chunk. [DONE] closes the open content block and ends the message.
+func (e *AnthropicStreamEncoder) Encode(data []byte) ([]model.StreamEvent, error) {
+	var events []map[string]interface{}
+	if string(data) == "[DONE]" {
+		events = append(events, e.start("", "")...)
+		events = append(events, e.closeBlock()...)
+		events = append(events,
+			map[string]interface{}{
+				"type":  "message_delta",
+				"delta": map[string]interface{}{"stop_reason": e.stopReason, "stop_sequence": nil},
+				"usage": map[string]interface{}{"input_tokens": e.inputTokens, "output_tokens": e.outputTokens},
+			},
+			map[string]interface{}{"type": "message_stop"},
+		)
+		return anthropicStreamEvents(events)
+	}
+
+	var openAIChunk struct {
+		ID      string `json:"id"`
+		Model   string `json:"model"`
+		Choices []struct {
+			Index int `json:"index"`
+			Delta struct {
+				Content   string                `json:"content"`
+				ToolCalls []openAIToolCallDelta `json:"tool_calls"`
+			} `json:"delta"`
+			FinishReason string `json:"finish_reason"`
+		} `json:"choices"`
+		Usage *struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.Unmarshal(data, &openAIChunk); err != nil {
+		return nil, err
+	}
+	if usage := openAIChunk.Usage; usage != nil {
+		e.inputTokens = usage.PromptTokens
+		e.outputTokens = usage.CompletionTokens
+	}
+	events = append(events, e.start(openAIChunk.ID, openAIChunk.Model)...)
+
+	for _, choice := range openAIChunk.Choices {
+		if choice.Index != 0 {
+			continue
+		}
+		if choice.Delta.Content != "" {
+			if e.open != "text" {
+				events = append(events, e.closeBlock()...)
+				events = append(events, e.openBlock("text", map[string]interface{}{"type": "text", "text": ""}))
+			}
+			events = append(events, map[string]interface{}{
+				"type":  "content_block_delta",
+				"index": e.blocks - 1,
+				"delta": map[string]interface{}{"type": "text_delta", "text": choice.Delta.Content},
+			})
+		}
+		for _, delta := range choice.Delta.ToolCalls {
+			if _, ok := e.toolBlocks[delta.Index]; !ok {
+				events = append(events, e.closeBlock()...)
+				e.toolBlocks[delta.Index] = e.blocks
+				events = append(events, e.openBlock("tool_use", map[string]interface{}{
+					"type":  "tool_use",
+					"id":    delta.ID,
+					"name":  delta.Function.Name,
+					"input": map[string]interface{}{},
+				}))
+			}
+			if delta.Function.Arguments != "" {
+				events = append(events, map[string]interface{}{
+					"type":  "content_block_delta",
+					"index": e.toolBlocks[delta.Index],
+					"delta": map[string]interface{}{"type": "input_json_delta", "partial_json": delta.Function.Arguments},
+				})
+			}
+		}
+		if choice.FinishReason != "" {
+			if reason, ok := openAIToAnthropicFinishReasons[choice.FinishReason]; ok {
+				e.stopReason = reason
+			} else {
+				e.stopReason = choice.FinishReason
+			}
+			events = append(events, e.closeBlock()...)
+		}
+	}
+	return anthropicStreamEvents(events)
+}
+
+// start returns the message_start event if the message has not started yet.
+func (e *AnthropicStreamEncoder) start(id, modelName string) []map[string]interface{} {
+	if e.started {
+		return nil
+	}
+	e.started = true
+	return []map[string]interface{}{{
+		"type": "message_start",
+		"message": map[string]interface{}{
+			"id":            id,
+			"type":          "message",
+			"role":          "assistant",
+			"model":         modelName,
+			"content":       []interface{}{},
+			"stop_reason":   nil,
+			"stop_sequence": nil,
+			"usage":         map[string]interface{}{"input_tokens": e.inputTokens, "output_tokens": 0},
+		},
+	}}
+}
+
+// openBlock returns the content_block_start event of a new content block.
+func (e *AnthropicStreamEncoder) openBlock(blockType string, block map[string]interface{}) map[string]interface{} {
+	e.open = blockType
+	e.blocks++
+	return map[string]interface{}{
+		"type":          "content_block_start",
+		"index":         e.blocks - 1,
+		"content_block": block,
+	}
+}
+
+// closeBlock returns the content_block_stop event of the open content block, if any.
+func (e *AnthropicStreamEncoder) closeBlock() []map[string]interface{} {
+	if e.open == "" {
+		return nil
+	}
+	e.open = ""
+	return []map[string]interface{}{{"type": "content_block_stop", "index": e.blocks - 1}}
+}
+
+// anthropicStreamEvents marshals Anthropic events, named after their type.
+func anthropicStreamEvents(events []map[string]interface{}) ([]model.StreamEvent, error) {
+	streamEvents := make([]model.StreamEvent, 0, len(events))
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return nil, err
+		}
+		streamEvents = append(streamEvents, model.StreamEvent{Type: event["type"].(string), Data: data})
+	}
+	return streamEvents, nil
+}
@@ -0,0 +1,195 @@
+package request
+
+import "testing"
+
+func TestVertexStreamDecoder(t *testing.T) {
+	events := []string{
+		`{"candidates": [{"content": {"role": "model", "parts": [{"functionCall": {"name": "get_weather", "args": {"city": "Paris"}}}]}}]}`,
+		`{"candidates": [{"content": {"role": "model", "parts": [{"functionCall": {"name": "get_time", "args": {"zone": "CET"}}}]}}]}`,
+		`{"candidates": [{"content": {"role": "model", "parts": [{"text": ""}]}, "finishReason": "STOP"}]}`,
+	}
+	want := []string{
+		`{"object": "chat.completion.chunk", "choices": [{"index": 0, "finish_reason": null, "delta": {"role": "assistant", "content": "", "tool_calls": [
+			{"index": 0, "id": "call_0", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}}
+		]}}]}`,
+		`{"object": "chat.completion.chunk", "choices": [{"index": 0, "finish_reason": null, "delta": {"role": "assistant", "content": "", "tool_calls": [
+			{"index": 1, "id": "call_1", "type": "function", "function": {"name": "get_time", "arguments": "{\"zone\":\"CET\"}"}}
+		]}}]}`,
+		`{"object": "chat.completion.chunk", "choices": [{"index": 0, "finish_reason": "tool_calls", "delta": {"role": "assistant", "content": ""}}]}`,
+	}
+
+	decoder := NewVertexStreamDecoder()
+	for i, event := range events {
+		got, err := decoder.Decode([]byte(event))
+		if err != nil {
+			t.Fatalf("Decode() event %d error = %v", i, err)
+		}
+		assertJSONEqual(t, got, want[i])
+	}
+}
+
+func TestVertexStreamEncoder(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		want   []string
+	}{
+		{
+			name: "tool call arguments collected until the choice finishes",
+			chunks: []string{
+				`{"choices": [{"index": 0, "delta": {"role": "assistant", "content": null, "tool_calls": [{"index": 0, "id": "call_abc", "type": "function", "function": {"name": "get_weather", "arguments": ""}}]}, "finish_reason": null}]}`,
+				`{"choices": [{"index": 0, "delta": {"tool_calls": [{"index": 0, "function": {"arguments": "{\"city\":"}}]}, "finish_reason": null}]}`,
+				`{"choices": [{"index": 0, "delta": {"tool_calls": [{"index": 1, "id": "call_def", "type": "function", "function": {"name": "get_time", "arguments": "{}"}}]}, "finish_reason": null}]}`,
+				`{"choices": [{"index": 0, "delta": {"tool_calls": [{"index": 0, "function": {"arguments": "\"Paris\"}"}}]}, "finish_reason": null}]}`,
+				`{"choices": [{"index": 0, "delta": {}, "finish_reason": "tool_calls"}]}`,
+				`[DONE]`,
+			},
+			want: []string{"", "", "", "",
+				`{"candidates": [{"index": 0, "finishReason": "STOP", "content": {"role": "model", "parts": [
+					{"functionCall": {"name": "get_weather", "args": {"city": "Paris"}}},
+					{"functionCall": {"name": "get_time", "args": {}}}
+				]}}]}`,
+				"",
+			},
+		},
+		{
+			name: "tool calls of an unfinished choice sent at the end of the stream",
+			chunks: []string{
+				`{"choices": [{"index": 0, "delta": {"content": "Checking"}, "finish_reason": null}]}`,
+				`{"choices": [{"index": 0, "delta": {"tool_calls": [{"index": 0, "id": "call_abc", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}}]}, "finish_reason": null}]}`,
+				`[DONE]`,
+			},
+			want: []string{
+				`{"candidates": [{"index": 0, "content": {"role": "model", "parts": [{"text": "Checking"}]}}]}`,
+				"",
+				`{"candidates": [{"index": 0, "content": {"role": "model", "parts": [
+					{"functionCall": {"name": "get_weather", "args": {"city": "Paris"}}}
+				]}}]}`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoder := NewVertexStreamEncoder()
+			for i, chunk := range tt.chunks {
+				got, err := encoder.Encode([]byte(chunk))
+				if err != nil {
+					t.Fatalf("Encode() chunk %d error = %v", i, err)
+				}
+				if tt.want[i] == "" {
+					if got != nil {
+						t.Errorf("Encode() chunk %d = %s, want nil", i, got)
+					}
+					continue
+				}
+				assertJSONEqual(t, got, tt.want[i])
+			}
+		})
+	}
+}
+
+func TestAnthropicStreamDecoder(t *testing.T) {
+	events := []string{
+		`{"type": "message_start", "message": {"id": "msg_1", "type": "message", "role": "assistant", "model": "claude-3-5-sonnet", "content": [], "usage": {"input_tokens": 12, "output_tokens": 1}}}`,
+		`{"type": "content_block_start", "index": 0, "content_block": {"type": "text", "text": ""}}`,
+		`{"type": "ping"}`,
+		`{"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "Checking"}}`,
+		`{"type": "content_block_stop", "index": 0}`,
+		`{"type": "content_block_start", "index": 1, "content_block": {"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {}}}`,
+		`{"type": "content_block_delta", "index": 1, "delta": {"type": "input_json_delta", "partial_json": "{\"city\": \"Paris\"}"}}`,
+		`{"type": "content_block_stop", "index": 1}`,
+		`{"type": "message_delta", "delta": {"stop_reason": "tool_use", "stop_sequence": null}, "usage": {"output_tokens": 20}}`,
+		`{"type": "message_stop"}`,
+	}
+	want := []string{
+		`{"id": "msg_1", "model": "claude-3-5-sonnet", "object": "chat.completion.chunk", "choices": [{"index": 0, "finish_reason": null, "delta": {"role": "assistant", "content": ""}}]}`,
+		"",
+		"",
+		`{"id": "msg_1", "model": "claude-3-5-sonnet", "object": "chat.completion.chunk", "choices": [{"index": 0, "finish_reason": null, "delta": {"content": "Checking"}}]}`,
+		"",
+		`{"id": "msg_1", "model": "claude-3-5-sonnet", "object": "chat.completion.chunk", "choices": [{"index": 0, "finish_reason": null, "delta": {"tool_calls": [
+			{"index": 0, "id": "toolu_1", "type": "function", "function": {"name": "get_weather", "arguments": ""}}
+		]}}]}`,
+		`{"id": "msg_1", "model": "claude-3-5-sonnet", "object": "chat.completion.chunk", "choices": [{"index": 0, "finish_reason": null, "delta": {"tool_calls": [
+			{"index": 0, "function": {"arguments": "{\"city\": \"Paris\"}"}}
+		]}}]}`,
+		"",
+		`{"id": "msg_1", "model": "claude-3-5-sonnet", "object": "chat.completion.chunk", "choices": [{"index": 0, "finish_reason": "tool_calls", "delta": {}}],
+			"usage": {"prompt_tokens": 12, "completion_tokens": 20, "total_tokens": 32}}`,
+		"",
+	}
+
+	decoder := NewAnthropicStreamDecoder()
+	for i, event := range events {
+		got, err := decoder.Decode([]byte(event))
+		if err != nil {
+			t.Fatalf("Decode() event %d error = %v", i, err)
+		}
+		if want[i] == "" {
+			if got != nil {
+				t.Errorf("Decode() event %d = %s, want nil", i, got)
+			}
+			continue
+		}
+		assertJSONEqual(t, got, want[i])
+	}
+
+	if _, err := decoder.Decode([]byte(`{"type": "error", "error": {"type": "overloaded_error", "message": "Overloaded"}}`)); err == nil {
+		t.Error("Decode() of an error event succeeded, want error")
+	}
+}
+
+func TestAnthropicStreamEncoder(t *testing.T) {
+	chunks := []string{
+		`{"id": "chatcmpl-1", "model": "gpt-4o", "choices": [{"index": 0, "delta": {"role": "assistant", "content": ""}, "finish_reason": null}]}`,
+		`{"id": "chatcmpl-1", "model": "gpt-4o", "choices": [{"index": 0, "delta": {"content": "Checking"}, "finish_reason": null}]}`,
+		`{"id": "chatcmpl-1", "model": "gpt-4o", "choices": [{"index": 0, "delta": {"tool_calls": [{"index": 0, "id": "call_abc", "type": "function", "function": {"name": "get_weather", "arguments": ""}}]}, "finish_reason": null}]}`,
+		`{"id": "chatcmpl-1", "model": "gpt-4o", "choices": [{"index": 0, "delta": {"tool_calls": [{"index": 0, "function": {"arguments": "{\"city\":\"Paris\"}"}}]}, "finish_reason": null}]}`,
+		`{"id": "chatcmpl-1", "model": "gpt-4o", "choices": [{"index": 0, "delta": {}, "finish_reason": "tool_calls"}]}`,
+		`{"id": "chatcmpl-1", "model": "gpt-4o", "choices": [], "usage": {"prompt_tokens": 12, "completion_tokens": 20, "total_tokens": 32}}`,
+		`[DONE]`,
+	}
+	want := [][]string{
+		{
+			`message_start`, `{"type": "message_start", "message": {"id": "chatcmpl-1", "type": "message", "role": "assistant", "model": "gpt-4o", "content": [],
+				"stop_reason": null, "stop_sequence": null, "usage": {"input_tokens": 0, "output_tokens": 0}}}`,
+		},
+		{
+			`content_block_start`, `{"type": "content_block_start", "index": 0, "content_block": {"type": "text", "text": ""}}`,
+			`content_block_delta`, `{"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "Checking"}}`,
+		},
+		{
+			`content_block_stop`, `{"type": "content_block_stop", "index": 0}`,
+			`content_block_start`, `{"type": "content_block_start", "index": 1, "content_block": {"type": "tool_use", "id": "call_abc", "name": "get_weather", "input": {}}}`,
+		},
+		{
+			`content_block_delta`, `{"type": "content_block_delta", "index": 1, "delta": {"type": "input_json_delta", "partial_json": "{\"city\":\"Paris\"}"}}`,
+		},
+		{
+			`content_block_stop`, `{"type": "content_block_stop", "index": 1}`,
+		},
+		nil,
+		{
+			`message_delta`, `{"type": "message_delta", "delta": {"stop_reason": "tool_use", "stop_sequence": null}, "usage": {"input_tokens": 12, "output_tokens": 20}}`,
+			`message_stop`, `{"type": "message_stop"}`,
+		},
+	}
+
+	encoder := NewAnthropicStreamEncoder()
+	for i, chunk := range chunks {
+		got, err := encoder.Encode([]byte(chunk))
+		if err != nil {
+			t.Fatalf("Encode() chunk %d error = %v", i, err)
+		}
+		if len(got) != len(want[i])/2 {
+			t.Fatalf("Encode() chunk %d = %d events, want %d", i, len(got), len(want[i])/2)
+		}
+		for j, event := range got {
+			if event.Type != want[i][2*j] {
+				t.Errorf("Encode() chunk %d event %d type = %s, want %s", i, j, event.Type, want[i][2*j])
+			}
+			assertJSONEqual(t, event.Data, want[i][2*j+1])
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package request
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// openAIMessage is an OpenAI chat message, including tool calls and tool results.
+type openAIMessage struct {
+	Role       string           `json:"role"`
+	Content    json.RawMessage  `json:"content"`
+	Name       string           `json:"name"`
+	ToolCalls  []openAIToolCall `json:"tool_calls"`
+	ToolCallID string           `json:"tool_call_id"`
+}
+
+// openAIToolCall is a function call requested by an OpenAI model.
+type openAIToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// openAITool is a tool offered to an OpenAI model.
+type openAITool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string      `json:"name"`
+		Description string      `json:"description"`
+		Parameters  interface{} `json:"parameters"`
+	} `json:"function"`
+}
+
+// vertexPart is a part of a Vertex AI content: text, a function call, a function
+// response, or inline or file data.
+type vertexPart struct {
+	Text         string `json:"text"`
+	FunctionCall *struct {
+		Name string          `json:"name"`
+		Args json.RawMessage `json:"args"`
+	} `json:"functionCall"`
+	FunctionResponse *struct {
+		Name     string          `json:"name"`
+		Response json.RawMessage `json:"response"`
+	} `json:"functionResponse"`
+	InlineData *struct {
+		MimeType string `json:"mimeType"`
+		Data     string `json:"data"`
+	} `json:"inlineData"`
+	FileData *struct {
+		MimeType string `json:"mimeType"`
+		FileURI  string `json:"fileUri"`
+	} `json:"fileData"`
+}
+
+// vertexTool is a Vertex AI tool offering functions to the model.
+type vertexTool struct {
+	FunctionDeclarations []map[string]interface{} `json:"functionDeclarations"`
+}
+
+// vertexFunctionCall builds the functionCall part of an OpenAI tool call.
+func vertexFunctionCall(call openAIToolCall) (map[string]interface{}, error) {
+	args := map[string]interface{}{}
+	if call.Function.Arguments != "" {
+		if err := json.Unmarshal([]byte(call.Function.Arguments), &args); err != nil {
+			return nil, fmt.Errorf("invalid arguments of tool call %s: %w", call.ID, err)
+		}
+	}
+	return map[string]interface{}{
+		"functionCall": map[string]interface{}{
+			"name": call.Function.Name,
+			"args": args,
+		},
+	}, nil
+}
+
+// vertexFunctionResponse builds the functionResponse part of an OpenAI tool result.
+// Vertex AI expects an object, so results that are not JSON objects are wrapped
+// in a content field.
+func vertexFunctionResponse(name, content string) map[string]interface{} {
+	var response map[string]interface{}
+	if err := json.Unmarshal([]byte(content), &response); err != nil || response == nil {
+		response = map[string]interface{}{"content": content}
+	}
+	return map[string]interface{}{
+		"functionResponse": map[string]interface{}{
+			"name":     name,
+			"response": response,
+		},
+	}
+}
+
+// openAIToolCallID returns the ID of the n-th tool call of a Vertex AI request or
+// response, which has no tool call IDs.
+func openAIToolCallID(n int) string {
+	return fmt.Sprintf("call_%d", n)
+}
+
+// openAIToolCallFromVertex builds an OpenAI tool call from a Vertex AI function call.
+func openAIToolCallFromVertex(id, name string, args json.RawMessage) map[string]interface{} {
+	arguments := "{}"
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, args); err == nil && compact.String() != "null" {
+		arguments = compact.String()
+	}
+	return map[string]interface{}{
+		"id":   id,
+		"type": "function",
+		"function": map[string]interface{}{
+			"name":      name,
+			"arguments": arguments,
+		},
+	}
+}
+
+// toolResultContent turns a Vertex AI function response into the content of an
+// OpenAI tool message, unwrapping results wrapped by vertexFunctionResponse.
+func toolResultContent(response json.RawMessage) string {
+	var wrapped map[string]json.RawMessage
+	if err := json.Unmarshal(response, &wrapped); err == nil && len(wrapped) == 1 {
+		var content string
+		if err := json.Unmarshal(wrapped["content"], &content); err == nil {
+			return content
+		}
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, response); err != nil {
+		return string(response)
+	}
+	return compact.String()
+}
+
+// vertexTools transforms OpenAI function tools into Vertex AI function declarations.
+// Parameter schemas Vertex AI cannot express are an error.
+func vertexTools(tools []openAITool) ([]map[string]interface{}, error) {
+	declarations := make([]map[string]interface{}, 0, len(tools))
+	for _, tool := range tools {
+		if tool.Type != "function" {
+			continue
+		}
+		declaration := map[string]interface{}{
+			"name": tool.Function.Name,
+		}
+		if tool.Function.Description != "" {
+			declaration["description"] = tool.Function.Description
+		}
+		if tool.Function.Parameters != nil {
+			parameters, err := toVertexSchema(tool.Function.Parameters)
+			if err != nil {
+				return nil, fmt.Errorf("parameters of tool %s cannot be sent to Vertex AI: %w", tool.Function.Name, err)
+			}
+			declaration["parameters"] = parameters
+		}
+		declarations = append(declarations, declaration)
+	}
+	if len(declarations) == 0 {
+		return nil, nil
+	}
+	return []map[string]interface{}{{"functionDeclarations": declarations}}, nil
+}
+
+// vertexToolConfig transforms an OpenAI tool_choice into a Vertex AI tool config.
+func vertexToolConfig(toolChoice json.RawMessage) map[string]interface{} {
+	if len(toolChoice) == 0 {
+		return nil
+	}
+
+	config := map[string]interface{}{}
+	var mode string
+	if err := json.Unmarshal(toolChoice, &mode); err == nil {
+		switch mode {
+		case "none":
+			config["mode"] = "NONE"
+		case "auto":
+			config["mode"] = "AUTO"
+		case "required":
+			config["mode"] = "ANY"
+		default:
+			return nil
+		}
+	} else {
+		var choice struct {
+			Function struct {
+				Name string `json:"name"`
+			} `json:"function"`
+		}
+		if err := json.Unmarshal(toolChoice, &choice); err != nil || choice.Function.Name == "" {
+			return nil
+		}
+		config["mode"] = "ANY"
+		config["allowedFunctionNames"] = []string{choice.Function.Name}
+	}
+	return map[string]interface{}{"functionCallingConfig": config}
+}
+
+// openAITools transforms Vertex AI function declarations into OpenAI function tools.
+func openAITools(tools []vertexTool) []map[string]interface{} {
+	var result []map[string]interface{}
+	for _, tool := range tools {
+		for _, declaration := range tool.FunctionDeclarations {
+			if parameters, ok := declaration["parameters"]; ok {
+				declaration["parameters"] = fromVertexSchema(parameters)
+			}
+			result = append(result, map[string]interface{}{
+				"type":     "function",
+				"function": declaration,
+			})
+		}
+	}
+	return result
+}
+
+// openAIToolChoice transforms a Vertex AI tool config into an OpenAI tool_choice.
+func openAIToolChoice(config map[string]interface{}) interface{} {
+	callingConfig, _ := config["functionCallingConfig"].(map[string]interface{})
+	mode, _ := callingConfig["mode"].(string)
+	switch mode {
+	case "NONE":
+		return "none"
+	case "AUTO":
+		return "auto"
+	case "ANY":
+		if names, _ := callingConfig["allowedFunctionNames"].([]interface{}); len(names) == 1 {
+			return map[string]interface{}{
+				"type":     "function",
+				"function": map[string]interface{}{"name": names[0]},
+			}
+		}
+		return "required"
+	default:
+		return nil
+	}
+}
@@ -1,78 +1,462 @@
 package response
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
 	"time"
 )
 
+// ModelHeader is the header of a response naming the model that answered, in
+// provider/model or provider/model/region format.
+const ModelHeader = "X-Notdiamond-Model"
+
+// RegionHeader is the header of a response naming the region of the model that
+// answered, if it has one. Model names can contain slashes, so the region can't
+// be told apart from the model name of ModelHeader without it.
+const RegionHeader = "X-Notdiamond-Region"
+
+// Result is a response parsed into the same form for all providers.
 type Result struct {
-	Model     string
-	Response  string
-	TimeTaken time.Duration
+	Model        string        // Model that answered, as reported by the response
+	Provider     string        // Provider that answered, e.g. openai, azure, anthropic or vertex
+	Response     string        // Text of the first choice
+	FinishReason string        // Finish reason of the first choice
+	ToolCalls    []ToolCall    // Tool calls of the first choice
+	Choices      []Choice      // All choices, or Vertex AI candidates
+	PromptFilter ContentFilter // Verdict of the safety filter on the prompt
+	TimeTaken    time.Duration
+	Usage        Usage
+}
+
+// Choice is a choice of an OpenAI or Azure response, a Vertex AI candidate or the
+// content of an Anthropic response.
+type Choice struct {
+	Index        int
+	Text         string
+	FinishReason string // As in OpenAI: stop, length, tool_calls or content_filter
+	ToolCalls    []ToolCall
+	Refusal      string        // Why an OpenAI model refused to answer
+	Filter       ContentFilter // Verdict of the safety filter on the choice
+}
+
+// ToolCall is a call of a tool the model asks for. Vertex AI function calls have
+// no ID.
+type ToolCall struct {
+	ID        string
+	Name      string
+	Arguments string // JSON object
+}
+
+// ContentFilter is the verdict of a provider's safety or content filter.
+type ContentFilter struct {
+	Blocked bool           // The content was withheld or cut short
+	Reason  string         // The provider's reason, e.g. SAFETY, content_filter or refusal
+	Ratings []SafetyRating // Azure content filter results or Vertex AI safety ratings
+}
+
+// SafetyRating rates content in one harm category.
+type SafetyRating struct {
+	Category string // e.g. hate (Azure) or HARM_CATEGORY_HATE_SPEECH (Vertex AI)
+	Severity string // Azure severity or Vertex AI probability
+	Blocked  bool
 }
 
-// Parse takes a response body and the time the request started,
-// and returns the parsed result from either OpenAI or Vertex AI
+// Parse takes a response body and the time the request started, and returns
+// the parsed result of an OpenAI, Azure, Anthropic or Vertex AI response. Vertex AI
+// and Gemini responses have the same format, both are reported as vertex.
 func Parse(body []byte, startTime time.Time) (*Result, error) {
-	// Try to parse as OpenAI response first
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %v", err)
+	}
+
+	var result *Result
+	var err error
+	switch {
+	case payload["choices"] != nil:
+		result, err = parseOpenAI(body)
+	case payload["stop_reason"] != nil || string(payload["type"]) == `"message"`:
+		result, err = parseAnthropic(body)
+	default:
+		result, err = parseVertex(body)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.Choices) > 0 {
+		first := result.Choices[0]
+		result.Response = first.Text
+		result.FinishReason = first.FinishReason
+		result.ToolCalls = first.ToolCalls
+	}
+	result.Usage, _ = ParseUsage(body)
+	result.TimeTaken = time.Since(startTime)
+	return result, nil
+}
+
+// ParseResponse reads and parses the body of a response of the client. The
+// provider that answered and the usage are taken from the headers the client sets,
+// they hold even when the body was translated into the caller's format, as is the
+// model if the body names none. The body is left for the caller to read again.
+func ParseResponse(resp *http.Response, startTime time.Time) (*Result, error) {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewBuffer(body))
+
+	result, err := Parse(body, startTime)
+	if err != nil {
+		return nil, err
+	}
+	if modelFull := resp.Header.Get(ModelHeader); modelFull != "" {
+		provider, modelName, _ := strings.Cut(modelFull, "/")
+		if region := resp.Header.Get(RegionHeader); region != "" {
+			modelName = strings.TrimSuffix(modelName, "/"+region)
+		}
+		result.Provider = provider
+		if result.Model == "" {
+			result.Model = modelName
+		}
+	}
+	if usage, ok := UsageFromHeader(resp.Header); ok {
+		result.Usage = usage
+	}
+	return result, nil
+}
+
+// azureFilterResult is the verdict of the Azure content filter on one category.
+type azureFilterResult struct {
+	Filtered bool   `json:"filtered"`
+	Severity string `json:"severity"`
+	Detected bool   `json:"detected"`
+}
+
+// azureFilter builds the verdict of the Azure content filter, with the categories
+// in name order. Jailbreak and protected material results have no severity, they
+// are reported as detected.
+func azureFilter(results map[string]azureFilterResult) ContentFilter {
+	var filter ContentFilter
+	categories := make([]string, 0, len(results))
+	for category := range results {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+
+	for _, category := range categories {
+		result := results[category]
+		severity := result.Severity
+		if severity == "" && result.Detected {
+			severity = "detected"
+		}
+		filter.Ratings = append(filter.Ratings, SafetyRating{Category: category, Severity: severity, Blocked: result.Filtered})
+		if result.Filtered {
+			filter.Blocked = true
+			filter.Reason = "content_filter"
+		}
+	}
+	return filter
+}
+
+// parseOpenAI parses an OpenAI or Azure chat completion. Azure responses are told
+// apart by their content filter results.
+func parseOpenAI(body []byte) (*Result, error) {
 	var openaiResponse struct {
+		Model   string `json:"model"`
 		Choices []struct {
+			Index   int `json:"index"`
 			Message struct {
-				Content string `json:"content"`
+				Content   json.RawMessage `json:"content"`
+				Refusal   string          `json:"refusal"`
+				ToolCalls []struct {
+					ID       string `json:"id"`
+					Function struct {
+						Name      string `json:"name"`
+						Arguments string `json:"arguments"`
+					} `json:"function"`
+				} `json:"tool_calls"`
 			} `json:"message"`
+			FinishReason         string                       `json:"finish_reason"`
+			ContentFilterResults map[string]azureFilterResult `json:"content_filter_results"`
 		} `json:"choices"`
+		PromptFilterResults []struct {
+			ContentFilterResults map[string]azureFilterResult `json:"content_filter_results"`
+		} `json:"prompt_filter_results"`
+	}
+	if err := json.Unmarshal(body, &openaiResponse); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %v", err)
+	}
+	if len(openaiResponse.Choices) == 0 {
+		return nil, fmt.Errorf("response did not contain any candidates: %s", string(body))
+	}
+
+	result := &Result{Model: openaiResponse.Model, Provider: "openai"}
+	if openaiResponse.PromptFilterResults != nil {
+		result.Provider = "azure"
+	}
+
+	// Azure rates each prompt, their ratings are reported together
+	promptResults := make(map[string]azureFilterResult)
+	for _, prompt := range openaiResponse.PromptFilterResults {
+		for category, verdict := range prompt.ContentFilterResults {
+			if existing, ok := promptResults[category]; !ok || verdict.Filtered && !existing.Filtered {
+				promptResults[category] = verdict
+			}
+		}
+	}
+	if len(promptResults) > 0 {
+		result.PromptFilter = azureFilter(promptResults)
+	}
+
+	for _, c := range openaiResponse.Choices {
+		choice := Choice{
+			Index:        c.Index,
+			Text:         openAIText(c.Message.Content),
+			FinishReason: c.FinishReason,
+			Refusal:      c.Message.Refusal,
+		}
+		for _, call := range c.Message.ToolCalls {
+			choice.ToolCalls = append(choice.ToolCalls, ToolCall{ID: call.ID, Name: call.Function.Name, Arguments: call.Function.Arguments})
+		}
+		if c.ContentFilterResults != nil {
+			result.Provider = "azure"
+			choice.Filter = azureFilter(c.ContentFilterResults)
+		}
+		if c.FinishReason == "content_filter" {
+			choice.Filter.Blocked = true
+			choice.Filter.Reason = c.FinishReason
+		}
+		result.Choices = append(result.Choices, choice)
+	}
+	return result, nil
+}
+
+// openAIText returns the text of OpenAI message content, either a string or a
+// list of content parts.
+func openAIText(raw json.RawMessage) string {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return ""
+	}
+	var texts []string
+	for _, part := range parts {
+		if part.Type == "text" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "")
+}
+
+// anthropicFinishReasons maps Anthropic stop reasons to OpenAI finish reasons.
+var anthropicFinishReasons = map[string]string{
+	"end_turn":      "stop",
+	"stop_sequence": "stop",
+	"max_tokens":    "length",
+	"tool_use":      "tool_calls",
+	"refusal":       "content_filter",
+}
+
+// parseAnthropic parses an Anthropic Messages API response. A refusal is reported
+// as blocked by the content filter.
+func parseAnthropic(body []byte) (*Result, error) {
+	var anthropicResponse struct {
+		Model   string `json:"model"`
+		Content []struct {
+			Type  string          `json:"type"`
+			Text  string          `json:"text"`
+			ID    string          `json:"id"`
+			Name  string          `json:"name"`
+			Input json.RawMessage `json:"input"`
+		} `json:"content"`
+		StopReason string `json:"stop_reason"`
+	}
+	if err := json.Unmarshal(body, &anthropicResponse); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %v", err)
+	}
+
+	choice := Choice{FinishReason: anthropicResponse.StopReason}
+	if finishReason, ok := anthropicFinishReasons[anthropicResponse.StopReason]; ok {
+		choice.FinishReason = finishReason
+	}
+	if anthropicResponse.StopReason == "refusal" {
+		choice.Filter = ContentFilter{Blocked: true, Reason: anthropicResponse.StopReason}
 	}
 
-	if err := json.Unmarshal(body, &openaiResponse); err == nil && len(openaiResponse.Choices) > 0 {
-		return &Result{
-			Model:     "gpt-4o",
-			Response:  openaiResponse.Choices[0].Message.Content,
-			TimeTaken: time.Since(startTime),
-		}, nil
+	var texts []string
+	for _, block := range anthropicResponse.Content {
+		switch block.Type {
+		case "text":
+			texts = append(texts, block.Text)
+		case "tool_use":
+			arguments := "{}"
+			if len(block.Input) > 0 {
+				arguments = string(block.Input)
+			}
+			choice.ToolCalls = append(choice.ToolCalls, ToolCall{ID: block.ID, Name: block.Name, Arguments: arguments})
+		}
 	}
+	choice.Text = strings.Join(texts, "\n")
 
-	// If not OpenAI, try to parse as Vertex AI response
+	return &Result{
+		Model:    anthropicResponse.Model,
+		Provider: "anthropic",
+		Choices:  []Choice{choice},
+	}, nil
+}
+
+// vertexFinishReasons maps Vertex AI finish reasons to OpenAI finish reasons. The
+// reasons mapped to content_filter are verdicts of the safety filter.
+var vertexFinishReasons = map[string]string{
+	"STOP":               "stop",
+	"MAX_TOKENS":         "length",
+	"SAFETY":             "content_filter",
+	"RECITATION":         "content_filter",
+	"BLOCKLIST":          "content_filter",
+	"PROHIBITED_CONTENT": "content_filter",
+	"SPII":               "content_filter",
+}
+
+// vertexSafetyRating is a Vertex AI safety rating of a candidate or prompt.
+type vertexSafetyRating struct {
+	Category    string `json:"category"`
+	Probability string `json:"probability"`
+	Blocked     bool   `json:"blocked"`
+}
+
+// vertexFilter builds the verdict of the Vertex AI safety filter.
+func vertexFilter(reason string, ratings []vertexSafetyRating) ContentFilter {
+	filter := ContentFilter{Blocked: reason != "", Reason: reason}
+	for _, rating := range ratings {
+		filter.Ratings = append(filter.Ratings, SafetyRating{Category: rating.Category, Severity: rating.Probability, Blocked: rating.Blocked})
+		filter.Blocked = filter.Blocked || rating.Blocked
+	}
+	return filter
+}
+
+// parseVertex parses a Vertex AI or Gemini response. A prompt blocked by the
+// safety filter gives a result without choices.
+func parseVertex(body []byte) (*Result, error) {
 	var vertexResponse struct {
 		Candidates []struct {
 			Content struct {
 				Parts []struct {
-					Text string `json:"text"`
+					Text         string `json:"text"`
+					Thought      bool   `json:"thought"`
+					FunctionCall *struct {
+						Name string          `json:"name"`
+						Args json.RawMessage `json:"args"`
+					} `json:"functionCall"`
 				} `json:"parts"`
 			} `json:"content"`
-			CitationMetadata *struct {
-				Citations []struct {
-					StartIndex int `json:"startIndex"`
-					EndIndex   int `json:"endIndex"`
-				} `json:"citations"`
-			} `json:"citationMetadata"`
-			FinishReason string `json:"finishReason"`
+			FinishReason  string               `json:"finishReason"`
+			SafetyRatings []vertexSafetyRating `json:"safetyRatings"`
 		} `json:"candidates"`
+		PromptFeedback *struct {
+			BlockReason   string               `json:"blockReason"`
+			SafetyRatings []vertexSafetyRating `json:"safetyRatings"`
+		} `json:"promptFeedback"`
+		ModelVersion string `json:"modelVersion"`
 	}
 
 	if err := json.Unmarshal(body, &vertexResponse); err != nil {
 		return nil, fmt.Errorf("failed to parse response: %v", err)
 	}
 
+	result := &Result{Model: vertexResponse.ModelVersion, Provider: "vertex"}
+	if feedback := vertexResponse.PromptFeedback; feedback != nil {
+		result.PromptFilter = vertexFilter(feedback.BlockReason, feedback.SafetyRatings)
+	}
+
 	if len(vertexResponse.Candidates) == 0 {
+		if result.PromptFilter.Blocked {
+			return result, nil
+		}
 		return nil, fmt.Errorf("response did not contain any candidates: %s", string(body))
 	}
 
-	candidate := vertexResponse.Candidates[0]
+	for i, candidate := range vertexResponse.Candidates {
+		finishReason, ok := vertexFinishReasons[candidate.FinishReason]
+		if !ok {
+			finishReason = strings.ToLower(candidate.FinishReason)
+		}
+		blockReason := ""
+		if finishReason == "content_filter" {
+			blockReason = candidate.FinishReason
+		}
+
+		if i == 0 {
+			// Check if the response was blocked due to recitation
+			if candidate.FinishReason == "RECITATION" {
+				return nil, fmt.Errorf("response was blocked due to content recitation. Please rephrase your query")
+			}
+
+			// Check if we have valid content, candidates blocked for safety have none
+			if len(candidate.Content.Parts) == 0 && blockReason == "" {
+				return nil, fmt.Errorf("response candidate did not contain any content parts: %s", string(body))
+			}
+		}
 
-	// Check if the response was blocked due to recitation
-	if candidate.FinishReason == "RECITATION" {
-		return nil, fmt.Errorf("response was blocked due to content recitation. Please rephrase your query")
+		choice := Choice{
+			Index:  i,
+			Filter: vertexFilter(blockReason, candidate.SafetyRatings),
+		}
+		var texts []string
+		for _, part := range candidate.Content.Parts {
+			switch {
+			case part.FunctionCall != nil:
+				arguments := "{}"
+				if len(part.FunctionCall.Args) > 0 {
+					arguments = string(part.FunctionCall.Args)
+				}
+				choice.ToolCalls = append(choice.ToolCalls, ToolCall{Name: part.FunctionCall.Name, Arguments: arguments})
+			case !part.Thought:
+				texts = append(texts, part.Text)
+			}
+		}
+		choice.Text = strings.Join(texts, "")
+
+		// Vertex AI stops after function calls as after text
+		if finishReason == "stop" && len(choice.ToolCalls) > 0 {
+			finishReason = "tool_calls"
+		}
+		choice.FinishReason = finishReason
+		result.Choices = append(result.Choices, choice)
 	}
+	return result, nil
+}
 
-	// Check if we have valid content
-	if len(candidate.Content.Parts) == 0 {
-		return nil, fmt.Errorf("response candidate did not contain any content parts: %s", string(body))
+// ParseError builds an error from an unsuccessful response, using the provider's
+// error message when the body has the common {"error": {"message": ...}} shape.
+func ParseError(statusCode int, body []byte) error {
+	var errorResponse struct {
+		Error struct {
+			Message string `json:"message"`
+			Type    string `json:"type"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &errorResponse); err == nil && errorResponse.Error.Message != "" {
+		return fmt.Errorf("with status %d (%s): %s",
+			statusCode,
+			http.StatusText(statusCode),
+			errorResponse.Error.Message)
 	}
 
-	return &Result{
-		Model:     "gemini-pro",
-		Response:  candidate.Content.Parts[0].Text,
-		TimeTaken: time.Since(startTime),
-	}, nil
+	// Fallback to raw body if can't parse error response
+	return fmt.Errorf("with status %d (%s): %s",
+		statusCode,
+		http.StatusText(statusCode),
+		string(body))
 }
@@ -1,6 +1,10 @@
 package response
 
 import (
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -11,22 +15,26 @@ func TestParse(t *testing.T) {
 		responseBody  string
 		expectedModel string
 		expectedText  string
+		expectedUsage Usage
 		expectError   bool
 		errorContains string
 	}{
 		{
 			name: "valid OpenAI response",
 			responseBody: `{
+				"model": "gpt-4o",
 				"choices": [
 					{
 						"message": {
 							"content": "I am GPT-4"
 						}
 					}
-				]
+				],
+				"usage": {"prompt_tokens": 3, "completion_tokens": 4, "total_tokens": 7}
 			}`,
 			expectedModel: "gpt-4o",
 			expectedText:  "I am GPT-4",
+			expectedUsage: Usage{PromptTokens: 3, CompletionTokens: 4, TotalTokens: 7},
 			expectError:   false,
 		},
 		{
@@ -42,7 +50,8 @@ func TestParse(t *testing.T) {
 							]
 						}
 					}
-				]
+				],
+				"modelVersion": "gemini-pro"
 			}`,
 			expectedModel: "gemini-pro",
 			expectedText:  "I am Gemini Pro",
@@ -138,6 +147,10 @@ func TestParse(t *testing.T) {
 				t.Errorf("expected response text %q, got %q", tt.expectedText, result.Response)
 			}
 
+			if result.Usage != tt.expectedUsage {
+				t.Errorf("expected usage %+v, got %+v", tt.expectedUsage, result.Usage)
+			}
+
 			if result.TimeTaken <= 0 {
 				t.Error("expected positive duration")
 			}
@@ -145,6 +158,265 @@ func TestParse(t *testing.T) {
 	}
 }
 
+func TestParseResult(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		want         Result
+		wantResponse string
+	}{
+		{
+			name: "OpenAI choices and tool calls",
+			body: `{
+				"model": "gpt-4o-2024-08-06",
+				"choices": [
+					{"index": 0, "message": {"role": "assistant", "content": null, "tool_calls": [{"id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}}]}, "finish_reason": "tool_calls"},
+					{"index": 1, "message": {"role": "assistant", "content": "It is", "refusal": null}, "finish_reason": "length"}
+				]
+			}`,
+			want: Result{
+				Model:        "gpt-4o-2024-08-06",
+				Provider:     "openai",
+				FinishReason: "tool_calls",
+				ToolCalls:    []ToolCall{{ID: "call_1", Name: "get_weather", Arguments: `{"city":"Paris"}`}},
+				Choices: []Choice{
+					{Index: 0, FinishReason: "tool_calls", ToolCalls: []ToolCall{{ID: "call_1", Name: "get_weather", Arguments: `{"city":"Paris"}`}}},
+					{Index: 1, Text: "It is", FinishReason: "length"},
+				},
+			},
+		},
+		{
+			name: "Azure content filter",
+			body: `{
+				"model": "gpt-4o",
+				"prompt_filter_results": [{"prompt_index": 0, "content_filter_results": {
+					"hate": {"filtered": false, "severity": "safe"},
+					"jailbreak": {"filtered": false, "detected": true}
+				}}],
+				"choices": [{"index": 0, "message": {"role": "assistant", "content": ""}, "finish_reason": "content_filter", "content_filter_results": {
+					"hate": {"filtered": true, "severity": "medium"},
+					"violence": {"filtered": false, "severity": "low"}
+				}}]
+			}`,
+			want: Result{
+				Model:        "gpt-4o",
+				Provider:     "azure",
+				FinishReason: "content_filter",
+				Choices: []Choice{{
+					FinishReason: "content_filter",
+					Filter: ContentFilter{Blocked: true, Reason: "content_filter", Ratings: []SafetyRating{
+						{Category: "hate", Severity: "medium", Blocked: true},
+						{Category: "violence", Severity: "low"},
+					}},
+				}},
+				PromptFilter: ContentFilter{Ratings: []SafetyRating{
+					{Category: "hate", Severity: "safe"},
+					{Category: "jailbreak", Severity: "detected"},
+				}},
+			},
+		},
+		{
+			name: "Anthropic tool use",
+			body: `{
+				"id": "msg_1",
+				"type": "message",
+				"role": "assistant",
+				"model": "claude-sonnet-4-5",
+				"content": [
+					{"type": "text", "text": "Let me check."},
+					{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Paris"}}
+				],
+				"stop_reason": "tool_use"
+			}`,
+			want: Result{
+				Model:        "claude-sonnet-4-5",
+				Provider:     "anthropic",
+				FinishReason: "tool_calls",
+				ToolCalls:    []ToolCall{{ID: "toolu_1", Name: "get_weather", Arguments: `{"city": "Paris"}`}},
+				Choices: []Choice{{
+					Text:         "Let me check.",
+					FinishReason: "tool_calls",
+					ToolCalls:    []ToolCall{{ID: "toolu_1", Name: "get_weather", Arguments: `{"city": "Paris"}`}},
+				}},
+			},
+			wantResponse: "Let me check.",
+		},
+		{
+			name: "Anthropic refusal",
+			body: `{"type": "message", "model": "claude-sonnet-4-5", "content": [], "stop_reason": "refusal"}`,
+			want: Result{
+				Model:        "claude-sonnet-4-5",
+				Provider:     "anthropic",
+				FinishReason: "content_filter",
+				Choices:      []Choice{{FinishReason: "content_filter", Filter: ContentFilter{Blocked: true, Reason: "refusal"}}},
+			},
+		},
+		{
+			name: "Vertex AI function call and blocked candidate",
+			body: `{
+				"modelVersion": "gemini-2.0-flash-001",
+				"candidates": [
+					{"content": {"role": "model", "parts": [{"functionCall": {"name": "get_weather", "args": {"city": "Paris"}}}]}, "finishReason": "STOP"},
+					{"finishReason": "SAFETY", "safetyRatings": [{"category": "HARM_CATEGORY_HARASSMENT", "probability": "HIGH", "blocked": true}]}
+				]
+			}`,
+			want: Result{
+				Model:        "gemini-2.0-flash-001",
+				Provider:     "vertex",
+				FinishReason: "tool_calls",
+				ToolCalls:    []ToolCall{{Name: "get_weather", Arguments: `{"city": "Paris"}`}},
+				Choices: []Choice{
+					{Index: 0, FinishReason: "tool_calls", ToolCalls: []ToolCall{{Name: "get_weather", Arguments: `{"city": "Paris"}`}}},
+					{Index: 1, FinishReason: "content_filter", Filter: ContentFilter{Blocked: true, Reason: "SAFETY", Ratings: []SafetyRating{
+						{Category: "HARM_CATEGORY_HARASSMENT", Severity: "HIGH", Blocked: true},
+					}}},
+				},
+			},
+		},
+		{
+			name: "Vertex AI blocked prompt",
+			body: `{
+				"promptFeedback": {"blockReason": "SAFETY", "safetyRatings": [{"category": "HARM_CATEGORY_DANGEROUS_CONTENT", "probability": "HIGH", "blocked": true}]},
+				"modelVersion": "gemini-2.0-flash-001"
+			}`,
+			want: Result{
+				Model:    "gemini-2.0-flash-001",
+				Provider: "vertex",
+				PromptFilter: ContentFilter{Blocked: true, Reason: "SAFETY", Ratings: []SafetyRating{
+					{Category: "HARM_CATEGORY_DANGEROUS_CONTENT", Severity: "HIGH", Blocked: true},
+				}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Parse([]byte(tt.body), time.Now())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Response != tt.wantResponse {
+				t.Errorf("Response = %q, want %q", result.Response, tt.wantResponse)
+			}
+			result.Response, result.TimeTaken, result.Usage = "", 0, Usage{}
+			if !reflect.DeepEqual(*result, tt.want) {
+				t.Errorf("Parse() = %+v, want %+v", *result, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseResponse(t *testing.T) {
+	resp := &http.Response{
+		Header: http.Header{},
+		// A Vertex AI caller served by OpenAI gets a translated body
+		Body: io.NopCloser(strings.NewReader(`{
+			"candidates": [{"content": {"role": "model", "parts": [{"text": "Bonjour"}]}, "finishReason": "STOP"}],
+			"usageMetadata": {"promptTokenCount": 5, "candidatesTokenCount": 2, "totalTokenCount": 7}
+		}`)),
+	}
+	resp.Header.Set(ModelHeader, "openai/gpt-4o")
+	SetUsageHeader(resp.Header, Usage{PromptTokens: 5, CompletionTokens: 2, TotalTokens: 7, CachedTokens: 3})
+
+	result, err := ParseResponse(resp, time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Provider != "openai" || result.Model != "gpt-4o" || result.Response != "Bonjour" {
+		t.Errorf("ParseResponse() = %+v, want Bonjour from openai/gpt-4o", result)
+	}
+	if result.Usage.CachedTokens != 3 {
+		t.Errorf("Usage = %+v, want the usage of the header", result.Usage)
+	}
+	if body, _ := io.ReadAll(resp.Body); !strings.Contains(string(body), "Bonjour") {
+		t.Errorf("body = %s, want it left to read again", body)
+	}
+}
+
+func TestParseResponseModelHeader(t *testing.T) {
+	tests := []struct {
+		name         string
+		model        string
+		region       string
+		wantProvider string
+		wantModel    string
+	}{
+		{
+			name:         "model name with slashes",
+			model:        "together/meta-llama/Llama-3-70b",
+			wantProvider: "together",
+			wantModel:    "meta-llama/Llama-3-70b",
+		},
+		{
+			name:         "model with region",
+			model:        "bedrock/amazon.nova-lite-v1:0/us-east-1",
+			region:       "us-east-1",
+			wantProvider: "bedrock",
+			wantModel:    "amazon.nova-lite-v1:0",
+		},
+		{
+			name:         "model name with slashes and region",
+			model:        "vertex/publishers/meta/llama-3/us-central1",
+			region:       "us-central1",
+			wantProvider: "vertex",
+			wantModel:    "publishers/meta/llama-3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The body names no model, so it is taken from the header
+			resp := &http.Response{
+				Header: http.Header{},
+				Body:   io.NopCloser(strings.NewReader(`{"choices": [{"message": {"role": "assistant", "content": "Hi"}}]}`)),
+			}
+			resp.Header.Set(ModelHeader, tt.model)
+			if tt.region != "" {
+				resp.Header.Set(RegionHeader, tt.region)
+			}
+
+			result, err := ParseResponse(resp, time.Now())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Provider != tt.wantProvider || result.Model != tt.wantModel {
+				t.Errorf("ParseResponse() provider, model = %q, %q, want %q, %q", result.Provider, result.Model, tt.wantProvider, tt.wantModel)
+			}
+		})
+	}
+}
+
 func contains(s, substr string) bool {
 	return len(s) >= len(substr) && s[0:len(substr)] == substr
 }
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		body       string
+		want       string
+	}{
+		{
+			name:       "OpenAI-style error",
+			statusCode: 429,
+			body:       `{"error": {"message": "Rate limit exceeded", "type": "rate_limit"}}`,
+			want:       "with status 429 (Too Many Requests): Rate limit exceeded",
+		},
+		{
+			name:       "unparseable body",
+			statusCode: 500,
+			body:       `upstream failure`,
+			want:       "with status 500 (Internal Server Error): upstream failure",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ParseError(tt.statusCode, []byte(tt.body))
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("ParseError() = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package response
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// UsageHeader is the header of a response carrying its token usage as JSON.
+const UsageHeader = "X-Notdiamond-Usage"
+
+// Usage is the token usage of a response, normalized across providers. As in
+// OpenAI responses, prompt tokens include cached tokens and completion tokens
+// include reasoning tokens.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+	CachedTokens     int `json:"cached_tokens"`    // Prompt tokens read from a cache
+	ReasoningTokens  int `json:"reasoning_tokens"` // Completion tokens spent on reasoning
+}
+
+// ParseUsage reads the token usage of an OpenAI, Azure, Anthropic or Bedrock
+// Converse response (usage), a Vertex AI or Gemini response (usageMetadata) or a
+// Vertex AI embeddings response (predictions). It reports false if the body has
+// none, or its usage has none of the known token counts.
+func ParseUsage(body []byte) (Usage, bool) {
+	var payload struct {
+		Usage *struct {
+			PromptTokens        int `json:"prompt_tokens"`
+			CompletionTokens    int `json:"completion_tokens"`
+			TotalTokens         int `json:"total_tokens"`
+			PromptTokensDetails struct {
+				CachedTokens int `json:"cached_tokens"`
+			} `json:"prompt_tokens_details"`
+			CompletionTokensDetails struct {
+				ReasoningTokens int `json:"reasoning_tokens"`
+			} `json:"completion_tokens_details"`
+
+			// Anthropic counts cached tokens apart from the input tokens
+			InputTokens              int `json:"input_tokens"`
+			OutputTokens             int `json:"output_tokens"`
+			CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+			CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+
+			// Bedrock Converse counts cached tokens apart from the input tokens too
+			ConverseInputTokens      int `json:"inputTokens"`
+			ConverseOutputTokens     int `json:"outputTokens"`
+			ConverseTotalTokens      int `json:"totalTokens"`
+			ConverseCacheReadTokens  int `json:"cacheReadInputTokens"`
+			ConverseCacheWriteTokens int `json:"cacheWriteInputTokens"`
+		} `json:"usage"`
+		UsageMetadata *struct {
+			PromptTokenCount        int `json:"promptTokenCount"`
+			CandidatesTokenCount    int `json:"candidatesTokenCount"`
+			TotalTokenCount         int `json:"totalTokenCount"`
+			CachedContentTokenCount int `json:"cachedContentTokenCount"`
+			ThoughtsTokenCount      int `json:"thoughtsTokenCount"`
+		} `json:"usageMetadata"`
+		// Vertex AI embedding models count the tokens of each input
+		Predictions []struct {
+			Embeddings struct {
+				Statistics struct {
+					TokenCount float64 `json:"token_count"`
+				} `json:"statistics"`
+			} `json:"embeddings"`
+		} `json:"predictions"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return Usage{}, false
+	}
+	// The formats are told apart by the fields their usage has
+	var fields struct {
+		Usage map[string]json.RawMessage `json:"usage"`
+	}
+	json.Unmarshal(body, &fields)
+	hasUsage := func(keys ...string) bool {
+		for _, key := range keys {
+			if _, ok := fields.Usage[key]; ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	var usage Usage
+	switch {
+	case payload.UsageMetadata != nil:
+		// Vertex AI counts thoughts apart from the candidates
+		metadata := payload.UsageMetadata
+		usage = Usage{
+			PromptTokens:     metadata.PromptTokenCount,
+			CompletionTokens: metadata.CandidatesTokenCount + metadata.ThoughtsTokenCount,
+			TotalTokens:      metadata.TotalTokenCount,
+			CachedTokens:     metadata.CachedContentTokenCount,
+			ReasoningTokens:  metadata.ThoughtsTokenCount,
+		}
+	case len(payload.Predictions) > 0:
+		for _, prediction := range payload.Predictions {
+			usage.PromptTokens += int(prediction.Embeddings.Statistics.TokenCount)
+		}
+	case hasUsage("inputTokens", "outputTokens", "totalTokens"):
+		u := payload.Usage
+		usage = Usage{
+			PromptTokens:     u.ConverseInputTokens + u.ConverseCacheReadTokens + u.ConverseCacheWriteTokens,
+			CompletionTokens: u.ConverseOutputTokens,
+			TotalTokens:      u.ConverseTotalTokens,
+			CachedTokens:     u.ConverseCacheReadTokens,
+		}
+	case hasUsage("input_tokens", "output_tokens"):
+		u := payload.Usage
+		usage = Usage{
+			PromptTokens:     u.InputTokens + u.CacheReadInputTokens + u.CacheCreationInputTokens,
+			CompletionTokens: u.OutputTokens,
+			CachedTokens:     u.CacheReadInputTokens,
+		}
+	case hasUsage("prompt_tokens", "completion_tokens", "total_tokens"):
+		u := payload.Usage
+		usage = Usage{
+			PromptTokens:     u.PromptTokens,
+			CompletionTokens: u.CompletionTokens,
+			TotalTokens:      u.TotalTokens,
+			CachedTokens:     u.PromptTokensDetails.CachedTokens,
+			ReasoningTokens:  u.CompletionTokensDetails.ReasoningTokens,
+		}
+	default:
+		return Usage{}, false
+	}
+
+	if usage.TotalTokens == 0 {
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+	return usage, true
+}
+
+// UsageFromHeader reads the token usage the client set on a response. It reports
+// false if the response has none, e.g. because it was streamed.
+func UsageFromHeader(header http.Header) (Usage, bool) {
+	value := header.Get(UsageHeader)
+	if value == "" {
+		return Usage{}, false
+	}
+	var usage Usage
+	if err := json.Unmarshal([]byte(value), &usage); err != nil {
+		return Usage{}, false
+	}
+	return usage, true
+}
+
+// SetUsageHeader sets the token usage on a response header.
+func SetUsageHeader(header http.Header, usage Usage) {
+	value, err := json.Marshal(usage)
+	if err != nil {
+		return
+	}
+	header.Set(UsageHeader, string(value))
+}
@@ -0,0 +1,113 @@
+package response
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestParseUsage(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		want   Usage
+		wantOK bool
+	}{
+		{
+			name: "OpenAI usage",
+			body: `{"usage": {
+				"prompt_tokens": 100,
+				"completion_tokens": 50,
+				"total_tokens": 150,
+				"prompt_tokens_details": {"cached_tokens": 80},
+				"completion_tokens_details": {"reasoning_tokens": 30}
+			}}`,
+			want:   Usage{PromptTokens: 100, CompletionTokens: 50, TotalTokens: 150, CachedTokens: 80, ReasoningTokens: 30},
+			wantOK: true,
+		},
+		{
+			name:   "OpenAI usage without total",
+			body:   `{"usage": {"prompt_tokens": 10, "completion_tokens": 5}}`,
+			want:   Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15},
+			wantOK: true,
+		},
+		{
+			name: "Vertex AI usage metadata",
+			body: `{"usageMetadata": {
+				"promptTokenCount": 100,
+				"candidatesTokenCount": 20,
+				"totalTokenCount": 150,
+				"cachedContentTokenCount": 60,
+				"thoughtsTokenCount": 30
+			}}`,
+			want:   Usage{PromptTokens: 100, CompletionTokens: 50, TotalTokens: 150, CachedTokens: 60, ReasoningTokens: 30},
+			wantOK: true,
+		},
+		{
+			name:   "Anthropic usage",
+			body:   `{"usage": {"input_tokens": 20, "output_tokens": 10, "cache_read_input_tokens": 70, "cache_creation_input_tokens": 10}}`,
+			want:   Usage{PromptTokens: 100, CompletionTokens: 10, TotalTokens: 110, CachedTokens: 70},
+			wantOK: true,
+		},
+		{
+			name:   "Bedrock Converse usage",
+			body:   `{"output": {"message": {"role": "assistant", "content": [{"text": "Hi"}]}}, "usage": {"inputTokens": 20, "outputTokens": 10, "totalTokens": 100, "cacheReadInputTokens": 60, "cacheWriteInputTokens": 10}}`,
+			want:   Usage{PromptTokens: 90, CompletionTokens: 10, TotalTokens: 100, CachedTokens: 60},
+			wantOK: true,
+		},
+		{
+			name:   "Bedrock Converse usage without cache",
+			body:   `{"usage": {"inputTokens": 12, "outputTokens": 3, "totalTokens": 15}}`,
+			want:   Usage{PromptTokens: 12, CompletionTokens: 3, TotalTokens: 15},
+			wantOK: true,
+		},
+		{
+			name: "Vertex AI embeddings statistics",
+			body: `{"predictions": [
+				{"embeddings": {"values": [0.1], "statistics": {"token_count": 4}}},
+				{"embeddings": {"values": [0.2], "statistics": {"token_count": 6}}}
+			]}`,
+			want:   Usage{PromptTokens: 10, TotalTokens: 10},
+			wantOK: true,
+		},
+		{
+			name:   "no usage",
+			body:   `{"choices": []}`,
+			wantOK: false,
+		},
+		{
+			name:   "usage without token counts",
+			body:   `{"usage": {"service_tier": "default"}}`,
+			wantOK: false,
+		},
+		{
+			name:   "invalid JSON",
+			body:   `{"usage": `,
+			wantOK: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParseUsage([]byte(tt.body))
+			if ok != tt.wantOK {
+				t.Fatalf("ParseUsage() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if got != tt.want {
+				t.Errorf("ParseUsage() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUsageHeader(t *testing.T) {
+	header := make(http.Header)
+	if _, ok := UsageFromHeader(header); ok {
+		t.Error("UsageFromHeader() of an empty header reported usage")
+	}
+
+	usage := Usage{PromptTokens: 100, CompletionTokens: 50, TotalTokens: 150, CachedTokens: 80, ReasoningTokens: 30}
+	SetUsageHeader(header, usage)
+	if got, ok := UsageFromHeader(header); !ok || got != usage {
+		t.Errorf("UsageFromHeader() = %+v, %v, want %+v", got, ok, usage)
+	}
+}
@@ -0,0 +1,159 @@
+package keypool
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Not-Diamond/go-notdiamond/pkg/model"
+)
+
+const (
+	// DefaultCooldown is how long a key rate limited with 429 is skipped.
+	DefaultCooldown = time.Minute
+	// DefaultQuarantine is how long a key rejected with 401 is skipped.
+	DefaultQuarantine = time.Hour
+)
+
+// ErrExhausted is returned when every key of a pool is cooling down.
+var ErrExhausted = errors.New("all keys of the pool are cooling down")
+
+// For testing purposes, we make this function variable
+var now = time.Now
+
+// Pool hands out the keys of a model.KeyPool and keeps track of throttled keys.
+// Its state is kept in memory and is safe for concurrent use.
+type Pool struct {
+	mu         sync.Mutex
+	keys       []keyState
+	strategy   model.KeyPoolStrategy
+	cooldown   time.Duration
+	quarantine time.Duration
+	next       int
+}
+
+type keyState struct {
+	key         string
+	throttledAt time.Time
+	until       time.Time
+}
+
+// New creates a pool from its configuration.
+func New(config model.KeyPool) *Pool {
+	pool := &Pool{
+		keys:       make([]keyState, len(config.Keys)),
+		strategy:   config.Strategy,
+		cooldown:   config.Cooldown,
+		quarantine: config.Quarantine,
+	}
+	for i, key := range config.Keys {
+		pool.keys[i].key = key
+	}
+	if pool.cooldown <= 0 {
+		pool.cooldown = DefaultCooldown
+	}
+	if pool.quarantine <= 0 {
+		pool.quarantine = DefaultQuarantine
+	}
+	return pool
+}
+
+// Next returns the next key that is not cooling down. Round robin takes the keys
+// in turn, least recently throttled prefers keys that were never or longest ago
+// throttled.
+func (p *Pool) Next() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t := now()
+	selected := -1
+	for i := range p.keys {
+		idx := (p.next + i) % len(p.keys)
+		if t.Before(p.keys[idx].until) {
+			continue
+		}
+		if p.strategy != model.KeyPoolLeastRecentlyThrottled {
+			selected = idx
+			break
+		}
+		if selected == -1 || p.keys[idx].throttledAt.Before(p.keys[selected].throttledAt) {
+			selected = idx
+		}
+	}
+	if selected == -1 {
+		return "", ErrExhausted
+	}
+
+	p.next = selected + 1
+	return p.keys[selected].key, nil
+}
+
+// Throttle cools a key down after a 429, or quarantines it after a 401. Other
+// status codes are ignored.
+func (p *Pool) Throttle(key string, statusCode int) {
+	var d time.Duration
+	switch statusCode {
+	case http.StatusTooManyRequests:
+		d = p.cooldown
+	case http.StatusUnauthorized:
+		d = p.quarantine
+	default:
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t := now()
+	for i := range p.keys {
+		if p.keys[i].key == key {
+			p.keys[i].throttledAt = t
+			p.keys[i].until = t.Add(d)
+		}
+	}
+}
+
+// Available reports whether a key is not cooling down.
+func (p *Pool) Available() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t := now()
+	for _, k := range p.keys {
+		if !t.Before(k.until) {
+			return true
+		}
+	}
+	return false
+}
+
+// Pools holds the pools of a configuration, keyed by provider or provider/region.
+type Pools struct {
+	pools map[string]*Pool
+}
+
+// NewPools creates the pools of the configuration.
+func NewPools(config map[string]model.KeyPool) *Pools {
+	pools := &Pools{pools: make(map[string]*Pool, len(config))}
+	for name, poolConfig := range config {
+		if len(poolConfig.Keys) > 0 {
+			pools.pools[name] = New(poolConfig)
+		}
+	}
+	return pools
+}
+
+// For returns the pool of the provider and region, falling back to the pool of
+// the provider, or nil if neither is configured.
+func (p *Pools) For(provider, region string) *Pool {
+	if p == nil {
+		return nil
+	}
+	if region != "" {
+		if pool, ok := p.pools[provider+"/"+region]; ok {
+			return pool
+		}
+	}
+	return p.pools[provider]
+}
@@ -0,0 +1,172 @@
+package keypool
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Not-Diamond/go-notdiamond/pkg/model"
+)
+
+func TestPoolNext(t *testing.T) {
+	type throttle struct {
+		key        string
+		statusCode int
+		after      time.Duration
+	}
+
+	tests := []struct {
+		name      string
+		config    model.KeyPool
+		throttles []throttle
+		elapsed   time.Duration
+		want      []string
+		wantErr   error
+	}{
+		{
+			name:   "round robin",
+			config: model.KeyPool{Keys: []string{"a", "b", "c"}},
+			want:   []string{"a", "b", "c", "a"},
+		},
+		{
+			name:      "round robin skips rate limited key",
+			config:    model.KeyPool{Keys: []string{"a", "b", "c"}},
+			throttles: []throttle{{key: "b", statusCode: http.StatusTooManyRequests}},
+			want:      []string{"a", "c", "a"},
+		},
+		{
+			name:      "rate limited key is back after the cooldown",
+			config:    model.KeyPool{Keys: []string{"a", "b"}, Cooldown: 10 * time.Second},
+			throttles: []throttle{{key: "a", statusCode: http.StatusTooManyRequests}},
+			elapsed:   10 * time.Second,
+			want:      []string{"a", "b"},
+		},
+		{
+			name:      "unauthorized key is quarantined after the cooldown",
+			config:    model.KeyPool{Keys: []string{"a", "b"}, Cooldown: 10 * time.Second},
+			throttles: []throttle{{key: "a", statusCode: http.StatusUnauthorized}},
+			elapsed:   10 * time.Second,
+			want:      []string{"b", "b"},
+		},
+		{
+			name:      "other status codes are ignored",
+			config:    model.KeyPool{Keys: []string{"a", "b"}},
+			throttles: []throttle{{key: "a", statusCode: http.StatusInternalServerError}},
+			want:      []string{"a", "b"},
+		},
+		{
+			name: "least recently throttled",
+			config: model.KeyPool{
+				Keys:     []string{"a", "b", "c"},
+				Strategy: model.KeyPoolLeastRecentlyThrottled,
+				Cooldown: 10 * time.Second,
+			},
+			throttles: []throttle{
+				{key: "a", statusCode: http.StatusTooManyRequests},
+				{key: "b", statusCode: http.StatusTooManyRequests, after: 5 * time.Second},
+			},
+			elapsed: 20 * time.Second,
+			want:    []string{"c", "c", "c"},
+		},
+		{
+			name: "least recently throttled after the cooldown",
+			config: model.KeyPool{
+				Keys:     []string{"a", "b"},
+				Strategy: model.KeyPoolLeastRecentlyThrottled,
+				Cooldown: 10 * time.Second,
+			},
+			throttles: []throttle{
+				{key: "b", statusCode: http.StatusTooManyRequests},
+				{key: "a", statusCode: http.StatusTooManyRequests, after: 5 * time.Second},
+			},
+			elapsed: 20 * time.Second,
+			want:    []string{"b", "b"},
+		},
+		{
+			name:   "all keys cooling down",
+			config: model.KeyPool{Keys: []string{"a", "b"}},
+			throttles: []throttle{
+				{key: "a", statusCode: http.StatusTooManyRequests},
+				{key: "b", statusCode: http.StatusUnauthorized},
+			},
+			wantErr: ErrExhausted,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+			originalNow := now
+			now = func() time.Time { return current }
+			defer func() { now = originalNow }()
+
+			pool := New(tt.config)
+			start := current
+			for _, th := range tt.throttles {
+				current = start.Add(th.after)
+				pool.Throttle(th.key, th.statusCode)
+			}
+			current = start.Add(tt.elapsed)
+
+			if tt.wantErr != nil {
+				if pool.Available() {
+					t.Error("Available() = true, want false")
+				}
+				if _, err := pool.Next(); !errors.Is(err, tt.wantErr) {
+					t.Errorf("Next() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+
+			for i, want := range tt.want {
+				got, err := pool.Next()
+				if err != nil {
+					t.Fatalf("Next() error = %v", err)
+				}
+				if got != want {
+					t.Errorf("Next() #%d = %q, want %q", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPoolsFor(t *testing.T) {
+	pools := NewPools(map[string]model.KeyPool{
+		"openai":       {Keys: []string{"openai-key"}},
+		"azure/eastus": {Keys: []string{"eastus-key"}},
+		"anthropic":    {},
+	})
+
+	tests := []struct {
+		name     string
+		provider string
+		region   string
+		wantKey  string
+	}{
+		{name: "provider pool", provider: "openai", wantKey: "openai-key"},
+		{name: "provider pool for region", provider: "openai", region: "us-east1", wantKey: "openai-key"},
+		{name: "region pool", provider: "azure", region: "eastus", wantKey: "eastus-key"},
+		{name: "no pool for region", provider: "azure", region: "westus"},
+		{name: "empty pool", provider: "anthropic"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := pools.For(tt.provider, tt.region)
+			if tt.wantKey == "" {
+				if pool != nil {
+					t.Errorf("For(%q, %q) = %v, want nil", tt.provider, tt.region, pool)
+				}
+				return
+			}
+			if pool == nil {
+				t.Fatalf("For(%q, %q) = nil", tt.provider, tt.region)
+			}
+			if key, _ := pool.Next(); key != tt.wantKey {
+				t.Errorf("Next() = %q, want %q", key, tt.wantKey)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package model
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+)
+
+// Message is a chat message in OpenAI format. Its content is either a string or a
+// list of content parts. Other fields, such as tool_calls, are kept as they are.
+type Message map[string]interface{}
+
+// Role returns the role of the message.
+func (m Message) Role() string {
+	role, _ := m["role"].(string)
+	return role
+}
+
+// Parts returns the content of the message as content parts. String content is a
+// single text part.
+func (m Message) Parts() []ContentPart {
+	switch content := m["content"].(type) {
+	case nil:
+		return nil
+	case string:
+		return []ContentPart{TextPart(content)}
+	case []ContentPart:
+		return content
+	default:
+		data, err := json.Marshal(content)
+		if err != nil {
+			return nil
+		}
+		var parts []ContentPart
+		if err := json.Unmarshal(data, &parts); err != nil {
+			return nil
+		}
+		return parts
+	}
+}
+
+// Text returns the text parts of the message content joined together.
+func (m Message) Text() string {
+	var texts []string
+	for _, part := range m.Parts() {
+		if part.Type == ContentPartText {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "")
+}
+
+// Content part types.
+const (
+	ContentPartText     = "text"
+	ContentPartImageURL = "image_url"
+	ContentPartFile     = "file"
+)
+
+// ContentPart is a part of multimodal message content in OpenAI format. Images are
+// referenced by URL or embedded as base64 data URLs, files are embedded as base64
+// data URLs or referenced by OpenAI file ID.
+type ContentPart struct {
+	Type     string    `json:"type"`
+	Text     string    `json:"text,omitempty"`
+	ImageURL *ImageURL `json:"image_url,omitempty"`
+	File     *File     `json:"file,omitempty"`
+}
+
+// ImageURL is the image of an image_url content part.
+type ImageURL struct {
+	URL    string `json:"url"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// File is the file of a file content part.
+type File struct {
+	FileData string `json:"file_data,omitempty"`
+	FileID   string `json:"file_id,omitempty"`
+	Filename string `json:"filename,omitempty"`
+}
+
+// TextPart returns a text content part.
+func TextPart(text string) ContentPart {
+	return ContentPart{Type: ContentPartText, Text: text}
+}
+
+// ImageURLPart returns a content part referencing an image by URL.
+func ImageURLPart(url string) ContentPart {
+	return ContentPart{Type: ContentPartImageURL, ImageURL: &ImageURL{URL: url}}
+}
+
+// ImageDataPart returns a content part embedding a base64 encoded image.
+func ImageDataPart(mimeType string, data []byte) ContentPart {
+	return ImageURLPart(DataURL(mimeType, data))
+}
+
+// FileDataPart returns a content part embedding a base64 encoded file.
+func FileDataPart(filename, mimeType string, data []byte) ContentPart {
+	return ContentPart{Type: ContentPartFile, File: &File{FileData: DataURL(mimeType, data), Filename: filename}}
+}
+
+// DataURL returns the base64 data URL of the data.
+func DataURL(mimeType string, data []byte) string {
+	return "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+// ParseDataURL splits a base64 data URL into its MIME type and base64 encoded data.
+func ParseDataURL(url string) (mimeType, data string, ok bool) {
+	rest, ok := strings.CutPrefix(url, "data:")
+	if !ok {
+		return "", "", false
+	}
+	header, data, ok := strings.Cut(rest, ",")
+	if !ok {
+		return "", "", false
+	}
+	mimeType, ok = strings.CutSuffix(header, ";base64")
+	if !ok {
+		return "", "", false
+	}
+	return mimeType, data, true
+}
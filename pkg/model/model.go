@@ -1,10 +1,14 @@
 package model
 
 import (
+	"context"
 	"net/http"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/Not-Diamond/go-notdiamond/pkg/redis"
+	"golang.org/x/oauth2"
 )
 
 // Models is a type that can be used to represent a list of models.
@@ -12,9 +16,6 @@ type Models interface {
 	isModels()
 }
 
-// Message is a map of strings.
-type Message map[string]string
-
 // OrderedModels is a type that can be used to represent a list of models.
 type OrderedModels []string
 
@@ -29,11 +30,192 @@ func (WeightedModels) isModels() {}
 type clientType string
 
 const (
-	ClientTypeAzure  clientType = "azure"
-	ClientTypeOpenai clientType = "openai"
-	ClientTypeVertex clientType = "vertex"
+	ClientTypeAzure     clientType = "azure"
+	ClientTypeOpenai    clientType = "openai"
+	ClientTypeVertex    clientType = "vertex"
+	ClientTypeAnthropic clientType = "anthropic"
+	ClientTypeBedrock   clientType = "bedrock"
+	ClientTypeGemini    clientType = "gemini"
 )
 
+// Provider describes how to talk to an LLM provider: which hosts belong to it,
+// how request URLs are built and authenticated, how bodies are encoded and
+// decoded, and how error responses are parsed. Built-in providers live in
+// pkg/clients; custom providers are registered through Config.Providers.
+type Provider interface {
+	// MatchesHost reports whether the given request host belongs to the provider.
+	MatchesHost(host string) bool
+	// UpdateURL rewrites the request URL for the model and optional region.
+	UpdateURL(req *http.Request, modelName string, region string, config Config) error
+	// Authenticate sets the authentication headers on the request. The key selected
+	// from a key pool, if any, is carried by ctx and read with APIKey.
+	Authenticate(ctx context.Context, req *http.Request, region string, config Config) error
+	// EncodeRequest transforms an OpenAI, Vertex or Anthropic body into the provider's format.
+	EncodeRequest(body []byte, modelName string, config Config) ([]byte, error)
+	// DecodeResponse transforms a provider response body into OpenAI format.
+	DecodeResponse(body []byte) ([]byte, error)
+	// EncodeResponse transforms an OpenAI format response body into the provider's
+	// format, for callers that sent their request in the provider's format.
+	EncodeResponse(body []byte) ([]byte, error)
+	// ParseError builds an error from an unsuccessful response of the model in the
+	// optional region.
+	ParseError(statusCode int, body []byte, modelName string, region string) error
+}
+
+// StreamProvider is implemented by providers that can translate their streamed
+// (SSE) responses. Streamed requests are not routed between providers that don't
+// implement it.
+type StreamProvider interface {
+	// DecodeStreamEvent transforms the data of a streamed event into the data of an
+	// OpenAI chat.completion.chunk event. Nil data drops the event.
+	DecodeStreamEvent(data []byte) ([]byte, error)
+	// EncodeStreamEvent transforms the data of an OpenAI chat.completion.chunk event
+	// into the data of the provider's event. It is called with [DONE] at the end of
+	// the stream. Nil data drops the event.
+	EncodeStreamEvent(data []byte) ([]byte, error)
+}
+
+// StatefulStreamProvider is implemented by stream providers whose translation of
+// an event depends on the earlier events of the stream, e.g. to number tool calls
+// or to collect tool call arguments streamed in fragments.
+type StatefulStreamProvider interface {
+	// NewStream returns the StreamProvider translating a single stream.
+	NewStream() StreamProvider
+}
+
+// StreamEvent is an event of a streamed (SSE) response.
+type StreamEvent struct {
+	// Type is the event field of the event, left out if empty.
+	Type string
+	// Data is the data field of the event.
+	Data []byte
+}
+
+// StreamEventsEncoder is implemented by stream providers whose events are named or
+// that answer an OpenAI chunk with several events, e.g. Anthropic. Its
+// EncodeStreamEvents is used instead of EncodeStreamEvent.
+type StreamEventsEncoder interface {
+	// EncodeStreamEvents transforms the data of an OpenAI chat.completion.chunk event
+	// into the provider's events. It is called with [DONE] at the end of the stream.
+	EncodeStreamEvents(data []byte) ([]StreamEvent, error)
+}
+
+// RegionalProvider is implemented by providers that serve models from regions.
+// The last part of their model names is the region, e.g. vertex/gemini-pro/us-east4.
+// The model names of other providers are kept whole, slashes included.
+type RegionalProvider interface {
+	// Regional reports whether the provider serves models from regions.
+	Regional() bool
+}
+
+// APIKeyProvider is implemented by providers that authenticate with the key of
+// APIKey when one is set, so that key pools in Config.KeyPools apply to them.
+type APIKeyProvider interface {
+	// UsesAPIKeys reports whether the provider authenticates with pooled API keys.
+	UsesAPIKeys() bool
+}
+
+// UsesAPIKeys reports whether the provider authenticates with pooled API keys.
+func UsesAPIKeys(provider Provider) bool {
+	keyed, ok := provider.(APIKeyProvider)
+	return ok && keyed.UsesAPIKeys()
+}
+
+// Providers is a registry of providers keyed by the prefix used in model names.
+type Providers map[string]Provider
+
+// Names returns the registered provider names in sorted order.
+func (p Providers) Names() []string {
+	names := make([]string, 0, len(p))
+	for name := range p {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SplitModel splits a model name in provider/model or provider/model/region format
+// into its parts. Only models of regional providers have a region, so that e.g.
+// openaicompat/meta-llama/Llama-3-70b is the model meta-llama/Llama-3-70b.
+func (p Providers) SplitModel(modelFull string) (provider, modelName, region string) {
+	provider, rest, _ := strings.Cut(modelFull, "/")
+	modelName, region = p.SplitRegion(provider, rest)
+	return provider, modelName, region
+}
+
+// SplitRegion splits a model name of the provider in model or model/region format.
+// The model names of providers that are not regional have no region.
+func (p Providers) SplitRegion(provider, name string) (modelName, region string) {
+	if regional, ok := p[provider].(RegionalProvider); ok && regional.Regional() {
+		if i := strings.LastIndex(name, "/"); i >= 0 {
+			return name[:i], name[i+1:]
+		}
+	}
+	return name, ""
+}
+
+// clientProviderKey is the context key of the provider a client request is tagged with.
+type clientProviderKey struct{}
+
+// WithProvider tags a client request with the provider that serves it. Tagged
+// clients are matched on their exact host, which is needed for gateways, private
+// endpoints and custom domains that the provider's MatchesHost does not recognise.
+func WithProvider(req *http.Request, provider string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), clientProviderKey{}, provider))
+}
+
+// ClientProvider returns the provider a client request was tagged with, or an
+// empty string if it is untagged.
+func ClientProvider(req *http.Request) string {
+	provider, _ := req.Context().Value(clientProviderKey{}).(string)
+	return provider
+}
+
+// apiKeyKey is the context key of the API key selected from a key pool.
+type apiKeyKey struct{}
+
+// WithAPIKey returns a context carrying the API key a request is authenticated
+// with, instead of the key of the client request.
+func WithAPIKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, apiKeyKey{}, key)
+}
+
+// APIKey returns the API key carried by the context, or an empty string.
+func APIKey(ctx context.Context) string {
+	key, _ := ctx.Value(apiKeyKey{}).(string)
+	return key
+}
+
+// streamingKey is the context key marking requests for a streamed response.
+type streamingKey struct{}
+
+// WithStreaming returns a context marking the request as asking for a streamed
+// response, for providers that stream from a different endpoint.
+func WithStreaming(ctx context.Context) context.Context {
+	return context.WithValue(ctx, streamingKey{}, true)
+}
+
+// Streaming reports whether the context marks a request for a streamed response.
+func Streaming(ctx context.Context) bool {
+	streaming, _ := ctx.Value(streamingKey{}).(bool)
+	return streaming
+}
+
+// embeddingsKey is the context key marking embedding requests.
+type embeddingsKey struct{}
+
+// WithEmbeddings returns a context marking the request as asking for embeddings,
+// for providers that serve them from a different endpoint.
+func WithEmbeddings(ctx context.Context) context.Context {
+	return context.WithValue(ctx, embeddingsKey{}, true)
+}
+
+// Embeddings reports whether the context marks a request for embeddings.
+func Embeddings(ctx context.Context) bool {
+	embeddings, _ := ctx.Value(embeddingsKey{}).(bool)
+	return embeddings
+}
+
 // RollingAverageLatency is a type that can be used to represent a rolling average latency.
 type RollingAverageLatency struct {
 	AvgLatencyThreshold float64
@@ -69,21 +251,105 @@ type RollingErrorTracking struct {
 // ModelErrorTracking is a type that can be used to represent model error tracking configuration.
 type ModelErrorTracking map[string]*RollingErrorTracking
 
+// AzureRegion is the configuration of the Azure OpenAI resource serving a region.
+type AzureRegion struct {
+	Endpoint    string             // Resource endpoint, e.g. https://eastus-resource.openai.azure.com
+	APIKey      string             // api-key of the resource, used instead of the caller's key
+	TokenSource oauth2.TokenSource // Entra ID token source, used instead of the api-key header
+	APIVersion  string             // API version, overriding Config.AzureAPIVersion
+	Deployments map[string]string  // Model name to deployment name, models default to their own name
+}
+
+// KeyPoolStrategy selects the next key of a key pool.
+type KeyPoolStrategy string
+
+const (
+	KeyPoolRoundRobin             KeyPoolStrategy = "round_robin"
+	KeyPoolLeastRecentlyThrottled KeyPoolStrategy = "least_recently_throttled"
+)
+
+// KeyPool is a set of API keys spreading the rate limits of a provider or region.
+// A key rate limited with 429 is skipped for Cooldown, a key rejected with 401
+// for Quarantine.
+type KeyPool struct {
+	Keys       []string
+	Strategy   KeyPoolStrategy // Defaults to KeyPoolRoundRobin
+	Cooldown   time.Duration   // Defaults to one minute
+	Quarantine time.Duration   // Defaults to one hour
+}
+
+// UnsupportedParamsPolicy decides what happens to request parameters that the
+// provider a request is translated for has no equivalent for.
+type UnsupportedParamsPolicy string
+
+const (
+	UnsupportedParamsDrop UnsupportedParamsPolicy = "drop" // Dropped with a logged warning
+	UnsupportedParamsFail UnsupportedParamsPolicy = "fail" // The attempt fails
+)
+
+// ModelParams are the request parameters of a model. They are set in the request
+// after it is translated for the model's provider, overriding the caller's values.
+// Parameters left nil or empty keep the caller's values.
+type ModelParams struct {
+	Temperature      *float64
+	MaxTokens        *int // Maximum output tokens, in the provider's field for them
+	TopP             *float64
+	PresencePenalty  *float64
+	FrequencyPenalty *float64
+	ReasoningEffort  string // OpenAI reasoning effort, e.g. low, medium or high
+	// MaxOutputTokens is the output token limit of the model. Requests asking for
+	// more are lowered to it.
+	MaxOutputTokens int
+	// Reasoning marks an OpenAI reasoning model, which takes max_completion_tokens
+	// instead of max_tokens. The o-series and gpt-5 models are recognised by name.
+	Reasoning bool
+	// Patches set or delete fields of the translated request, after the parameters.
+	Patches []ParamPatch
+}
+
+// ParamPatchOp is the operation of a ParamPatch.
+type ParamPatchOp string
+
+const (
+	ParamPatchSet    ParamPatchOp = "set"    // Sets the field, creating the objects on its path
+	ParamPatchDelete ParamPatchOp = "delete" // Deletes the field if present
+)
+
+// ParamPatch sets or deletes a field of a request body. Path is a JSON Pointer
+// through objects, e.g. /generationConfig/thinkingConfig/thinkingBudget.
+type ParamPatch struct {
+	Op    ParamPatchOp
+	Path  string
+	Value interface{} // Value set, marshalled to JSON
+}
+
 // Config is the configuration for the NotDiamond client.
 type Config struct {
-	Clients            []http.Request
-	Models             Models
-	MaxRetries         map[string]int
-	Timeout            map[string]float64
-	ModelMessages      map[string][]Message
-	Backoff            map[string]float64
-	StatusCodeRetry    interface{}
-	ModelLatency       ModelLatency
-	ModelErrorTracking ModelErrorTracking // Configuration for error code tracking
-	ModelLimits        ModelLimits
-	RedisConfig        *redis.Config // Redis configuration for metrics tracking
-	VertexProjectID    string
-	VertexLocation     string
-	AzureAPIVersion    string            // Azure API version to use for requests
-	AzureRegions       map[string]string // Map of region names to Azure endpoints
+	Clients               []http.Request
+	Models                Models
+	MaxRetries            map[string]int
+	Timeout               map[string]float64
+	ModelMessages         map[string][]Message
+	ModelParams           map[string]ModelParams // Keyed by provider/model or provider/model/region
+	Backoff               map[string]float64
+	StatusCodeRetry       interface{}
+	ModelLatency          ModelLatency
+	ModelErrorTracking    ModelErrorTracking // Configuration for error code tracking
+	ModelLimits           ModelLimits
+	RedisConfig           *redis.Config // Redis configuration for metrics tracking
+	VertexProjectID       string
+	VertexLocation        string
+	VertexTokenSource     oauth2.TokenSource      // Google token source for Vertex AI, should cache its tokens
+	VertexCredentialsFile string                  // Service-account JSON file, used when VertexTokenSource is not set
+	AzureAPIVersion       string                  // Azure API version to use for requests
+	AzureRegions          map[string]AzureRegion  // Azure resources keyed by region name
+	AzureTokenSource      oauth2.TokenSource      // Entra ID token source, used instead of the api-key header
+	Providers             Providers               // Custom providers, keyed by model name prefix
+	KeyPools              map[string]KeyPool      // API key pools, keyed by provider or provider/region
+	UnsupportedParams     UnsupportedParamsPolicy // Defaults to UnsupportedParamsDrop
+	// EmbeddingDimensions are the vector sizes of embedding models, keyed by
+	// provider/model. Embedding requests don't fall back between models of known,
+	// different sizes unless AllowEmbeddingDimensionMismatch is set.
+	EmbeddingDimensions             map[string]int
+	AllowEmbeddingDimensionMismatch bool
 }
@@ -2,6 +2,7 @@ package model
 
 import (
 	"net/http"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -109,6 +110,88 @@ func TestMessage_Usage(t *testing.T) {
 	}
 }
 
+func TestMessage_Parts(t *testing.T) {
+	tests := []struct {
+		name      string
+		message   Message
+		wantRole  string
+		wantParts []ContentPart
+		wantText  string
+	}{
+		{
+			name:      "string content",
+			message:   Message{"role": "user", "content": "Hello"},
+			wantRole:  "user",
+			wantParts: []ContentPart{TextPart("Hello")},
+			wantText:  "Hello",
+		},
+		{
+			name: "decoded content parts",
+			message: Message{"role": "user", "content": []interface{}{
+				map[string]interface{}{"type": "text", "text": "What is "},
+				map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": "https://example.com/cat.png"}},
+				map[string]interface{}{"type": "text", "text": "this?"},
+			}},
+			wantRole:  "user",
+			wantParts: []ContentPart{TextPart("What is "), ImageURLPart("https://example.com/cat.png"), TextPart("this?")},
+			wantText:  "What is this?",
+		},
+		{
+			name: "typed content parts",
+			message: Message{"role": "user", "content": []ContentPart{
+				ImageDataPart("image/png", []byte("png")),
+				FileDataPart("spec.pdf", "application/pdf", []byte("pdf")),
+			}},
+			wantRole: "user",
+			wantParts: []ContentPart{
+				{Type: ContentPartImageURL, ImageURL: &ImageURL{URL: "data:image/png;base64,cG5n"}},
+				{Type: ContentPartFile, File: &File{FileData: "data:application/pdf;base64,cGRm", Filename: "spec.pdf"}},
+			},
+		},
+		{
+			name:     "no content",
+			message:  Message{"role": "assistant", "content": nil},
+			wantRole: "assistant",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.message.Role(); got != tt.wantRole {
+				t.Errorf("Role() = %q, want %q", got, tt.wantRole)
+			}
+			if got := tt.message.Parts(); !reflect.DeepEqual(got, tt.wantParts) {
+				t.Errorf("Parts() = %+v, want %+v", got, tt.wantParts)
+			}
+			if got := tt.message.Text(); got != tt.wantText {
+				t.Errorf("Text() = %q, want %q", got, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestParseDataURL(t *testing.T) {
+	tests := []struct {
+		url          string
+		wantMimeType string
+		wantData     string
+		wantOK       bool
+	}{
+		{url: "data:image/png;base64,iVBORw0KGgo=", wantMimeType: "image/png", wantData: "iVBORw0KGgo=", wantOK: true},
+		{url: "data:text/plain,hello"},
+		{url: "https://example.com/cat.png"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			mimeType, data, ok := ParseDataURL(tt.url)
+			if ok != tt.wantOK || mimeType != tt.wantMimeType || data != tt.wantData {
+				t.Errorf("ParseDataURL() = %q, %q, %v, want %q, %q, %v", mimeType, data, ok, tt.wantMimeType, tt.wantData, tt.wantOK)
+			}
+		})
+	}
+}
+
 func TestRollingAverageLatency_Usage(t *testing.T) {
 	latency := &RollingAverageLatency{
 		AvgLatencyThreshold: 3.5,
@@ -97,7 +97,8 @@ func TestRegionFallback(t *testing.T) {
 			"vertex/gemini-pro/us-central1", // Final fallback to us-central1 (will succeed)
 		},
 		Clients: []http.Request{
-			*vertexRequest,
+			// The test server host is not a Vertex AI host, so the client is tagged explicitly
+			*model.WithProvider(vertexRequest, "vertex"),
 		},
 		VertexProjectID: "test-project",
 		VertexLocation:  "us-central1",
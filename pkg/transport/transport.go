@@ -11,6 +11,7 @@ import (
 
 	"bytes"
 
+	"github.com/Not-Diamond/go-notdiamond/pkg/clients"
 	http_client "github.com/Not-Diamond/go-notdiamond/pkg/http/client"
 	"github.com/Not-Diamond/go-notdiamond/pkg/http/request"
 	"github.com/Not-Diamond/go-notdiamond/pkg/metric"
@@ -56,7 +57,7 @@ func NewTransport(config model.Config) (*Transport, error) {
 	client := &http_client.Client{
 		Clients:        config.Clients,
 		Models:         config.Models,
-		ModelProviders: buildModelProviders(config.Models),
+		ModelProviders: buildModelProviders(config.Models, clients.Registry(config)),
 		IsOrdered:      isOrderedModels(config.Models),
 		HttpClient:     ndHttpClient,
 	}
@@ -70,59 +71,66 @@ func NewTransport(config model.Config) (*Transport, error) {
 }
 
 func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
-	// Extract the original messages and model
-	messages := request.ExtractMessagesFromRequest(req)
-	extractedModel, err := request.ExtractModelFromRequest(req)
+	providers := clients.Registry(t.config)
+	extractedProvider, err := request.ExtractProviderFromClients(req, t.client.Clients, providers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract provider: %w", err)
+	}
+
+	// Parse the body once, the client carries it through all attempts
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body: %w", err)
+	}
+	env, err := request.ParseEnvelope(req, body, extractedProvider, providers)
 	if err != nil {
 		return nil, fmt.Errorf("failed to extract model: %w", err)
 	}
-	extractedProvider := request.ExtractProviderFromRequest(req)
-	currentModel := extractedProvider + "/" + extractedModel
 
 	// Combine with model messages if they exist
-	if modelMessages, exists := t.config.ModelMessages[currentModel]; exists {
-		if err := updateRequestWithCombinedMessages(req, modelMessages, messages, extractedModel); err != nil {
+	if modelMessages, exists := t.config.ModelMessages[env.RequestedModel()]; exists {
+		if body, err = combinedMessagesBody(env, modelMessages); err != nil {
 			return nil, err
 		}
+		// The combined messages are parsed again, only the messages changed
+		if env, err = request.ParseEnvelope(req, body, extractedProvider, providers); err != nil {
+			return nil, fmt.Errorf("failed to extract model: %w", err)
+		}
 	}
+	req.Body = io.NopCloser(bytes.NewBuffer(body))
+	req.ContentLength = int64(len(body))
 
-	// Add client to context and proceed with request
+	// Add client and parsed request to context and proceed with request
 	ctx := context.WithValue(req.Context(), http_client.ClientKey, t.client)
-	req = req.WithContext(ctx)
+	req = req.WithContext(request.WithEnvelope(ctx, env))
 
 	return t.client.HttpClient.Do(req)
 }
 
-// updateRequestWithCombinedMessages updates the request with combined messages.
-func updateRequestWithCombinedMessages(req *http.Request, modelMessages []model.Message, messages []model.Message, extractedModel string) error {
-	combinedMessages, err := http_client.CombineMessages(modelMessages, messages)
-	if err != nil {
-		return err
+// combinedMessagesBody puts the model messages into the request body, leaving its
+// other fields unchanged. Vertex AI and Anthropic bodies get them before their own
+// contents or messages, other bodies get the combined messages.
+func combinedMessagesBody(env *request.Envelope, modelMessages []model.Message) ([]byte, error) {
+	if _, ok := env.Fields["contents"]; ok {
+		return request.PrependVertexMessages(env.Body, modelMessages)
 	}
-
-	payload := map[string]interface{}{
-		"model":    extractedModel,
-		"messages": combinedMessages,
+	if env.IsAnthropic() {
+		return request.PrependAnthropicMessages(env.Body, modelMessages)
 	}
-
-	jsonData, err := json.Marshal(payload)
+	combinedMessages, err := http_client.CombineMessages(modelMessages, env.Messages)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	req.Body = io.NopCloser(bytes.NewBuffer(jsonData))
-	req.ContentLength = int64(len(jsonData))
-	return nil
+	return request.WithMessages(env.Body, combinedMessages)
 }
 
-func buildModelProviders(models model.Models) map[string]map[string]bool {
+func buildModelProviders(models model.Models, providers model.Providers) map[string]map[string]bool {
 	modelProviders := make(map[string]map[string]bool)
 
 	switch m := models.(type) {
 	case model.WeightedModels:
 		for modelFull := range m {
-			parts := strings.Split(modelFull, "/")
-			provider, model := parts[0], parts[1]
+			provider, model, _ := providers.SplitModel(modelFull)
 			if modelProviders[model] == nil {
 				modelProviders[model] = make(map[string]bool)
 			}
@@ -130,8 +138,7 @@ func buildModelProviders(models model.Models) map[string]map[string]bool {
 		}
 	case model.OrderedModels:
 		for _, modelFull := range m {
-			parts := strings.Split(modelFull, "/")
-			provider, model := parts[0], parts[1]
+			provider, model, _ := providers.SplitModel(modelFull)
 			if modelProviders[model] == nil {
 				modelProviders[model] = make(map[string]bool)
 			}
@@ -13,6 +13,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/Not-Diamond/go-notdiamond/pkg/clients"
 	http_client "github.com/Not-Diamond/go-notdiamond/pkg/http/client"
 	"github.com/Not-Diamond/go-notdiamond/pkg/http/request"
 	"github.com/Not-Diamond/go-notdiamond/pkg/metric"
@@ -91,10 +92,10 @@ func TestNewTransport(t *testing.T) {
 						},
 					},
 					{
-						Host: "myresource.azure.openai.com",
+						Host: "myresource.openai.azure.com",
 						URL: &url.URL{
 							Scheme: "https",
-							Host:   "myresource.azure.openai.com",
+							Host:   "myresource.openai.azure.com",
 							Path:   "/openai/deployments/gpt-4/chat/completions",
 						},
 					},
@@ -122,10 +123,10 @@ func TestNewTransport(t *testing.T) {
 						},
 					},
 					{
-						Host: "myresource.azure.openai.com",
+						Host: "myresource.openai.azure.com",
 						URL: &url.URL{
 							Scheme: "https",
-							Host:   "myresource.azure.openai.com",
+							Host:   "myresource.openai.azure.com",
 							Path:   "/openai/deployments/gpt-4/chat/completions",
 						},
 					},
@@ -228,7 +229,7 @@ func TestBuildModelProviders(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := buildModelProviders(tt.models)
+			got := buildModelProviders(tt.models, clients.Builtin())
 			if !reflect.DeepEqual(got, tt.expected) {
 				t.Errorf("buildModelProviders() = %v, want %v", got, tt.expected)
 			}
@@ -277,6 +278,37 @@ func TestTransport_RoundTrip(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "model messages keep image content",
+			requestBody: `{
+				"model": "gpt-4",
+				"messages": [{"role": "user", "content": [
+					{"type": "text", "text": "What is this?"},
+					{"type": "image_url", "image_url": {"url": "data:image/png;base64,iVBORw0KGgo="}}
+				]}]
+			}`,
+			modelMessages: map[string][]model.Message{
+				"openai/gpt-4": {
+					{"role": "system", "content": "You describe images."},
+				},
+			},
+			expectedBody: `{
+				"model": "gpt-4",
+				"messages": [
+					{"role": "system", "content": "You describe images."},
+					{"role": "user", "content": [
+						{"type": "text", "text": "What is this?"},
+						{"type": "image_url", "image_url": {"url": "data:image/png;base64,iVBORw0KGgo="}}
+					]}
+				]
+			}`,
+			mockResponse: &http.Response{
+				Status:     "200 OK",
+				StatusCode: 200,
+				Body:       io.NopCloser(bytes.NewBufferString(`{"success": true}`)),
+				Header:     http.Header{"Content-Type": []string{"application/json"}},
+			},
+		},
 		{
 			name: "request with model messages",
 			requestBody: `{
@@ -388,9 +420,8 @@ func TestTransport_RoundTrip(t *testing.T) {
 			ctx := context.WithValue(req.Context(), http_client.ClientKey, transport.client)
 			req = req.WithContext(ctx)
 
-			// Extract messages and model
-			messages := request.ExtractMessagesFromRequest(req)
-			extractedModel, err := request.ExtractModelFromRequest(req)
+			// Parse the request
+			env, err := request.ParseEnvelope(req, []byte(tt.requestBody), "openai", clients.Builtin())
 			if err != nil {
 				if tt.expectError {
 					if !strings.Contains(err.Error(), tt.errorContains) {
@@ -398,14 +429,16 @@ func TestTransport_RoundTrip(t *testing.T) {
 					}
 					return
 				}
-				t.Fatalf("Failed to extract model: %v", err)
+				t.Fatalf("Failed to parse request: %v", err)
 			}
 
 			// Combine with model messages if they exist
-			if modelMessages, exists := tt.modelMessages["openai/"+extractedModel]; exists {
-				if err := updateRequestWithCombinedMessages(req, modelMessages, messages, extractedModel); err != nil {
-					t.Fatalf("Failed to update request with combined messages: %v", err)
+			if modelMessages, exists := tt.modelMessages[env.RequestedModel()]; exists {
+				body, err := combinedMessagesBody(env, modelMessages)
+				if err != nil {
+					t.Fatalf("Failed to combine messages: %v", err)
 				}
+				req.Body = io.NopCloser(bytes.NewBuffer(body))
 			}
 
 			// Use mockTransport directly for testing
@@ -458,64 +491,158 @@ func TestTransport_RoundTrip(t *testing.T) {
 	}
 }
 
-func TestUpdateRequestWithCombinedMessages(t *testing.T) {
+func TestCombinedMessagesBody(t *testing.T) {
 	tests := []struct {
-		name           string
-		modelMessages  []model.Message
-		messages       []model.Message
-		extractedModel string
-		expectedBody   string
-		wantErr        bool
+		name          string
+		body          string
+		modelMessages []model.Message
+		expectedBody  string
+		wantErr       bool
 	}{
 		{
 			name: "successfully combines messages",
+			body: `{"model":"gpt-4","messages":[{"role":"user","content":"Hello"}]}`,
 			modelMessages: []model.Message{
 				{"role": "system", "content": "You are a helpful assistant."},
 			},
-			messages: []model.Message{
-				{"role": "user", "content": "Hello"},
-			},
-			extractedModel: "gpt-4",
-			expectedBody:   `{"model":"gpt-4","messages":[{"role":"system","content":"You are a helpful assistant."},{"role":"user","content":"Hello"}]}`,
-			wantErr:        false,
+			expectedBody: `{"model":"gpt-4","messages":[{"role":"system","content":"You are a helpful assistant."},{"role":"user","content":"Hello"}]}`,
+			wantErr:      false,
 		},
 		{
 			name:          "empty model messages",
+			body:          `{"model":"gpt-4","messages":[{"role":"user","content":"Hello"}]}`,
 			modelMessages: []model.Message{},
-			messages: []model.Message{
-				{"role": "user", "content": "Hello"},
+			expectedBody:  `{"model":"gpt-4","messages":[{"role":"user","content":"Hello"}]}`,
+			wantErr:       false,
+		},
+		{
+			name: "other fields are kept",
+			body: `{
+				"model": "openai/gpt-4",
+				"messages": [{"role": "user", "content": "Hello"}],
+				"temperature": 0.2,
+				"max_tokens": 50,
+				"stream": true,
+				"seed": 9007199254740993,
+				"user": "user-1",
+				"tools": [{"type": "function", "function": {"name": "get_weather"}}],
+				"response_format": {"type": "json_object"}
+			}`,
+			modelMessages: []model.Message{
+				{"role": "system", "content": "Be brief"},
 			},
-			extractedModel: "gpt-4",
-			expectedBody:   `{"model":"gpt-4","messages":[{"role":"user","content":"Hello"}]}`,
-			wantErr:        false,
+			expectedBody: `{
+				"model": "openai/gpt-4",
+				"messages": [{"role": "system", "content": "Be brief"}, {"role": "user", "content": "Hello"}],
+				"temperature": 0.2,
+				"max_tokens": 50,
+				"stream": true,
+				"seed": 9007199254740993,
+				"user": "user-1",
+				"tools": [{"type": "function", "function": {"name": "get_weather"}}],
+				"response_format": {"type": "json_object"}
+			}`,
+			wantErr: false,
+		},
+		{
+			name: "vertex contents",
+			body: `{
+				"model": "gemini-pro",
+				"systemInstruction": {"parts": [{"text": "Caller prompt"}]},
+				"contents": [
+					{"role": "user", "parts": [{"text": "Weather in Paris?"}]},
+					{"role": "model", "parts": [{"functionCall": {"name": "get_weather", "args": {"city": "Paris"}}}]},
+					{"role": "user", "parts": [{"functionResponse": {"name": "get_weather", "response": {"temp": 21}}}]}
+				],
+				"generationConfig": {"temperature": 0.2, "candidateCount": 1},
+				"safetySettings": [{"category": "HARM_CATEGORY_HARASSMENT", "threshold": "BLOCK_NONE"}]
+			}`,
+			modelMessages: []model.Message{
+				{"role": "system", "content": "You are a helpful assistant."},
+				{"role": "user", "content": "Answer in French"},
+			},
+			expectedBody: `{
+				"model": "gemini-pro",
+				"systemInstruction": {"parts": [{"text": "You are a helpful assistant."}]},
+				"contents": [
+					{"role": "user", "parts": [{"text": "Answer in French"}, {"text": "Weather in Paris?"}]},
+					{"role": "model", "parts": [{"functionCall": {"name": "get_weather", "args": {"city": "Paris"}}}]},
+					{"role": "user", "parts": [{"functionResponse": {"name": "get_weather", "response": {"temp": 21}}}]}
+				],
+				"generationConfig": {"temperature": 0.2, "candidateCount": 1},
+				"safetySettings": [{"category": "HARM_CATEGORY_HARASSMENT", "threshold": "BLOCK_NONE"}]
+			}`,
+			wantErr: false,
+		},
+		{
+			name: "anthropic messages",
+			body: `{
+				"model": "openai/gpt-4o",
+				"system": "Caller prompt",
+				"max_tokens": 100,
+				"messages": [
+					{"role": "user", "content": [{"type": "text", "text": "Weather in Paris?"}]},
+					{"role": "assistant", "content": [{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Paris"}}]},
+					{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "toolu_1", "content": "21 degrees"}]}
+				]
+			}`,
+			modelMessages: []model.Message{
+				{"role": "system", "content": "You are a helpful assistant."},
+			},
+			expectedBody: `{
+				"model": "openai/gpt-4o",
+				"system": "You are a helpful assistant.",
+				"max_tokens": 100,
+				"messages": [
+					{"role": "user", "content": [{"type": "text", "text": "Weather in Paris?"}]},
+					{"role": "assistant", "content": [{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Paris"}}]},
+					{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "toolu_1", "content": "21 degrees"}]}
+				]
+			}`,
+			wantErr: false,
 		},
 		{
 			name: "invalid message sequence",
+			body: `{"model":"gpt-4","messages":[{"role":"user","content":"Hello"}]}`,
 			modelMessages: []model.Message{
 				{"role": "assistant", "content": "Invalid first message"},
 			},
-			messages: []model.Message{
-				{"role": "user", "content": "Hello"},
-			},
-			extractedModel: "gpt-4",
-			wantErr:        true,
+			wantErr: true,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			req, err := http.NewRequest("POST", "http://example.com",
-				bytes.NewBufferString(tt.expectedBody))
+			env, err := request.ParseEnvelope(nil, []byte(tt.body), "openai", clients.Builtin())
 			if err != nil {
-				t.Fatalf("Failed to create request: %v", err)
+				t.Fatalf("Failed to parse request: %v", err)
 			}
 
-			err = updateRequestWithCombinedMessages(req, tt.modelMessages, tt.messages, tt.extractedModel)
+			actualBody, err := combinedMessagesBody(env, tt.modelMessages)
 
 			if (err != nil) != tt.wantErr {
-				t.Errorf("updateRequestWithCombinedMessages() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("combinedMessagesBody() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
 				return
 			}
+
+			// Decode numbers as json.Number so that large integers compare exactly
+			var actualJSON, expectedJSON interface{}
+			decoder := json.NewDecoder(bytes.NewReader(actualBody))
+			decoder.UseNumber()
+			if err := decoder.Decode(&actualJSON); err != nil {
+				t.Fatalf("Failed to parse actual JSON: %v", err)
+			}
+			decoder = json.NewDecoder(strings.NewReader(tt.expectedBody))
+			decoder.UseNumber()
+			if err := decoder.Decode(&expectedJSON); err != nil {
+				t.Fatalf("Failed to parse expected JSON: %v", err)
+			}
+			if !reflect.DeepEqual(actualJSON, expectedJSON) {
+				t.Errorf("Request body mismatch.\nGot: %s\nWant: %s", actualBody, tt.expectedBody)
+			}
 		})
 	}
 }
@@ -7,44 +7,80 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/Not-Diamond/go-notdiamond/pkg/clients"
 	"github.com/Not-Diamond/go-notdiamond/pkg/model"
 )
 
-// ValidateConfig validates the configuration for the NotDiamond client.
+// ValidateConfig validates the configuration for the NotDiamond client. Provider
+// names are checked against the built-in providers and the custom ones of the config.
 func ValidateConfig(config model.Config) error {
-	if err := validateClients(config.Clients); err != nil {
+	providers := clients.Registry(config)
+
+	if err := validateClients(config.Clients, providers); err != nil {
+		return err
+	}
+
+	if err := validateModels(config.Models, providers); err != nil {
+		return err
+	}
+
+	if err := validateAzureRegions(config); err != nil {
+		return err
+	}
+
+	if err := validateKeyPools(config.KeyPools, providers); err != nil {
 		return err
 	}
 
-	if err := validateModels(config.Models); err != nil {
+	if err := validateEmbeddingDimensions(config.EmbeddingDimensions, providers); err != nil {
 		return err
 	}
 
-	return validateStatusCodeRetry(config.StatusCodeRetry)
+	if err := validateModelParams(config.ModelParams, providers); err != nil {
+		return err
+	}
+
+	switch config.UnsupportedParams {
+	case "", model.UnsupportedParamsDrop, model.UnsupportedParamsFail:
+	default:
+		return fmt.Errorf("unknown unsupported params policy: %s", config.UnsupportedParams)
+	}
+
+	return validateStatusCodeRetry(config.StatusCodeRetry, providers)
 }
 
 // validateClients validates the clients for the NotDiamond client.
-func validateClients(clients []http.Request) error {
+func validateClients(clients []http.Request, providers model.Providers) error {
 	if len(clients) == 0 {
 		return errors.New("at least one client must be provided")
 	}
+	for i := range clients {
+		// Untagged clients are matched by the providers' host heuristics at request time
+		tag := model.ClientProvider(&clients[i])
+		if tag == "" {
+			continue
+		}
+		if err := validateProvider(tag, providers); err != nil {
+			return fmt.Errorf("invalid provider tag on client %d: %w", i, err)
+		}
+	}
 	return nil
 }
 
 // validateModels validates the models for the NotDiamond client.
-func validateModels(models interface{}) error {
+func validateModels(models interface{}, providers model.Providers) error {
 	switch m := models.(type) {
 	case model.OrderedModels:
-		return validateOrderedModels(m)
+		return validateOrderedModels(m, providers)
 	case model.WeightedModels:
-		return validateWeightedModels(m)
+		return validateWeightedModels(m, providers)
 	default:
 		return fmt.Errorf("models must be either notdiamond.OrderedModels or map[string]float64, got %T", models)
 	}
 }
 
 // validateWeightedModels validates the weighted models for the NotDiamond client.
-func validateWeightedModels(models map[string]float64) error {
+func validateWeightedModels(models map[string]float64, providers model.Providers) error {
 	if len(models) == 0 {
 		return errors.New("at least one model must be provided")
 	}
@@ -53,7 +89,7 @@ func validateWeightedModels(models map[string]float64) error {
 		return err
 	}
 
-	return validateModelNames(getModelNames(models))
+	return validateModelNames(getModelNames(models), providers)
 }
 
 // validateWeights validates the weights for the NotDiamond client.
@@ -75,17 +111,17 @@ func validateWeights(models map[string]float64) error {
 }
 
 // validateOrderedModels validates the ordered models for the NotDiamond client.
-func validateOrderedModels(models []string) error {
+func validateOrderedModels(models []string, providers model.Providers) error {
 	if len(models) == 0 {
 		return errors.New("at least one model must be provided")
 	}
-	return validateModelNames(models)
+	return validateModelNames(models, providers)
 }
 
 // validateModelNames validates the model names for the NotDiamond client.
-func validateModelNames(models []string) error {
+func validateModelNames(models []string, providers model.Providers) error {
 	for _, model := range models {
-		if err := validateModelName(model); err != nil {
+		if err := validateModelName(model, providers); err != nil {
 			return err
 		}
 	}
@@ -93,43 +129,146 @@ func validateModelNames(models []string) error {
 }
 
 // validateModelName validates the model name for the NotDiamond client.
-func validateModelName(model string) error {
+func validateModelName(model string, providers model.Providers) error {
 	if model == "" {
 		return errors.New("empty model name not allowed")
 	}
 
-	parts := strings.Split(model, "/")
+	// Model names can contain slashes, except those of regional providers, which
+	// take a region instead
+	provider, modelName, region := providers.SplitModel(model)
+	if !strings.Contains(model, "/") || modelName == "" || region != "" && strings.Contains(modelName, "/") {
+		return fmt.Errorf("invalid model format: %s (expected 'provider/model' or 'provider/model/region')", model)
+	}
+	if err := validateProvider(provider, providers); err != nil {
+		return fmt.Errorf("invalid provider in model %s: %w", model, err)
+	}
+	// We don't validate the region as it can be any string
+	return nil
+}
 
-	// Handle provider/model format
-	if len(parts) == 2 {
-		provider := parts[0]
-		if err := validateProvider(provider); err != nil {
-			return fmt.Errorf("invalid provider in model %s: %w", model, err)
-		}
+// validateProvider validates the provider for the NotDiamond client. It must be
+// registered in providers.
+func validateProvider(provider string, providers model.Providers) error {
+	if _, ok := providers[provider]; ok && provider != "" {
 		return nil
 	}
+	return fmt.Errorf("unknown provider: %s", provider)
+}
 
-	// Handle provider/model/region format
-	if len(parts) == 3 {
-		provider := parts[0]
-		if err := validateProvider(provider); err != nil {
-			return fmt.Errorf("invalid provider in model %s: %w", model, err)
-		}
-		// We don't validate the region as it can be any string
+// validateAzureRegions validates that every Azure model with a region has a
+// resource configured in AzureRegions. A custom Azure provider is not checked.
+func validateAzureRegions(config model.Config) error {
+	if _, ok := config.Providers[string(model.ClientTypeAzure)]; ok {
 		return nil
 	}
 
-	return fmt.Errorf("invalid model format: %s (expected 'provider/model' or 'provider/model/region')", model)
+	var models []string
+	switch m := config.Models.(type) {
+	case model.OrderedModels:
+		models = m
+	case model.WeightedModels:
+		models = getModelNames(m)
+	}
+
+	for _, modelName := range models {
+		provider, _, regionName := clients.Registry(config).SplitModel(modelName)
+		if provider != string(model.ClientTypeAzure) || regionName == "" {
+			continue
+		}
+		region, ok := config.AzureRegions[regionName]
+		if !ok {
+			return fmt.Errorf("azure region %s of model %s is not configured in AzureRegions", regionName, modelName)
+		}
+		if region.Endpoint == "" {
+			return fmt.Errorf("azure region %s has no endpoint", regionName)
+		}
+	}
+	return nil
+}
+
+// validateKeyPools validates the API key pools, keyed by provider or provider/region.
+func validateKeyPools(pools map[string]model.KeyPool, providers model.Providers) error {
+	for name, pool := range pools {
+		parts := strings.Split(name, "/")
+		if len(parts) > 2 {
+			return fmt.Errorf("invalid key pool %s (expected 'provider' or 'provider/region')", name)
+		}
+		if err := validateProvider(parts[0], providers); err != nil {
+			return fmt.Errorf("invalid provider in key pool %s: %w", name, err)
+		}
+		if !model.UsesAPIKeys(providers[parts[0]]) {
+			return fmt.Errorf("invalid key pool %s: provider %s does not authenticate with API keys", name, parts[0])
+		}
+		if len(pool.Keys) == 0 {
+			return fmt.Errorf("key pool %s has no keys", name)
+		}
+		for _, key := range pool.Keys {
+			if key == "" {
+				return fmt.Errorf("key pool %s has an empty key", name)
+			}
+		}
+		switch pool.Strategy {
+		case "", model.KeyPoolRoundRobin, model.KeyPoolLeastRecentlyThrottled:
+		default:
+			return fmt.Errorf("key pool %s has unknown strategy: %s", name, pool.Strategy)
+		}
+	}
+	return nil
 }
 
-// validateProvider validates the provider for the NotDiamond client.
-func validateProvider(provider string) error {
-	switch provider {
-	case string(model.ClientTypeAzure), string(model.ClientTypeOpenai), string(model.ClientTypeVertex):
-		return nil
-	default:
-		return fmt.Errorf("unknown provider: %s", provider)
+// validateEmbeddingDimensions validates the embedding model sizes, keyed by provider/model.
+func validateEmbeddingDimensions(dimensions map[string]int, providers model.Providers) error {
+	for name, size := range dimensions {
+		provider, modelName, region := providers.SplitModel(name)
+		if !strings.Contains(name, "/") || modelName == "" || region != "" {
+			return fmt.Errorf("invalid embedding model %s (expected 'provider/model')", name)
+		}
+		if err := validateProvider(provider, providers); err != nil {
+			return fmt.Errorf("invalid provider in embedding model %s: %w", name, err)
+		}
+		if size <= 0 {
+			return fmt.Errorf("embedding model %s has invalid dimensions: %d", name, size)
+		}
 	}
+	return nil
+}
+
+// validateModelParams validates the parameters of each model, keyed by
+// provider/model or provider/model/region.
+func validateModelParams(modelParams map[string]model.ModelParams, providers model.Providers) error {
+	for name, params := range modelParams {
+		if err := validateModelName(name, providers); err != nil {
+			return fmt.Errorf("invalid model in model params: %w", err)
+		}
+		if params.Temperature != nil && (*params.Temperature < 0 || *params.Temperature > 2) {
+			return fmt.Errorf("model %s has invalid temperature: %v", name, *params.Temperature)
+		}
+		if params.TopP != nil && (*params.TopP < 0 || *params.TopP > 1) {
+			return fmt.Errorf("model %s has invalid top_p: %v", name, *params.TopP)
+		}
+		if params.PresencePenalty != nil && (*params.PresencePenalty < -2 || *params.PresencePenalty > 2) {
+			return fmt.Errorf("model %s has invalid presence penalty: %v", name, *params.PresencePenalty)
+		}
+		if params.FrequencyPenalty != nil && (*params.FrequencyPenalty < -2 || *params.FrequencyPenalty > 2) {
+			return fmt.Errorf("model %s has invalid frequency penalty: %v", name, *params.FrequencyPenalty)
+		}
+		if params.MaxTokens != nil && *params.MaxTokens <= 0 {
+			return fmt.Errorf("model %s has invalid max tokens: %d", name, *params.MaxTokens)
+		}
+		if params.MaxOutputTokens < 0 {
+			return fmt.Errorf("model %s has invalid max output tokens: %d", name, params.MaxOutputTokens)
+		}
+		for i, patch := range params.Patches {
+			if patch.Op != model.ParamPatchSet && patch.Op != model.ParamPatchDelete {
+				return fmt.Errorf("patch %d of model %s has unknown operation: %s", i, name, patch.Op)
+			}
+			if !strings.HasPrefix(patch.Path, "/") {
+				return fmt.Errorf("patch %d of model %s has invalid path %q (expected a JSON Pointer)", i, name, patch.Path)
+			}
+		}
+	}
+	return nil
 }
 
 // getModelNames gets the model names for the NotDiamond client.
@@ -142,7 +281,7 @@ func getModelNames(models map[string]float64) []string {
 }
 
 // validateStatusCodeRetry validates the status code retry for the NotDiamond client.
-func validateStatusCodeRetry(retry interface{}) error {
+func validateStatusCodeRetry(retry interface{}, providers model.Providers) error {
 	if retry == nil {
 		return nil
 	}
@@ -151,7 +290,7 @@ func validateStatusCodeRetry(retry interface{}) error {
 	case map[string]map[string]int:
 		// Per model validation
 		for model, statusCodes := range r {
-			if err := validateModelName(model); err != nil {
+			if err := validateModelName(model, providers); err != nil {
 				return fmt.Errorf("invalid model in status code retry: %w", err)
 			}
 			if err := validateStatusCodes(statusCodes); err != nil {
@@ -184,7 +323,9 @@ func validateStatusCodes(codes map[string]int) error {
 	return nil
 }
 
-// ValidateMessageSequence ensures messages alternate properly between roles
+// ValidateMessageSequence ensures messages alternate properly between roles. Tool
+// results follow the assistant message calling the tools, and are followed by the
+// assistant's answer.
 func ValidateMessageSequence(messages []model.Message) error {
 	if len(messages) == 0 {
 		return nil
@@ -192,7 +333,7 @@ func ValidateMessageSequence(messages []model.Message) error {
 
 	lastRole := ""
 	for i, msg := range messages {
-		currentRole := msg["role"]
+		currentRole := msg.Role()
 
 		// First message can be either system or user
 		if i == 0 {
@@ -213,9 +354,14 @@ func ValidateMessageSequence(messages []model.Message) error {
 			return fmt.Errorf("message after 'user' must be 'assistant', got '%s'", currentRole)
 		}
 
-		// After an assistant message, only user message is allowed
-		if lastRole == "assistant" && currentRole != "user" {
-			return fmt.Errorf("message after 'assistant' must be 'user', got '%s'", currentRole)
+		// After an assistant message, only user or tool messages are allowed
+		if lastRole == "assistant" && currentRole != "user" && currentRole != "tool" {
+			return fmt.Errorf("message after 'assistant' must be 'user' or 'tool', got '%s'", currentRole)
+		}
+
+		// After a tool message, only more tool results or the assistant's answer are allowed
+		if lastRole == "tool" && currentRole != "tool" && currentRole != "assistant" {
+			return fmt.Errorf("message after 'tool' must be 'tool' or 'assistant', got '%s'", currentRole)
 		}
 
 		lastRole = currentRole
@@ -7,6 +7,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/Not-Diamond/go-notdiamond/pkg/clients"
 	"github.com/Not-Diamond/go-notdiamond/pkg/model"
 )
 
@@ -38,6 +39,51 @@ func TestValidateConfig(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "valid config with unsupported params policy",
+			config: model.Config{
+				Clients:           []http.Request{*&http.Request{}},
+				Models:            model.OrderedModels{"openai/gpt-4"},
+				UnsupportedParams: model.UnsupportedParamsFail,
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid - unknown unsupported params policy",
+			config: model.Config{
+				Clients:           []http.Request{*&http.Request{}},
+				Models:            model.OrderedModels{"openai/gpt-4"},
+				UnsupportedParams: "ignore",
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid config with embedding dimensions",
+			config: model.Config{
+				Clients:             []http.Request{*&http.Request{}},
+				Models:              model.OrderedModels{"openai/text-embedding-3-small", "vertex/text-embedding-005"},
+				EmbeddingDimensions: map[string]int{"openai/text-embedding-3-small": 1536, "vertex/text-embedding-005": 768},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid - embedding dimensions with region",
+			config: model.Config{
+				Clients:             []http.Request{*&http.Request{}},
+				Models:              model.OrderedModels{"vertex/text-embedding-005"},
+				EmbeddingDimensions: map[string]int{"vertex/text-embedding-005/us-central1": 768},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid - non-positive embedding dimensions",
+			config: model.Config{
+				Clients:             []http.Request{*&http.Request{}},
+				Models:              model.OrderedModels{"vertex/text-embedding-005"},
+				EmbeddingDimensions: map[string]int{"vertex/text-embedding-005": 0},
+			},
+			wantErr: true,
+		},
 		{
 			name: "invalid - no clients",
 			config: model.Config{
@@ -45,6 +91,56 @@ func TestValidateConfig(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "valid config with tagged client",
+			config: model.Config{
+				Clients: []http.Request{*model.WithProvider(&http.Request{}, "azure")},
+				Models:  model.OrderedModels{"azure/gpt-4"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid - client tagged with unknown provider",
+			config: model.Config{
+				Clients: []http.Request{*model.WithProvider(&http.Request{}, "unknown")},
+				Models:  model.OrderedModels{"openai/gpt-4"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid config with configured azure region",
+			config: model.Config{
+				Clients: []http.Request{*&http.Request{}},
+				Models:  model.OrderedModels{"azure/gpt-4/eastus"},
+				AzureRegions: map[string]model.AzureRegion{
+					"eastus": {Endpoint: "https://eastus-resource.openai.azure.com"},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid - azure region not configured",
+			config: model.Config{
+				Clients: []http.Request{*&http.Request{}},
+				Models: model.WeightedModels{
+					"azure/gpt-4/eastus": 0.5,
+					"azure/gpt-4/westus": 0.5,
+				},
+				AzureRegions: map[string]model.AzureRegion{
+					"eastus": {Endpoint: "https://eastus-resource.openai.azure.com"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid - azure region without endpoint",
+			config: model.Config{
+				Clients:      []http.Request{*&http.Request{}},
+				Models:       model.OrderedModels{"azure/gpt-4/eastus"},
+				AzureRegions: map[string]model.AzureRegion{"eastus": {}},
+			},
+			wantErr: true,
+		},
 		{
 			name: "invalid - no models",
 			config: model.Config{
@@ -136,7 +232,7 @@ func TestValidateWeightedModels(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := validateWeightedModels(tt.models)
+			err := validateWeightedModels(tt.models, clients.Builtin())
 			if (err != nil) != tt.wantErr {
 				t.Errorf("validateWeightedModels() error = %v, wantErr %v", err, tt.wantErr)
 			}
@@ -169,11 +265,11 @@ func TestValidateOrderedModels(t *testing.T) {
 			name: "invalid model in list",
 			models: []string{
 				"openai/gpt-4",
-				"invalid/model/format/toomany",
+				"azure/model/format/toomany",
 				"azure/gpt-4",
 			},
 			wantErr:     true,
-			errContains: "invalid model format: invalid/model/format/toomany",
+			errContains: "invalid model format: azure/model/format/toomany",
 		},
 		{
 			name: "unknown provider in list",
@@ -188,7 +284,7 @@ func TestValidateOrderedModels(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := validateOrderedModels(tt.models)
+			err := validateOrderedModels(tt.models, clients.Builtin())
 
 			if (err != nil) != tt.wantErr {
 				t.Errorf("validateOrderedModels() error = %v, wantErr %v", err, tt.wantErr)
@@ -226,10 +322,10 @@ func TestValidateModelNames(t *testing.T) {
 			name: "invalid model in list",
 			models: []string{
 				"openai/gpt-4",
-				"invalid/model/format/toomany",
+				"azure/model/format/toomany",
 			},
 			wantErr:     true,
-			errContains: "invalid model format: invalid/model/format/toomany",
+			errContains: "invalid model format: azure/model/format/toomany",
 		},
 		{
 			name: "unknown provider in list",
@@ -253,7 +349,7 @@ func TestValidateModelNames(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := validateModelNames(tt.models)
+			err := validateModelNames(tt.models, clients.Builtin())
 
 			if (err != nil) != tt.wantErr {
 				t.Errorf("validateModelNames() error = %v, wantErr %v", err, tt.wantErr)
@@ -284,6 +380,16 @@ func TestValidateModelName(t *testing.T) {
 			model:   "azure/gpt-4",
 			wantErr: false,
 		},
+		{
+			name:    "valid anthropic model",
+			model:   "anthropic/claude-3-5-sonnet-latest",
+			wantErr: false,
+		},
+		{
+			name:    "valid bedrock model with region",
+			model:   "bedrock/anthropic.claude-3-haiku-20240307-v1:0/us-east-1",
+			wantErr: false,
+		},
 		{
 			name:        "empty model name",
 			model:       "",
@@ -296,11 +402,16 @@ func TestValidateModelName(t *testing.T) {
 			wantErr:     true,
 			errContains: "invalid model format: gpt-4 (expected 'provider/model' or 'provider/model/region')",
 		},
+		{
+			name:    "model name with slashes",
+			model:   "openai/ft/gpt-4",
+			wantErr: false,
+		},
 		{
 			name:        "too many parts",
-			model:       "openai/gpt-4/extra/toomany",
+			model:       "azure/gpt-4/extra/eastus",
 			wantErr:     true,
-			errContains: "invalid model format: openai/gpt-4/extra/toomany (expected 'provider/model' or 'provider/model/region')",
+			errContains: "invalid model format: azure/gpt-4/extra/eastus (expected 'provider/model' or 'provider/model/region')",
 		},
 		{
 			name:        "unknown provider",
@@ -318,7 +429,7 @@ func TestValidateModelName(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := validateModelName(tt.model)
+			err := validateModelName(tt.model, clients.Builtin())
 
 			if (err != nil) != tt.wantErr {
 				t.Errorf("validateModelName() error = %v, wantErr %v", err, tt.wantErr)
@@ -336,6 +447,7 @@ func TestValidateProvider(t *testing.T) {
 	tests := []struct {
 		name        string
 		provider    string
+		providers   model.Providers
 		wantErr     bool
 		errContains string
 	}{
@@ -349,6 +461,27 @@ func TestValidateProvider(t *testing.T) {
 			provider: "openai",
 			wantErr:  false,
 		},
+		{
+			name:     "valid anthropic provider",
+			provider: "anthropic",
+			wantErr:  false,
+		},
+		{
+			name:     "valid bedrock provider",
+			provider: "bedrock",
+			wantErr:  false,
+		},
+		{
+			name:     "valid gemini provider",
+			provider: "gemini",
+			wantErr:  false,
+		},
+		{
+			name:      "registered custom provider",
+			provider:  "gateway",
+			providers: model.Providers{"gateway": nil},
+			wantErr:   false,
+		},
 		{
 			name:        "invalid provider",
 			provider:    "unknown",
@@ -365,7 +498,7 @@ func TestValidateProvider(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := validateProvider(tt.provider)
+			err := validateProvider(tt.provider, clients.Registry(model.Config{Providers: tt.providers}))
 
 			if (err != nil) != tt.wantErr {
 				t.Errorf("validateProvider() error = %v, wantErr %v", err, tt.wantErr)
@@ -511,7 +644,7 @@ func TestValidateStatusCodeRetry(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := validateStatusCodeRetry(tt.retry)
+			err := validateStatusCodeRetry(tt.retry, clients.Builtin())
 
 			if (err != nil) != tt.wantErr {
 				t.Errorf("validateStatusCodeRetry() error = %v, wantErr %v", err, tt.wantErr)
@@ -642,7 +775,7 @@ func TestValidateModels(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := validateModels(tt.models)
+			err := validateModels(tt.models, clients.Builtin())
 			if (err != nil) != tt.wantErr {
 				t.Errorf("validateModels() error = %v, wantErr %v", err, tt.wantErr)
 			}
@@ -650,6 +783,128 @@ func TestValidateModels(t *testing.T) {
 	}
 }
 
+func TestValidateKeyPools(t *testing.T) {
+	tests := []struct {
+		name    string
+		pools   map[string]model.KeyPool
+		wantErr bool
+	}{
+		{
+			name: "valid provider and region pools",
+			pools: map[string]model.KeyPool{
+				"openai":       {Keys: []string{"key-a", "key-b"}},
+				"azure/eastus": {Keys: []string{"key-c"}, Strategy: model.KeyPoolLeastRecentlyThrottled},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "invalid - unknown provider",
+			pools:   map[string]model.KeyPool{"unknown": {Keys: []string{"key-a"}}},
+			wantErr: true,
+		},
+		{
+			name:    "valid - gemini pool",
+			pools:   map[string]model.KeyPool{"gemini": {Keys: []string{"key-a"}}},
+			wantErr: false,
+		},
+		{
+			name:    "invalid - vertex authenticates with Google credentials",
+			pools:   map[string]model.KeyPool{"vertex/us-central1": {Keys: []string{"key-a"}}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid - bedrock signs with AWS credentials",
+			pools:   map[string]model.KeyPool{"bedrock": {Keys: []string{"key-a"}}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid - too many parts",
+			pools:   map[string]model.KeyPool{"azure/gpt-4/eastus": {Keys: []string{"key-a"}}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid - no keys",
+			pools:   map[string]model.KeyPool{"openai": {}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid - empty key",
+			pools:   map[string]model.KeyPool{"openai": {Keys: []string{"key-a", ""}}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid - unknown strategy",
+			pools:   map[string]model.KeyPool{"openai": {Keys: []string{"key-a"}, Strategy: "random"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateKeyPools(tt.pools, clients.Builtin())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateKeyPools() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateModelParams(t *testing.T) {
+	temperature, topP, maxTokens := 0.2, 1.5, 0
+
+	tests := []struct {
+		name    string
+		params  map[string]model.ModelParams
+		wantErr bool
+	}{
+		{
+			name: "valid params and patches",
+			params: map[string]model.ModelParams{
+				"openai/o3-mini": {ReasoningEffort: "low", MaxOutputTokens: 100000},
+				"vertex/gemini-pro/us-east1": {Temperature: &temperature, Patches: []model.ParamPatch{
+					{Op: model.ParamPatchSet, Path: "/generationConfig/topK", Value: 40},
+					{Op: model.ParamPatchDelete, Path: "/safetySettings"},
+				}},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "invalid - unknown provider",
+			params:  map[string]model.ModelParams{"unknown/gpt-4": {Temperature: &temperature}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid - top_p out of range",
+			params:  map[string]model.ModelParams{"openai/gpt-4o": {TopP: &topP}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid - max tokens",
+			params:  map[string]model.ModelParams{"openai/gpt-4o": {MaxTokens: &maxTokens}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid - unknown patch operation",
+			params:  map[string]model.ModelParams{"openai/gpt-4o": {Patches: []model.ParamPatch{{Op: "replace", Path: "/seed"}}}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid - patch path",
+			params:  map[string]model.ModelParams{"openai/gpt-4o": {Patches: []model.ParamPatch{{Op: model.ParamPatchDelete, Path: "seed"}}}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateModelParams(tt.params, clients.Builtin())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateModelParams() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestValidateMessageSequence(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -716,6 +971,29 @@ func TestValidateMessageSequence(t *testing.T) {
 			wantErr:     true,
 			errContains: "message after 'assistant' must be 'user'",
 		},
+		{
+			name: "valid tool calling sequence",
+			messages: []model.Message{
+				{"role": "user", "content": "What's the weather in Paris and Rome?"},
+				{"role": "assistant", "content": ""},
+				{"role": "tool", "tool_call_id": "call_1", "content": "18C"},
+				{"role": "tool", "tool_call_id": "call_2", "content": "24C"},
+				{"role": "assistant", "content": "Paris is 18C and Rome is 24C."},
+				{"role": "user", "content": "Thanks"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid sequence after tool",
+			messages: []model.Message{
+				{"role": "user", "content": "What's the weather in Paris?"},
+				{"role": "assistant", "content": ""},
+				{"role": "tool", "tool_call_id": "call_1", "content": "18C"},
+				{"role": "user", "content": "Well?"},
+			},
+			wantErr:     true,
+			errContains: "message after 'tool' must be 'tool' or 'assistant'",
+		},
 		{
 			name: "valid complex sequence",
 			messages: []model.Message{